@@ -0,0 +1,52 @@
+// s7server 在内存中模拟一台 S7 PLC，供开发环境下的数采调试使用：
+//
+//	go run ./cmd/s7server -listen :102 -db 1:1024,2:256
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"acetek-mes/driver/s7/s7server"
+)
+
+func main() {
+	listen := flag.String("listen", ":102", "监听地址")
+	dbs := flag.String("db", "1:1024", "数据块，格式 编号:字节数，多个以逗号分隔")
+	size := flag.Int("area", 256, "M/I/Q 区字节数")
+	pdu := flag.Int("pdu", s7server.DefaultPDULength, "最大 PDU 长度")
+	flag.Parse()
+
+	server := s7server.NewServer()
+	server.PDULength = *pdu
+	for _, area := range []int{s7server.AreaPE, s7server.AreaPA, s7server.AreaMK} {
+		server.SetArea(area, make([]byte, *size))
+	}
+	for _, spec := range strings.Split(*dbs, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		number, length, ok := strings.Cut(spec, ":")
+		n, err := strconv.Atoi(number)
+		l, lerr := strconv.Atoi(length)
+		if !ok || err != nil || lerr != nil || n <= 0 || l <= 0 {
+			log.Fatalf("invalid db spec: %s", spec)
+		}
+		server.SetDB(n, make([]byte, l))
+		log.Printf("DB%d: %d bytes", n, l)
+	}
+	if err := server.Listen(*listen); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("s7server listening on", server.Addr())
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	<-ch
+	log.Println("s7server stopped:", server.Close(), server.Stats())
+}
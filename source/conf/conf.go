@@ -28,12 +28,15 @@ type InfluxDB struct {
 }
 
 type RedisConfig struct {
-	Url string `json:"url"`
+	Url       string `json:"url"`       // 为空时不使用 Redis：不消费写命令 stream，触发器状态只在内存中
+	Spool     string `json:"spool"`     // Redis 不可用时缓存实时值的目录，为空时为工作目录下的 spool
+	SpoolSize int    `json:"spoolsize"` // 缓存上限 MB，0 为 256，小于 0 不缓存
 }
 
 type Api struct {
-	ListenAddr string `json:"listenaddr"`
-	Path       string `json:"path"`
+	ListenAddr string            `json:"listenaddr"`
+	Path       string            `json:"path"`
+	Tokens     map[string]string `json:"tokens"` // 访问令牌 -> 用户名，写入与确认接口按令牌认证操作人
 }
 
 type FileWatch struct {
@@ -46,7 +49,11 @@ type FileWatch struct {
 }
 
 type DataCollection struct {
-	Drivers []string `json:"drivers"`
+	Drivers        []string `json:"drivers"`
+	ReloadInterval int      `json:"reloadinterval"` // 检查驱动配置变化的间隔秒数，0 为 60 秒，小于 0 不检查
+	// 各驱动的输出：驱动 ID -> 输出 URL 列表，"*" 为未单独配置的驱动，未配置时写 Redis，空列表不输出。
+	// 例如 {"*": ["redis://", "mqtt://broker:1883?factory=hc&qos=1"], "PLC01": ["influx://influx:8086?org=dzr&bucket=dc&token=xxx"]}
+	Sinks map[string][]string `json:"sinks"`
 }
 
 var (
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"acetek-mes/conf"
+	"acetek-mes/driver"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// startApi 未配置 api.listenaddr 时不启动，返回 nil
+func startApi() *http.Server {
+	if conf.Conf().Api.ListenAddr == "" {
+		return nil
+	}
+	r := gin.New()
+	r.Use(gin.Recovery())
+	path := conf.Conf().Api.Path
+	if len(conf.Conf().Api.Tokens) == 0 {
+		log.Println("api.tokens 未配置，写入与重新加载接口不可用")
+	}
+	// 写入、确认与重新加载驱动需要 Authorization: Bearer <token>，操作人为令牌对应的用户
+	// POST /drivers/:driver/write {"id": "...", "tag": "...", "value": 12}
+	// 需要第二人确认的变量返回 202，由另一个用户 POST /drivers/:driver/confirm/:id 确认后执行
+	auth := r.Group(path, authenticate)
+	auth.POST("/drivers/:driver/write", writeTag)
+	auth.POST("/drivers/:driver/confirm/:id", confirmWrite)
+	auth.GET("/drivers/:driver/pending", pendingWrites)
+	auth.POST("/drivers/reload", reloadDrivers)
+
+	srv := &http.Server{Addr: conf.Conf().Api.ListenAddr, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("api server", err)
+		}
+	}()
+	return srv
+}
+
+// authenticate 按 Bearer 令牌认证用户，用户名保存在 "user" 中
+func authenticate(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if ok && token != "" {
+		for t, user := range conf.Conf().Api.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				c.Set("user", user)
+				c.Next()
+				return
+			}
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+}
+
+func writeTag(c *gin.Context) {
+	var cmd driver.WriteCommand
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cmd.Source = "http"
+	cmd.User = c.GetString("user")
+	if cmd.ID == "" {
+		cmd.ID = uuid.NewString()
+	}
+	if driverMgr == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "driver manager not started"})
+		return
+	}
+	result, err := driverMgr.Execute(c.Param("driver"), cmd)
+	c.JSON(writeStatus(err), result)
+}
+
+// confirmWrite 第二人确认等待中的写命令，确认人为当前认证的用户
+func confirmWrite(c *gin.Context) {
+	if driverMgr == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "driver manager not started"})
+		return
+	}
+	result, err := driverMgr.Confirm(c.Param("driver"), c.Param("id"), c.GetString("user"))
+	c.JSON(writeStatus(err), result)
+}
+
+func pendingWrites(c *gin.Context) {
+	if driverMgr == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "driver manager not started"})
+		return
+	}
+	c.JSON(http.StatusOK, driverMgr.Pending(c.Param("driver")))
+}
+
+func writeStatus(err error) int {
+	status := http.StatusOK
+	switch {
+	case err == nil:
+	case errors.Is(err, driver.ErrPendingConfirm):
+		status = http.StatusAccepted
+	case errors.Is(err, driver.ErrDriverNotFound), errors.Is(err, driver.ErrTagNotFound), errors.Is(err, driver.ErrNoPending):
+		status = http.StatusNotFound
+	case errors.Is(err, driver.ErrNotWritable), errors.Is(err, driver.ErrInvalidValue):
+		status = http.StatusBadRequest
+	case errors.Is(err, driver.ErrInterlock):
+		status = http.StatusForbidden
+	default:
+		status = http.StatusBadGateway
+	}
+	return status
+}
+
+// reloadDrivers 重新加载驱动配置，操作人记录在日志中
+func reloadDrivers(c *gin.Context) {
+	if driverMgr == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "driver manager not started"})
+		return
+	}
+	log.Printf("reload drivers by %s from %s", c.GetString("user"), c.ClientIP())
+	if err := driverMgr.Reload(); err != nil {
+		log.Printf("reload drivers by %s: %v", c.GetString("user"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
@@ -3,10 +3,25 @@ package main
 import (
 	"acetek-mes/conf"
 	"acetek-mes/driver"
+	_ "acetek-mes/driver/fins"
+	_ "acetek-mes/driver/influxsink"
+	_ "acetek-mes/driver/mc"
+	_ "acetek-mes/driver/modbus"
+	_ "acetek-mes/driver/mqtt"
+	_ "acetek-mes/driver/mqttsink"
+	_ "acetek-mes/driver/opcua"
 	_ "acetek-mes/driver/s7"
+	_ "acetek-mes/driver/sim"
 	"acetek-mes/model"
 	"acetek-mes/redishelper"
+	"context"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/yxcloud1/go-comm/config"
 	"github.com/yxcloud1/go-comm/db"
@@ -20,19 +35,75 @@ func init() {
 
 	db.SetOption(conf.Conf().DB.Type, conf.Conf().DB.Url)
 	log.Println(config.WorkDir())
-	log.Println("init redis ", redishelper.Instance().InitFromURL(conf.Conf().RedisConfig.Url))
+	// 未配置 Redis 时驱动只写配置的输出，不消费写命令 stream，触发器状态只在内存中
+	if rc := conf.Conf().RedisConfig; rc.Url != "" {
+		if rc.SpoolSize >= 0 {
+			if rc.Spool == "" {
+				rc.Spool = filepath.Join(config.WorkDir(), "spool")
+			}
+			if rc.SpoolSize == 0 {
+				rc.SpoolSize = 256
+			}
+			log.Println("init redis spool ", redishelper.Instance().EnableSpool(rc.Spool, int64(rc.SpoolSize)<<20))
+		}
+		log.Println("init redis ", redishelper.Instance().InitFromURL(rc.Url))
+	}
 	db.DB().Conn().Debug().AutoMigrate(model.GetEntitys()...)
 
 }
 
 func main() {
+	// SIGINT/SIGTERM 优雅退出，SIGHUP 重新加载驱动配置
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	var err error
+	var srv *http.Server
 	if driverMgr, err = driver.NewDriverMgr(conf.Conf().DataCollection.Drivers); err == nil {
-		driverMgr.Start()
+		driverMgr.SetSinks(conf.Conf().DataCollection.Sinks)
+		if err = driverMgr.Start(ctx); err != nil {
+			log.Println(err)
+		}
+		if interval := conf.Conf().DataCollection.ReloadInterval; interval >= 0 {
+			if interval == 0 {
+				interval = 60
+			}
+			driverMgr.Watch(ctx, time.Duration(interval)*time.Second)
+		}
+		srv = startApi()
 	} else {
 		log.Println(err)
 	}
 
-	select {} // 阻塞主线程
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown(srv)
+			return
+		case <-ch:
+			if driverMgr != nil {
+				log.Println("reload driver config:", driverMgr.Reload())
+			}
+		}
+	}
+}
+
+// shutdown 先停止接收写命令，再停止驱动，驱动退出前会把变量质量置为 Stopped
+func shutdown(srv *http.Server) {
+	log.Println("shutting down")
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("api shutdown", err)
+		}
+	}
+	if driverMgr != nil {
+		if err := driverMgr.Stop(); err != nil {
+			log.Println("driver stop", err)
+		}
+	}
 }
@@ -0,0 +1,85 @@
+package driver
+
+import (
+	"maps"
+	"slices"
+	"sort"
+)
+
+// Block 同一区域中地址连续（或相近）的一段变量，一次批量读取。A 为驱动的区域类型（软元件、存储区）
+type Block[A any] struct {
+	Area   A
+	Start  int
+	Points int // 字数或位数
+	Tags   []*Tag
+}
+
+// Span 变量在 PLC 中的位置，由驱动根据 Tag.Mate 给出
+type Span[A any] struct {
+	Area   A
+	Key    int // 区域代码，相同代码的变量才能合并，块按代码与起始地址排序
+	Start  int
+	Points int
+	Limit  int // 一个块的最大点数
+	Gap    int // 合并时允许跨越的空闲点数
+}
+
+// MergeBlocks 按区域分组，合并地址相近的变量为读取块；未解析或 span 返回 false 的变量跳过
+func MergeBlocks[A any](tags []*Tag, span func(*Tag) (Span[A], bool)) []*Block[A] {
+	type item struct {
+		tag  *Tag
+		span Span[A]
+	}
+	groups := make(map[int][]item)
+	for _, v := range tags {
+		if !v.Parsed {
+			continue
+		}
+		if s, ok := span(v); ok {
+			groups[s.Key] = append(groups[s.Key], item{v, s})
+		}
+	}
+	var blocks []*Block[A]
+	for _, key := range slices.Sorted(maps.Keys(groups)) {
+		group := groups[key]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].span.Start < group[j].span.Start })
+		var current *Block[A]
+		for _, it := range group {
+			s := it.span
+			end := s.Start + s.Points
+			if current != nil && s.Start <= current.Start+current.Points+s.Gap && max(end, current.Start+current.Points)-current.Start <= s.Limit {
+				current.Points = max(end, current.Start+current.Points) - current.Start
+				current.Tags = append(current.Tags, it.tag)
+				continue
+			}
+			current = &Block[A]{Area: s.Area, Start: s.Start, Points: s.Points, Tags: []*Tag{it.tag}}
+			blocks = append(blocks, current)
+		}
+	}
+	return blocks
+}
+
+// PlanCache 各扫描组的读取计划，只在扫描协程中使用
+type PlanCache[P any] struct {
+	plans map[*ScanGroup]P
+}
+
+// Get 返回扫描组 g 的读取计划，没有或 valid 返回 false 时用 build 重新生成，built 表示新生成的计划。
+// groups 为当前的扫描组，热更新重建扫描组后丢弃旧扫描组的计划
+func (c *PlanCache[P]) Get(groups []*ScanGroup, g *ScanGroup, valid func(P) bool, build func() P) (plan P, built bool) {
+	plan, ok := c.plans[g]
+	if ok && (valid == nil || valid(plan)) {
+		return plan, false
+	}
+	if c.plans == nil {
+		c.plans = make(map[*ScanGroup]P)
+	}
+	for k := range c.plans {
+		if !slices.Contains(groups, k) {
+			delete(c.plans, k)
+		}
+	}
+	plan = build()
+	c.plans[g] = plan
+	return plan, true
+}
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMergeBlocks(t *testing.T) {
+	type addr struct {
+		area  string
+		start int
+		size  int
+	}
+	tags := []*Tag{
+		{Name: "a", Parsed: true, Mate: addr{"D", 10, 2}},
+		{Name: "b", Parsed: true, Mate: addr{"D", 0, 1}},
+		{Name: "c", Parsed: true, Mate: addr{"D", 40, 1}},
+		{Name: "d", Parsed: true, Mate: addr{"W", 0, 1}},
+		{Name: "e", Parsed: true, Mate: addr{"D", 49, 4}},
+		{Name: "f", Mate: addr{"D", 1, 1}},
+	}
+	keys := map[string]int{"W": 1, "D": 2}
+	blocks := MergeBlocks(tags, func(v *Tag) (Span[string], bool) {
+		a := v.Mate.(addr)
+		return Span[string]{Area: a.area, Key: keys[a.area], Start: a.start, Points: a.size, Limit: 12, Gap: 10}, true
+	})
+	// 按区域代码与地址排序；超过间隔或最大点数时分块，未解析的变量跳过
+	want := []string{"W 0 1 [d]", "D 0 12 [b a]", "D 40 1 [c]", "D 49 4 [e]"}
+	if len(blocks) != len(want) {
+		t.Fatalf("blocks: %d", len(blocks))
+	}
+	for i, b := range blocks {
+		var names []string
+		for _, v := range b.Tags {
+			names = append(names, v.Name)
+		}
+		if got := fmt.Sprintf("%s %d %d %v", b.Area, b.Start, b.Points, names); got != want[i] {
+			t.Errorf("block %d: %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestPlanCache(t *testing.T) {
+	g1, g2 := &ScanGroup{}, &ScanGroup{}
+	var cache PlanCache[int]
+	builds := 0
+	build := func() int { builds++; return builds }
+	if p, built := cache.Get([]*ScanGroup{g1}, g1, nil, build); !built || p != 1 {
+		t.Fatalf("first: %d %v", p, built)
+	}
+	if p, built := cache.Get([]*ScanGroup{g1}, g1, nil, build); built || p != 1 {
+		t.Fatalf("cached: %d %v", p, built)
+	}
+	if p, _ := cache.Get([]*ScanGroup{g1}, g1, func(p int) bool { return p > 1 }, build); p != 2 {
+		t.Fatalf("invalid: %d", p)
+	}
+	// 扫描组重建后旧扫描组的计划被丢弃
+	cache.Get([]*ScanGroup{g2}, g2, nil, build)
+	if _, ok := cache.plans[g1]; ok || len(cache.plans) != 1 {
+		t.Fatalf("plans: %v", cache.plans)
+	}
+}
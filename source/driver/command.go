@@ -0,0 +1,274 @@
+package driver
+
+import (
+	"acetek-mes/redishelper"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDriverNotFound = errors.New("driver not found")
+	ErrTagNotFound    = errors.New("tag not found")
+	ErrNotWritable    = errors.New("tag is readonly")
+	ErrInvalidValue   = errors.New("invalid value")
+	ErrExpired        = errors.New("command expired")
+	ErrVerify         = errors.New("read back verify failed")
+)
+
+const (
+	// CommandMaxAge 超过该时长的写命令不再执行，避免 dc 重启后下发过期的设定值
+	CommandMaxAge = 30 * time.Second
+	// commandGroup cmd:<driver> stream 的消费组
+	commandGroup = "dc"
+)
+
+// WriteCommand 一条写入命令，来自 cmd:<driver> stream 或 HTTP 接口
+type WriteCommand struct {
+	ID        string      `json:"id"`  // 请求方的命令 ID，结果中原样返回
+	Tag       string      `json:"tag"` // 变量名称
+	Value     interface{} `json:"value"`
+	User      string      `json:"user"`  // 操作人，HTTP 接口由认证填写
+	Confirmer string      `json:"-"`     // 第二确认人，只由 Confirm 填写
+	Source    string      `json:"-"`     // 来源，例如 "http", "redis"
+	Issued    time.Time   `json:"-"`     // 命令产生时间，超过 CommandMaxAge 不执行
+}
+
+// WriteResult 写入结果，同时写入 cmd:<driver>:result stream
+type WriteResult struct {
+	ID        string      `json:"id"`
+	Driver    string      `json:"driver"`
+	Tag       string      `json:"tag"`
+	User      string      `json:"user"`
+	Confirmer string      `json:"confirmer,omitempty"`
+	OK        bool        `json:"ok"`
+	Pending   bool        `json:"pending,omitempty"` // 等待第二人确认
+	Error     string      `json:"error,omitempty"`
+	Previous  interface{} `json:"previous"` // 写入前的值
+	Value     interface{} `json:"value"`    // 请求写入的值
+	ReadBack  interface{} `json:"readback"` // 写入后读回的值
+	Timestamp time.Time   `json:"ts"`
+}
+
+func (r *WriteResult) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"id":        r.ID,
+		"driver":    r.Driver,
+		"tag":       r.Tag,
+		"user":      r.User,
+		"confirmer": r.Confirmer,
+		"ok":        strconv.FormatBool(r.OK),
+		"pending":   strconv.FormatBool(r.Pending),
+		"error":     r.Error,
+		"previous":  fmt.Sprintf("%v", r.Previous),
+		"value":     fmt.Sprintf("%v", r.Value),
+		"readback":  fmt.Sprintf("%v", r.ReadBack),
+		"ts":        r.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// Normalize 将命令中的值（JSON 数字、字符串等）转换为驱动写入接受的形式
+func (t *Tag) Normalize(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, errors.New("value is empty")
+	}
+	switch t.Datatype {
+	case TypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		}
+		if f, ok := ToFloat64(value); ok {
+			return f != 0, nil
+		}
+	case TypeString, TypeChars, TypeWString:
+		return fmt.Sprintf("%v", value), nil
+	case TypeByte, TypeInt16, TypeUInt16, TypeInt32, TypeUInt32, TypeInt64, TypeFloat32, TypeFloat64:
+		f, ok := ToFloat64(value)
+		if !ok {
+			s, isString := value.(string)
+			if !isString {
+				break
+			}
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(s), 64); err != nil {
+				return nil, err
+			}
+		}
+		// 配置了换算时由 Unscale 检查原始值范围
+		if _, _, scaled := t.scaling(); !scaled {
+			if min, max, ok := IntRange(t.Datatype); ok && (f < min || f > max || f != math.Trunc(f)) {
+				return nil, fmt.Errorf("%v out of %s range", value, t.Datatype)
+			}
+		}
+		return f, nil
+	case TypeDTL, TypeDateTime, TypeTime:
+		if v := t.ConvertValue(value); v != nil {
+			return v, nil
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot convert %v (%T) to %s", value, value, t.Datatype)
+}
+
+// Matches 判断读回的值与写入值是否一致，配置换算时允许半个原始量的误差
+func (t *Tag) Matches(value interface{}) bool {
+	if a, ok := t.Value.(time.Time); ok {
+		b, ok := t.ConvertValue(value).(time.Time)
+		return ok && a.Sub(b).Abs() < time.Millisecond
+	}
+	a, aok := ToFloat64(t.Value)
+	b, bok := ToFloat64(value)
+	if aok && bok {
+		tolerance := 1e-6 * math.Max(1, math.Abs(b))
+		if gain, _, scaled := t.scaling(); scaled {
+			tolerance = math.Max(tolerance, math.Abs(gain)/2)
+		}
+		return math.Abs(a-b) <= tolerance
+	}
+	return fmt.Sprintf("%v", t.Value) == fmt.Sprintf("%v", t.ConvertValue(value))
+}
+
+// readBack 写入成功后重新读取包含这些变量的扫描组，并校验读回的值
+func (d *Driver) readBack(values map[string]interface{}, readGroup func(*ScanGroup) (map[string]interface{}, error)) error {
+	for _, g := range d.Groups {
+		for _, v := range g.Tags {
+			if _, ok := values[v.Name]; !ok {
+				continue
+			}
+			_, err := readGroup(g)
+			d.UpdateTags(g.Tags)
+			d.FireTriggers(g.Tags)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrVerify, err)
+			}
+			break
+		}
+	}
+	for name, value := range values {
+		tag, ok := d.Tags[name]
+		if ok && (tag.Quality != "Good" || !tag.Matches(value)) {
+			return fmt.Errorf("%w: %s wrote %v, read %v (%s)", ErrVerify, name, value, tag.Value, tag.Quality)
+		}
+	}
+	return nil
+}
+
+// Execute 执行一条写命令：校验变量可写、转换数值、写入、读回校验，结果写入 cmd:<driver>:result。
+// 需要第二人确认的变量返回 ErrPendingConfirm，命令等待 Confirm
+func (mgr *DriverMgr) Execute(driverID string, cmd WriteCommand) (*WriteResult, error) {
+	result := &WriteResult{ID: cmd.ID, Driver: driverID, Tag: cmd.Tag, User: cmd.User, Confirmer: cmd.Confirmer, Value: cmd.Value}
+	err := mgr.execute(driverID, cmd, result)
+	result.Timestamp = time.Now()
+	result.OK = err == nil
+	result.Pending = errors.Is(err, ErrPendingConfirm)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	log.Printf("write %s.%s = %v by %s: previous %v, readback %v, error %v",
+		driverID, cmd.Tag, cmd.Value, cmd.User, result.Previous, result.ReadBack, err)
+	mgr.publish(result)
+	return result, err
+}
+
+// publish 结果写入 cmd:<driver>:result，未配置 Redis 时只记录日志
+func (mgr *DriverMgr) publish(result *WriteResult) {
+	if !mgr.commands {
+		return
+	}
+	if err := redishelper.Instance().AddStream(fmt.Sprintf("cmd:%s:result", result.Driver), result.fields()); err != nil {
+		log.Println("publish write result:", err)
+	}
+}
+
+func (mgr *DriverMgr) execute(driverID string, cmd WriteCommand, result *WriteResult) error {
+	if !cmd.Issued.IsZero() && time.Since(cmd.Issued) > CommandMaxAge {
+		return fmt.Errorf("%w: issued at %s", ErrExpired, cmd.Issued.Format(time.RFC3339))
+	}
+	c, ok := mgr.client(driverID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrDriverNotFound, driverID)
+	}
+	tag, ok := c.GetTag(cmd.Tag)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTagNotFound, cmd.Tag)
+	}
+	if !tag.Writable {
+		return fmt.Errorf("%w: %s", ErrNotWritable, cmd.Tag)
+	}
+	value, err := tag.Normalize(cmd.Value)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	if tag.Confirm && cmd.Confirmer == "" {
+		return mgr.hold(driverID, cmd)
+	}
+	req := &WriteRequest{
+		Values:    map[string]interface{}{cmd.Tag: value},
+		User:      cmd.User,
+		Confirmer: cmd.Confirmer,
+		Source:    cmd.Source,
+	}
+	err = c.Submit(req)
+	result.Previous = req.Previous[cmd.Tag]
+	result.ReadBack = req.ReadBack[cmd.Tag]
+	return err
+}
+
+// consumeCommands 消费 cmd:<driver> stream 中的写命令，字段为 id、tag、value、user；
+// 确认消息的字段为 confirm（等待确认的命令 ID）与 user（确认人）。stream 由 MES 后端在认证用户后写入，user 按可信处理。
+// 命令执行后立即确认，无论成功与否；确认失败或进程在执行中途退出时，命令留在消费组的待确认列表中，
+// 不会被重新认领或重试（写入可能已经生效，重复下发比丢失更危险），结果以审计记录为准
+func (mgr *DriverMgr) consumeCommands(ctx context.Context, driverID string) {
+	stream := fmt.Sprintf("cmd:%s", driverID)
+	host, _ := os.Hostname()
+	consumer := fmt.Sprintf("%s-%d", host, os.Getpid())
+	for ctx.Err() == nil {
+		messages, err := redishelper.Instance().ReadGroup(stream, commandGroup, consumer, 10, 5*time.Second)
+		if err != nil {
+			log.Println("read command stream", stream, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		for _, m := range messages {
+			user, _ := m.Values["user"].(string)
+			if id, ok := m.Values["confirm"].(string); ok && id != "" {
+				mgr.Confirm(driverID, id, user)
+			} else {
+				cmd := WriteCommand{ID: m.ID, User: user, Source: "redis", Issued: streamTime(m.ID)}
+				if id, ok := m.Values["id"].(string); ok && id != "" {
+					cmd.ID = id
+				}
+				cmd.Tag, _ = m.Values["tag"].(string)
+				if v, ok := m.Values["value"]; ok {
+					cmd.Value = v
+				}
+				mgr.Execute(driverID, cmd)
+			}
+			if err := redishelper.Instance().Ack(stream, commandGroup, m.ID); err != nil {
+				log.Println("ack command", m.ID, err)
+			}
+		}
+	}
+}
+
+// streamTime stream 消息 ID 的毫秒时间部分
+func streamTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,158 @@
+package driver
+
+import (
+	"acetek-mes/model"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memDriver 用内存模拟 PLC，ignore 为 true 时写入不生效
+type memDriver struct {
+	Driver
+	plc    map[string]interface{}
+	ignore bool
+}
+
+func (m *memDriver) readGroup(g *ScanGroup) (map[string]interface{}, error) {
+	for _, v := range g.Tags {
+		v.Value, v.Quality, v.Timestamp = m.plc[v.Name], "Good", time.Now()
+	}
+	return nil, nil
+}
+
+func (m *memDriver) write(values map[string]interface{}) error {
+	for k, v := range values {
+		raw, err := m.Tags[k].Unscale(v)
+		if err != nil {
+			return err
+		}
+		if !m.ignore {
+			m.plc[k] = m.Tags[k].Scale(m.Tags[k].ConvertValue(raw))
+		}
+	}
+	return nil
+}
+
+func (m *memDriver) Read() (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (m *memDriver) Write(name string, value interface{}) error {
+	return m.Submit(&WriteRequest{Values: map[string]interface{}{name: value}})
+}
+
+func (m *memDriver) Start(ctx context.Context) error {
+	return m.Go(ctx, m.readGroup, m.write, func() {})
+}
+
+// newMemDriver 启动一个内存驱动，审计记录写入 audits
+func newMemDriver(t *testing.T, plc map[string]interface{}, tags map[string]*Tag) (*memDriver, *[]*model.WriteAudit) {
+	m := &memDriver{
+		plc: plc,
+		Driver: Driver{
+			ID:            "plc1",
+			Interval:      60000,
+			Tags:          tags,
+			ChCommand:     make(chan string, 1),
+			ChWrite:       make(chan *WriteRequest, 1),
+			ChWriteResult: make(chan error),
+			ChConfig:      make(chan *Config, 1),
+		},
+	}
+	audits := new([]*model.WriteAudit)
+	save := saveAudit
+	saveAudit = func(a *model.WriteAudit) error {
+		*audits = append(*audits, a)
+		return nil
+	}
+	m.InitScanGroups()
+	if err := m.Go(context.Background(), m.readGroup, m.write, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m.Stop()
+		saveAudit = save
+	})
+	return m, audits
+}
+
+func TestExecute(t *testing.T) {
+	m, audits := newMemDriver(t,
+		map[string]interface{}{"设定时间": int16(0), "运行": false, "线号": int16(3)},
+		map[string]*Tag{
+			"设定时间": {Name: "设定时间", Datatype: TypeInt16, Writable: true, Gain: 0.1},
+			"运行":   {Name: "运行", Datatype: TypeBool, Writable: true},
+			"线号":   {Name: "线号", Datatype: TypeInt16},
+		})
+	mgr := &DriverMgr{clients: map[string]IDriver{"plc1": m}}
+
+	result, err := mgr.Execute("plc1", WriteCommand{ID: "1", Tag: "设定时间", Value: "12.5", User: "张三"})
+	if err != nil || !result.OK || result.ReadBack != 12.5 || m.plc["设定时间"] != 12.5 {
+		t.Fatalf("write: %+v %v", result, err)
+	}
+	if len(*audits) != 1 || (*audits)[0].User != "张三" || (*audits)[0].Result != "ok" || (*audits)[0].ReadBack != "12.5" {
+		t.Fatalf("audit: %+v", *audits)
+	}
+	if result, err = mgr.Execute("plc1", WriteCommand{Tag: "运行", Value: float64(1)}); err != nil || result.ReadBack != true {
+		t.Fatalf("bool: %+v %v", result, err)
+	}
+
+	checks := []struct {
+		cmd  WriteCommand
+		want error
+	}{
+		{WriteCommand{Tag: "线号", Value: 4}, ErrNotWritable},
+		{WriteCommand{Tag: "不存在", Value: 4}, ErrTagNotFound},
+		{WriteCommand{Tag: "运行", Value: "maybe"}, ErrInvalidValue},
+		{WriteCommand{Tag: "设定时间", Value: 1, Issued: time.Now().Add(-time.Minute)}, ErrExpired},
+	}
+	for _, c := range checks {
+		if _, err := mgr.Execute("plc1", c.cmd); !errors.Is(err, c.want) {
+			t.Errorf("%+v: got %v, want %v", c.cmd, err, c.want)
+		}
+	}
+	if _, err := mgr.Execute("plc2", WriteCommand{Tag: "运行", Value: true}); !errors.Is(err, ErrDriverNotFound) {
+		t.Errorf("driver: %v", err)
+	}
+
+	m.ignore = true
+	if result, err = mgr.Execute("plc1", WriteCommand{Tag: "设定时间", Value: 30}); !errors.Is(err, ErrVerify) || result.Previous != 12.5 {
+		t.Fatalf("verify: %+v %v", result, err)
+	}
+}
+
+// 扫描协程替换变量时执行写命令，用 -race 检查
+func TestExecuteDuringReconfig(t *testing.T) {
+	m, _ := newMemDriver(t, map[string]interface{}{"设定时间": int16(0)}, map[string]*Tag{
+		"设定时间": {Name: "设定时间", Datatype: TypeInt16, Writable: true},
+	})
+	mgr := &DriverMgr{clients: map[string]IDriver{"plc1": m}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			m.Reconfig(&Config{Tags: []*Tag{{Name: "设定时间", Datatype: TypeInt16, Writable: true}}})
+		}
+	}()
+	for i := range 20 {
+		if _, err := mgr.Execute("plc1", WriteCommand{Tag: "设定时间", Value: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestNormalize(t *testing.T) {
+	tag := &Tag{Datatype: TypeUInt16}
+	if _, err := tag.Normalize(70000); err == nil {
+		t.Error("expect range error")
+	}
+	if _, err := tag.Normalize(1.5); err == nil {
+		t.Error("expect integer error")
+	}
+	if v, err := tag.Normalize("65535"); err != nil || v != float64(65535) {
+		t.Errorf("string: %v %v", v, err)
+	}
+}
@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrPendingConfirm = errors.New("waiting for confirmation")
+	ErrNoPending      = errors.New("pending command not found")
+)
+
+// ConfirmTimeout 等待第二人确认的写命令超过该时长作废
+const ConfirmTimeout = 5 * time.Minute
+
+// pendingWrite 等待第二人确认的写命令
+type pendingWrite struct {
+	driver string
+	cmd    WriteCommand
+	held   time.Time
+}
+
+// hold 需要第二人确认的命令先保存，由另一个人通过 Confirm 单独确认后再执行
+func (mgr *DriverMgr) hold(driverID string, cmd WriteCommand) error {
+	if cmd.User == "" {
+		return fmt.Errorf("%w: %s: 需要第二人确认的变量必须指定操作人", ErrInterlock, cmd.Tag)
+	}
+	mgr.pendingMu.Lock()
+	defer mgr.pendingMu.Unlock()
+	mgr.expirePending()
+	if _, ok := mgr.pending[cmd.ID]; ok {
+		return fmt.Errorf("%w: duplicate command id %s", ErrInvalidValue, cmd.ID)
+	}
+	if mgr.pending == nil {
+		mgr.pending = make(map[string]*pendingWrite)
+	}
+	mgr.pending[cmd.ID] = &pendingWrite{driver: driverID, cmd: cmd, held: time.Now()}
+	return fmt.Errorf("%w: %s", ErrPendingConfirm, cmd.ID)
+}
+
+// expirePending 删除超时的命令，调用方持有 pendingMu
+func (mgr *DriverMgr) expirePending() {
+	for id, p := range mgr.pending {
+		if time.Since(p.held) > ConfirmTimeout {
+			delete(mgr.pending, id)
+		}
+	}
+}
+
+// Confirm 第二人确认等待中的写命令并执行。confirmer 由调用方认证，不能与操作人相同；
+// 确认人不符时命令继续等待
+func (mgr *DriverMgr) Confirm(driverID, id, confirmer string) (*WriteResult, error) {
+	mgr.pendingMu.Lock()
+	mgr.expirePending()
+	p, ok := mgr.pending[id]
+	var err error
+	switch {
+	case !ok || p.driver != driverID:
+		err = fmt.Errorf("%w: %s", ErrNoPending, id)
+	case confirmer == "" || confirmer == p.cmd.User:
+		err = fmt.Errorf("%w: %s: 确认人不能与操作人相同", ErrInterlock, p.cmd.Tag)
+	default:
+		delete(mgr.pending, id)
+	}
+	mgr.pendingMu.Unlock()
+	if err != nil {
+		result := &WriteResult{ID: id, Driver: driverID, Confirmer: confirmer, Error: err.Error(), Timestamp: time.Now()}
+		if ok {
+			result.Tag, result.User, result.Value = p.cmd.Tag, p.cmd.User, p.cmd.Value
+		}
+		mgr.publish(result)
+		return result, err
+	}
+	cmd := p.cmd
+	cmd.Confirmer = confirmer
+	cmd.Issued = time.Now()
+	return mgr.Execute(driverID, cmd)
+}
+
+// Pending 驱动中等待确认的命令，按保存时间排序
+func (mgr *DriverMgr) Pending(driverID string) []WriteCommand {
+	mgr.pendingMu.Lock()
+	defer mgr.pendingMu.Unlock()
+	mgr.expirePending()
+	var list []*pendingWrite
+	for _, p := range mgr.pending {
+		if p.driver == driverID {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].held.Before(list[j].held) })
+	cmds := make([]WriteCommand, len(list))
+	for i, p := range list {
+		cmds[i] = p.cmd
+	}
+	return cmds
+}
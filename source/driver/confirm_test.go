@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	m, audits := newMemDriver(t,
+		map[string]interface{}{"规格": ""},
+		map[string]*Tag{
+			"规格": {Name: "规格", Datatype: TypeString, Writable: true, Confirm: true},
+		})
+	mgr := &DriverMgr{clients: map[string]IDriver{"plc1": m}}
+
+	// 需要确认的写入先保存，不写入 PLC
+	result, err := mgr.Execute("plc1", WriteCommand{ID: "1", Tag: "规格", Value: "A", User: "张三"})
+	if !errors.Is(err, ErrPendingConfirm) || !result.Pending || m.plc["规格"] != "" || len(*audits) != 0 {
+		t.Fatalf("hold: %+v %v", result, err)
+	}
+	if _, err := mgr.Execute("plc1", WriteCommand{ID: "2", Tag: "规格", Value: "B"}); !errors.Is(err, ErrInterlock) {
+		t.Fatalf("anonymous: %v", err)
+	}
+	if pending := mgr.Pending("plc1"); len(pending) != 1 || pending[0].ID != "1" {
+		t.Fatalf("pending: %+v", pending)
+	}
+
+	// 操作人自己确认或确认其它驱动无效，命令继续等待
+	if _, err := mgr.Confirm("plc1", "1", "张三"); !errors.Is(err, ErrInterlock) {
+		t.Fatalf("self confirm: %v", err)
+	}
+	if _, err := mgr.Confirm("plc2", "1", "李四"); !errors.Is(err, ErrNoPending) {
+		t.Fatalf("other driver: %v", err)
+	}
+	result, err = mgr.Confirm("plc1", "1", "李四")
+	if err != nil || !result.OK || result.Confirmer != "李四" || m.plc["规格"] != "A" {
+		t.Fatalf("confirm: %+v %v", result, err)
+	}
+	if len(*audits) != 1 || (*audits)[0].User != "张三" || (*audits)[0].Confirmer != "李四" {
+		t.Fatalf("audit: %+v", *audits)
+	}
+	// 只能确认一次
+	if _, err := mgr.Confirm("plc1", "1", "李四"); !errors.Is(err, ErrNoPending) {
+		t.Fatalf("confirm twice: %v", err)
+	}
+}
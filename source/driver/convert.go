@@ -23,7 +23,42 @@ func BytesToFloat64(b []byte) float64 {
 	return math.Float64frombits(bits)
 }
 
-
 func GetBit(b byte, bit int) bool {
 	return b&(1<<bit) != 0
+}
+
+// ToFloat64 数值及 bool 类型转换为 float64，其余类型返回 false
+func ToFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
 }
\ No newline at end of file
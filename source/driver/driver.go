@@ -1,7 +1,7 @@
 package driver
 
 import (
-	"acetek-mes/redishelper"
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -13,23 +13,42 @@ type IDriver interface {
 	IsConnected() bool
 	Read() (map[string]interface{}, error)
 	Write(tag string, value interface{}) error
-	Start() error
+	Start(ctx context.Context) error
 	Stop() error
-	Reconfig() error
+	Reconfig(cfg *Config) error
+	GetTag(name string) (*Tag, bool)
+	Submit(req *WriteRequest) error
 }
 
 type Driver struct {
 	ID            string
 	Name          string
 	Tags          map[string]*Tag
+	tagsMu        sync.RWMutex // 扫描协程替换 Tags 时加写锁，其他协程读取 Tags 时加读锁
 	Mu            sync.Mutex
 	Connected     bool
 	LastPing      time.Time
 	Interval      uint32
 	ChCommand     chan string
-	ChWrite       chan map[string]interface{}
+	ChWrite       chan *WriteRequest
 	ChWriteResult chan error
+	ChConfig      chan *Config
+	writeMu       sync.Mutex
+	lifeMu        sync.Mutex
+	cancel        context.CancelFunc
+	done          chan struct{}
 	FailCount     int
+	Groups        []*ScanGroup
+	Triggers      []*Trigger
+
+	events        []*Event
+	triggerLoaded bool
+
+	status     Status
+	statusMu   sync.Mutex
+	disconnect func()
+	sinks      []Sink
+	retry      [][]Point // 各输出的重发队列，与 sinks 对应，只在扫描协程中使用
 }
 
 func (d *Driver) Connect() error {
@@ -47,24 +66,88 @@ func (d *Driver) Read([]*Tag) (map[string]interface{}, error) {
 func (d *Driver) Write(map[string]interface{}) error {
 	return nil
 }
-func (d *Driver) Start() error {
-	return nil
+
+// Submit 把写入请求交给扫描协程执行并等待结果，并发调用时逐个提交，保证结果与请求对应
+func (d *Driver) Submit(req *WriteRequest) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	done := d.running()
+	if done == nil {
+		return ErrStopped
+	}
+	select {
+	case d.ChWrite <- req:
+	case <-done:
+		return ErrStopped
+	}
+	select {
+	case err := <-d.ChWriteResult:
+		return err
+	case <-done:
+		return ErrStopped
+	}
 }
-func (d *Driver) Stop() error {
-	d.ChCommand <- "stop"
-	return nil
+
+// TagMap 按名称索引变量
+func TagMap(tags []*Tag) map[string]*Tag {
+	result := make(map[string]*Tag, len(tags))
+	for _, v := range tags {
+		result[v.Name] = v
+	}
+	return result
 }
-func (d *Driver) Reconfig() error {
-	d.ChCommand <- "reconfig"
-	return nil
+
+// GetTag 可在扫描协程外调用，返回的变量只能读取配置部分，值与质量由扫描协程更新
+func (d *Driver) GetTag(name string) (*Tag, bool) {
+	d.tagsMu.RLock()
+	defer d.tagsMu.RUnlock()
+	tag, ok := d.Tags[name]
+	return tag, ok
 }
 
 func (d *Driver) Update() error {
-	for _, v := range d.Tags{
-		err := redishelper.Instance().SetRealtime(d.ID, v.Name, v.Value, v.Quality, v.Timestamp)
-		if err != nil{
-			log.Println("set realtime",err)
+	tags := make([]*Tag, 0, len(d.Tags))
+	for _, v := range d.Tags {
+		tags = append(tags, v)
+	}
+	return d.UpdateTags(tags)
+}
+
+// UpdateTags 按变化上报到各输出：超过死区或心跳到期时写历史（Redis 为 stream），值有变化时只刷新最新值。
+// 需要上报的变量合并为一批写入
+func (d *Driver) UpdateTags(tags []*Tag) error {
+	now := time.Now()
+	var batch []*Tag
+	var points []Point
+	for _, v := range tags {
+		stream, hash := v.reportDecision(now)
+		if stream || hash {
+			p := v.Point()
+			p.Stream = stream
+			batch = append(batch, v)
+			points = append(points, p)
 		}
 	}
+	if len(points) == 0 {
+		return nil
+	}
+	failed := 0
+	var first error
+	for i, err := range d.emitBatch(points) {
+		if err != nil {
+			if failed++; first == nil {
+				first = err
+			}
+			continue
+		}
+		if points[i].Stream {
+			batch[i].reportedStream(now)
+		} else {
+			batch[i].reportedHash()
+		}
+	}
+	if failed > 0 {
+		log.Printf("set realtime %s: %d of %d failed: %v", d.ID, failed, len(points), first)
+	}
 	return nil
 }
@@ -2,49 +2,315 @@ package driver
 
 import (
 	"acetek-mes/model"
+	"acetek-mes/redishelper"
 	"acetek-mes/valconv"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/yxcloud1/go-comm/db"
 	"github.com/yxcloud1/go-comm/logger"
 )
 
 type DriverMgr struct {
-	drivers []string
-	clients map[string]IDriver
+	drivers   []string
+	clients   map[string]IDriver
+	configs   map[string]*driverConfig
+	consumers map[string]context.CancelFunc // 驱动 ID -> 停止写命令消费协程
+	commands  bool // 配置了 Redis 时消费 cmd:<driver> 写命令并发布结果
+	pending   map[string]*pendingWrite // 命令 ID -> 等待第二人确认的写命令
+	pendingMu sync.Mutex
+	sinkURLs  map[string][]string
+	sinks     map[string]Sink // 按 URL 共用
+	started   bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mu        sync.RWMutex
+	reloadMu  sync.Mutex
+}
+
+// driverConfig 数据库中一个驱动的配置，fingerprint 用于判断变量与触发器是否变化
+type driverConfig struct {
+	ID          string
+	Name        string
+	Url         string
+	Tags        []*Tag
+	Triggers    []*Trigger
+	fingerprint string
 }
 
 func NewDriverMgr(drivers []string) (*DriverMgr, error) {
 	result := &DriverMgr{
 		clients: make(map[string]IDriver),
+		configs: make(map[string]*driverConfig),
+		consumers: make(map[string]context.CancelFunc),
+		sinks: make(map[string]Sink),
 		drivers: drivers,
+		commands: redishelper.Instance().Configured(),
+	}
+	if configs, err := loadConfigs(drivers); err != nil{
+		logger.TxtErr(err)
+	}else{
+		for _, cfg := range configs{
+			result.add(cfg)
+		}
 	}
-	result.load()
 	return result, nil
 }
 
-func (mgr *DriverMgr) Start() {
+// Start 启动所有驱动，ctx 取消时驱动随之停止；Reload 新增的驱动同样使用该 ctx
+func (mgr *DriverMgr) Start(ctx context.Context) error {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if mgr.started{
+		return ErrAlreadyStarted
+	}
+	mgr.ctx, mgr.cancel = context.WithCancel(ctx)
+	mgr.started = true
+	var errs []error
 	for k, v := range mgr.clients{
-		log.Println("start plc driver ", k)
-		v.Start()
+		if err := mgr.start(k, v); err != nil{
+			errs = append(errs, fmt.Errorf("driver %s: %w", k, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetSinks 配置各驱动的输出：驱动 ID -> 输出 URL 列表，"*" 为未单独配置的驱动，都没有时写 Redis。
+// 在 Start 之前调用，Reload 新增或替换的驱动同样生效
+func (mgr *DriverMgr) SetSinks(cfg map[string][]string) {
+	mgr.mu.Lock()
+	mgr.sinkURLs = cfg
+	mgr.mu.Unlock()
+	mgr.openSinks()
+}
+
+// openSinks 创建还没有创建（或上次创建失败）的输出，同一 URL 只创建一次。
+// MQTT、InfluxDB 输出创建时可能要连接服务器，不持有 mgr.mu，创建后再加锁放入
+func (mgr *DriverMgr) openSinks() {
+	mgr.mu.RLock()
+	var missing []string
+	for _, urls := range mgr.sinkURLs{
+		for _, u := range urls{
+			if _, ok := mgr.sinks[u]; !ok && !slices.Contains(missing, u){
+				missing = append(missing, u)
+			}
+		}
+	}
+	mgr.mu.RUnlock()
+	created := make(map[string]Sink)
+	for _, u := range missing{
+		s, err := NewSink(u)
+		if err != nil{
+			logger.TxtErr(fmt.Errorf("sink %s: %w", u, err))
+			continue
+		}
+		created[u] = s
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	for u, s := range created{
+		if _, ok := mgr.sinks[u]; ok{
+			// 同时进行的 Reload 已经创建
+			s.Close()
+			continue
+		}
+		mgr.sinks[u] = s
+	}
+}
+
+// sinksFor 驱动的输出，只取已创建的输出，调用方持有 mgr.mu
+func (mgr *DriverMgr) sinksFor(id string) []Sink {
+	urls, ok := mgr.sinkURLs[id]
+	if !ok{
+		urls, ok = mgr.sinkURLs["*"]
+	}
+	if !ok{
+		return nil
+	}
+	sinks := make([]Sink, 0, len(urls))
+	for _, u := range urls{
+		if s, ok := mgr.sinks[u]; ok{
+			sinks = append(sinks, s)
+		}
+	}
+	return sinks
+}
+
+func (mgr *DriverMgr) start(id string, c IDriver) error {
+	log.Println("start plc driver ", id)
+	if s, ok := c.(interface{ SetSinks([]Sink) }); ok{
+		s.SetSinks(mgr.sinksFor(id))
+	}
+	if err := c.Start(mgr.ctx); err != nil{
+		return err
+	}
+	if _, ok := mgr.consumers[id]; !ok && mgr.commands{
+		ctx, cancel := context.WithCancel(mgr.ctx)
+		mgr.consumers[id] = cancel
+		go mgr.consumeCommands(ctx, id)
+	}
+	return nil
+}
+
+func (mgr *DriverMgr) client(id string) (IDriver, bool) {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+	c, ok := mgr.clients[id]
+	return c, ok
+}
+
+// add 创建驱动，管理器已启动时立即启动
+func (mgr *DriverMgr) add(cfg *driverConfig) {
+	u, err := url.Parse(cfg.Url)
+	if err != nil{
+		logger.TxtErr(err)
+		return
+	}
+	c, err := NewDriver(cfg.ID, cfg.Name, u.Scheme, cfg.Url, cfg.Tags)
+	if err != nil{
+		logger.TxtErr(err)
+		return
+	}
+	if t, ok := c.(interface{ SetTriggers([]*Trigger) }); ok{
+		t.SetTriggers(cfg.Triggers)
+	}
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	mgr.clients[cfg.ID] = c
+	mgr.configs[cfg.ID] = cfg
+	if mgr.started{
+		if err := mgr.start(cfg.ID, c); err != nil{
+			logger.TxtErr(err)
+		}
+	}
+}
+
+func (mgr *DriverMgr) remove(id string) {
+	mgr.mu.Lock()
+	c, ok := mgr.clients[id]
+	delete(mgr.clients, id)
+	delete(mgr.configs, id)
+	if cancel, ok := mgr.consumers[id]; ok{
+		// 正在等待的 XREADGROUP 最多阻塞 5 秒后退出
+		cancel()
+		delete(mgr.consumers, id)
+	}
+	started := mgr.started
+	mgr.mu.Unlock()
+	if ok && started{
+		log.Println("stop plc driver ", id)
+		c.Stop()
 	}
 }
 
-func (mgr *DriverMgr) load() error {
+// Reload 重新读取数据库配置并与运行中的驱动比较：新增的启动，删除的停止，
+// 连接参数变化的替换，只有变量或触发器变化的通过 Reconfig 应用，不重连 PLC
+func (mgr *DriverMgr) Reload() error {
+	mgr.reloadMu.Lock()
+	defer mgr.reloadMu.Unlock()
+
+	// 上次创建失败的输出（例如 MQTT 服务器不可达）重新创建，新增或替换的驱动可以使用；Stop 后输出已关闭，不再创建
+	mgr.mu.RLock()
+	started := mgr.started
+	mgr.mu.RUnlock()
+	if started{
+		mgr.openSinks()
+	}
+	configs, err := loadConfigs(mgr.drivers)
+	if err != nil{
+		return err
+	}
+	mgr.mu.RLock()
+	current := make(map[string]*driverConfig, len(mgr.configs))
+	for k, v := range mgr.configs{
+		current[k] = v
+	}
+	mgr.mu.RUnlock()
+
+	for id := range current{
+		if _, ok := configs[id]; !ok{
+			log.Println("reload: remove driver", id)
+			mgr.remove(id)
+		}
+	}
+	for id, cfg := range configs{
+		old, ok := current[id]
+		switch {
+		case !ok:
+			log.Println("reload: add driver", id)
+			mgr.add(cfg)
+		case old.Url != cfg.Url || old.Name != cfg.Name:
+			log.Println("reload: replace driver", id)
+			mgr.remove(id)
+			mgr.add(cfg)
+		case old.fingerprint != cfg.fingerprint:
+			c, ok := mgr.client(id)
+			if !ok{
+				continue
+			}
+			mgr.mu.RLock()
+			started := mgr.started
+			mgr.mu.RUnlock()
+			if !started{
+				// 扫描协程未运行，直接重建
+				mgr.remove(id)
+				mgr.add(cfg)
+				continue
+			}
+			log.Println("reload: reconfig driver", id)
+			if err := c.Reconfig(&Config{Tags: cfg.Tags, Triggers: cfg.Triggers}); err != nil{
+				logger.TxtErr(err)
+				continue
+			}
+			mgr.mu.Lock()
+			mgr.configs[id] = cfg
+			mgr.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// Watch 每隔 interval 检查一次数据库配置，ctx 取消后退出
+func (mgr *DriverMgr) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select{
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := mgr.Reload(); err != nil{
+					logger.TxtErr(err)
+				}
+			}
+		}
+	}()
+}
+
+func loadConfigs(ids []string) (map[string]*driverConfig, error) {
 
 	var drivers[] model.DCDriver
-	tx := db.DB().Conn().Debug().Find(&drivers, mgr.drivers)
+	tx := db.DB().Conn().Find(&drivers, ids)
 	if tx.Error != nil{
-		return tx.Error
+		return nil, tx.Error
 	}
+	configs := make(map[string]*driverConfig)
 	for _, v := range drivers{
 		var items[] model.DCItem
-		tx = db.DB().Conn().Debug().Where(&model.DCItem{
+		tx = db.DB().Conn().Where(&model.DCItem{
 			DriverID: v.ID,
 		}).Find(&items)
 		if tx.Error != nil{
-			return tx.Error
+			return nil, tx.Error
 		}
 		var tags []*Tag
 		for _, item := range items{
@@ -54,6 +320,22 @@ func (mgr *DriverMgr) load() error {
 				Datatype: item.DataType,
 				Writable: item.Writable,
 				Comment: item.Description,
+				ScanClass: item.ScanClass,
+				Deadband: item.Deadband,
+				DeadbandPercent: item.DeadbandPct,
+				Heartbeat: uint32(max(item.Heartbeat, 0)),
+				RawMin: item.RawMin,
+				RawMax: item.RawMax,
+				EngMin: item.EngMin,
+				EngMax: item.EngMax,
+				Gain: item.Gain,
+				Offset: item.Offset,
+				Clamp: item.Clamp,
+				Unit: item.Unit,
+				WriteMin: item.WriteMin,
+				WriteMax: item.WriteMax,
+				Interlock: item.Interlock,
+				Confirm: item.Confirm,
 				Value: func()interface{}{
 					if item.DataType != "string" && item.Value == ""{
 						return nil
@@ -67,21 +349,98 @@ func (mgr *DriverMgr) load() error {
 				}(),
 			})
 		}
-		if u, err := url.Parse(v.Url); err != nil{
-			logger.TxtErr(err)
-			continue;
-		}else{
-			if c,  err := NewDriver(v.ID, v.Name, u.Scheme, v.Url, tags);err == nil{
-				mgr.clients[v.ID] = c
-			}else{
-				logger.TxtErr(err)
-			}
+		triggers, err := loadTriggers(v.ID)
+		if err != nil{
+			return nil, err
+		}
+		configs[v.ID] = &driverConfig{
+			ID: v.ID,
+			Name: v.Name,
+			Url: v.Url,
+			Tags: tags,
+			Triggers: triggers,
+			fingerprint: fingerprint(tags, triggers),
 		}
 	}
-	return nil
+	return configs, nil
 }
 
+// fingerprint 变量与触发器配置的摘要，不含变量的值
+func fingerprint(tags []*Tag, triggers []*Trigger) string {
+	var lines []string
+	for _, v := range tags{
+		lines = append(lines, fmt.Sprintf("%+v", v.config()))
+	}
+	for _, v := range triggers{
+		lines = append(lines, fmt.Sprintf("%+v", *v))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func loadTriggers(driverID string) ([]*Trigger, error) {
+	var items []model.DCTrigger
+	tx := db.DB().Conn().Where(&model.DCTrigger{
+		DriverID: driverID,
+		Enabled: true,
+	}).Find(&items)
+	if tx.Error != nil{
+		return nil, tx.Error
+	}
+	var triggers []*Trigger
+	for _, item := range items{
+		triggers = append(triggers, &Trigger{
+			Name: item.Name,
+			Tag: item.Tag,
+			Edge: item.Edge,
+			Snapshot: ParseSnapshot(item.Snapshot),
+		})
+	}
+	return triggers, nil
+}
+
+// Stop 并行停止所有驱动，等待扫描协程退出、连接关闭、最终质量上报完成
 func (mgr *DriverMgr) Stop() error {
+	mgr.reloadMu.Lock()
+	defer mgr.reloadMu.Unlock()
+	mgr.mu.Lock()
+	if !mgr.started{
+		mgr.mu.Unlock()
+		return nil
+	}
+	mgr.started = false
+	mgr.cancel()
+	mgr.consumers = make(map[string]context.CancelFunc)
+	clients := make(map[string]IDriver, len(mgr.clients))
+	for k, v := range mgr.clients{
+		clients[k] = v
+	}
+	mgr.mu.Unlock()
 
-	return nil
+	var wg sync.WaitGroup
+	errs := make([]error, 0, len(clients))
+	var errMu sync.Mutex
+	for k, v := range clients{
+		wg.Add(1)
+		go func(id string, c IDriver) {
+			defer wg.Done()
+			if err := c.Stop(); err != nil{
+				errMu.Lock()
+				errs = append(errs, fmt.Errorf("driver %s: %w", id, err))
+				errMu.Unlock()
+			}
+		}(k, v)
+	}
+	wg.Wait()
+
+	// 驱动停止后最终质量已经上报，再关闭输出
+	mgr.mu.Lock()
+	for u, s := range mgr.sinks{
+		if err := s.Close(); err != nil{
+			errs = append(errs, fmt.Errorf("sink %s: %w", u, err))
+		}
+		delete(mgr.sinks, u)
+	}
+	mgr.mu.Unlock()
+	return errors.Join(errs...)
 }
@@ -1,6 +1,38 @@
 package driver
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 func TestMgr(t *testing.T){
 
-}
\ No newline at end of file
+}
+// 删除驱动时停止其写命令消费协程，重新启动后再创建
+func TestRemoveConsumer(t *testing.T) {
+	m := &memDriver{plc: map[string]interface{}{}, Driver: Driver{
+		ID:            "plc1",
+		Interval:      60000,
+		Tags:          map[string]*Tag{},
+		ChCommand:     make(chan string, 1),
+		ChWrite:       make(chan *WriteRequest, 1),
+		ChWriteResult: make(chan error),
+		ChConfig:      make(chan *Config, 1),
+	}}
+	mgr := &DriverMgr{
+		clients:   map[string]IDriver{"plc1": m},
+		configs:   map[string]*driverConfig{},
+		consumers: map[string]context.CancelFunc{},
+		commands:  true,
+	}
+	if err := mgr.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Stop()
+	if _, ok := mgr.consumers["plc1"]; !ok {
+		t.Fatal("consumer not started")
+	}
+	mgr.remove("plc1")
+	if len(mgr.consumers) != 0 {
+		t.Fatalf("consumers: %v", mgr.consumers)
+	}
+}
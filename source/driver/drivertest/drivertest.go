@@ -0,0 +1,84 @@
+// Package drivertest 驱动测试共用的模拟服务端与检查，仅用于测试
+package drivertest
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"acetek-mes/driver"
+)
+
+// Listen 在本地随机端口启动模拟服务端，每个连接交给 serve，测试结束时关闭
+func Listen(t *testing.T, serve func(net.Conn)) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// NewClient 按 URL 创建已注册的驱动，测试结束时断开
+func NewClient(t *testing.T, rawURL string, tags []*driver.Tag) driver.IDriver {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := driver.NewDriver("TEST", "", u.Scheme, rawURL, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Disconnect() })
+	return c
+}
+
+// CheckRead 读取一次，检查各变量的值与类型
+func CheckRead(t *testing.T, c driver.IDriver, want map[string]interface{}) {
+	t.Helper()
+	values, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s: got %v (%T), want %v", name, values[name], values[name], v)
+		}
+	}
+}
+
+// CheckEndCode 结束代码异常只影响 bad 所在请求内的变量，good 的质量不受影响，连接保持
+func CheckEndCode(t *testing.T, c driver.IDriver, bad, good string) {
+	t.Helper()
+	values, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag, _ := c.GetTag(bad); values[bad] != nil || tag.Quality != "Bad" {
+		t.Fatalf("%s: %v %s", bad, values[bad], tag.Quality)
+	}
+	if tag, _ := c.GetTag(good); tag.Quality != "Good" || !c.IsConnected() {
+		t.Fatal("other tags affected")
+	}
+}
+
+// CheckInvalid 地址 -> 数据类型，都应解析失败
+func CheckInvalid(t *testing.T, invalid map[string]string, parse func(address, datatype string) error) {
+	t.Helper()
+	for address, datatype := range invalid {
+		if err := parse(address, datatype); err == nil {
+			t.Errorf("%s %s: expect error", address, datatype)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package fins
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"acetek-mes/driver"
+
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+const defaultGap = 32 // 合并相邻地址时允许跨越的空闲字数
+
+// FinsClient 欧姆龙 FINS/TCP 驱动，适用于 CS/CJ/NJ 系列
+type FinsClient struct {
+	driver.Driver
+	transport *transport
+	addr      string
+	gap       int
+	plans     driver.PlanCache[*readPlan]
+}
+
+// readBlock 同一区域中地址连续（或相近）的一段
+type readBlock = driver.Block[Area]
+
+// readPlan 连续的块逐块读取，只有一个字的块合并为复合读取
+type readPlan struct {
+	blocks []*readBlock
+	multi  [][]*readBlock
+}
+
+func (p *readPlan) requests() int {
+	return len(p.blocks) + len(p.multi)
+}
+
+// fins://host:9600?node=0&timeout=2000&interval=1000&gap=32
+func NewFinsClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	port := u.Port()
+	if port == "" {
+		port = "9600"
+	}
+	t := &transport{
+		address: fmt.Sprintf("%s:%s", u.Hostname(), port),
+		timeout: 2 * time.Second,
+	}
+	if s := q.Get("node"); s != "" {
+		v, err := strconv.ParseUint(s, 0, 8)
+		if err != nil || v > 254 {
+			return nil, fmt.Errorf("invalid node: %s", s)
+		}
+		t.node = byte(v)
+	}
+	if s := q.Get("timeout"); s != "" {
+		if ms, err := strconv.Atoi(s); err == nil && ms > 0 {
+			t.timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	var interval uint32 = 1000
+	if i := q.Get("interval"); i != "" {
+		if intval, err := strconv.Atoi(i); err == nil {
+			interval = uint32(intval)
+		}
+	}
+	gap := defaultGap
+	if g := q.Get("gap"); g != "" {
+		if intval, err := strconv.Atoi(g); err == nil && intval >= 0 {
+			gap = intval
+		}
+	}
+
+	c := &FinsClient{
+		transport: t,
+		addr:      rawURL,
+		gap:       gap,
+		Driver: driver.Driver{
+			ID:            id,
+			Name:          name,
+			Tags:          driver.TagMap(parseTags(tags)),
+			Interval:      interval,
+			ChCommand:     make(chan string, 100),
+			ChWrite:       make(chan *driver.WriteRequest, 100),
+			ChWriteResult: make(chan error),
+			ChConfig:      make(chan *driver.Config, 1),
+		},
+	}
+	c.InitScanGroups()
+	return c, nil
+}
+
+// parseTags 解析变量地址，返回解析成功的变量
+func parseTags(tags []*driver.Tag) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if ft, err := ParseAddress(v.Address, v.Datatype); err == nil {
+			v.Parsed = true
+			v.Mate = *ft
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse address error:", err)
+		}
+	}
+	return result
+}
+
+// 注册欧姆龙 FINS 驱动
+func init() {
+	logger.TxtLog("register driver fins")
+	driver.RegisterDriver("fins", NewFinsClient)
+}
+
+func (c *FinsClient) Connect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if c.Connected {
+		return nil
+	}
+	if err := c.transport.Connect(); err != nil {
+		return err
+	}
+	c.Connected = true
+	return nil
+}
+
+func (c *FinsClient) Disconnect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = false
+	return c.transport.Close()
+}
+
+func (c *FinsClient) IsConnected() bool {
+	return c.Connected
+}
+
+func (c *FinsClient) reconnectIfNeeded() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if !c.Connected {
+		c.transport.Close()
+		if err := c.transport.Connect(); err != nil {
+			return fmt.Errorf("%w: %v", driver.ErrConnection, err)
+		}
+		c.Connected = true
+		c.LastPing = time.Now()
+	}
+	return nil
+}
+
+// buildReadPlan 按区域分组，合并地址相近的变量，剩余的单字变量合并为复合读取
+func buildReadPlan(tags []*driver.Tag, gap int) *readPlan {
+	blocks := driver.MergeBlocks(tags, func(v *driver.Tag) (driver.Span[Area], bool) {
+		t, ok := v.Mate.(FinsTag)
+		if !ok {
+			return driver.Span[Area]{}, false
+		}
+		return driver.Span[Area]{Area: t.Area, Key: int(t.Area.Word), Start: t.Address, Points: t.Words, Limit: maxReadWords, Gap: gap}, true
+	})
+
+	plan := &readPlan{}
+	var singles []*readBlock
+	for _, b := range blocks {
+		if b.Points == 1 {
+			singles = append(singles, b)
+		} else {
+			plan.blocks = append(plan.blocks, b)
+		}
+	}
+	// 只有一个单字时直接按块读取
+	if len(singles) == 1 {
+		plan.blocks = append(plan.blocks, singles[0])
+		singles = nil
+	}
+	for i := 0; i < len(singles); i += maxMultiItems {
+		plan.multi = append(plan.multi, singles[i:min(i+maxMultiItems, len(singles))])
+	}
+	return plan
+}
+
+func (c *FinsClient) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadGroup 读取一个扫描组，单个请求失败只影响该请求内变量的质量
+func (c *FinsClient) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if err := c.reconnectIfNeeded(); err != nil {
+		return nil, err
+	}
+	plan, built := c.plans.Get(c.Groups, g, nil, func() *readPlan { return buildReadPlan(g.Tags, c.gap) })
+	if built {
+		log.Printf("fins %s group %s read plan: %d tags, %d requests", c.ID, g.Name(), len(g.Tags), plan.requests())
+	}
+	check := func(blocks []*readBlock, err error) error {
+		for _, b := range blocks {
+			for _, v := range b.Tags {
+				result[v.Name] = v.Value
+			}
+		}
+		if err != nil {
+			log.Println("read error:", blocks[0].Area.Name, blocks[0].Start, err)
+			if !c.Connected || driver.IsConnError(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, b := range plan.blocks {
+		data, err := c.readWords(b.Area, b.Start, b.Points)
+		updateBlock(b, data, err)
+		if err := check([]*readBlock{b}, err); err != nil {
+			return result, err
+		}
+	}
+	for _, items := range plan.multi {
+		data, err := c.readMulti(items)
+		for i, b := range items {
+			if err != nil {
+				updateBlock(b, nil, err)
+			} else {
+				updateBlock(b, data[i], nil)
+			}
+		}
+		if err := check(items, err); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// updateBlock 用读取到的字数据更新块内变量
+func updateBlock(b *readBlock, words []byte, err error) {
+	ts := time.Now()
+	for _, v := range b.Tags {
+		t := v.Mate.(FinsTag)
+		var value any
+		valueErr := err
+		if err == nil {
+			value, valueErr = ParseValueFromBuffer(t, words[(t.Address-b.Start)*2:])
+		}
+		v.Timestamp = ts
+		if valueErr != nil {
+			v.Quality = "Bad"
+			v.Value = nil
+			continue
+		}
+		v.Quality = "Good"
+		v.Value = v.Scale(value)
+	}
+}
+
+func (c *FinsClient) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
+}
+
+func (c *FinsClient) write(values map[string]interface{}) error {
+	if err := c.reconnectIfNeeded(); err != nil {
+		return err
+	}
+	for k, v := range values {
+		if t, ok := c.Tags[k]; !ok {
+			return fmt.Errorf("tag %s is not define", k)
+		} else if !t.Writable {
+			return fmt.Errorf("tag %s is readonly", k)
+		}
+		if err := c.WriteTag(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *FinsClient) WriteTag(name string, value interface{}) error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	tag, ok := c.Tags[name]
+	if !ok {
+		return fmt.Errorf("tag %s is not defined", name)
+	}
+	t, ok := tag.Mate.(FinsTag)
+	if !ok {
+		return fmt.Errorf("tag %s Mate not FinsTag", name)
+	}
+
+	// 位地址直接按位写入
+	if t.Bit >= 0 {
+		v, ok := tag.ConvertValue(value).(bool)
+		if !ok {
+			return fmt.Errorf("期望写入 bool 类型")
+		}
+		return c.writeBit(t.Area, t.Address, t.Bit, v)
+	}
+
+	raw, err := tag.Unscale(value)
+	if err != nil {
+		return err
+	}
+	data, err := valueToWords(tag, t, raw)
+	if err != nil {
+		return err
+	}
+	return c.writeWords(t.Area, t.Address, data)
+}
+
+func (c *FinsClient) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, func() { c.Disconnect() })
+}
+
+func (c *FinsClient) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags)
+	return c.Driver.Reconfig(cfg)
+}
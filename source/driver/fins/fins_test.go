@@ -0,0 +1,233 @@
+package fins
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+
+	driver "acetek-mes/driver"
+	"acetek-mes/driver/drivertest"
+)
+
+// testServer 内存中的 FINS/TCP 服务端，仅用于测试；服务端节点号 1，客户端自动分配为 10
+type testServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	words    map[byte][]uint16 // 按字访问的区域代码 -> 数据
+	requests int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{words: make(map[byte][]uint16)}
+	for _, a := range areas {
+		s.words[a.Word] = make([]uint16, min(a.Size, 1024))
+	}
+	s.listener = drivertest.Listen(t, s.serve)
+	return s
+}
+
+func (s *testServer) area(code byte) (words []uint16, bit bool, ok bool) {
+	for _, a := range areas {
+		if a.Word == code {
+			return s.words[a.Word], false, true
+		}
+		if a.Bit == code {
+			return s.words[a.Word], true, true
+		}
+	}
+	return nil, false, false
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	send := func(command uint32, data []byte) {
+		header := make([]byte, 16)
+		copy(header, "FINS")
+		binary.BigEndian.PutUint32(header[4:], uint32(8+len(data)))
+		binary.BigEndian.PutUint32(header[8:], command)
+		conn.Write(append(header, data...))
+	}
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[4:])-8)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		switch binary.BigEndian.Uint32(header[8:]) {
+		case tcpNodeRequest:
+			send(tcpNodeResponse, []byte{0, 0, 0, 10, 0, 0, 0, 1})
+		case tcpFrame:
+			if data[4] != 1 || data[7] != 10 {
+				return
+			}
+			command := binary.BigEndian.Uint16(data[10:])
+			code, body := s.handle(command, data[12:])
+			response := []byte{0xC0, 0x00, 0x02, 0x00, data[7], 0x00, 0x00, data[4], 0x00, data[9], data[10], data[11], byte(code >> 8), byte(code)}
+			send(tcpFrame, append(response, body...))
+		}
+	}
+}
+
+// handle 处理命令，返回结束代码与应答数据
+func (s *testServer) handle(command uint16, req []byte) (uint16, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	switch command {
+	case CmdMemoryRead, CmdMemoryWrite:
+		words, bit, ok := s.area(req[0])
+		if !ok {
+			return 0x1101, nil
+		}
+		address := int(binary.BigEndian.Uint16(req[1:]))
+		count := int(binary.BigEndian.Uint16(req[4:]))
+		if bit {
+			if command != CmdMemoryWrite || count != 1 || address >= len(words) {
+				return 0x1103, nil
+			}
+			if req[6] != 0 {
+				words[address] |= 1 << req[3]
+			} else {
+				words[address] &^= 1 << req[3]
+			}
+			return 0, nil
+		}
+		if address+count > len(words) {
+			return 0x1103, nil
+		}
+		if command == CmdMemoryWrite {
+			for i := range count {
+				words[address+i] = binary.BigEndian.Uint16(req[6+i*2:])
+			}
+			return 0, nil
+		}
+		var result []byte
+		for _, w := range words[address : address+count] {
+			result = binary.BigEndian.AppendUint16(result, w)
+		}
+		return 0, result
+	case CmdMultiMemoryRead:
+		var result []byte
+		for i := 0; i+4 <= len(req); i += 4 {
+			words, _, ok := s.area(req[i])
+			address := int(binary.BigEndian.Uint16(req[i+1:]))
+			if !ok || address >= len(words) {
+				return 0x1103, nil
+			}
+			result = binary.BigEndian.AppendUint16(append(result, req[i]), words[address])
+		}
+		return 0, result
+	}
+	return 0x0401, nil
+}
+
+func newTestClient(t *testing.T, s *testServer, tags []*driver.Tag) *FinsClient {
+	return drivertest.NewClient(t, "fins://"+s.listener.Addr().String()+"?timeout=1000", tags).(*FinsClient)
+}
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		address, datatype string
+		area              string
+		word, bit, words  int
+	}{
+		{"D100", "int16", "D", 100, -1, 1},
+		{"DM100.05", "bool", "D", 100, 5, 1},
+		{"CIO0.15", "bool", "CIO", 0, 15, 1},
+		{"W20", "float32", "W", 20, -1, 2},
+		{"hr30", "int64", "H", 30, -1, 4},
+		{"D200(10)", "string", "D", 200, -1, 10},
+	}
+	for _, c := range cases {
+		tag, err := ParseAddress(c.address, c.datatype)
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		if tag.Area.Name != c.area || tag.Address != c.word || tag.Bit != c.bit || tag.Words != c.words {
+			t.Errorf("%s: %+v", c.address, tag)
+		}
+	}
+	invalid := map[string]string{"D1.16": "bool", "D1.2": "int16", "D1(2)": "float32", "W512": "int16", "E0": "int16"}
+	drivertest.CheckInvalid(t, invalid, func(address, datatype string) error {
+		_, err := ParseAddress(address, datatype)
+		return err
+	})
+}
+
+func testTags() []*driver.Tag {
+	return []*driver.Tag{
+		{Name: "运行", Address: "CIO0.02", Datatype: "bool", Writable: true},
+		{Name: "故障", Address: "W10.00", Datatype: "bool"},
+		{Name: "液位", Address: "D100", Datatype: "uint16", Writable: true},
+		{Name: "流量", Address: "D102", Datatype: "float32", Writable: true},
+		{Name: "配方", Address: "D110(4)", Datatype: "string", Writable: true},
+		{Name: "累计", Address: "H0", Datatype: "int32", Writable: true},
+	}
+}
+
+func TestRead(t *testing.T) {
+	s := newTestServer(t)
+	s.words[areas["CIO"].Word][0] = 0x0004
+	s.words[areas["W"].Word][10] = 0x0001
+	d := s.words[areas["D"].Word]
+	d[100] = 1200
+	f := math.Float32bits(36.5)
+	d[102], d[103] = uint16(f), uint16(f>>16)
+	d[110], d[111] = 'S'<<8|'L', 'Y'<<8
+	h := s.words[areas["H"].Word]
+	h[0], h[1] = 0xFFFE, 0xFFFF
+
+	drivertest.CheckRead(t, newTestClient(t, s, testTags()), map[string]interface{}{
+		"运行": true, "故障": true, "液位": uint16(1200), "流量": float32(36.5), "配方": "SLY", "累计": int32(-2),
+	})
+	// D 区合并为一次读取，H 区两字一次读取，CIO 与 W 的单字合并为一次复合读取
+	if s.requests != 3 {
+		t.Fatalf("requests: %d", s.requests)
+	}
+}
+
+func TestWriteTag(t *testing.T) {
+	s := newTestServer(t)
+	s.words[areas["CIO"].Word][0] = 0x0101
+	client := newTestClient(t, s, testTags())
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.WriteTag("运行", true); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.words[areas["CIO"].Word][0]; w != 0x0105 {
+		t.Fatalf("set bit: %016b", w)
+	}
+	for name, value := range map[string]interface{}{"液位": 1500.0, "流量": float32(-12.25), "配方": "A-01", "累计": -300.0} {
+		if err := client.WriteTag(name, value); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	d := s.words[areas["D"].Word]
+	if d[100] != 1500 {
+		t.Fatalf("uint16: %d", d[100])
+	}
+	if math.Float32frombits(uint32(d[102])|uint32(d[103])<<16) != -12.25 {
+		t.Fatalf("float32: %04X %04X", d[102], d[103])
+	}
+	if d[110] != 'A'<<8|'-' || d[111] != '0'<<8|'1' || d[112] != 0 {
+		t.Fatalf("string: %04X", d[110:114])
+	}
+	if h := s.words[areas["H"].Word]; int32(uint32(h[0])|uint32(h[1])<<16) != -300 {
+		t.Fatalf("int32: %04X %04X", h[0], h[1])
+	}
+}
+
+func TestEndCode(t *testing.T) {
+	s := newTestServer(t)
+	tags := append(testTags(), &driver.Tag{Name: "越界", Address: "D2000", Datatype: "int32"})
+	drivertest.CheckEndCode(t, newTestClient(t, s, tags), "越界", "液位")
+}
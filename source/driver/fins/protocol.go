@@ -0,0 +1,276 @@
+package fins
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// FINS/TCP 头部命令
+const (
+	tcpNodeRequest  uint32 = 0 // 客户端节点地址请求
+	tcpNodeResponse uint32 = 1 // 服务端节点地址应答
+	tcpFrame        uint32 = 2 // FINS 帧
+)
+
+// FINS 命令
+const (
+	CmdMemoryRead      uint16 = 0x0101
+	CmdMemoryWrite     uint16 = 0x0102
+	CmdMultiMemoryRead uint16 = 0x0104
+
+	maxReadWords   = 999 // 单次读取的最大字数
+	maxWriteWords  = 996 // 单次写入的最大字数
+	maxMultiItems  = 128 // 复合读取的最大项数
+	tcpHeaderBytes = 16
+)
+
+// FinsError PLC 返回的结束代码非 0
+type FinsError struct {
+	Command uint16
+	EndCode uint16
+}
+
+func (e *FinsError) Error() string {
+	var text string
+	switch e.EndCode {
+	case 0x0401:
+		text = "command not supported"
+	case 0x1001, 0x1002:
+		text = "command too long/short"
+	case 0x1101:
+		text = "area not found"
+	case 0x1103:
+		text = "address range exceeded"
+	case 0x1104:
+		text = "address range designation error"
+	case 0x2102:
+		text = "write protected"
+	default:
+		text = "unknown error"
+	}
+	return fmt.Sprintf("fins end code 0x%04X (%s) on command 0x%04X", e.EndCode, text, e.Command)
+}
+
+// transport FINS/TCP 收发，连接后先协商节点地址，同一时间只有一个请求在途
+type transport struct {
+	address string
+	timeout time.Duration
+	node    byte // 客户端节点号，0 表示由 PLC 自动分配
+
+	mu         sync.Mutex
+	conn       net.Conn
+	clientNode byte
+	serverNode byte
+	sid        byte
+}
+
+func (t *transport) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	if err := t.negotiate(); err != nil {
+		t.close()
+		return fmt.Errorf("fins node negotiation: %w", err)
+	}
+	return nil
+}
+
+// negotiate 发送节点地址请求，记录双方节点号
+func (t *transport) negotiate() error {
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	if err := t.writeTCP(tcpNodeRequest, []byte{0, 0, 0, t.node}); err != nil {
+		return err
+	}
+	command, data, err := t.readTCP()
+	if err != nil {
+		return err
+	}
+	if command != tcpNodeResponse || len(data) < 8 {
+		return fmt.Errorf("unexpected node response command %d", command)
+	}
+	t.clientNode = data[3]
+	t.serverNode = data[7]
+	return nil
+}
+
+func (t *transport) writeTCP(command uint32, data []byte) error {
+	frame := make([]byte, tcpHeaderBytes, tcpHeaderBytes+len(data))
+	copy(frame, "FINS")
+	binary.BigEndian.PutUint32(frame[4:], uint32(8+len(data)))
+	binary.BigEndian.PutUint32(frame[8:], command)
+	frame = append(frame, data...)
+	_, err := t.conn.Write(frame)
+	return err
+}
+
+// readTCP 读取一个 FINS/TCP 报文，错误代码非 0 视为连接错误
+func (t *transport) readTCP() (uint32, []byte, error) {
+	header := make([]byte, tcpHeaderBytes)
+	if _, err := io.ReadFull(t.conn, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[4:])
+	if string(header[:4]) != "FINS" || length < 8 || length > 4096 {
+		return 0, nil, fmt.Errorf("invalid fins/tcp header: % X", header)
+	}
+	if code := binary.BigEndian.Uint32(header[12:]); code != 0 {
+		return 0, nil, fmt.Errorf("fins/tcp error code 0x%08X", code)
+	}
+	data := make([]byte, length-8)
+	if _, err := io.ReadFull(t.conn, data); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(header[8:]), data, nil
+}
+
+func (t *transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.close()
+}
+
+func (t *transport) close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *transport) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+// Send 发送一个 FINS 命令，返回结束代码之后的应答数据
+func (t *transport) Send(command uint16, payload []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	t.sid++
+	// ICF RSV GCT DNA DA1 DA2 SNA SA1 SA2 SID MRC SRC
+	frame := []byte{0x80, 0x00, 0x02, 0x00, t.serverNode, 0x00, 0x00, t.clientNode, 0x00, t.sid, byte(command >> 8), byte(command)}
+	frame = append(frame, payload...)
+
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	if err := t.writeTCP(tcpFrame, frame); err != nil {
+		t.close()
+		return nil, err
+	}
+	for {
+		tcpCommand, data, err := t.readTCP()
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+		if tcpCommand != tcpFrame || len(data) < 14 {
+			t.close()
+			return nil, fmt.Errorf("invalid fins response")
+		}
+		// 丢弃超时请求的迟到应答
+		if data[9] != t.sid {
+			continue
+		}
+		if binary.BigEndian.Uint16(data[10:]) != command {
+			t.close()
+			return nil, fmt.Errorf("fins response command mismatch: % X", data[10:12])
+		}
+		// 屏蔽网络中继错误与 CPU 异常标志位
+		if code := binary.BigEndian.Uint16(data[12:]) & 0x7F3F; code != 0 {
+			return nil, &FinsError{Command: command, EndCode: code}
+		}
+		return data[14:], nil
+	}
+}
+
+// areaSpec 区域代码、起始字地址、位号
+func areaSpec(code byte, address int, bit int) []byte {
+	return []byte{code, byte(address >> 8), byte(address), byte(bit)}
+}
+
+func (c *FinsClient) request(command uint16, payload []byte) ([]byte, error) {
+	response, err := c.transport.Send(command, payload)
+	if err != nil {
+		if !c.transport.Connected() {
+			c.Connected = false
+		}
+		return nil, err
+	}
+	c.LastPing = time.Now()
+	return response, nil
+}
+
+// readWords 读取连续的字，返回每字 2 字节（高字节在前）
+func (c *FinsClient) readWords(area Area, address int, count int) ([]byte, error) {
+	if count <= 0 || count > maxReadWords {
+		return nil, fmt.Errorf("word count must be 1-%d: %d", maxReadWords, count)
+	}
+	payload := binary.BigEndian.AppendUint16(areaSpec(area.Word, address, 0), uint16(count))
+	data, err := c.request(CmdMemoryRead, payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != count*2 {
+		return nil, fmt.Errorf("response length mismatch: expect %d, got %d", count*2, len(data))
+	}
+	return data, nil
+}
+
+// readMulti 复合读取，一次请求读取多个不同区域的单字
+func (c *FinsClient) readMulti(items []*readBlock) ([][]byte, error) {
+	if len(items) == 0 || len(items) > maxMultiItems {
+		return nil, fmt.Errorf("item count must be 1-%d: %d", maxMultiItems, len(items))
+	}
+	var payload []byte
+	for _, item := range items {
+		payload = append(payload, areaSpec(item.Area.Word, item.Start, 0)...)
+	}
+	data, err := c.request(CmdMultiMemoryRead, payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != len(items)*3 {
+		return nil, fmt.Errorf("response length mismatch: expect %d, got %d", len(items)*3, len(data))
+	}
+	result := make([][]byte, len(items))
+	for i := range items {
+		result[i] = data[i*3+1 : i*3+3]
+	}
+	return result, nil
+}
+
+func (c *FinsClient) writeWords(area Area, address int, data []byte) error {
+	count := len(data) / 2
+	if count <= 0 || count > maxWriteWords || len(data)%2 != 0 {
+		return fmt.Errorf("invalid word data length: %d", len(data))
+	}
+	payload := binary.BigEndian.AppendUint16(areaSpec(area.Word, address, 0), uint16(count))
+	_, err := c.request(CmdMemoryWrite, append(payload, data...))
+	return err
+}
+
+// writeBit 按位写入，PLC 只修改该位
+func (c *FinsClient) writeBit(area Area, address int, bit int, value bool) error {
+	payload := binary.BigEndian.AppendUint16(areaSpec(area.Bit, address, bit), 1)
+	var b byte
+	if value {
+		b = 1
+	}
+	_, err := c.request(CmdMemoryWrite, append(payload, b))
+	return err
+}
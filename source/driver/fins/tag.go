@@ -0,0 +1,198 @@
+package fins
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	driver "acetek-mes/driver"
+)
+
+// Area 存储区，CS/CJ/NJ 系列的区域代码
+type Area struct {
+	Name string
+	Bit  byte // 按位访问的区域代码
+	Word byte // 按字访问的区域代码
+	Size int  // 字数
+}
+
+var areas = map[string]Area{
+	"CIO": {"CIO", 0x30, 0xB0, 6144},
+	"W":   {"W", 0x31, 0xB1, 512},
+	"H":   {"H", 0x32, 0xB2, 1536},
+	"A":   {"A", 0x33, 0xB3, 960},
+	"D":   {"D", 0x02, 0x82, 32768},
+}
+
+// 区域别名
+var areaAlias = map[string]string{"DM": "D", "WR": "W", "HR": "H", "AR": "A"}
+
+const defaultStringWords = 16
+
+type FinsTag struct {
+	Area     Area
+	Address  int // 字地址
+	Bit      int // 位号 0-15, -1 表示整个字
+	Words    int // 占用的字数
+	Raw      string
+	DataType string
+}
+
+// 地址格式：
+//
+//	字：D100 / DM100 / CIO10 / W20 / H30 / A40
+//	位：D100.05 / CIO0.00 / W20.15（位号为十进制 0-15）
+//	字符串、字节长度（字数）：D200(10)
+var addressRe = regexp.MustCompile(`(?i)^(CIO|DM|WR|HR|AR|D|W|H|A)(\d+)(?:\.(\d{1,2}))?(?:\((\d+)\))?$`)
+
+func ParseAddress(address string, datatype string) (*FinsTag, error) {
+	match := addressRe.FindStringSubmatch(strings.TrimSpace(address))
+	if match == nil {
+		return nil, fmt.Errorf("invalid address format: %s", address)
+	}
+	name := strings.ToUpper(match[1])
+	if alias, ok := areaAlias[name]; ok {
+		name = alias
+	}
+	area := areas[name]
+	start, err := strconv.Atoi(match[2])
+	if err != nil || start >= area.Size {
+		return nil, fmt.Errorf("invalid word address: %s", address)
+	}
+	tag := &FinsTag{Area: area, Address: start, Bit: -1, Raw: address, DataType: datatype}
+	bit, length := match[3], match[4]
+
+	if bit != "" {
+		b, _ := strconv.Atoi(bit)
+		if b > 15 {
+			return nil, fmt.Errorf("bit must be 0-15: %s", address)
+		}
+		if datatype != driver.TypeBool {
+			return nil, fmt.Errorf("bit address only supports bool: %s", address)
+		}
+		tag.Bit = b
+	}
+	switch datatype {
+	case driver.TypeBool, driver.TypeByte, driver.TypeInt16, driver.TypeUInt16:
+		tag.Words = 1
+	case driver.TypeInt32, driver.TypeUInt32, driver.TypeFloat32:
+		tag.Words = 2
+	case driver.TypeInt64, driver.TypeFloat64:
+		tag.Words = 4
+	case driver.TypeString, driver.TypeBytes:
+		tag.Words = defaultStringWords
+		if length != "" {
+			l, _ := strconv.Atoi(length)
+			if l <= 0 || l > maxWriteWords {
+				return nil, fmt.Errorf("length must be 1-%d words: %s", maxWriteWords, address)
+			}
+			tag.Words = l
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type: %s", datatype)
+	}
+	if length != "" && datatype != driver.TypeString && datatype != driver.TypeBytes {
+		return nil, fmt.Errorf("length only allowed on string/bytes: %s", address)
+	}
+	if tag.Address+tag.Words > area.Size {
+		return nil, fmt.Errorf("address out of range: %s", address)
+	}
+	return tag, nil
+}
+
+// swapWords 颠倒字的顺序，欧姆龙多字数据低字在前，字内高字节在前
+func swapWords(b []byte) []byte {
+	out := make([]byte, len(b))
+	n := len(b) / 2
+	for i := 0; i < n; i++ {
+		copy(out[i*2:i*2+2], b[(n-1-i)*2:(n-i)*2])
+	}
+	return out
+}
+
+// ParseValueFromBuffer 解析字数据
+func ParseValueFromBuffer(tag FinsTag, buffer []byte) (any, error) {
+	if len(buffer) < tag.Words*2 {
+		return nil, errors.New("buffer too short for words")
+	}
+	b := buffer[:tag.Words*2]
+	if tag.Bit >= 0 {
+		return binary.BigEndian.Uint16(b)&(1<<uint(tag.Bit)) != 0, nil
+	}
+	switch tag.DataType {
+	case driver.TypeBool:
+		return binary.BigEndian.Uint16(b) != 0, nil
+	case driver.TypeByte:
+		return b[1], nil
+	case driver.TypeBytes:
+		return slices.Clone(b), nil
+	case driver.TypeInt16:
+		return int16(binary.BigEndian.Uint16(b)), nil
+	case driver.TypeUInt16:
+		return binary.BigEndian.Uint16(b), nil
+	case driver.TypeInt32:
+		return int32(binary.BigEndian.Uint32(swapWords(b))), nil
+	case driver.TypeUInt32:
+		return binary.BigEndian.Uint32(swapWords(b)), nil
+	case driver.TypeFloat32:
+		return math.Float32frombits(binary.BigEndian.Uint32(swapWords(b))), nil
+	case driver.TypeInt64:
+		return int64(binary.BigEndian.Uint64(swapWords(b))), nil
+	case driver.TypeFloat64:
+		return math.Float64frombits(binary.BigEndian.Uint64(swapWords(b))), nil
+	case driver.TypeString:
+		return strings.TrimRight(string(b), "\x00 "), nil
+	default:
+		return nil, errors.New("unsupported data type: " + tag.DataType)
+	}
+}
+
+// valueToWords 将写入值编码为字数据
+func valueToWords(t *driver.Tag, tag FinsTag, value interface{}) ([]byte, error) {
+	size := tag.Words * 2
+	switch tag.DataType {
+	case driver.TypeBool:
+		v, ok := t.ConvertValue(value).(bool)
+		if !ok {
+			return nil, fmt.Errorf("期望写入 bool 类型")
+		}
+		if v {
+			return []byte{0, 1}, nil
+		}
+		return []byte{0, 0}, nil
+	case driver.TypeByte:
+		v, ok := t.ConvertValue(value).(byte)
+		if !ok {
+			return nil, fmt.Errorf("期望写入 byte 类型")
+		}
+		return []byte{0, v}, nil
+	case driver.TypeString, driver.TypeBytes:
+		var data []byte
+		if tag.DataType == driver.TypeString {
+			data = []byte(fmt.Sprintf("%v", value))
+		} else if v, ok := t.ConvertValue(value).([]byte); ok {
+			data = v
+		} else {
+			return nil, fmt.Errorf("期望写入 bytes 类型")
+		}
+		if len(data) > size {
+			return nil, fmt.Errorf("value too long: %d > %d bytes", len(data), size)
+		}
+		b := make([]byte, size)
+		copy(b, data)
+		return b, nil
+	}
+	data, err := t.ConvertToBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("unexpected encoded length %d for %s", len(data), tag.DataType)
+	}
+	return swapWords(data), nil
+}
@@ -0,0 +1,209 @@
+package driver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 驱动连接状态
+const (
+	StateConnecting = "connecting" // 正在建立连接
+	StateOnline     = "online"     // 已连接且所有变量质量为 Good
+	StateDegraded   = "degraded"   // 已连接，但有变量读取失败
+	StateOffline    = "offline"    // 连接断开，等待退避后重连
+	StateStopped    = "stopped"
+)
+
+const (
+	BackoffMin = time.Second
+	BackoffMax = time.Minute
+
+	// maxReadFailures 连续读取失败超过此次数视为断线
+	maxReadFailures = 5
+	// statusInterval 状态未变化时最长多久刷新一次
+	statusInterval = 5 * time.Second
+)
+
+// ErrConnection 驱动建立连接失败时包装返回，扫描循环据此进入 offline 并退避重连
+var ErrConnection = errors.New("connection failed")
+
+// Status 驱动运行状态，发布到实现了 StatusSink 的输出，Redis 为哈希 driver:<id>:status
+type Status struct {
+	State         string
+	Since         time.Time // 进入当前状态的时间
+	LastError     string
+	LastErrorTime time.Time
+	Reconnects    uint64        // 断线后的重连次数
+	Failures      int           // 连续连接失败次数，决定退避时长
+	RetryAt       time.Time     // offline 时下一次重连时间
+	CycleTime     time.Duration // 最近一次扫描耗时
+	GoodTags      int
+	BadTags       int
+
+	published time.Time
+	changed   bool
+}
+
+func (s Status) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"state":      s.State,
+		"since":      s.Since.UTC().Format(time.RFC3339),
+		"last_error": s.LastError,
+		"reconnects": s.Reconnects,
+		"failures":   s.Failures,
+		"cycle_ms":   strconv.FormatFloat(float64(s.CycleTime)/float64(time.Millisecond), 'f', 1, 64),
+		"good":       s.GoodTags,
+		"bad":        s.BadTags,
+		"ts":         time.Now().UTC().Format(time.RFC3339),
+		"retry_at":   "",
+		"error_ts":   "",
+	}
+	if !s.RetryAt.IsZero() {
+		fields["retry_at"] = s.RetryAt.UTC().Format(time.RFC3339)
+	}
+	if !s.LastErrorTime.IsZero() {
+		fields["error_ts"] = s.LastErrorTime.UTC().Format(time.RFC3339)
+	}
+	return fields
+}
+
+// Backoff 第 failures 次连接失败后的等待时间，从 BackoffMin 开始翻倍，最长 BackoffMax
+func Backoff(failures int) time.Duration {
+	delay := BackoffMin
+	for i := 1; i < failures && delay < BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, BackoffMax)
+}
+
+// IsConnError 判断错误是否意味着连接已不可用。
+// 超时不算断线（例如 RTU 链路上某个从站不应答），连续超时由 maxReadFailures 处理
+func IsConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrConnection) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && !ne.Timeout()
+}
+
+// Status 返回驱动当前状态的副本
+func (d *Driver) Status() Status {
+	d.statusMu.Lock()
+	defer d.statusMu.Unlock()
+	return d.status
+}
+
+func (d *Driver) setState(state string, now time.Time) {
+	d.statusMu.Lock()
+	if d.status.State == state {
+		d.statusMu.Unlock()
+		return
+	}
+	log.Printf("driver %s %s -> %s", d.ID, d.status.State, state)
+	d.status.State = state
+	d.status.Since = now
+	d.status.changed = true
+	d.statusMu.Unlock()
+}
+
+// waiting offline 状态下退避未结束时返回 true
+func (d *Driver) waiting(now time.Time) bool {
+	s := d.Status()
+	return s.State == StateOffline && now.Before(s.RetryAt)
+}
+
+// reconnecting 退避结束，开始新一轮连接尝试
+func (d *Driver) reconnecting(now time.Time) {
+	if d.Status().State != StateOffline {
+		return
+	}
+	d.statusMu.Lock()
+	d.status.Reconnects++
+	d.statusMu.Unlock()
+	d.setState(StateConnecting, now)
+}
+
+// recordError 记录最近一次错误
+func (d *Driver) recordError(err error, now time.Time) {
+	d.statusMu.Lock()
+	defer d.statusMu.Unlock()
+	d.status.LastError = err.Error()
+	d.status.LastErrorTime = now
+}
+
+// offline 连接断开：所有变量置为 Bad，关闭连接并按退避时间安排下一次重连
+func (d *Driver) offline(err error, now time.Time) {
+	d.FailCount = 0
+	d.statusMu.Lock()
+	d.status.Failures++
+	d.status.RetryAt = now.Add(Backoff(d.status.Failures))
+	d.statusMu.Unlock()
+	d.recordError(err, now)
+	d.setState(StateOffline, now)
+	// 先发布状态（MQTT 的 DEATH），再上报各变量的 Bad 质量
+	d.publishStatus(now)
+	log.Printf("driver %s offline: %v, retry in %v", d.ID, err, Backoff(d.Status().Failures))
+
+	if d.disconnect != nil {
+		d.disconnect()
+	}
+	tags := make([]*Tag, 0, len(d.Tags))
+	for _, v := range d.Tags {
+		v.Quality = "Bad"
+		v.Value = nil
+		v.Timestamp = now
+		tags = append(tags, v)
+	}
+	d.UpdateTags(tags)
+	d.FireTriggers(tags)
+}
+
+// cycleDone 一轮扫描结束后统计变量质量并更新状态，readFailed 表示本轮有扫描组读取失败
+func (d *Driver) cycleDone(duration time.Duration, readFailed bool, now time.Time) {
+	good, bad := 0, 0
+	for _, v := range d.Tags {
+		if v.Quality == "Good" {
+			good++
+		} else {
+			bad++
+		}
+	}
+	d.statusMu.Lock()
+	d.status.CycleTime = duration
+	d.status.GoodTags, d.status.BadTags = good, bad
+	d.status.Failures = 0
+	d.status.RetryAt = time.Time{}
+	d.statusMu.Unlock()
+	if readFailed || bad > 0 {
+		d.setState(StateDegraded, now)
+	} else {
+		d.setState(StateOnline, now)
+	}
+	d.publishStatus(now)
+}
+
+// publishStatus 状态变化时立即发布到各输出，否则每 statusInterval 刷新一次；
+// 发布失败不在每个扫描周期重试，等下一次定期刷新，避免 Redis 不可用时每个周期都报错
+func (d *Driver) publishStatus(now time.Time) {
+	d.statusMu.Lock()
+	if !d.status.changed && now.Sub(d.status.published) < statusInterval {
+		d.statusMu.Unlock()
+		return
+	}
+	d.status.published = now
+	d.status.changed = false
+	s := d.status
+	d.statusMu.Unlock()
+	if err := d.emitStatus(s); err != nil {
+		log.Printf("driver %s publish status: %v", d.ID, err)
+	}
+}
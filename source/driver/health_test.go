@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, w := range want {
+		if got := Backoff(i + 1); got != w {
+			t.Errorf("backoff %d: %v", i+1, got)
+		}
+	}
+	if got := Backoff(30); got != BackoffMax {
+		t.Errorf("max backoff: %v", got)
+	}
+}
+
+// statusSink 记录发布的状态
+type statusSink struct {
+	*MemorySink
+	states []string
+}
+
+func (s *statusSink) WriteStatus(driverID string, st Status) error {
+	s.states = append(s.states, st.State)
+	return nil
+}
+
+func TestConnectionState(t *testing.T) {
+	sink := &statusSink{MemorySink: NewMemorySink()}
+	d := &Driver{
+		ID:       "plc1",
+		Interval: 1000,
+		Tags: map[string]*Tag{
+			"运行": {Name: "运行", Datatype: TypeBool},
+			"线号": {Name: "线号", Datatype: TypeInt16},
+		},
+	}
+	d.InitScanGroups()
+	d.SetSinks([]Sink{sink})
+	disconnects := 0
+	d.disconnect = func() { disconnects++ }
+
+	down, reads, bad := true, 0, ""
+	readGroup := func(g *ScanGroup) (map[string]interface{}, error) {
+		reads++
+		if down {
+			return nil, fmt.Errorf("%w: connection refused", ErrConnection)
+		}
+		for _, v := range g.Tags {
+			v.Value, v.Quality = int16(1), "Good"
+			if v.Name == bad {
+				v.Value, v.Quality = nil, "Bad"
+			}
+		}
+		return nil, nil
+	}
+	scan := func() {
+		// 让扫描组与退避立即到期
+		for _, g := range d.Groups {
+			g.next = time.Time{}
+		}
+		d.scan(context.Background(), readGroup)
+	}
+	expire := func() {
+		d.statusMu.Lock()
+		d.status.RetryAt = time.Now().Add(-time.Millisecond)
+		d.statusMu.Unlock()
+	}
+
+	d.setState(StateConnecting, time.Now())
+	scan()
+	if s := d.Status(); s.State != StateOffline || s.Failures != 1 || disconnects != 1 || d.Tags["运行"].Quality != "Bad" {
+		t.Fatalf("first failure: %+v, disconnects %d", s, disconnects)
+	}
+	if s := d.Status(); s.RetryAt.Sub(s.LastErrorTime) != BackoffMin {
+		t.Fatalf("retry at: %v", s.RetryAt.Sub(s.LastErrorTime))
+	}
+
+	scan()
+	if reads != 1 {
+		t.Fatalf("read during backoff: %d", reads)
+	}
+
+	expire()
+	scan()
+	if s := d.Status(); s.State != StateOffline || s.Failures != 2 || s.Reconnects != 1 || s.RetryAt.Sub(s.LastErrorTime) != 2*BackoffMin {
+		t.Fatalf("second failure: %+v", s)
+	}
+
+	down = false
+	expire()
+	scan()
+	if s := d.Status(); s.State != StateOnline || s.Failures != 0 || s.GoodTags != 2 || s.Reconnects != 2 {
+		t.Fatalf("online: %+v", s)
+	}
+
+	bad = "线号"
+	scan()
+	if s := d.Status(); s.State != StateDegraded || s.BadTags != 1 {
+		t.Fatalf("degraded: %+v", s)
+	}
+	if fmt.Sprint(sink.states) != "[offline offline online degraded]" {
+		t.Fatalf("published: %v", sink.states)
+	}
+}
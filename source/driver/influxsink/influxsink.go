@@ -0,0 +1,95 @@
+// Package influxsink 把变量的历史值直接写入 InfluxDB 2
+package influxsink
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+
+	"acetek-mes/driver"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// 注册 influx 输出
+func init() {
+	driver.RegisterSink("influx", New)
+}
+
+// Sink 异步批量写入，只写历史（超过死区或心跳到期），死区内的小幅变化不写
+type Sink struct {
+	client      influxdb2.Client
+	writeAPI    api.WriteAPI
+	measurement string
+	done        chan struct{}
+}
+
+// influx://host:8086?org=&bucket=&token=&measurement=realtime&tls=1&batch=500&flush=1000
+func New(rawURL string) (driver.Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if q.Get("org") == "" || q.Get("bucket") == "" {
+		return nil, fmt.Errorf("influx sink: org and bucket required")
+	}
+	scheme := "http"
+	if q.Get("tls") == "1" || q.Get("tls") == "true" {
+		scheme = "https"
+	}
+	opts := influxdb2.DefaultOptions()
+	if n, err := strconv.Atoi(q.Get("batch")); err == nil && n > 0 {
+		opts.SetBatchSize(uint(n))
+	}
+	if n, err := strconv.Atoi(q.Get("flush")); err == nil && n > 0 {
+		opts.SetFlushInterval(uint(n))
+	}
+	s := &Sink{
+		client:      influxdb2.NewClientWithOptions(scheme+"://"+u.Host, q.Get("token"), opts),
+		measurement: q.Get("measurement"),
+		done:        make(chan struct{}),
+	}
+	if s.measurement == "" {
+		s.measurement = "realtime"
+	}
+	s.writeAPI = s.client.WriteAPI(q.Get("org"), q.Get("bucket"))
+	errs := s.writeAPI.Errors()
+	go func() {
+		defer close(s.done)
+		for err := range errs {
+			log.Println("influx sink write:", err)
+		}
+	}()
+	return s, nil
+}
+
+func (s *Sink) Write(driverID string, p driver.Point, stream bool) error {
+	if !stream {
+		return nil
+	}
+	fields := map[string]interface{}{"quality": p.Quality}
+	switch v := p.Value.(type) {
+	case nil:
+	case []interface{}, map[string]interface{}:
+		// 数组与结构体值以文本保存
+		fields["value"] = fmt.Sprintf("%v", v)
+	default:
+		fields["value"] = v
+	}
+	tags := map[string]string{"driver": driverID, "tag": p.Name}
+	if p.Unit != "" {
+		tags["unit"] = p.Unit
+	}
+	s.writeAPI.WritePoint(influxdb2.NewPoint(s.measurement, tags, fields, p.Timestamp))
+	return nil
+}
+
+// Close 写出缓冲区中的数据
+func (s *Sink) Close() error {
+	s.client.Close()
+	<-s.done
+	return nil
+}
@@ -0,0 +1,58 @@
+package influxsink
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"acetek-mes/driver"
+)
+
+func TestWrite(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "dc" || r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("request: %s %v", r.URL, r.Header)
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		lines = append(lines, strings.Split(strings.TrimSpace(string(b)), "\n")...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := New("influx://" + server.Listener.Addr().String() + "?org=dzr&bucket=dc&token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 0)
+	s.Write("PLC01", driver.Point{Name: "液位", Value: float32(12.5), Quality: "Good", Timestamp: ts, Unit: "m"}, true)
+	s.Write("PLC01", driver.Point{Name: "液位", Value: float32(12.6), Quality: "Good", Timestamp: ts}, false)
+	s.Write("PLC01", driver.Point{Name: "运行", Value: nil, Quality: "Bad", Timestamp: ts}, true)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 死区内的变化不写，Bad 质量只写 quality 字段
+	want := []string{
+		`realtime,driver=PLC01,tag=液位,unit=m quality="Good",value=12.5 1700000000000000000`,
+		`realtime,driver=PLC01,tag=运行 quality="Bad" 1700000000000000000`,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("lines:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("influx://localhost:8086?bucket=dc"); err == nil {
+		t.Fatal("org required")
+	}
+}
@@ -0,0 +1,198 @@
+package driver
+
+import (
+	"acetek-mes/model"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yxcloud1/go-comm/db"
+)
+
+// ErrInterlock 写入被联锁规则拒绝
+var ErrInterlock = errors.New("write rejected by interlock")
+
+// WriteRequest 一次写入请求，经 ChWrite 交给扫描协程串行执行
+type WriteRequest struct {
+	Values    map[string]interface{}
+	User      string // 操作人，空为系统写入
+	Confirmer string // 第二确认人
+	Source    string // 来源，例如 "http", "redis"
+
+	// 由扫描协程填写，Submit 返回后读取，避免在其他协程中直接访问变量
+	Previous map[string]interface{}
+	ReadBack map[string]interface{}
+}
+
+var conditionRe = regexp.MustCompile(`^\s*(.+?)\s*(==|!=|>=|<=|>|<)\s*(.+?)\s*$`)
+
+// saveAudit 写入审计表，便于测试替换
+var saveAudit = func(a *model.WriteAudit) error {
+	conn := db.DB().Conn()
+	if conn == nil {
+		return errors.New("database not initialized")
+	}
+	return conn.Create(a).Error
+}
+
+// handleWrite 在扫描协程中执行写入：联锁检查、写入、读回校验，结果逐个变量记录审计
+func (d *Driver) handleWrite(req *WriteRequest, readGroup func(*ScanGroup) (map[string]interface{}, error), write func(map[string]interface{}) error) error {
+	previous := make(map[string]interface{}, len(req.Values))
+	for name := range req.Values {
+		if tag, ok := d.Tags[name]; ok {
+			previous[name] = tag.Value
+		}
+	}
+	req.Previous = previous
+	err := d.checkInterlocks(req)
+	if err == nil {
+		if err = write(req.Values); err == nil {
+			err = d.readBack(req.Values, readGroup)
+		}
+	}
+	d.audit(req, previous, err)
+	return err
+}
+
+// checkInterlocks 检查写入范围、第二人确认与联锁条件
+func (d *Driver) checkInterlocks(req *WriteRequest) error {
+	for name, value := range req.Values {
+		tag, ok := d.Tags[name]
+		if !ok {
+			continue
+		}
+		if err := d.checkTag(tag, value, req); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInterlock, name, err)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) checkTag(tag *Tag, value interface{}, req *WriteRequest) error {
+	if tag.WriteMax > tag.WriteMin {
+		v, ok := ToFloat64(value)
+		if s, isString := value.(string); !ok && isString {
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			v, ok = f, err == nil
+		}
+		if !ok {
+			return fmt.Errorf("写入值 %v 不是数值", value)
+		}
+		if v < tag.WriteMin || v > tag.WriteMax {
+			return fmt.Errorf("写入值 %v 超出允许范围 [%v, %v]", value, tag.WriteMin, tag.WriteMax)
+		}
+	}
+	if tag.Confirm {
+		if req.Confirmer == "" {
+			return errors.New("需要第二人确认")
+		}
+		if req.Confirmer == req.User {
+			return errors.New("确认人不能与操作人相同")
+		}
+	}
+	if tag.Interlock != "" {
+		for _, cond := range strings.Split(tag.Interlock, "&&") {
+			if err := d.checkCondition(cond); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCondition 检查一个联锁条件，条件变量必须属于同一驱动且质量为 Good
+func (d *Driver) checkCondition(cond string) error {
+	match := conditionRe.FindStringSubmatch(cond)
+	if match == nil {
+		return fmt.Errorf("联锁条件格式错误: %s", strings.TrimSpace(cond))
+	}
+	state, ok := d.Tags[match[1]]
+	if !ok {
+		return fmt.Errorf("联锁变量 %s 不存在", match[1])
+	}
+	if state.Quality != "Good" {
+		return fmt.Errorf("联锁变量 %s 质量为 %s", match[1], state.Quality)
+	}
+	if !compare(state.Value, match[2], strings.Trim(match[3], `"'`)) {
+		return fmt.Errorf("联锁条件不满足: %s（当前 %v）", strings.TrimSpace(cond), state.Value)
+	}
+	return nil
+}
+
+// compare 数值（含 bool）按数值比较，其它按字符串只支持 == 与 !=
+func compare(value interface{}, op string, expect string) bool {
+	a, aok := ToFloat64(value)
+	b, err := strconv.ParseFloat(expect, 64)
+	if bv, berr := strconv.ParseBool(expect); berr == nil && err != nil {
+		b, err = 0, nil
+		if bv {
+			b = 1
+		}
+	}
+	if aok && err == nil {
+		switch op {
+		case "==":
+			return a == b
+		case "!=":
+			return a != b
+		case ">=":
+			return a >= b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		case "<":
+			return a < b
+		}
+		return false
+	}
+	switch op {
+	case "==":
+		return fmt.Sprintf("%v", value) == expect
+	case "!=":
+		return fmt.Sprintf("%v", value) != expect
+	}
+	return false
+}
+
+func (d *Driver) audit(req *WriteRequest, previous map[string]interface{}, err error) {
+	now := time.Now()
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrInterlock):
+		result = "rejected"
+	case err != nil:
+		result = "failed"
+	}
+	for name, value := range req.Values {
+		a := &model.WriteAudit{
+			Entity:    model.Entity{ID: uuid.NewString(), Name: name},
+			DriverID:  d.ID,
+			User:      req.User,
+			Confirmer: req.Confirmer,
+			Source:    req.Source,
+			Previous:  fmt.Sprintf("%v", previous[name]),
+			Value:     fmt.Sprintf("%v", value),
+			Result:    result,
+			Timestamp: now,
+		}
+		if err != nil {
+			a.Message = err.Error()
+		}
+		if tag, ok := d.Tags[name]; ok && result != "rejected" {
+			a.ReadBack = fmt.Sprintf("%v", tag.Value)
+			if req.ReadBack == nil {
+				req.ReadBack = make(map[string]interface{})
+			}
+			req.ReadBack[name] = tag.Value
+		}
+		if err := saveAudit(a); err != nil {
+			log.Println("save write audit:", err)
+		}
+	}
+}
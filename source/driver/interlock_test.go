@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInterlocks(t *testing.T) {
+	m, audits := newMemDriver(t,
+		map[string]interface{}{"设定时间": float32(0), "规格": "", "运行状态": int16(1)},
+		map[string]*Tag{
+			"设定时间": {Name: "设定时间", Datatype: TypeFloat32, Writable: true, WriteMin: 0, WriteMax: 60, Interlock: "运行状态 == 0"},
+			"规格":   {Name: "规格", Datatype: TypeString, Writable: true, Confirm: true},
+			"运行状态": {Name: "运行状态", Datatype: TypeInt16},
+		})
+	// 先完成一次扫描，联锁变量有值
+	if err := m.Submit(&WriteRequest{Values: map[string]interface{}{"规格": "A"}, User: "张三", Confirmer: "李四"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rejects := []*WriteRequest{
+		{Values: map[string]interface{}{"设定时间": 75}, User: "张三"},
+		{Values: map[string]interface{}{"设定时间": 30}, User: "张三"},
+		{Values: map[string]interface{}{"规格": "B"}, User: "张三"},
+		{Values: map[string]interface{}{"规格": "B"}, User: "张三", Confirmer: "张三"},
+	}
+	for _, req := range rejects {
+		if err := m.Submit(req); !errors.Is(err, ErrInterlock) {
+			t.Errorf("%+v: %v", req, err)
+		}
+	}
+	if m.plc["规格"] != "A" {
+		t.Fatalf("rejected write reached plc: %v", m.plc)
+	}
+
+	m.plc["运行状态"] = int16(0)
+	m.Submit(&WriteRequest{Values: map[string]interface{}{"规格": "A"}, User: "张三", Confirmer: "李四"})
+	if err := m.Submit(&WriteRequest{Values: map[string]interface{}{"设定时间": 30}, User: "张三"}); err != nil {
+		t.Fatalf("interlock satisfied: %v", err)
+	}
+
+	var results []string
+	for _, a := range *audits {
+		results = append(results, a.Result)
+	}
+	if len(results) != 7 || results[1] != "rejected" || results[6] != "ok" || (*audits)[2].Message == "" {
+		t.Fatalf("audits: %v", results)
+	}
+}
@@ -0,0 +1,103 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// QualityStopped 驱动停止后变量的最终质量
+const QualityStopped = "Stopped"
+
+var (
+	ErrStopped        = errors.New("driver is not running")
+	ErrAlreadyStarted = errors.New("driver already started")
+)
+
+// Go 在新协程中运行扫描循环，ctx 取消或调用 Stop 后退出。
+// cleanup 关闭 PLC 连接，断线进入 offline 时也会调用，驱动下次读取时应重新连接。
+// 退出时先调用 cleanup，再把所有变量的质量置为 Stopped 并上报
+func (d *Driver) Go(ctx context.Context, readGroup func(*ScanGroup) (map[string]interface{}, error), write func(map[string]interface{}) error, cleanup func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.lifeMu.Lock()
+	defer d.lifeMu.Unlock()
+	if d.done != nil {
+		// 扫描循环收到 stop 命令自行退出后允许再次启动
+		select {
+		case <-d.done:
+		default:
+			return ErrAlreadyStarted
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	d.cancel, d.done = cancel, done
+	d.disconnect = cleanup
+	d.setState(StateConnecting, time.Now())
+	go func() {
+		defer close(done)
+		defer cancel()
+		d.Run(ctx, readGroup, write)
+		d.drain()
+		cleanup()
+		d.stopped()
+		log.Printf("driver %s stopped", d.ID)
+	}()
+	return nil
+}
+
+func (d *Driver) Start(ctx context.Context) error {
+	return errors.New("driver does not implement Start")
+}
+
+// Stop 停止扫描循环并等待连接关闭，未运行时直接返回
+func (d *Driver) Stop() error {
+	d.lifeMu.Lock()
+	cancel, done := d.cancel, d.done
+	d.cancel, d.done = nil, nil
+	d.lifeMu.Unlock()
+	if done == nil {
+		return nil
+	}
+	cancel()
+	<-done
+	return nil
+}
+
+// running 扫描协程运行中时返回其退出通知
+func (d *Driver) running() <-chan struct{} {
+	d.lifeMu.Lock()
+	defer d.lifeMu.Unlock()
+	return d.done
+}
+
+// drain 丢弃停止时尚未处理的写入请求与配置，避免重新启动后执行过期的写入
+func (d *Driver) drain() {
+	for {
+		select {
+		case <-d.ChWrite:
+		case <-d.ChConfig:
+		case <-d.ChCommand:
+		default:
+			return
+		}
+	}
+}
+
+// stopped 上报所有变量的最终质量
+func (d *Driver) stopped() {
+	d.flushEvents()
+	now := time.Now()
+	tags := make([]*Tag, 0, len(d.Tags))
+	for _, v := range d.Tags {
+		v.Quality = QualityStopped
+		v.Timestamp = now
+		tags = append(tags, v)
+	}
+	d.UpdateTags(tags)
+	d.setState(StateStopped, now)
+	d.publishStatus(now)
+}
@@ -0,0 +1,31 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	m, _ := newMemDriver(t,
+		map[string]interface{}{"运行": true},
+		map[string]*Tag{"运行": {Name: "运行", Datatype: TypeBool, Writable: true}})
+
+	if err := m.Write("运行", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(); err != nil {
+		t.Fatalf("second stop: %v", err)
+	}
+	if tag, _ := m.GetTag("运行"); tag.Quality != QualityStopped {
+		t.Fatalf("quality after stop: %s", tag.Quality)
+	}
+	if err := m.Write("运行", true); !errors.Is(err, ErrStopped) {
+		t.Fatalf("write after stop: %v", err)
+	}
+	if err := m.Reconfig(&Config{}); !errors.Is(err, ErrStopped) {
+		t.Fatalf("reconfig after stop: %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package mc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"acetek-mes/driver"
+
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+const (
+	defaultWordGap = 32 // 合并相邻字软元件时允许跨越的空闲字数
+	defaultBitGap  = 64 // 合并相邻位软元件时允许跨越的空闲点数
+)
+
+// MCClient 三菱 MC 协议（二进制 3E 帧）驱动，适用于 Q/L/FX5 系列
+type MCClient struct {
+	driver.Driver
+	transport *transport
+	addr      string
+	octal     bool
+	gap       int
+	plans     driver.PlanCache[[]*readBlock]
+}
+
+// readBlock 同一软元件中编号连续（或相近）的一段，一次批量读取
+type readBlock = driver.Block[Device]
+
+// mc://host:5000?network=0&pc=255&io=1023&station=0&timeout=2000&interval=1000&series=fx5&gap=32
+func NewMCClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	port := u.Port()
+	if port == "" {
+		port = "5000"
+	}
+	t := &transport{
+		address: fmt.Sprintf("%s:%s", u.Hostname(), port),
+		timeout: 2 * time.Second,
+		pc:      0xFF,
+		io:      0x03FF,
+	}
+	for key, p := range map[string]*byte{"network": &t.network, "pc": &t.pc, "station": &t.station} {
+		if s := q.Get(key); s != "" {
+			v, err := strconv.ParseUint(s, 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", key, s)
+			}
+			*p = byte(v)
+		}
+	}
+	if s := q.Get("io"); s != "" {
+		v, err := strconv.ParseUint(s, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid io: %s", s)
+		}
+		t.io = uint16(v)
+	}
+	if s := q.Get("timeout"); s != "" {
+		if ms, err := strconv.Atoi(s); err == nil && ms > 0 {
+			t.timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	var interval uint32 = 1000
+	if i := q.Get("interval"); i != "" {
+		if intval, err := strconv.Atoi(i); err == nil {
+			interval = uint32(intval)
+		}
+	}
+	gap := defaultWordGap
+	if g := q.Get("gap"); g != "" {
+		if intval, err := strconv.Atoi(g); err == nil && intval >= 0 {
+			gap = intval
+		}
+	}
+	var octal bool
+	switch strings.ToLower(q.Get("series")) {
+	case "", "q", "l", "qna":
+	case "fx5", "iq-f":
+		octal = true
+	default:
+		return nil, fmt.Errorf("unknown series: %s", q.Get("series"))
+	}
+
+	c := &MCClient{
+		transport: t,
+		addr:      rawURL,
+		octal:     octal,
+		gap:       gap,
+		Driver: driver.Driver{
+			ID:            id,
+			Name:          name,
+			Tags:          driver.TagMap(parseTags(tags, octal)),
+			Interval:      interval,
+			ChCommand:     make(chan string, 100),
+			ChWrite:       make(chan *driver.WriteRequest, 100),
+			ChWriteResult: make(chan error),
+			ChConfig:      make(chan *driver.Config, 1),
+		},
+	}
+	c.InitScanGroups()
+	return c, nil
+}
+
+// parseTags 解析变量地址，返回解析成功的变量
+func parseTags(tags []*driver.Tag, octal bool) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if mt, err := parseAddress(v.Address, v.Datatype, octal); err == nil {
+			v.Parsed = true
+			v.Mate = *mt
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse address error:", err)
+		}
+	}
+	return result
+}
+
+// 注册三菱 MC 驱动
+func init() {
+	logger.TxtLog("register driver mc")
+	driver.RegisterDriver("mc", NewMCClient)
+}
+
+func (c *MCClient) Connect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if c.Connected {
+		return nil
+	}
+	if err := c.transport.Connect(); err != nil {
+		return err
+	}
+	c.Connected = true
+	return nil
+}
+
+func (c *MCClient) Disconnect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = false
+	return c.transport.Close()
+}
+
+func (c *MCClient) IsConnected() bool {
+	return c.Connected
+}
+
+func (c *MCClient) reconnectIfNeeded() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if !c.Connected {
+		c.transport.Close()
+		if err := c.transport.Connect(); err != nil {
+			return fmt.Errorf("%w: %v", driver.ErrConnection, err)
+		}
+		c.Connected = true
+		c.LastPing = time.Now()
+	}
+	return nil
+}
+
+// buildReadPlan 按软元件分组，合并编号相近的变量为批量读取块
+func buildReadPlan(tags []*driver.Tag, gap int) []*readBlock {
+	return driver.MergeBlocks(tags, func(v *driver.Tag) (driver.Span[Device], bool) {
+		t, ok := v.Mate.(MCTag)
+		if !ok {
+			return driver.Span[Device]{}, false
+		}
+		span := driver.Span[Device]{Area: t.Device, Key: int(t.Device.Code), Start: t.Start, Points: t.Words, Limit: maxWordPoints, Gap: gap}
+		if t.Device.Bit {
+			span.Points, span.Limit, span.Gap = 1, maxBitPoints, max(gap, defaultBitGap)
+		}
+		return span, true
+	})
+}
+
+func (c *MCClient) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadGroup 读取一个扫描组，每个读取块一次请求，单个块失败只影响块内变量的质量
+func (c *MCClient) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if err := c.reconnectIfNeeded(); err != nil {
+		return nil, err
+	}
+	plan, built := c.plans.Get(c.Groups, g, nil, func() []*readBlock { return buildReadPlan(g.Tags, c.gap) })
+	if built {
+		log.Printf("mc %s group %s read plan: %d tags, %d requests", c.ID, g.Name(), len(g.Tags), len(plan))
+	}
+	for _, b := range plan {
+		err := c.readBlock(b)
+		for _, v := range b.Tags {
+			result[v.Name] = v.Value
+		}
+		if err != nil {
+			log.Println("read block error:", b.Area.Name, b.Start, err)
+			if !c.Connected || driver.IsConnError(err) {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *MCClient) readBlock(b *readBlock) error {
+	var words []byte
+	var bits []bool
+	var err error
+	if b.Area.Bit {
+		bits, err = c.readBits(b.Area, b.Start, b.Points)
+	} else {
+		words, err = c.readWords(b.Area, b.Start, b.Points)
+	}
+	ts := time.Now()
+	for _, v := range b.Tags {
+		t := v.Mate.(MCTag)
+		var value any
+		valueErr := err
+		if err == nil {
+			offset := t.Start - b.Start
+			if b.Area.Bit {
+				value = bits[offset]
+			} else {
+				value, valueErr = ParseValueFromBuffer(t, words[offset*2:])
+			}
+		}
+		v.Timestamp = ts
+		if valueErr != nil {
+			v.Quality = "Bad"
+			v.Value = nil
+			continue
+		}
+		v.Quality = "Good"
+		v.Value = v.Scale(value)
+	}
+	return err
+}
+
+func (c *MCClient) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
+}
+
+func (c *MCClient) write(values map[string]interface{}) error {
+	if err := c.reconnectIfNeeded(); err != nil {
+		return err
+	}
+	for k, v := range values {
+		if t, ok := c.Tags[k]; !ok {
+			return fmt.Errorf("tag %s is not define", k)
+		} else if !t.Writable {
+			return fmt.Errorf("tag %s is readonly", k)
+		}
+		if err := c.WriteTag(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MCClient) WriteTag(name string, value interface{}) error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	tag, ok := c.Tags[name]
+	if !ok {
+		return fmt.Errorf("tag %s is not defined", name)
+	}
+	t, ok := tag.Mate.(MCTag)
+	if !ok {
+		return fmt.Errorf("tag %s Mate not MCTag", name)
+	}
+
+	if t.Device.Bit {
+		v, ok := tag.ConvertValue(value).(bool)
+		if !ok {
+			return fmt.Errorf("期望写入 bool 类型")
+		}
+		return c.writeBits(t.Device, t.Start, []bool{v})
+	}
+
+	// 字中的位：读-改-写
+	if t.Bit >= 0 {
+		v, ok := tag.ConvertValue(value).(bool)
+		if !ok {
+			return fmt.Errorf("期望写入 bool 类型")
+		}
+		buffer, err := c.readWords(t.Device, t.Start, 1)
+		if err != nil {
+			return fmt.Errorf("读取原始字失败: %v", err)
+		}
+		word := binary.LittleEndian.Uint16(buffer)
+		if v {
+			word |= 1 << uint(t.Bit)
+		} else {
+			word &^= 1 << uint(t.Bit)
+		}
+		return c.writeWords(t.Device, t.Start, binary.LittleEndian.AppendUint16(nil, word))
+	}
+
+	raw, err := tag.Unscale(value)
+	if err != nil {
+		return err
+	}
+	data, err := valueToWords(tag, t, raw)
+	if err != nil {
+		return err
+	}
+	return c.writeWords(t.Device, t.Start, data)
+}
+
+func (c *MCClient) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, func() { c.Disconnect() })
+}
+
+func (c *MCClient) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags, c.octal)
+	return c.Driver.Reconfig(cfg)
+}
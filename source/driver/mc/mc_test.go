@@ -0,0 +1,213 @@
+package mc
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+
+	driver "acetek-mes/driver"
+	"acetek-mes/driver/drivertest"
+)
+
+// testServer 内存中的 3E 帧服务端，仅用于测试；每个软元件最多 1024 点
+type testServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	words    map[byte][]uint16
+	bits     map[byte][]bool
+	requests int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{words: make(map[byte][]uint16), bits: make(map[byte][]bool)}
+	for _, d := range devices {
+		if d.Bit {
+			s.bits[d.Code] = make([]bool, 1024)
+		} else {
+			s.words[d.Code] = make([]uint16, 1024)
+		}
+	}
+	s.listener = drivertest.Listen(t, s.serve)
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 9)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint16(header[7:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		code, data := s.handle(body[2:])
+		response := []byte{0xD0, 0x00, header[2], header[3], header[4], header[5], header[6], 0, 0, byte(code), byte(code >> 8)}
+		binary.LittleEndian.PutUint16(response[7:], uint16(2+len(data)))
+		conn.Write(append(response, data...))
+	}
+}
+
+// handle 处理命令，返回结束代码与应答数据
+func (s *testServer) handle(req []byte) (uint16, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	command := binary.LittleEndian.Uint16(req)
+	sub := binary.LittleEndian.Uint16(req[2:])
+	start := int(req[4]) | int(req[5])<<8 | int(req[6])<<16
+	code := req[7]
+	points := int(binary.LittleEndian.Uint16(req[8:]))
+	data := req[10:]
+
+	if sub == SubBit {
+		bits, ok := s.bits[code]
+		if !ok || start+points > len(bits) {
+			return 0xC056, nil
+		}
+		switch command {
+		case CmdBatchRead:
+			return 0, packBits(bits[start : start+points])
+		case CmdBatchWrite:
+			copy(bits[start:], unpackBits(data, points))
+			return 0, nil
+		}
+		return 0xC059, nil
+	}
+	words, ok := s.words[code]
+	if !ok || start+points > len(words) {
+		return 0xC056, nil
+	}
+	switch command {
+	case CmdBatchRead:
+		var result []byte
+		for _, w := range words[start : start+points] {
+			result = binary.LittleEndian.AppendUint16(result, w)
+		}
+		return 0, result
+	case CmdBatchWrite:
+		for i := range points {
+			words[start+i] = binary.LittleEndian.Uint16(data[i*2:])
+		}
+		return 0, nil
+	}
+	return 0xC059, nil
+}
+
+func newTestClient(t *testing.T, s *testServer, tags []*driver.Tag) *MCClient {
+	return drivertest.NewClient(t, "mc://"+s.listener.Addr().String()+"?timeout=1000", tags).(*MCClient)
+}
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		address, datatype string
+		start, bit, words int
+		device            string
+	}{
+		{"D100", "int16", 100, -1, 1, "D"},
+		{"D100.A", "bool", 100, 10, 1, "D"},
+		{"D200(10)", "string", 200, -1, 10, "D"},
+		{"R10", "float64", 10, -1, 4, "R"},
+		{"W1F", "int32", 0x1F, -1, 2, "W"},
+		{"X1F", "bool", 0x1F, -1, 0, "X"},
+		{"m100", "bool", 100, -1, 0, "M"},
+	}
+	for _, c := range cases {
+		tag, err := ParseAddress(c.address, c.datatype)
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		if tag.Device.Name != c.device || tag.Start != c.start || tag.Bit != c.bit || tag.Words != c.words {
+			t.Errorf("%s: %+v", c.address, tag)
+		}
+	}
+	if tag, _ := parseAddress("X17", "bool", true); tag == nil || tag.Start != 15 {
+		t.Errorf("fx5 octal X17: %+v", tag)
+	}
+	invalid := map[string]string{"M1.2": "bool", "M1": "int16", "D1(2)": "float32", "D1.3": "int16", "Z1": "int16", "X18": "bool"}
+	drivertest.CheckInvalid(t, invalid, func(address, datatype string) error {
+		_, err := parseAddress(address, datatype, true)
+		return err
+	})
+}
+
+func testTags() []*driver.Tag {
+	return []*driver.Tag{
+		{Name: "运行", Address: "M10", Datatype: "bool", Writable: true},
+		{Name: "故障", Address: "M12", Datatype: "bool"},
+		{Name: "报警", Address: "D0.3", Datatype: "bool", Writable: true},
+		{Name: "线速", Address: "D10", Datatype: "uint16", Writable: true},
+		{Name: "温度", Address: "D20", Datatype: "float32", Writable: true},
+		{Name: "批号", Address: "D30(4)", Datatype: "string", Writable: true},
+		{Name: "计数", Address: "R5", Datatype: "int32", Writable: true},
+	}
+}
+
+func TestRead(t *testing.T) {
+	s := newTestServer(t)
+	s.bits[devices["M"].Code][12] = true
+	d := s.words[devices["D"].Code]
+	d[0] = 0x0008
+	d[10] = 1200
+	f := math.Float32bits(36.5)
+	d[20], d[21] = uint16(f), uint16(f>>16)
+	d[30], d[31] = 'D'|'Z'<<8, 'R'
+	r := s.words[devices["R"].Code]
+	r[5], r[6] = 0xFFFE, 0xFFFF
+
+	drivertest.CheckRead(t, newTestClient(t, s, testTags()), map[string]interface{}{
+		"运行": false, "故障": true, "报警": true, "线速": uint16(1200), "温度": float32(36.5), "批号": "DZR", "计数": int32(-2),
+	})
+	// M、D、R 各合并为一次请求
+	if s.requests != 3 {
+		t.Fatalf("requests: %d", s.requests)
+	}
+}
+
+func TestWriteTag(t *testing.T) {
+	s := newTestServer(t)
+	s.words[devices["D"].Code][0] = 0x0101
+	client := newTestClient(t, s, testTags())
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 字中的位为读-改-写，不影响同一字的其他位
+	if err := client.WriteTag("报警", true); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.words[devices["D"].Code][0]; w != 0x0109 {
+		t.Fatalf("set bit: %016b", w)
+	}
+	for name, value := range map[string]interface{}{"运行": true, "线速": 1500.0, "温度": float32(-12.25), "批号": "A-01", "计数": -300.0} {
+		if err := client.WriteTag(name, value); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	d := s.words[devices["D"].Code]
+	if !s.bits[devices["M"].Code][10] {
+		t.Fatal("bit device not set")
+	}
+	if d[10] != 1500 {
+		t.Fatalf("uint16: %d", d[10])
+	}
+	if math.Float32frombits(uint32(d[20])|uint32(d[21])<<16) != -12.25 {
+		t.Fatalf("float32: %04X %04X", d[20], d[21])
+	}
+	if d[30] != 'A'|'-'<<8 || d[31] != '0'|'1'<<8 || d[32] != 0 {
+		t.Fatalf("string: %04X", d[30:34])
+	}
+	if r := s.words[devices["R"].Code]; int32(uint32(r[5])|uint32(r[6])<<16) != -300 {
+		t.Fatalf("int32: %04X %04X", r[5], r[6])
+	}
+}
+
+func TestEndCode(t *testing.T) {
+	s := newTestServer(t)
+	tags := append(testTags(), &driver.Tag{Name: "越界", Address: "D2000", Datatype: "int16"})
+	drivertest.CheckEndCode(t, newTestClient(t, s, tags), "越界", "线速")
+}
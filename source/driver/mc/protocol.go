@@ -0,0 +1,244 @@
+package mc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 3E 帧命令
+const (
+	CmdBatchRead  uint16 = 0x0401
+	CmdBatchWrite uint16 = 0x1401
+
+	SubWord uint16 = 0x0000 // 按字访问
+	SubBit  uint16 = 0x0001 // 按位访问
+
+	maxWordPoints = 960  // 单次批量读写的最大字数
+	maxBitPoints  = 7168 // 单次批量读写的最大位数
+)
+
+// MCError PLC 返回的结束代码非 0
+type MCError struct {
+	Command uint16
+	EndCode uint16
+}
+
+func (e *MCError) Error() string {
+	var text string
+	switch e.EndCode {
+	case 0xC050:
+		text = "ASCII code not convertible"
+	case 0xC051, 0xC052, 0xC053, 0xC054:
+		text = "number of points out of range"
+	case 0xC056:
+		text = "device out of range"
+	case 0xC059:
+		text = "command/subcommand not supported"
+	case 0xC05B:
+		text = "device cannot be accessed"
+	case 0xC05C:
+		text = "request content error"
+	case 0xC061:
+		text = "request data length mismatch"
+	default:
+		text = "unknown error"
+	}
+	return fmt.Sprintf("mc end code 0x%04X (%s) on command 0x%04X", e.EndCode, text, e.Command)
+}
+
+// transport 二进制 3E 帧的收发，同一时间只有一个请求在途
+type transport struct {
+	address string
+	timeout time.Duration
+	network byte   // 网络号
+	pc      byte   // 可编程控制器号
+	io      uint16 // 请求目标模块 I/O 编号
+	station byte   // 请求目标模块站号
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (t *transport) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+func (t *transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.close()
+}
+
+func (t *transport) close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *transport) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+// monitoringTimer 监视定时器，单位 250ms
+func (t *transport) monitoringTimer() uint16 {
+	units := t.timeout / (250 * time.Millisecond)
+	if units < 1 {
+		units = 1
+	}
+	return uint16(min(units, 0xFFFF))
+}
+
+// Send 发送一个请求，payload 为命令、子命令之后的数据，返回结束代码之后的应答数据
+func (t *transport) Send(command, subcommand uint16, payload []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	frame := make([]byte, 15, 15+len(payload))
+	frame[0], frame[1] = 0x50, 0x00
+	frame[2] = t.network
+	frame[3] = t.pc
+	binary.LittleEndian.PutUint16(frame[4:], t.io)
+	frame[6] = t.station
+	binary.LittleEndian.PutUint16(frame[7:], uint16(6+len(payload)))
+	binary.LittleEndian.PutUint16(frame[9:], t.monitoringTimer())
+	binary.LittleEndian.PutUint16(frame[11:], command)
+	binary.LittleEndian.PutUint16(frame[13:], subcommand)
+	frame = append(frame, payload...)
+
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	if _, err := t.conn.Write(frame); err != nil {
+		t.close()
+		return nil, err
+	}
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(t.conn, header); err != nil {
+		t.close()
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint16(header[7:]))
+	if header[0] != 0xD0 || header[1] != 0x00 || length < 2 {
+		t.close()
+		return nil, fmt.Errorf("invalid 3E response header: % X", header)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(t.conn, body); err != nil {
+		t.close()
+		return nil, err
+	}
+	if code := binary.LittleEndian.Uint16(body); code != 0 {
+		return nil, &MCError{Command: command, EndCode: code}
+	}
+	return body[2:], nil
+}
+
+// deviceSpec 起始软元件编号（3 字节）、软元件代码、点数
+func deviceSpec(device Device, start int, points int) []byte {
+	return []byte{byte(start), byte(start >> 8), byte(start >> 16), device.Code, byte(points), byte(points >> 8)}
+}
+
+func (c *MCClient) request(command, subcommand uint16, payload []byte) ([]byte, error) {
+	response, err := c.transport.Send(command, subcommand, payload)
+	if err != nil {
+		if !c.transport.Connected() {
+			c.Connected = false
+		}
+		return nil, err
+	}
+	c.LastPing = time.Now()
+	return response, nil
+}
+
+// readWords 按字批量读取，返回每字 2 字节（低字节在前）
+func (c *MCClient) readWords(device Device, start int, points int) ([]byte, error) {
+	if points <= 0 || points > maxWordPoints {
+		return nil, fmt.Errorf("word points must be 1-%d: %d", maxWordPoints, points)
+	}
+	data, err := c.request(CmdBatchRead, SubWord, deviceSpec(device, start, points))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != points*2 {
+		return nil, fmt.Errorf("response length mismatch: expect %d, got %d", points*2, len(data))
+	}
+	return data, nil
+}
+
+// readBits 按位批量读取，应答每字节包含两个点，高 4 位为前一个点
+func (c *MCClient) readBits(device Device, start int, points int) ([]bool, error) {
+	if points <= 0 || points > maxBitPoints {
+		return nil, fmt.Errorf("bit points must be 1-%d: %d", maxBitPoints, points)
+	}
+	data, err := c.request(CmdBatchRead, SubBit, deviceSpec(device, start, points))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != (points+1)/2 {
+		return nil, fmt.Errorf("response length mismatch: expect %d, got %d", (points+1)/2, len(data))
+	}
+	return unpackBits(data, points), nil
+}
+
+func (c *MCClient) writeWords(device Device, start int, data []byte) error {
+	points := len(data) / 2
+	if points <= 0 || points > maxWordPoints || len(data)%2 != 0 {
+		return fmt.Errorf("invalid word data length: %d", len(data))
+	}
+	_, err := c.request(CmdBatchWrite, SubWord, append(deviceSpec(device, start, points), data...))
+	return err
+}
+
+func (c *MCClient) writeBits(device Device, start int, values []bool) error {
+	if len(values) == 0 || len(values) > maxBitPoints {
+		return fmt.Errorf("bit points must be 1-%d: %d", maxBitPoints, len(values))
+	}
+	_, err := c.request(CmdBatchWrite, SubBit, append(deviceSpec(device, start, len(values)), packBits(values)...))
+	return err
+}
+
+func packBits(values []bool) []byte {
+	data := make([]byte, (len(values)+1)/2)
+	for i, v := range values {
+		if !v {
+			continue
+		}
+		if i%2 == 0 {
+			data[i/2] |= 0x10
+		} else {
+			data[i/2] |= 0x01
+		}
+	}
+	return data
+}
+
+func unpackBits(data []byte, points int) []bool {
+	values := make([]bool, points)
+	for i := range values {
+		if i%2 == 0 {
+			values[i] = data[i/2]&0x10 != 0
+		} else {
+			values[i] = data[i/2]&0x01 != 0
+		}
+	}
+	return values
+}
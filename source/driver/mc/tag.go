@@ -0,0 +1,208 @@
+package mc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	driver "acetek-mes/driver"
+)
+
+// Device 软元件
+type Device struct {
+	Name  string
+	Code  byte // 二进制 3E 帧中的软元件代码
+	Bit   bool // 位软元件
+	Radix int  // 软元件编号的进制
+}
+
+var devices = map[string]Device{
+	"X":  {"X", 0x9C, true, 16},
+	"Y":  {"Y", 0x9D, true, 16},
+	"M":  {"M", 0x90, true, 10},
+	"L":  {"L", 0x92, true, 10},
+	"B":  {"B", 0xA0, true, 16},
+	"SM": {"SM", 0x91, true, 10},
+	"D":  {"D", 0xA8, false, 10},
+	"W":  {"W", 0xB4, false, 16},
+	"R":  {"R", 0xAF, false, 10},
+	"SD": {"SD", 0xA9, false, 10},
+}
+
+const (
+	defaultStringWords = 16
+	maxDeviceNumber    = 0xFFFFFF
+)
+
+type MCTag struct {
+	Device   Device
+	Start    int // 软元件编号
+	Bit      int // 字软元件中的位号, -1 表示整个字
+	Words    int // 字软元件占用的字数，位软元件为 0
+	Raw      string
+	DataType string
+}
+
+// 地址格式：
+//
+//	字软元件：D100 / R200 / W1F（W 为十六进制）
+//	字中的位：D100.A（位号 0-F）
+//	字符串、字节长度（字数）：D200(10)
+//	位软元件：M100 / X1F / Y20，FX5 的 X/Y 为八进制（series=fx5）
+var addressRe = regexp.MustCompile(`(?i)^(SM|SD|X|Y|M|L|B|D|W|R)([0-9A-F]+)(?:\.([0-9A-F]))?(?:\((\d+)\))?$`)
+
+func ParseAddress(address string, datatype string) (*MCTag, error) {
+	return parseAddress(address, datatype, false)
+}
+
+// parseAddress octal 为 true 时 X/Y 按八进制编号
+func parseAddress(address string, datatype string, octal bool) (*MCTag, error) {
+	match := addressRe.FindStringSubmatch(strings.TrimSpace(address))
+	if match == nil {
+		return nil, fmt.Errorf("invalid address format: %s", address)
+	}
+	device := devices[strings.ToUpper(match[1])]
+	radix := device.Radix
+	if octal && (device.Name == "X" || device.Name == "Y") {
+		radix = 8
+	}
+	start, err := strconv.ParseInt(match[2], radix, 32)
+	if err != nil || start > maxDeviceNumber {
+		return nil, fmt.Errorf("invalid device number: %s", address)
+	}
+	tag := &MCTag{Device: device, Start: int(start), Bit: -1, Raw: address, DataType: datatype}
+	bit, length := match[3], match[4]
+
+	if device.Bit {
+		if bit != "" || length != "" {
+			return nil, fmt.Errorf("bit or length not allowed on bit device: %s", address)
+		}
+		if datatype != driver.TypeBool {
+			return nil, fmt.Errorf("bit device only supports bool: %s", address)
+		}
+		return tag, nil
+	}
+
+	if bit != "" {
+		b, _ := strconv.ParseInt(bit, 16, 8)
+		tag.Bit = int(b)
+		if datatype != driver.TypeBool {
+			return nil, fmt.Errorf("word bit only supports bool: %s", address)
+		}
+	}
+	switch datatype {
+	case driver.TypeBool, driver.TypeByte, driver.TypeInt16, driver.TypeUInt16:
+		tag.Words = 1
+	case driver.TypeInt32, driver.TypeUInt32, driver.TypeFloat32:
+		tag.Words = 2
+	case driver.TypeInt64, driver.TypeFloat64:
+		tag.Words = 4
+	case driver.TypeString, driver.TypeBytes:
+		tag.Words = defaultStringWords
+		if length != "" {
+			l, _ := strconv.Atoi(length)
+			if l <= 0 || l > maxWordPoints {
+				return nil, fmt.Errorf("length must be 1-%d words: %s", maxWordPoints, address)
+			}
+			tag.Words = l
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type: %s", datatype)
+	}
+	if length != "" && datatype != driver.TypeString && datatype != driver.TypeBytes {
+		return nil, fmt.Errorf("length only allowed on string/bytes: %s", address)
+	}
+	if tag.Start+tag.Words-1 > maxDeviceNumber {
+		return nil, fmt.Errorf("address out of range: %s", address)
+	}
+	return tag, nil
+}
+
+// ParseValueFromBuffer 解析字软元件的数据，三菱 PLC 为小端：字内低字节在前，多字的值低字在前
+func ParseValueFromBuffer(tag MCTag, buffer []byte) (any, error) {
+	if len(buffer) < tag.Words*2 {
+		return nil, errors.New("buffer too short for words")
+	}
+	b := buffer[:tag.Words*2]
+	if tag.Bit >= 0 {
+		return binary.LittleEndian.Uint16(b)&(1<<uint(tag.Bit)) != 0, nil
+	}
+	switch tag.DataType {
+	case driver.TypeBool:
+		return binary.LittleEndian.Uint16(b) != 0, nil
+	case driver.TypeByte:
+		return b[0], nil
+	case driver.TypeBytes:
+		return slices.Clone(b), nil
+	case driver.TypeInt16:
+		return int16(binary.LittleEndian.Uint16(b)), nil
+	case driver.TypeUInt16:
+		return binary.LittleEndian.Uint16(b), nil
+	case driver.TypeInt32:
+		return int32(binary.LittleEndian.Uint32(b)), nil
+	case driver.TypeUInt32:
+		return binary.LittleEndian.Uint32(b), nil
+	case driver.TypeFloat32:
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case driver.TypeInt64:
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	case driver.TypeFloat64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case driver.TypeString:
+		return strings.TrimRight(string(b), "\x00 "), nil
+	default:
+		return nil, errors.New("unsupported data type: " + tag.DataType)
+	}
+}
+
+// valueToWords 将写入值编码为字软元件数据
+func valueToWords(t *driver.Tag, tag MCTag, value interface{}) ([]byte, error) {
+	size := tag.Words * 2
+	switch tag.DataType {
+	case driver.TypeBool:
+		v, ok := t.ConvertValue(value).(bool)
+		if !ok {
+			return nil, fmt.Errorf("期望写入 bool 类型")
+		}
+		if v {
+			return []byte{1, 0}, nil
+		}
+		return []byte{0, 0}, nil
+	case driver.TypeByte:
+		v, ok := t.ConvertValue(value).(byte)
+		if !ok {
+			return nil, fmt.Errorf("期望写入 byte 类型")
+		}
+		return []byte{v, 0}, nil
+	case driver.TypeString, driver.TypeBytes:
+		var data []byte
+		if tag.DataType == driver.TypeString {
+			data = []byte(fmt.Sprintf("%v", value))
+		} else if v, ok := t.ConvertValue(value).([]byte); ok {
+			data = v
+		} else {
+			return nil, fmt.Errorf("期望写入 bytes 类型")
+		}
+		if len(data) > size {
+			return nil, fmt.Errorf("value too long: %d > %d bytes", len(data), size)
+		}
+		b := make([]byte, size)
+		copy(b, data)
+		return b, nil
+	}
+	data, err := t.ConvertToBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("unexpected encoded length %d for %s", len(data), tag.DataType)
+	}
+	// ConvertToBytes 为大端，整体反转即为低字在前的小端
+	slices.Reverse(data)
+	return data, nil
+}
@@ -0,0 +1,295 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"acetek-mes/driver"
+
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+type ModbusClient struct {
+	driver.Driver
+	transport transporter
+	addr      string
+	unit      byte
+	order     ByteOrder
+}
+
+// modbus://host:502?unit=1&interval=1000&timeout=2000&byteorder=big&wordorder=big
+func NewModbusClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "502"
+	}
+	transport := &tcpTransporter{
+		address: fmt.Sprintf("%s:%s", u.Hostname(), port),
+		timeout: parseTimeout(u, 2*time.Second),
+	}
+	return newModbusClient(id, name, rawURL, transport, tags)
+}
+
+func parseTimeout(u *url.URL, def time.Duration) time.Duration {
+	if t := u.Query().Get("timeout"); t != "" {
+		if ms, err := strconv.Atoi(t); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return def
+}
+
+func newModbusClient(id string, name string, rawURL string, transport transporter, tags []*driver.Tag) (*ModbusClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	unit := 1
+	var interval uint32 = 1000
+	if s := u.Query().Get("unit"); s != "" {
+		if unit, err = strconv.Atoi(s); err != nil || unit < 0 || unit > 255 {
+			return nil, fmt.Errorf("invalid unit: %s", s)
+		}
+	}
+	if i := u.Query().Get("interval"); i != "" {
+		if intval, err := strconv.Atoi(i); err == nil {
+			interval = uint32(intval)
+		}
+	}
+	order, err := ParseByteOrder(u.Query().Get("byteorder"), u.Query().Get("wordorder"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ModbusClient{
+		transport: transport,
+		addr:      rawURL,
+		unit:      byte(unit),
+		order:     order,
+		Driver: driver.Driver{
+			ID:            id,
+			Name:          name,
+			Tags:          driver.TagMap(parseTags(tags)),
+			Interval:      interval,
+			ChCommand:     make(chan string, 100),
+			ChWrite:       make(chan *driver.WriteRequest, 100),
+			ChWriteResult: make(chan error),
+			ChConfig:      make(chan *driver.Config, 1),
+		},
+	}
+	c.InitScanGroups()
+	return c, nil
+}
+
+// parseTags 解析变量地址，返回解析成功的变量
+func parseTags(tags []*driver.Tag) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if mt, err := ParseAddress(v.Address, v.Datatype); err == nil {
+			v.Parsed = true
+			v.Mate = *mt
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse address error:", err)
+		}
+	}
+	return result
+}
+
+// 注册 Modbus TCP 驱动
+func init() {
+	logger.TxtLog("register driver modbus")
+	driver.RegisterDriver("modbus", NewModbusClient)
+}
+
+func (c *ModbusClient) Connect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if c.Connected {
+		return nil
+	}
+	if err := c.transport.Connect(); err != nil {
+		return err
+	}
+	c.Connected = true
+	return nil
+}
+
+func (c *ModbusClient) Disconnect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = false
+	return c.transport.Close()
+}
+
+func (c *ModbusClient) IsConnected() bool {
+	return c.Connected
+}
+
+func (c *ModbusClient) reconnectIfNeeded() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if !c.Connected {
+		c.transport.Close()
+		if err := c.transport.Connect(); err != nil {
+			return fmt.Errorf("%w: %v", driver.ErrConnection, err)
+		}
+		c.Connected = true
+		c.LastPing = time.Now()
+	}
+	return nil
+}
+
+func (c *ModbusClient) tagUnit(t ModbusTag) byte {
+	if t.Unit >= 0 {
+		return byte(t.Unit)
+	}
+	return c.unit
+}
+
+func (c *ModbusClient) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (c *ModbusClient) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if err := c.reconnectIfNeeded(); err != nil {
+		return nil, err
+	}
+	for _, v := range g.Tags {
+		if !v.Parsed {
+			continue
+		}
+		mt, ok := v.Mate.(ModbusTag)
+		if !ok {
+			log.Println("tag is not modbus tag")
+			continue
+		}
+		buffer, resultError := c.readData(c.tagUnit(mt), mt.Area, mt.Start, mt.Quantity)
+		var value any
+		var err error
+		if resultError == nil {
+			value, err = ParseValueFromBuffer(mt, c.order, buffer)
+		}
+		if resultError == nil && err == nil {
+			v.Quality = "Good"
+			v.Value = v.Scale(value)
+		} else {
+			v.Quality = "Bad"
+			v.Value = nil
+			log.Println("resultError:", resultError, "parse error:", err)
+		}
+		v.Timestamp = time.Now()
+		result[v.Name] = v.Value
+		if !c.Connected || driver.IsConnError(resultError) {
+			return result, resultError
+		}
+	}
+	return result, nil
+}
+
+func (c *ModbusClient) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
+}
+
+func (c *ModbusClient) write(values map[string]interface{}) error {
+	for k, v := range values {
+		if t, ok := c.Tags[k]; !ok {
+			return fmt.Errorf("tag %s is not define", k)
+		} else {
+			if !t.Writable {
+				return fmt.Errorf("tag %s is readonly", k)
+			} else {
+				return c.WriteTag(k, v)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ModbusClient) WriteTag(name string, value interface{}) error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	tag, ok := c.Tags[name]
+	if !ok {
+		return fmt.Errorf("tag %s is not defined", name)
+	}
+	t, ok := tag.Mate.(ModbusTag)
+	if !ok {
+		return fmt.Errorf("tag %s Mate not ModbusTag", name)
+	}
+	unit := c.tagUnit(t)
+
+	switch t.Area {
+	case AreaCoil:
+		v, ok := tag.ConvertValue(value).(bool)
+		if !ok {
+			return fmt.Errorf("期望写入 bool 类型")
+		}
+		return c.writeSingleCoil(unit, t.Start, v)
+	case AreaHoldingRegister:
+	default:
+		return fmt.Errorf("区域不可写: %s", t.Raw)
+	}
+
+	// 寄存器位写入：读-改-写
+	if t.Bit >= 0 {
+		v, ok := tag.ConvertValue(value).(bool)
+		if !ok {
+			return fmt.Errorf("期望写入 bool 类型")
+		}
+		buffer, err := c.readData(unit, t.Area, t.Start, 1)
+		if err != nil {
+			return fmt.Errorf("读取原始寄存器失败: %v", err)
+		}
+		word := driver.BytesToUint16(buffer)
+		if v {
+			word |= 1 << uint(t.Bit)
+		} else {
+			word &^= 1 << uint(t.Bit)
+		}
+		return c.writeRegisters(unit, t.Start, []byte{byte(word >> 8), byte(word)})
+	}
+
+	raw, err := tag.Unscale(value)
+	if err != nil {
+		return err
+	}
+	data, err := valueToRegisters(tag, t, c.order, raw)
+	if err != nil {
+		return err
+	}
+	return c.writeRegisters(unit, t.Start, data)
+}
+
+func (c *ModbusClient) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, func() { c.Disconnect() })
+}
+
+func (c *ModbusClient) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags)
+	return c.Driver.Reconfig(cfg)
+}
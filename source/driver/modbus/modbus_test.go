@@ -0,0 +1,247 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	driver "acetek-mes/driver"
+)
+
+// testServer 内存中的 Modbus TCP 从站，仅用于测试
+type testServer struct {
+	listener  net.Listener
+	mu        sync.Mutex
+	coils     [256]bool
+	registers [256]uint16
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return startTestServer(t, func(s *testServer, conn net.Conn) { s.serve(conn) })
+}
+
+// newRTUTestServer 模拟串口服务器后的 RTU 从站，只应答 unit 1
+func newRTUTestServer(t *testing.T) *testServer {
+	return startTestServer(t, func(s *testServer, conn net.Conn) { s.serveRTU(conn, 1) })
+}
+
+func startTestServer(t *testing.T, serve func(*testServer, net.Conn)) *testServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(s, conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		response := s.handle(pdu)
+		frame := append(header[:4:4], 0, 0, header[6])
+		binary.BigEndian.PutUint16(frame[4:], uint16(len(response)+1))
+		conn.Write(append(frame, response...))
+	}
+}
+
+func (s *testServer) serveRTU(conn net.Conn, unit byte) {
+	defer conn.Close()
+	for {
+		frame := make([]byte, 256)
+		n, err := conn.Read(frame)
+		if err != nil {
+			return
+		}
+		frame = frame[:n]
+		if n < 4 || frame[0] != unit || crc16(frame[:n-2]) != uint16(frame[n-2])|uint16(frame[n-1])<<8 {
+			continue
+		}
+		response := append([]byte{unit}, s.handle(frame[1:n-2])...)
+		crc := crc16(response)
+		// 分两次发送，模拟串口服务器拆包
+		conn.Write(response[:2])
+		time.Sleep(5 * time.Millisecond)
+		conn.Write(append(response[2:], byte(crc), byte(crc>>8)))
+	}
+}
+
+func (s *testServer) handle(pdu []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	address := int(binary.BigEndian.Uint16(pdu[1:]))
+	switch pdu[0] {
+	case FuncReadCoils, FuncReadDiscreteInputs:
+		quantity := int(binary.BigEndian.Uint16(pdu[3:]))
+		data := make([]byte, (quantity+7)/8)
+		for i := 0; i < quantity; i++ {
+			if s.coils[address+i] {
+				data[i/8] |= 1 << uint(i%8)
+			}
+		}
+		return append([]byte{pdu[0], byte(len(data))}, data...)
+	case FuncReadHoldingRegisters, FuncReadInputRegisters:
+		quantity := int(binary.BigEndian.Uint16(pdu[3:]))
+		if address+quantity > len(s.registers) {
+			return []byte{pdu[0] | 0x80, 0x02}
+		}
+		data := make([]byte, quantity*2)
+		for i := 0; i < quantity; i++ {
+			binary.BigEndian.PutUint16(data[i*2:], s.registers[address+i])
+		}
+		return append([]byte{pdu[0], byte(len(data))}, data...)
+	case FuncWriteSingleCoil:
+		s.coils[address] = pdu[3] == 0xFF
+		return pdu
+	case FuncWriteSingleRegister:
+		s.registers[address] = binary.BigEndian.Uint16(pdu[3:])
+		return pdu
+	case FuncWriteMultipleRegisters:
+		quantity := int(binary.BigEndian.Uint16(pdu[3:]))
+		for i := 0; i < quantity; i++ {
+			s.registers[address+i] = binary.BigEndian.Uint16(pdu[6+i*2:])
+		}
+		return pdu[:5]
+	}
+	return []byte{pdu[0] | 0x80, 0x01}
+}
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		address  string
+		datatype string
+		area     Area
+		start    uint16
+		bit      int
+		quantity uint16
+		unit     int
+	}{
+		{"40001", driver.TypeInt16, AreaHoldingRegister, 0, -1, 1, -1},
+		{"400101", driver.TypeFloat32, AreaHoldingRegister, 100, -1, 2, -1},
+		{"30010", driver.TypeUInt32, AreaInputRegister, 9, -1, 2, -1},
+		{"00005", driver.TypeBool, AreaCoil, 4, -1, 1, -1},
+		{"10001", driver.TypeBool, AreaDiscreteInput, 0, -1, 1, -1},
+		{"HR100", driver.TypeUInt16, AreaHoldingRegister, 100, -1, 1, -1},
+		{"hr100.3", driver.TypeBool, AreaHoldingRegister, 100, 3, 1, -1},
+		{"C5", driver.TypeBool, AreaCoil, 5, -1, 1, -1},
+		{"DI7", driver.TypeBool, AreaDiscreteInput, 7, -1, 1, -1},
+		{"IR2(10)", driver.TypeString, AreaInputRegister, 2, -1, 10, -1},
+		{"3:40010", driver.TypeInt32, AreaHoldingRegister, 9, -1, 2, 3},
+	}
+	for _, c := range cases {
+		tag, err := ParseAddress(c.address, c.datatype)
+		if err != nil {
+			t.Errorf("%s: %v", c.address, err)
+			continue
+		}
+		if tag.Area != c.area || tag.Start != c.start || tag.Bit != c.bit || tag.Quantity != c.quantity || tag.Unit != c.unit {
+			t.Errorf("%s: got %+v", c.address, tag)
+		}
+	}
+	for _, address := range []string{"50001", "C5.1", "HR1.16", "X100", "40000"} {
+		if _, err := ParseAddress(address, driver.TypeBool); err == nil {
+			t.Errorf("%s: expect error", address)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	server := newTestServer(t)
+	server.registers[0] = 0xFFFE
+	// 3.14 按 CDAB 存放
+	server.registers[10] = 0xF5C3
+	server.registers[11] = 0x4048
+	server.coils[3] = true
+
+	tags := []*driver.Tag{
+		{Name: "i16", Address: "40001", Datatype: driver.TypeInt16, Writable: true},
+		{Name: "f32", Address: "HR10", Datatype: driver.TypeFloat32, Writable: true},
+		{Name: "coil", Address: "C3", Datatype: driver.TypeBool, Writable: true},
+		{Name: "bit", Address: "HR20.2", Datatype: driver.TypeBool, Writable: true},
+		{Name: "str", Address: "HR30(4)", Datatype: driver.TypeString, Writable: true},
+	}
+	c, err := NewModbusClient("MB", "", "modbus://"+server.listener.Addr().String()+"?wordorder=little", tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*ModbusClient)
+	values, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["i16"] != int16(-2) || values["f32"] != float32(3.14) || values["coil"] != true || values["bit"] != false {
+		t.Fatalf("unexpected values: %+v", values)
+	}
+
+	for name, value := range map[string]interface{}{"i16": 1234, "f32": 1.5, "coil": false, "bit": true, "str": "DZR"} {
+		if err := client.WriteTag(name, value); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if server.registers[11] != 0x3FC0 || server.registers[10] != 0 || server.registers[20] != 4 || server.coils[3] {
+		t.Fatalf("unexpected server state: %v %v", server.registers[:32], server.coils[3])
+	}
+	values, _ = client.Read()
+	if values["i16"] != int16(1234) || values["f32"] != float32(1.5) || values["str"] != "DZR" {
+		t.Fatalf("unexpected values after write: %+v", values)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	if crc := crc16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}); crc != 0xCDC5 {
+		t.Fatalf("crc16 = %04X", crc)
+	}
+}
+
+func TestRTUOverTCP(t *testing.T) {
+	server := newRTUTestServer(t)
+	server.registers[5] = 0x1234
+	tags := []*driver.Tag{
+		{Name: "weight", Address: "HR5", Datatype: driver.TypeUInt16, Writable: true},
+		{Name: "offline", Address: "2:HR5", Datatype: driver.TypeUInt16},
+	}
+	c, err := NewModbusRTUOverTCPClient("RTU", "", "modbusrtu+tcp://"+server.listener.Addr().String()+"?timeout=200", tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*ModbusClient)
+	// 从站 2 不应答不能影响同一链路上的从站 1
+	for i := 0; i < 2; i++ {
+		values, err := client.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values["weight"] != uint16(0x1234) || values["offline"] != nil {
+			t.Fatalf("unexpected values: %+v", values)
+		}
+		if !client.IsConnected() {
+			t.Fatal("timeout of one slave must not drop the connection")
+		}
+	}
+	if err := client.WriteTag("weight", 42); err != nil {
+		t.Fatal(err)
+	}
+	if server.registers[5] != 42 {
+		t.Fatalf("register = %d", server.registers[5])
+	}
+}
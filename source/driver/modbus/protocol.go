@@ -0,0 +1,231 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	FuncReadCoils              byte = 0x01
+	FuncReadDiscreteInputs     byte = 0x02
+	FuncReadHoldingRegisters   byte = 0x03
+	FuncReadInputRegisters     byte = 0x04
+	FuncWriteSingleCoil        byte = 0x05
+	FuncWriteSingleRegister    byte = 0x06
+	FuncWriteMultipleCoils     byte = 0x0F
+	FuncWriteMultipleRegisters byte = 0x10
+)
+
+// ModbusError 从站返回的异常响应
+type ModbusError struct {
+	FunctionCode  byte
+	ExceptionCode byte
+}
+
+func (e *ModbusError) Error() string {
+	var text string
+	switch e.ExceptionCode {
+	case 0x01:
+		text = "illegal function"
+	case 0x02:
+		text = "illegal data address"
+	case 0x03:
+		text = "illegal data value"
+	case 0x04:
+		text = "server device failure"
+	case 0x06:
+		text = "server device busy"
+	case 0x0A:
+		text = "gateway path unavailable"
+	case 0x0B:
+		text = "gateway target device failed to respond"
+	default:
+		text = "unknown exception"
+	}
+	return fmt.Sprintf("modbus exception 0x%02X (%s) on function 0x%02X", e.ExceptionCode, text, e.FunctionCode)
+}
+
+// transporter 负责报文封装与收发，PDU 层与传输层分离
+type transporter interface {
+	Connect() error
+	Close() error
+	Connected() bool
+	Send(unit byte, pdu []byte) ([]byte, error)
+}
+
+// tcpTransporter Modbus TCP（MBAP 报文头）
+type tcpTransporter struct {
+	address       string
+	timeout       time.Duration
+	mu            sync.Mutex
+	conn          net.Conn
+	transactionID uint16
+}
+
+func (t *tcpTransporter) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+func (t *tcpTransporter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.close()
+}
+
+func (t *tcpTransporter) close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *tcpTransporter) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+func (t *tcpTransporter) Send(unit byte, pdu []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	t.transactionID++
+	frame := make([]byte, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], t.transactionID)
+	binary.BigEndian.PutUint16(frame[2:], 0)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(pdu)+1))
+	frame[6] = unit
+	copy(frame[7:], pdu)
+
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	if _, err := t.conn.Write(frame); err != nil {
+		t.close()
+		return nil, err
+	}
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(t.conn, header); err != nil {
+			t.close()
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > 254 {
+			t.close()
+			return nil, fmt.Errorf("invalid mbap header: % X", header)
+		}
+		body := make([]byte, length-1)
+		if _, err := io.ReadFull(t.conn, body); err != nil {
+			t.close()
+			return nil, err
+		}
+		// 丢弃超时后迟到的旧响应
+		if tid := binary.BigEndian.Uint16(header[0:]); tid != t.transactionID {
+			continue
+		}
+		if header[6] != unit {
+			return nil, fmt.Errorf("unit id mismatch: expect %d, got %d", unit, header[6])
+		}
+		return body, nil
+	}
+}
+
+func checkResponse(request []byte, response []byte) error {
+	if len(response) < 2 {
+		return fmt.Errorf("response too short: % X", response)
+	}
+	if response[0] == request[0]|0x80 {
+		return &ModbusError{FunctionCode: request[0], ExceptionCode: response[1]}
+	}
+	if response[0] != request[0] {
+		return fmt.Errorf("function code mismatch: expect 0x%02X, got 0x%02X", request[0], response[0])
+	}
+	return nil
+}
+
+func (c *ModbusClient) request(unit byte, pdu []byte) ([]byte, error) {
+	response, err := c.transport.Send(unit, pdu)
+	if err != nil {
+		if !c.transport.Connected() {
+			c.Connected = false
+		}
+		return nil, err
+	}
+	if err := checkResponse(pdu, response); err != nil {
+		return nil, err
+	}
+	c.LastPing = time.Now()
+	return response, nil
+}
+
+// readData 读取线圈/离散输入/寄存器，返回响应中的数据部分
+func (c *ModbusClient) readData(unit byte, area Area, start, quantity uint16) ([]byte, error) {
+	if (area == AreaCoil || area == AreaDiscreteInput) && quantity > maxReadBits {
+		return nil, fmt.Errorf("too many bits: %d", quantity)
+	}
+	if (area == AreaHoldingRegister || area == AreaInputRegister) && quantity > maxReadRegisters {
+		return nil, fmt.Errorf("too many registers: %d", quantity)
+	}
+	pdu := make([]byte, 5)
+	pdu[0] = byte(area)
+	binary.BigEndian.PutUint16(pdu[1:], start)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+	response, err := c.request(unit, pdu)
+	if err != nil {
+		return nil, err
+	}
+	count := int(response[1])
+	expect := int(quantity) * 2
+	if area == AreaCoil || area == AreaDiscreteInput {
+		expect = (int(quantity) + 7) / 8
+	}
+	if count != expect || len(response) < 2+count {
+		return nil, fmt.Errorf("response byte count mismatch: expect %d, got %d", expect, count)
+	}
+	return response[2 : 2+count], nil
+}
+
+func (c *ModbusClient) writeSingleCoil(unit byte, address uint16, value bool) error {
+	pdu := []byte{FuncWriteSingleCoil, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	if value {
+		pdu[3] = 0xFF
+	}
+	_, err := c.request(unit, pdu)
+	return err
+}
+
+func (c *ModbusClient) writeRegisters(unit byte, address uint16, data []byte) error {
+	if len(data) == 2 {
+		pdu := []byte{FuncWriteSingleRegister, 0, 0, data[0], data[1]}
+		binary.BigEndian.PutUint16(pdu[1:], address)
+		_, err := c.request(unit, pdu)
+		return err
+	}
+	quantity := len(data) / 2
+	pdu := make([]byte, 6+len(data))
+	pdu[0] = FuncWriteMultipleRegisters
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], uint16(quantity))
+	pdu[5] = byte(len(data))
+	copy(pdu[6:], data)
+	_, err := c.request(unit, pdu)
+	return err
+}
@@ -0,0 +1,220 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"acetek-mes/driver"
+
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+const (
+	rtuMinFrameSize = 4   // 从站号 + 功能码 + CRC
+	rtuMaxFrameSize = 256 // 从站号 + PDU(253) + CRC
+)
+
+// crc16 Modbus RTU 校验（多项式 0xA001，初值 0xFFFF）
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// rtuFrameDelay 按波特率计算 3.5 个字符的帧间隔（每字符 11 位），19200 以上固定 1.75ms
+func rtuFrameDelay(baud int) time.Duration {
+	if baud <= 0 || baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(38500000/baud) * time.Microsecond
+}
+
+// rtuTransporter 通过串口服务器（透明传输）发送 RTU 帧，一个 socket 上可轮询多个从站。
+// RTU 帧没有事务号，只能一问一答，超时后需要清掉迟到的应答再发下一帧。
+type rtuTransporter struct {
+	address    string
+	timeout    time.Duration // 整帧应答超时
+	frameDelay time.Duration // 帧间静默时间
+	mu         sync.Mutex
+	conn       net.Conn
+	lastActive time.Time
+}
+
+func (t *rtuTransporter) Connect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", t.address, t.timeout)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+func (t *rtuTransporter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.close()
+}
+
+func (t *rtuTransporter) close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *rtuTransporter) Connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn != nil
+}
+
+// flush 丢弃上一次超时后才到达的数据
+func (t *rtuTransporter) flush() error {
+	buffer := make([]byte, rtuMaxFrameSize)
+	for {
+		t.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+		n, err := t.conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+func (t *rtuTransporter) Send(unit byte, pdu []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	if wait := t.frameDelay - time.Since(t.lastActive); wait > 0 {
+		time.Sleep(wait)
+	}
+	if err := t.flush(); err != nil {
+		t.close()
+		return nil, err
+	}
+
+	frame := make([]byte, 0, len(pdu)+3)
+	frame = append(frame, unit)
+	frame = append(frame, pdu...)
+	crc := crc16(frame)
+	frame = append(frame, byte(crc), byte(crc>>8))
+
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	_, err := t.conn.Write(frame)
+	if err == nil {
+		var response []byte
+		response, err = t.readFrame(pdu[0])
+		t.lastActive = time.Now()
+		if err == nil {
+			if response[0] != unit {
+				return nil, fmt.Errorf("unit id mismatch: expect %d, got %d", unit, response[0])
+			}
+			return response[1 : len(response)-2], nil
+		}
+	}
+	// 超时只影响当前从站，其余错误说明链路已断开
+	if !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, errCRC) {
+		t.close()
+	}
+	return nil, err
+}
+
+var errCRC = errors.New("crc mismatch")
+
+// readFrame 根据功能码推算应答长度读取一帧
+func (t *rtuTransporter) readFrame(function byte) ([]byte, error) {
+	frame := make([]byte, rtuMaxFrameSize)
+	if _, err := io.ReadFull(t.conn, frame[:3]); err != nil {
+		return nil, err
+	}
+	var length int
+	switch {
+	case frame[1] == function|0x80:
+		length = 5
+	case frame[1] != function:
+		return nil, fmt.Errorf("function code mismatch: expect 0x%02X, got 0x%02X", function, frame[1])
+	case function <= FuncReadInputRegisters:
+		length = 3 + int(frame[2]) + 2
+	case function == FuncWriteSingleCoil, function == FuncWriteSingleRegister,
+		function == FuncWriteMultipleCoils, function == FuncWriteMultipleRegisters:
+		length = 8
+	default:
+		return nil, fmt.Errorf("unsupported function code: 0x%02X", function)
+	}
+	if length > rtuMaxFrameSize || length < rtuMinFrameSize {
+		return nil, fmt.Errorf("invalid frame length: %d", length)
+	}
+	if _, err := io.ReadFull(t.conn, frame[3:length]); err != nil {
+		return nil, err
+	}
+	frame = frame[:length]
+	if crc := crc16(frame[:length-2]); byte(crc) != frame[length-2] || byte(crc>>8) != frame[length-1] {
+		return nil, fmt.Errorf("%w: % X", errCRC, frame)
+	}
+	return frame, nil
+}
+
+// modbusrtu+tcp://host:port?unit=1&interval=1000&timeout=1000&baud=9600&framedelay=5
+func NewModbusRTUOverTCPClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("serial server port is required: %s", rawURL)
+	}
+	baud := 9600
+	if b := u.Query().Get("baud"); b != "" {
+		if baud, err = strconv.Atoi(b); err != nil {
+			return nil, fmt.Errorf("invalid baud: %s", b)
+		}
+	}
+	frameDelay := rtuFrameDelay(baud)
+	if d := u.Query().Get("framedelay"); d != "" {
+		if ms, err := strconv.Atoi(d); err == nil && ms >= 0 {
+			frameDelay = time.Duration(ms) * time.Millisecond
+		}
+	}
+	transport := &rtuTransporter{
+		address:    u.Host,
+		timeout:    parseTimeout(u, time.Second),
+		frameDelay: frameDelay,
+	}
+	return newModbusClient(id, name, rawURL, transport, tags)
+}
+
+// 注册 Modbus RTU over TCP 驱动
+func init() {
+	logger.TxtLog("register driver modbusrtu+tcp")
+	driver.RegisterDriver("modbusrtu+tcp", NewModbusRTUOverTCPClient)
+}
@@ -0,0 +1,282 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	driver "acetek-mes/driver"
+)
+
+type Area byte
+
+const (
+	AreaCoil            Area = 0x01 // 线圈 0xxxx
+	AreaDiscreteInput   Area = 0x02 // 离散输入 1xxxx
+	AreaHoldingRegister Area = 0x03 // 保持寄存器 4xxxx
+	AreaInputRegister   Area = 0x04 // 输入寄存器 3xxxx
+)
+
+const (
+	defaultStringRegisters = 16
+	maxReadRegisters       = 125
+	maxReadBits            = 2000
+)
+
+type ModbusTag struct {
+	Unit     int // 从站号, -1 表示使用驱动 URL 中的 unit
+	Area     Area
+	Start    uint16 // 协议地址（从 0 开始）
+	Bit      int    // 寄存器中的位号, -1 表示整个寄存器
+	Quantity uint16 // 寄存器或线圈个数
+	Raw      string
+	DataType string
+}
+
+// 地址格式：
+//
+//	经典格式（从 1 开始）：00001 / 10001 / 30001 / 40001，也支持 6 位 400001
+//	前缀格式（从 0 开始）：C5 / DI5 / IR100 / HR100
+//	寄存器位：40001.3 / HR100.3
+//	字符串、字节长度（寄存器个数）：HR100(10)
+//	指定从站：2:40001 / 2:HR100
+var (
+	classicRe = regexp.MustCompile(`^([0134])(\d{4,5})(?:\.(\d{1,2}))?(?:\((\d+)\))?$`)
+	prefixRe  = regexp.MustCompile(`(?i)^(C|DI|IR|HR)(\d+)(?:\.(\d{1,2}))?(?:\((\d+)\))?$`)
+)
+
+func ParseAddress(address string, datatype string) (*ModbusTag, error) {
+	tag := &ModbusTag{Raw: address, Unit: -1, Bit: -1, DataType: datatype}
+	addr := strings.TrimSpace(address)
+
+	if i := strings.Index(addr, ":"); i > 0 {
+		unit, err := strconv.Atoi(addr[:i])
+		if err != nil || unit < 0 || unit > 255 {
+			return nil, fmt.Errorf("invalid unit id in address: %s", address)
+		}
+		tag.Unit = unit
+		addr = addr[i+1:]
+	}
+
+	var offset int
+	var bit, length string
+	if match := classicRe.FindStringSubmatch(addr); match != nil {
+		n, _ := strconv.Atoi(match[2])
+		if n < 1 || n > 65536 {
+			return nil, fmt.Errorf("address out of range: %s", address)
+		}
+		offset = n - 1
+		switch match[1] {
+		case "0":
+			tag.Area = AreaCoil
+		case "1":
+			tag.Area = AreaDiscreteInput
+		case "3":
+			tag.Area = AreaInputRegister
+		case "4":
+			tag.Area = AreaHoldingRegister
+		}
+		bit, length = match[3], match[4]
+	} else if match := prefixRe.FindStringSubmatch(addr); match != nil {
+		n, err := strconv.Atoi(match[2])
+		if err != nil || n > 65535 {
+			return nil, fmt.Errorf("address out of range: %s", address)
+		}
+		offset = n
+		switch strings.ToUpper(match[1]) {
+		case "C":
+			tag.Area = AreaCoil
+		case "DI":
+			tag.Area = AreaDiscreteInput
+		case "IR":
+			tag.Area = AreaInputRegister
+		case "HR":
+			tag.Area = AreaHoldingRegister
+		}
+		bit, length = match[3], match[4]
+	} else {
+		return nil, fmt.Errorf("invalid address format: %s", address)
+	}
+	tag.Start = uint16(offset)
+
+	if tag.Area == AreaCoil || tag.Area == AreaDiscreteInput {
+		if bit != "" || length != "" {
+			return nil, fmt.Errorf("bit or length not allowed on coil/discrete input: %s", address)
+		}
+		if datatype != driver.TypeBool {
+			return nil, fmt.Errorf("coil/discrete input only supports bool: %s", address)
+		}
+		tag.Quantity = 1
+		return tag, nil
+	}
+
+	if bit != "" {
+		tag.Bit, _ = strconv.Atoi(bit)
+		if tag.Bit > 15 {
+			return nil, fmt.Errorf("bit offset must be 0-15: %s", address)
+		}
+		if datatype != driver.TypeBool {
+			return nil, fmt.Errorf("register bit only supports bool: %s", address)
+		}
+	}
+
+	switch datatype {
+	case driver.TypeBool, driver.TypeByte, driver.TypeInt16, driver.TypeUInt16:
+		tag.Quantity = 1
+	case driver.TypeInt32, driver.TypeUInt32, driver.TypeFloat32:
+		tag.Quantity = 2
+	case driver.TypeString, driver.TypeBytes:
+		tag.Quantity = defaultStringRegisters
+		if length != "" {
+			l, _ := strconv.Atoi(length)
+			if l <= 0 || l > maxReadRegisters {
+				return nil, fmt.Errorf("length must be 1-%d registers: %s", maxReadRegisters, address)
+			}
+			tag.Quantity = uint16(l)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data type: %s", datatype)
+	}
+	if length != "" && datatype != driver.TypeString && datatype != driver.TypeBytes {
+		return nil, fmt.Errorf("length only allowed on string/bytes: %s", address)
+	}
+	if int(tag.Start)+int(tag.Quantity) > 65536 {
+		return nil, fmt.Errorf("address out of range: %s", address)
+	}
+	return tag, nil
+}
+
+// ByteOrder 描述设备的字节序/字序，ByteSwap 为寄存器内高低字节交换，WordSwap 为多寄存器值的低字在前
+type ByteOrder struct {
+	ByteSwap bool
+	WordSwap bool
+}
+
+func ParseByteOrder(byteOrder, wordOrder string) (ByteOrder, error) {
+	var order ByteOrder
+	switch strings.ToLower(byteOrder) {
+	case "", "big":
+	case "little":
+		order.ByteSwap = true
+	default:
+		return order, fmt.Errorf("invalid byteorder: %s", byteOrder)
+	}
+	switch strings.ToLower(wordOrder) {
+	case "", "big":
+	case "little":
+		order.WordSwap = true
+	default:
+		return order, fmt.Errorf("invalid wordorder: %s", wordOrder)
+	}
+	return order, nil
+}
+
+// reorder 在设备字节序与大端（ABCD）之间转换，两个方向是同一个变换
+func (o ByteOrder) reorder(buffer []byte, datatype string) []byte {
+	result := make([]byte, len(buffer))
+	copy(result, buffer)
+	if o.ByteSwap {
+		for i := 0; i+1 < len(result); i += 2 {
+			result[i], result[i+1] = result[i+1], result[i]
+		}
+	}
+	if o.WordSwap {
+		switch datatype {
+		case driver.TypeInt32, driver.TypeUInt32, driver.TypeFloat32:
+			words := len(result) / 2
+			for i, j := 0, words-1; i < j; i, j = i+1, j-1 {
+				result[i*2], result[j*2] = result[j*2], result[i*2]
+				result[i*2+1], result[j*2+1] = result[j*2+1], result[i*2+1]
+			}
+		}
+	}
+	return result
+}
+
+// ParseValueFromBuffer 解析寄存器读取结果，线圈/离散输入的 buffer 为按位打包的响应数据
+func ParseValueFromBuffer(tag ModbusTag, order ByteOrder, buffer []byte) (any, error) {
+	if tag.Area == AreaCoil || tag.Area == AreaDiscreteInput {
+		if len(buffer) < 1 {
+			return nil, errors.New("buffer too short for bit")
+		}
+		return driver.GetBit(buffer[0], 0), nil
+	}
+	if len(buffer) < int(tag.Quantity)*2 {
+		return nil, errors.New("buffer too short for registers")
+	}
+	if tag.Bit >= 0 {
+		return driver.BytesToUint16(buffer)&(1<<uint(tag.Bit)) != 0, nil
+	}
+	b := order.reorder(buffer[:int(tag.Quantity)*2], tag.DataType)
+
+	switch tag.DataType {
+	case driver.TypeBool:
+		return driver.BytesToUint16(b) != 0, nil
+	case driver.TypeByte:
+		return b[1], nil
+	case driver.TypeBytes:
+		return b, nil
+	case driver.TypeInt16:
+		return int16(driver.BytesToUint16(b)), nil
+	case driver.TypeUInt16:
+		return driver.BytesToUint16(b), nil
+	case driver.TypeInt32:
+		return int32(driver.BytesToUint32(b)), nil
+	case driver.TypeUInt32:
+		return driver.BytesToUint32(b), nil
+	case driver.TypeFloat32:
+		return math.Float32frombits(driver.BytesToUint32(b)), nil
+	case driver.TypeString:
+		return strings.TrimRight(string(b), "\x00 "), nil
+	default:
+		return nil, errors.New("unsupported data type: " + tag.DataType)
+	}
+}
+
+// valueToRegisters 将写入值编码为设备字节序的寄存器数据
+func valueToRegisters(t *driver.Tag, tag ModbusTag, order ByteOrder, value interface{}) ([]byte, error) {
+	size := int(tag.Quantity) * 2
+	switch tag.DataType {
+	case driver.TypeBool:
+		v, ok := t.ConvertValue(value).(bool)
+		if !ok {
+			return nil, fmt.Errorf("期望写入 bool 类型")
+		}
+		if v {
+			return []byte{0, 1}, nil
+		}
+		return []byte{0, 0}, nil
+	case driver.TypeByte:
+		v, ok := t.ConvertValue(value).(byte)
+		if !ok {
+			return nil, fmt.Errorf("期望写入 byte 类型")
+		}
+		return order.reorder([]byte{0, v}, tag.DataType), nil
+	case driver.TypeString, driver.TypeBytes:
+		var data []byte
+		if tag.DataType == driver.TypeString {
+			data = []byte(fmt.Sprintf("%v", value))
+		} else if v, ok := t.ConvertValue(value).([]byte); ok {
+			data = v
+		} else {
+			return nil, fmt.Errorf("期望写入 bytes 类型")
+		}
+		if len(data) > size {
+			return nil, fmt.Errorf("value too long: %d > %d bytes", len(data), size)
+		}
+		b := make([]byte, size)
+		copy(b, data)
+		return order.reorder(b, tag.DataType), nil
+	}
+	data, err := t.ConvertToBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("unexpected encoded length %d for %s", len(data), tag.DataType)
+	}
+	return order.reorder(data, tag.DataType), nil
+}
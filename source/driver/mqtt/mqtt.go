@@ -0,0 +1,494 @@
+// Package mqtt 订阅设备自行发布的 MQTT 消息，按变量地址中的主题与提取器转换为变量值；
+// 写入时向命令主题发布
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"acetek-mes/driver"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+var ErrTimeout = errors.New("mqtt timeout")
+
+// MqttClient MQTT 采集驱动，消息由 paho 的回调协程保存，扫描协程按扫描周期转换为变量值
+type MqttClient struct {
+	driver.Driver
+	broker   string
+	user     string
+	password string
+	clientID string
+	qos      byte
+	timeout  time.Duration
+	stale    time.Duration // 超过此时间没有新消息时质量为 Uncertain，0 不检查
+	command  string        // 写入命令主题模板，{topic} 为变量主题，{name} 为变量名
+	raw      bool          // 命令消息体为文本值，否则为 {"value": ...}
+	echo     bool          // 写入后等待设备发布新值，否则直接更新变量
+
+	client     paho.Client
+	subscribed map[string]bool
+	groups     []*driver.ScanGroup // 订阅时的扫描组，热更新后重新订阅
+	state      map[*driver.Tag]*tagState
+
+	mu     sync.Mutex // 保护 latest 与 seq，由回调协程写入
+	latest map[string]message
+	seq    uint64
+	notify chan struct{}
+}
+
+// message 每个主题的最新消息
+type message struct {
+	seq     uint64
+	payload []byte
+	at      time.Time
+}
+
+// tagState 变量已应用的消息，只在扫描协程中访问
+type tagState struct {
+	seq uint64
+	at  time.Time
+}
+
+// mqtt://[user:password@]host:1883?qos=1&timeout=5000&interval=1000&stale=60000&command={topic}/set&format=json&echo=1
+func NewMqttClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	c := &MqttClient{
+		broker:   u.Host,
+		clientID: q.Get("clientid"),
+		timeout:  5 * time.Second,
+		command:  "{topic}/set",
+		echo:     q.Get("echo") != "0" && q.Get("echo") != "false",
+		latest:   make(map[string]message),
+		state:    make(map[*driver.Tag]*tagState),
+		notify:   make(chan struct{}, 1),
+	}
+	if c.clientID == "" {
+		c.clientID = "dc-" + id + "-" + uuid.NewString()[:8]
+	}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if v := q.Get("qos"); v != "" {
+		qos, err := strconv.Atoi(v)
+		if err != nil || qos < 0 || qos > 2 {
+			return nil, fmt.Errorf("invalid qos: %s", v)
+		}
+		c.qos = byte(qos)
+	}
+	if ms, err := strconv.Atoi(q.Get("timeout")); err == nil && ms > 0 {
+		c.timeout = time.Duration(ms) * time.Millisecond
+	}
+	if ms, err := strconv.Atoi(q.Get("stale")); err == nil && ms > 0 {
+		c.stale = time.Duration(ms) * time.Millisecond
+	}
+	if s := q.Get("command"); s != "" {
+		c.command = s
+	}
+	switch q.Get("format") {
+	case "", "json":
+	case "raw":
+		c.raw = true
+	default:
+		return nil, fmt.Errorf("unknown format: %s", q.Get("format"))
+	}
+	var interval uint32 = 1000
+	if i := q.Get("interval"); i != "" {
+		if intval, err := strconv.Atoi(i); err == nil {
+			interval = uint32(intval)
+		}
+	}
+	c.Driver = driver.Driver{
+		ID:            id,
+		Name:          name,
+		Tags:          driver.TagMap(parseTags(tags)),
+		Interval:      interval,
+		ChCommand:     make(chan string, 100),
+		ChWrite:       make(chan *driver.WriteRequest, 100),
+		ChWriteResult: make(chan error),
+		ChConfig:      make(chan *driver.Config, 1),
+	}
+	c.InitScanGroups()
+	return c, nil
+}
+
+// 注册 MQTT 驱动
+func init() {
+	logger.TxtLog("register driver mqtt")
+	driver.RegisterDriver("mqtt", NewMqttClient)
+}
+
+func (c *MqttClient) Connect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	return c.connect()
+}
+
+// connect 由驱动的退避重连负责重连，不使用 paho 的自动重连
+func (c *MqttClient) connect() error {
+	if c.Connected {
+		return nil
+	}
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + c.broker).
+		SetClientID(c.clientID).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectTimeout(c.timeout).
+		SetDefaultPublishHandler(c.onMessage)
+	if c.user != "" {
+		opts.SetUsername(c.user).SetPassword(c.password)
+	}
+	client := paho.NewClient(opts)
+	t := client.Connect()
+	if !t.WaitTimeout(c.timeout) {
+		client.Disconnect(0)
+		return fmt.Errorf("%w: connect %s", ErrTimeout, c.broker)
+	}
+	if err := t.Error(); err != nil {
+		return err
+	}
+	c.client = client
+	c.subscribed = make(map[string]bool)
+	c.groups = nil
+	c.Connected = true
+	c.LastPing = time.Now()
+	return nil
+}
+
+func (c *MqttClient) Disconnect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = false
+	if c.client != nil {
+		c.client.Disconnect(250)
+		c.client = nil
+	}
+	return nil
+}
+
+func (c *MqttClient) IsConnected() bool {
+	return c.Connected
+}
+
+func (c *MqttClient) reconnectIfNeeded() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if c.Connected && !c.client.IsConnectionOpen() {
+		c.Connected = false
+		c.client.Disconnect(0)
+		c.client = nil
+	}
+	if !c.Connected {
+		if err := c.connect(); err != nil {
+			return fmt.Errorf("%w: %v", driver.ErrConnection, err)
+		}
+	}
+	return nil
+}
+
+// onMessage paho 回调协程中只保存每个主题的最新消息
+func (c *MqttClient) onMessage(_ paho.Client, m paho.Message) {
+	c.mu.Lock()
+	c.seq++
+	c.latest[m.Topic()] = message{seq: c.seq, payload: m.Payload(), at: time.Now()}
+	c.mu.Unlock()
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ensureSubscriptions 首次扫描、重连或热更新后订阅新增的主题，取消不再需要的主题
+func (c *MqttClient) ensureSubscriptions() error {
+	if slices.Equal(c.groups, c.Groups) {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, v := range c.Tags {
+		wanted[v.Mate.(*Address).Filter] = true
+	}
+	filters := make(map[string]byte)
+	for f := range wanted {
+		if !c.subscribed[f] {
+			filters[f] = c.qos
+		}
+	}
+	if len(filters) > 0 {
+		t := c.client.SubscribeMultiple(filters, nil)
+		if !t.WaitTimeout(c.timeout) {
+			return fmt.Errorf("%w: subscribe", ErrTimeout)
+		}
+		if err := t.Error(); err != nil {
+			return err
+		}
+		for f, code := range t.(*paho.SubscribeToken).Result() {
+			if code == 0x80 {
+				logger.TxtErr(fmt.Errorf("mqtt %s subscribe %s rejected", c.ID, f))
+				continue
+			}
+			c.subscribed[f] = true
+		}
+	}
+	var removed []string
+	for f := range c.subscribed {
+		if !wanted[f] {
+			removed = append(removed, f)
+		}
+	}
+	if len(removed) > 0 {
+		if t := c.client.Unsubscribe(removed...); !t.WaitTimeout(c.timeout) {
+			return fmt.Errorf("%w: unsubscribe", ErrTimeout)
+		}
+		for _, f := range removed {
+			delete(c.subscribed, f)
+		}
+	}
+
+	// 热更新后删除已移除变量的状态与不再订阅的主题
+	state := make(map[*driver.Tag]*tagState, len(c.Tags))
+	for _, v := range c.Tags {
+		if s, ok := c.state[v]; ok {
+			state[v] = s
+		}
+	}
+	c.state = state
+	c.mu.Lock()
+	for topic := range c.latest {
+		found := false
+		for f := range wanted {
+			if found = match(f, topic); found {
+				break
+			}
+		}
+		if !found {
+			delete(c.latest, topic)
+		}
+	}
+	c.mu.Unlock()
+	if len(c.subscribed) < len(wanted) {
+		// 有主题被拒绝时下一个周期重试
+		return fmt.Errorf("mqtt %s: %d topics not subscribed", c.ID, len(wanted)-len(c.subscribed))
+	}
+	c.groups = c.Groups
+	return nil
+}
+
+// newest 匹配过滤器且比 after 新的最新消息，调用方持有 c.mu
+func (c *MqttClient) newest(filter string, after uint64) (message, bool) {
+	if !strings.ContainsAny(filter, "+#") {
+		m, ok := c.latest[filter]
+		return m, ok && m.seq > after
+	}
+	var result message
+	for topic, m := range c.latest {
+		if m.seq > after && m.seq > result.seq && match(filter, topic) {
+			result = m
+		}
+	}
+	return result, result.seq > 0
+}
+
+func (c *MqttClient) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadGroup 应用扫描组内变量收到的新消息；没有新消息的变量保持原值，超过 stale 时质量为 Uncertain
+func (c *MqttClient) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
+	if err := c.reconnectIfNeeded(); err != nil {
+		return nil, err
+	}
+	if err := c.ensureSubscriptions(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	updates := make(map[*driver.Tag]message)
+	c.mu.Lock()
+	for _, v := range g.Tags {
+		s, ok := c.state[v]
+		if !ok {
+			s = &tagState{}
+			c.state[v] = s
+		}
+		if m, ok := c.newest(v.Mate.(*Address).Filter, s.seq); ok {
+			updates[v] = m
+			s.seq, s.at = m.seq, m.at
+		}
+	}
+	c.mu.Unlock()
+
+	result := make(map[string]interface{})
+	for _, v := range g.Tags {
+		s := c.state[v]
+		if m, ok := updates[v]; ok {
+			apply(v, m)
+		} else if s.at.IsZero() {
+			// 还没有收到消息
+			v.Quality = "Bad"
+			v.Value = nil
+			v.Timestamp = now
+		} else if c.stale > 0 && now.Sub(s.at) > c.stale && v.Quality == "Good" {
+			v.Quality = "Uncertain"
+		}
+		result[v.Name] = v.Value
+	}
+	return result, nil
+}
+
+// apply 按地址提取并转换消息中的值，失败时质量为 Bad
+func apply(v *driver.Tag, m message) {
+	raw, ts, err := v.Mate.(*Address).Extract(m.payload)
+	if ts.IsZero() {
+		ts = m.at
+	}
+	v.Timestamp = ts
+	if err == nil {
+		raw, err = convertValue(v, raw)
+	}
+	if err != nil {
+		log.Printf("mqtt tag %s: %v", v.Name, err)
+		v.Quality = "Bad"
+		v.Value = nil
+		return
+	}
+	v.Quality = "Good"
+	v.Value = v.Scale(raw)
+}
+
+func (c *MqttClient) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
+}
+
+// commandTopic 写入命令的主题，带通配符的主题只能使用不含 {topic} 的模板
+func (c *MqttClient) commandTopic(tag *driver.Tag) (string, error) {
+	a := tag.Mate.(*Address)
+	if strings.Contains(c.command, "{topic}") && !a.literal {
+		return "", fmt.Errorf("tag %s: wildcard topic %s cannot be used as command topic", tag.Name, a.Filter)
+	}
+	return strings.NewReplacer("{topic}", a.Filter, "{name}", tag.Name).Replace(c.command), nil
+}
+
+// write 逐个变量发布命令，等待 broker 确认；echo 时再等待设备发布新值，读回校验由扫描协程完成
+func (c *MqttClient) write(values map[string]interface{}) error {
+	if err := c.reconnectIfNeeded(); err != nil {
+		return err
+	}
+	// 首次扫描前写入时先订阅，否则收不到设备回报
+	if err := c.ensureSubscriptions(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	since := c.seq
+	c.mu.Unlock()
+	written := make(map[*driver.Tag]interface{})
+	for k, v := range values {
+		tag, ok := c.Tags[k]
+		if !ok {
+			return fmt.Errorf("tag %s is not define", k)
+		} else if !tag.Writable {
+			return fmt.Errorf("tag %s is readonly", k)
+		}
+		topic, err := c.commandTopic(tag)
+		if err != nil {
+			return err
+		}
+		raw, err := tag.Unscale(v)
+		if err != nil {
+			return fmt.Errorf("tag %s: %v", k, err)
+		}
+		if tag.Datatype != "" {
+			if raw = tag.ConvertValue(raw); raw == nil {
+				return fmt.Errorf("tag %s: cannot convert %v to %s", k, v, tag.Datatype)
+			}
+		}
+		var payload []byte
+		if c.raw {
+			payload = []byte(fmt.Sprintf("%v", raw))
+		} else if payload, err = json.Marshal(map[string]interface{}{"value": raw}); err != nil {
+			return err
+		}
+		t := c.client.Publish(topic, c.qos, false, payload)
+		if !t.WaitTimeout(c.timeout) {
+			return fmt.Errorf("%w: publish %s", ErrTimeout, topic)
+		}
+		if err := t.Error(); err != nil {
+			return err
+		}
+		written[tag] = raw
+	}
+	if !c.echo {
+		// 不等待设备回报，直接按写入值更新，忽略写入前收到的消息
+		now := time.Now()
+		for tag, raw := range written {
+			tag.Value = tag.Scale(raw)
+			tag.Quality = "Good"
+			tag.Timestamp = now
+			c.state[tag] = &tagState{seq: since, at: now}
+		}
+		return nil
+	}
+	c.waitEcho(written, since)
+	return nil
+}
+
+// waitEcho 等待所有写入的变量收到新消息，超时后由读回校验报告不一致
+func (c *MqttClient) waitEcho(written map[*driver.Tag]interface{}, since uint64) {
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		pending := 0
+		for tag := range written {
+			if _, ok := c.newest(tag.Mate.(*Address).Filter, since); !ok {
+				pending++
+			}
+		}
+		c.mu.Unlock()
+		if pending == 0 {
+			return
+		}
+		select {
+		case <-c.notify:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+func (c *MqttClient) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, func() { c.Disconnect() })
+}
+
+func (c *MqttClient) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags)
+	return c.Driver.Reconfig(cfg)
+}
@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	driver "acetek-mes/driver"
+	"acetek-mes/driver/mqttbroker"
+)
+
+func newTestBroker(t *testing.T) *mqttbroker.Broker {
+	b := mqttbroker.NewBroker()
+	if err := b.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func newTestClient(t *testing.T, rawURL string, tags []*driver.Tag) *MqttClient {
+	c, err := NewMqttClient("MQTT", "", rawURL, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*MqttClient)
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func testTags() []*driver.Tag {
+	return []*driver.Tag{
+		{Name: "水分", Address: "sensors/m01", Datatype: "float32"},
+		{Name: "净重", Address: "scale/+/weight|$.data.net|$.ts", Datatype: "float64"},
+		{Name: "状态", Address: "sensors/m02|re:STATE=(\\w+)", Datatype: "string"},
+		{Name: "设定", Address: "scale/w01/target|$.value", Datatype: "int32", Writable: true},
+	}
+}
+
+// readUntil 消息由回调协程异步保存，反复读取直到条件满足
+func readUntil(t *testing.T, client *MqttClient, cond func(map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		values, err := client.Read()
+		if err == nil && cond(values) {
+			return values
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("values: %v, err: %v", values, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		address, payload string
+		want             any
+	}{
+		{"a/b", " 12.5\n", "12.5"},
+		{"a/b|$.data.items[1].v", `{"data":{"items":[{"v":1},{"v":2}]}}`, 2.0},
+		{"a/b|data.ok", `{"data":{"ok":true}}`, true},
+		{"a/b|re:W=([\\d.]+)kg", "ST,GS,W=001.25kg", "001.25"},
+		{"a/b|re:^\\w+", "READY 1", "READY"},
+		{"a/b|re:(\\w+)=([\\d.]+)", "W=1.5", "W"},
+	}
+	for _, c := range cases {
+		a, err := ParseAddress(c.address)
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		v, _, err := a.Extract([]byte(c.payload))
+		if err != nil || v != c.want {
+			t.Errorf("%s: %v (%T) %v", c.address, v, v, err)
+		}
+	}
+	for _, address := range []string{"", "a/#/b", "a/b+", "a|$.x[", "a|re:(", "a|$.x[a]"} {
+		if _, err := ParseAddress(address); err == nil {
+			t.Errorf("%q: expect error", address)
+		}
+	}
+	if !match("scale/+/weight", "scale/w01/weight") || !match("a/#", "a") || match("+/x", "$SYS/x") || match("a/+", "a/b/c") {
+		t.Fatal("match")
+	}
+}
+
+func TestRead(t *testing.T) {
+	broker := newTestBroker(t)
+	// 订阅前的保留消息在订阅时收到
+	broker.Publish("sensors/m01", []byte("12.5"), 1, true)
+	client := newTestClient(t, "mqtt://"+broker.Addr()+"?qos=1", testTags())
+	readUntil(t, client, func(v map[string]interface{}) bool { return v["水分"] == float32(12.5) })
+	// 还没有收到消息的变量质量为 Bad
+	if tag := client.Tags["净重"]; tag.Quality != "Bad" || tag.Value != nil {
+		t.Fatalf("no message: %v %s", tag.Value, tag.Quality)
+	}
+
+	broker.Publish("scale/w02/weight", []byte(`{"data":{"net":25.04},"ts":1700000000123}`), 0, false)
+	broker.Publish("sensors/m02", []byte("ID=2;STATE=RUN"), 0, false)
+	readUntil(t, client, func(v map[string]interface{}) bool { return v["净重"] == 25.04 && v["状态"] == "RUN" })
+	if ts := client.Tags["净重"].Timestamp; !ts.Equal(time.UnixMilli(1700000000123)) {
+		t.Fatalf("timestamp: %v", ts)
+	}
+
+	// 无法解析的消息只影响该变量
+	broker.Publish("sensors/m01", []byte("n/a"), 0, false)
+	readUntil(t, client, func(v map[string]interface{}) bool { return client.Tags["水分"].Quality == "Bad" })
+	if v := client.Tags["状态"]; v.Quality != "Good" {
+		t.Fatalf("other tag: %s", v.Quality)
+	}
+}
+
+func TestStale(t *testing.T) {
+	broker := newTestBroker(t)
+	client := newTestClient(t, "mqtt://"+broker.Addr()+"?stale=100", testTags())
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish("sensors/m01", []byte("8"), 0, false)
+	readUntil(t, client, func(v map[string]interface{}) bool { return v["水分"] == float32(8) })
+	time.Sleep(150 * time.Millisecond)
+	// 超时没有新消息时保留最后的值，质量为 Uncertain
+	if values, _ := client.Read(); values["水分"] != float32(8) || client.Tags["水分"].Quality != "Uncertain" {
+		t.Fatalf("stale: %v %s", values["水分"], client.Tags["水分"].Quality)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	broker := newTestBroker(t)
+	// 模拟设备：收到命令后发布新的设定值
+	commands := broker.Watch("scale/w01/target/set")
+	go func() {
+		for m := range commands {
+			broker.Publish("scale/w01/target", m.Payload, 0, true)
+		}
+	}()
+	client := newTestClient(t, "mqtt://"+broker.Addr()+"?qos=1&interval=50", testTags())
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	req := &driver.WriteRequest{Values: map[string]interface{}{"设定": 1500.0}}
+	if err := client.Submit(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ReadBack["设定"] != int32(1500) {
+		t.Fatalf("read back: %v", req.ReadBack)
+	}
+	if m, ok := broker.Retained("scale/w01/target"); !ok || string(m.Payload) != `{"value":1500}` {
+		t.Fatalf("command: %s", m.Payload)
+	}
+	if err := client.Write("水分", 1.0); err == nil {
+		t.Fatal("readonly tag written")
+	}
+}
+
+func TestWriteNoEcho(t *testing.T) {
+	broker := newTestBroker(t)
+	commands := broker.Watch("cmd/#")
+	client := newTestClient(t, "mqtt://"+broker.Addr()+"?echo=0&format=raw&command=cmd/{name}", testTags())
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.write(map[string]interface{}{"设定": 42.0}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-commands:
+		if m.Topic != "cmd/设定" || string(m.Payload) != "42" {
+			t.Fatalf("command: %s %s", m.Topic, m.Payload)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no command")
+	}
+	// 不等待设备回报，写入值直接生效
+	if values, err := client.Read(); err != nil || values["设定"] != int32(42) {
+		t.Fatalf("values: %v %v", values, err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	broker := newTestBroker(t)
+	broker.Publish("sensors/m01", []byte("3"), 0, true)
+	client := newTestClient(t, "mqtt://"+broker.Addr(), testTags())
+	readUntil(t, client, func(v map[string]interface{}) bool { return v["水分"] == float32(3) })
+
+	broker.Disconnect()
+	broker.Publish("sensors/m01", []byte("4"), 0, true)
+	// 断线后重新连接并订阅，收到断线期间的保留消息
+	readUntil(t, client, func(v map[string]interface{}) bool { return v["水分"] == float32(4) })
+	if s := broker.Stats(); s.Connections != 2 {
+		t.Fatalf("connections: %d", s.Connections)
+	}
+}
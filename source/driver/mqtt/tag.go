@@ -0,0 +1,268 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"acetek-mes/driver"
+)
+
+// Address 解析后的变量地址：主题|提取器|时间戳路径
+//
+//	sensors/m01                      整个消息体
+//	scale/+/weight|$.data.net        JSON 路径，支持 a.b[0].c
+//	sensors/m01|re:H2O=([\d.]+)      正则，有分组时取第一个分组
+//	scale/w01|$.net|$.ts             第三段为时间戳的 JSON 路径（RFC3339 或 Unix 秒/毫秒）
+type Address struct {
+	Filter  string
+	path    []step
+	re      *regexp.Regexp
+	tsPath  []step
+	literal bool // 主题中没有通配符，可以作为写入命令主题
+}
+
+// step JSON 路径的一级，key 为空时为数组下标
+type step struct {
+	key   string
+	index int
+}
+
+var errNotFound = errors.New("path not found")
+
+func ParseAddress(address string) (*Address, error) {
+	parts := strings.SplitN(address, "|", 3)
+	a := &Address{Filter: strings.TrimSpace(parts[0])}
+	if a.Filter == "" {
+		return nil, fmt.Errorf("empty topic: %s", address)
+	}
+	for i, level := range strings.Split(a.Filter, "/") {
+		if (strings.ContainsAny(level, "+#") && len(level) > 1) || (level == "#" && i != strings.Count(a.Filter, "/")) {
+			return nil, fmt.Errorf("invalid topic filter: %s", a.Filter)
+		}
+	}
+	a.literal = !strings.ContainsAny(a.Filter, "+#")
+	if len(parts) > 1 {
+		extractor := strings.TrimSpace(parts[1])
+		var err error
+		switch {
+		case strings.HasPrefix(extractor, "re:"):
+			if a.re, err = regexp.Compile(extractor[3:]); err != nil {
+				return nil, err
+			}
+		case extractor != "":
+			if a.path, err = parsePath(extractor); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(parts) > 2 {
+		var err error
+		if a.tsPath, err = parsePath(strings.TrimSpace(parts[2])); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// parsePath 解析 $.a.b[0].c，$ 可省略
+func parsePath(s string) ([]step, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if s == "" {
+		return nil, fmt.Errorf("empty json path")
+	}
+	var steps []step
+	for _, part := range strings.Split(s, ".") {
+		key, rest, bracket := strings.Cut(part, "[")
+		if key != "" {
+			steps = append(steps, step{key: key})
+		} else if rest == "" {
+			return nil, fmt.Errorf("invalid json path: %s", s)
+		}
+		if bracket && rest == "" {
+			return nil, fmt.Errorf("invalid json path: %s", s)
+		}
+		for rest != "" {
+			n, after, ok := strings.Cut(rest, "]")
+			index, err := strconv.Atoi(n)
+			if !ok || err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid json path: %s", s)
+			}
+			steps = append(steps, step{index: index})
+			rest = strings.TrimPrefix(after, "[")
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid json path: %s", s)
+			}
+		}
+	}
+	return steps, nil
+}
+
+func lookup(doc any, path []step) (any, error) {
+	for _, s := range path {
+		switch v := doc.(type) {
+		case map[string]any:
+			if s.key == "" {
+				return nil, errNotFound
+			}
+			var ok bool
+			if doc, ok = v[s.key]; !ok {
+				return nil, errNotFound
+			}
+		case []any:
+			if s.key != "" || s.index >= len(v) {
+				return nil, errNotFound
+			}
+			doc = v[s.index]
+		default:
+			return nil, errNotFound
+		}
+	}
+	return doc, nil
+}
+
+// Extract 从消息体中提取原始值与时间戳，消息中没有时间戳时返回零值
+func (a *Address) Extract(payload []byte) (any, time.Time, error) {
+	var ts time.Time
+	if a.path == nil && a.tsPath == nil {
+		if a.re != nil {
+			v, err := a.match(payload)
+			return v, ts, err
+		}
+		return strings.TrimSpace(string(payload)), ts, nil
+	}
+	var doc any
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return nil, ts, err
+	}
+	if a.tsPath != nil {
+		v, err := lookup(doc, a.tsPath)
+		if err != nil {
+			return nil, ts, fmt.Errorf("timestamp: %w", err)
+		}
+		if ts, err = parseTime(v); err != nil {
+			return nil, ts, err
+		}
+	}
+	if a.re != nil {
+		v, err := a.match(payload)
+		return v, ts, err
+	}
+	if a.path == nil {
+		return doc, ts, nil
+	}
+	v, err := lookup(doc, a.path)
+	return v, ts, err
+}
+
+// match 正则提取的值：有分组时取第一个分组，否则取整个匹配
+func (a *Address) match(payload []byte) (any, error) {
+	m := a.re.FindSubmatch(payload)
+	if m == nil {
+		return nil, fmt.Errorf("regexp %s not matched", a.re)
+	}
+	if len(m) > 1 {
+		return string(m[1]), nil
+	}
+	return string(m[0]), nil
+}
+
+// parseTime RFC3339 文本，或 Unix 秒/毫秒
+func parseTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, t)
+	case float64:
+		if t > 1e12 {
+			return time.UnixMilli(int64(t)), nil
+		}
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %v", v)
+}
+
+// convertValue 按数据类型转换提取的值，文本无法解析时返回错误而不是零值
+func convertValue(tag *driver.Tag, raw any) (any, error) {
+	if raw == nil {
+		return nil, fmt.Errorf("null value")
+	}
+	switch tag.Datatype {
+	case "":
+		return raw, nil
+	case driver.TypeString, driver.TypeChars, driver.TypeWString:
+		if s, ok := raw.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(raw)
+		return string(b), err
+	case driver.TypeBool:
+		switch v := raw.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			return v != 0, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		}
+	default:
+		switch v := raw.(type) {
+		case bool:
+			raw = 0.0
+			if v {
+				raw = 1.0
+			}
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, err
+			}
+			raw = f
+		}
+		if v := tag.ConvertValue(raw); v != nil {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", raw, tag.Datatype)
+}
+
+// match 主题是否匹配订阅过滤器，$ 开头的主题不匹配通配符
+func match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// parseTags 解析地址，返回解析成功的变量
+func parseTags(tags []*driver.Tag) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if a, err := ParseAddress(v.Address); err == nil {
+			v.Parsed = true
+			v.Mate = a
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse address error:", err)
+		}
+	}
+	return result
+}
@@ -0,0 +1,542 @@
+// Package mqttbroker 内存中的 MQTT 3.1.1 broker，支持保留消息、遗嘱与用户名验证，
+// 用于 MQTT 输出与 MQTT 驱动的测试。向订阅者投递的 QoS 最高为 1，不保存离线会话
+package mqttbroker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// 控制报文类型
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK 返回码
+const (
+	connAccepted          = 0
+	connBadProtocol       = 1
+	connBadUserOrPassword = 4
+)
+
+var errProtocol = errors.New("mqtt protocol error")
+
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+type Stats struct {
+	Connections int
+	Publishes   int // 客户端发布的消息数
+}
+
+type watcher struct {
+	filter string
+	ch     chan Message
+}
+
+// Broker MQTT broker
+type Broker struct {
+	mu       sync.Mutex
+	listener net.Listener
+	users    map[string]string
+	clients  map[*client]bool
+	retained map[string]Message
+	watchers []*watcher
+	stats    Stats
+	wg       sync.WaitGroup
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		users:    make(map[string]string),
+		clients:  make(map[*client]bool),
+		retained: make(map[string]Message),
+	}
+}
+
+// AddUser 添加用户后拒绝匿名连接
+func (b *Broker) AddUser(user, password string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users[user] = password
+}
+
+func (b *Broker) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.listener = l
+	b.mu.Unlock()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c := &client{broker: b, conn: conn, subs: make(map[string]byte)}
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				c.serve()
+			}()
+		}
+	}()
+	return nil
+}
+
+func (b *Broker) Addr() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.listener.Addr().String()
+}
+
+// Watch 观察匹配 filter 的消息（含保留消息），用于测试断言；缓冲区满时丢弃
+func (b *Broker) Watch(filter string) <-chan Message {
+	w := &watcher{filter: filter, ch: make(chan Message, 1024)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.retained {
+		if Match(filter, m.Topic) {
+			w.ch <- m
+		}
+	}
+	b.watchers = append(b.watchers, w)
+	return w.ch
+}
+
+// Publish 以 broker 自身的身份发布消息，模拟设备上报
+func (b *Broker) Publish(topic string, payload []byte, qos byte, retain bool) {
+	b.route(Message{Topic: topic, Payload: payload, QoS: qos, Retain: retain})
+}
+
+// Retained 主题的保留消息
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+func (b *Broker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// Disconnect 断开所有客户端，模拟网络中断，遗嘱消息照常发布
+func (b *Broker) Disconnect() {
+	b.mu.Lock()
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	l := b.listener
+	b.mu.Unlock()
+	var err error
+	if l != nil {
+		err = l.Close()
+	}
+	b.Disconnect()
+	b.wg.Wait()
+	return err
+}
+
+// route 保存保留消息并投递给订阅者
+func (b *Broker) route(m Message) {
+	b.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	var targets []*client
+	var qos []byte
+	for c := range b.clients {
+		if q, ok := c.matches(m.Topic); ok {
+			targets = append(targets, c)
+			qos = append(qos, min(q, m.QoS, 1))
+		}
+	}
+	for _, w := range b.watchers {
+		if Match(w.filter, m.Topic) {
+			select {
+			case w.ch <- m:
+			default:
+			}
+		}
+	}
+	b.mu.Unlock()
+	for i, c := range targets {
+		// 转发给订阅者时不带保留标志
+		c.deliver(Message{Topic: m.Topic, Payload: m.Payload, QoS: qos[i]})
+	}
+}
+
+// Match 主题是否匹配订阅过滤器，支持 + 与 #，$ 开头的主题不匹配通配符
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+type client struct {
+	broker *Broker
+	conn   net.Conn
+	wmu    sync.Mutex
+	id     string
+	subs   map[string]byte // 过滤器 -> QoS，broker.mu 保护
+	will   *Message
+	nextID uint16
+}
+
+// matches 返回匹配的订阅中最高的 QoS，调用方持有 broker.mu
+func (c *client) matches(topic string) (byte, bool) {
+	var qos byte
+	found := false
+	for filter, q := range c.subs {
+		if Match(filter, topic) {
+			qos = max(qos, q)
+			found = true
+		}
+	}
+	return qos, found
+}
+
+func (c *client) serve() {
+	r := bufio.NewReader(c.conn)
+	clean := false
+	defer func() {
+		c.conn.Close()
+		b := c.broker
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.mu.Unlock()
+		if !clean && c.will != nil {
+			b.route(*c.will)
+		}
+	}()
+
+	header, body, err := readPacket(r)
+	if err != nil || header>>4 != packetConnect {
+		return
+	}
+	code, err := c.connect(body)
+	if err != nil {
+		return
+	}
+	c.write(packetConnack<<4, []byte{0, code})
+	if code != connAccepted {
+		return
+	}
+	b := c.broker
+	b.mu.Lock()
+	b.clients[c] = true
+	b.stats.Connections++
+	b.mu.Unlock()
+
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetPublish:
+			if err := c.publish(header, body); err != nil {
+				return
+			}
+		case packetPubrel:
+			if len(body) < 2 {
+				return
+			}
+			c.write(packetPubcomp<<4, body[:2])
+		case packetPuback, packetPubrec, packetPubcomp:
+		case packetSubscribe:
+			if err := c.subscribe(body); err != nil {
+				return
+			}
+		case packetUnsubscribe:
+			if err := c.unsubscribe(body); err != nil {
+				return
+			}
+		case packetPingreq:
+			c.write(packetPingresp<<4, nil)
+		case packetDisconnect:
+			clean = true
+			return
+		default:
+			return
+		}
+	}
+}
+
+// connect 解析 CONNECT，返回 CONNACK 返回码
+func (c *client) connect(body []byte) (byte, error) {
+	d := &decoder{b: body}
+	protocol := d.str()
+	level := d.byte()
+	flags := d.byte()
+	d.uint16() // keep alive，测试中不检查
+	c.id = d.str()
+	if flags&0x04 != 0 {
+		will := &Message{Topic: d.str(), QoS: flags >> 3 & 0x03, Retain: flags&0x20 != 0}
+		will.Payload = []byte(d.str())
+		c.will = will
+	}
+	var user, password string
+	if flags&0x80 != 0 {
+		user = d.str()
+	}
+	if flags&0x40 != 0 {
+		password = d.str()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	if !(protocol == "MQTT" && level == 4) && !(protocol == "MQIsdp" && level == 3) {
+		return connBadProtocol, nil
+	}
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.users) > 0 {
+		if p, ok := b.users[user]; !ok || p != password {
+			return connBadUserOrPassword, nil
+		}
+	}
+	return connAccepted, nil
+}
+
+func (c *client) publish(header byte, body []byte) error {
+	qos := header >> 1 & 0x03
+	d := &decoder{b: body}
+	topic := d.str()
+	var id uint16
+	if qos > 0 {
+		id = d.uint16()
+	}
+	if d.err != nil || qos > 2 {
+		return errProtocol
+	}
+	payload := append([]byte(nil), d.b...)
+	switch qos {
+	case 1:
+		c.write(packetPuback<<4, binary.BigEndian.AppendUint16(nil, id))
+	case 2:
+		c.write(packetPubrec<<4, binary.BigEndian.AppendUint16(nil, id))
+	}
+	c.broker.mu.Lock()
+	c.broker.stats.Publishes++
+	c.broker.mu.Unlock()
+	c.broker.route(Message{Topic: topic, Payload: payload, QoS: qos, Retain: header&0x01 != 0})
+	return nil
+}
+
+func (c *client) subscribe(body []byte) error {
+	d := &decoder{b: body}
+	id := d.uint16()
+	var filters []string
+	var codes []byte
+	for len(d.b) > 0 && d.err == nil {
+		filter := d.str()
+		qos := min(d.byte(), 1)
+		filters = append(filters, filter)
+		codes = append(codes, qos)
+	}
+	if d.err != nil || len(filters) == 0 {
+		return errProtocol
+	}
+	b := c.broker
+	b.mu.Lock()
+	var retained []Message
+	for i, filter := range filters {
+		c.subs[filter] = codes[i]
+		for _, m := range b.retained {
+			if Match(filter, m.Topic) {
+				retained = append(retained, Message{Topic: m.Topic, Payload: m.Payload, QoS: min(m.QoS, codes[i]), Retain: true})
+			}
+		}
+	}
+	b.mu.Unlock()
+	c.write(packetSuback<<4, append(binary.BigEndian.AppendUint16(nil, id), codes...))
+	for _, m := range retained {
+		c.deliver(m)
+	}
+	return nil
+}
+
+func (c *client) unsubscribe(body []byte) error {
+	d := &decoder{b: body}
+	id := d.uint16()
+	b := c.broker
+	b.mu.Lock()
+	for len(d.b) > 0 && d.err == nil {
+		delete(c.subs, d.str())
+	}
+	b.mu.Unlock()
+	if d.err != nil {
+		return errProtocol
+	}
+	c.write(packetUnsuback<<4, binary.BigEndian.AppendUint16(nil, id))
+	return nil
+}
+
+// deliver 向订阅者发送 PUBLISH，不等待 PUBACK
+func (c *client) deliver(m Message) {
+	header := byte(packetPublish<<4) | m.QoS<<1
+	if m.Retain {
+		header |= 0x01
+	}
+	body := appendStr(nil, m.Topic)
+	if m.QoS > 0 {
+		c.wmu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id := c.nextID
+		c.wmu.Unlock()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	c.write(header, append(body, m.Payload...))
+}
+
+func (c *client) write(header byte, body []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.Write(appendPacket(nil, header, body))
+}
+
+func appendPacket(b []byte, header byte, body []byte) []byte {
+	b = append(b, header)
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, body...)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(digit&0x7F) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errProtocol
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendStr(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = errProtocol
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = errProtocol
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) str() string {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.err = fmt.Errorf("%w: string length %d", errProtocol, n)
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
@@ -0,0 +1,336 @@
+// Package mqttsink 把变量值发布到 MQTT，主题为 <prefix>/<factory>/<驱动>/<变量>。
+// 驱动连接后发布 <驱动>/BIRTH（携带所有变量的最新值），断开或停止时发布 <驱动>/DEATH，触发器事件发布到 <驱动>/EVENT；
+// 本进程的在线状态为保留消息 <prefix>/<factory>/STATE，异常退出时由 broker 发布遗嘱
+package mqttsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"acetek-mes/driver"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultQueue     = 10000
+	defaultReconnect = 5 * time.Second
+	publishTimeout   = 5 * time.Second
+	closeTimeout     = 2 * time.Second
+)
+
+// 注册 mqtt 输出
+func init() {
+	driver.RegisterSink("mqtt", New)
+}
+
+// Sink MQTT 输出。所有消息先进入本地队列，由发送协程按顺序发布并等待确认，
+// 断线期间积压，重连后按原顺序补发；队列满时丢弃最旧的消息。
+// 队列只在内存中，进程重启时未发送的消息丢失（Redis 输出的磁盘缓存见 redishelper.Spool）
+type Sink struct {
+	client paho.Client
+	base   string
+	qos    byte
+	retain bool
+
+	mu      sync.Mutex
+	queue   []message
+	seq     uint64
+	limit   int
+	dropped int
+	online  map[string]bool               // 已发布 BIRTH 的驱动
+	last    map[string]map[string]payload // 驱动 -> 变量 -> 最新值
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type message struct {
+	seq     uint64
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// payload 与 Redis real: 哈希的字段一致，值保留 JSON 类型
+type payload struct {
+	Value   any    `json:"value"`
+	Quality string `json:"quality"`
+	TS      string `json:"ts"`
+	DT      string `json:"dt"`
+	Unit    string `json:"unit,omitempty"`
+}
+
+// lifecycle BIRTH/DEATH/STATE 消息
+type lifecycle struct {
+	State   string             `json:"state"`
+	TS      string             `json:"ts"`
+	Error   string             `json:"error,omitempty"`
+	Metrics map[string]payload `json:"metrics,omitempty"`
+}
+
+// event 触发器事件，字段与 Redis event:<驱动> stream 一致
+type event struct {
+	Event    string         `json:"event"`
+	Tag      string         `json:"tag"`
+	Edge     string         `json:"edge"`
+	Value    float64        `json:"value"`
+	Previous float64        `json:"previous"`
+	Seq      uint64         `json:"seq"`
+	TS       string         `json:"ts"`
+	Snapshot map[string]any `json:"snapshot"`
+}
+
+// mqtt://[user:password@]host:1883?prefix=dzr&factory=hc&qos=1&retain=1&queue=10000&clientid=&reconnect=5000
+//
+// queue 为断线期间内存中最多积压的消息数，超过时丢弃最旧的；队列不写磁盘，dc 重启时积压的消息丢失
+func New(rawURL string) (driver.Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	s := &Sink{
+		base:   strings.Trim(q.Get("prefix"), "/"),
+		retain: true,
+		limit:  defaultQueue,
+		online: make(map[string]bool),
+		last:   make(map[string]map[string]payload),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if s.base == "" {
+		s.base = "dzr"
+	}
+	if factory := strings.Trim(q.Get("factory"), "/"); factory != "" {
+		s.base += "/" + factory
+	}
+	if v := q.Get("qos"); v != "" {
+		qos, err := strconv.Atoi(v)
+		if err != nil || qos < 0 || qos > 2 {
+			return nil, fmt.Errorf("invalid qos: %s", v)
+		}
+		s.qos = byte(qos)
+	}
+	if v := q.Get("retain"); v != "" {
+		s.retain = v == "1" || v == "true"
+	}
+	if n, err := strconv.Atoi(q.Get("queue")); err == nil && n > 0 {
+		s.limit = n
+	}
+	reconnect := defaultReconnect
+	if ms, err := strconv.Atoi(q.Get("reconnect")); err == nil && ms > 0 {
+		reconnect = time.Duration(ms) * time.Millisecond
+	}
+	clientID := q.Get("clientid")
+	if clientID == "" {
+		clientID = "dc-" + uuid.NewString()[:8]
+	}
+
+	will, _ := json.Marshal(lifecycle{State: "offline"})
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://"+u.Host).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(reconnect).
+		SetMaxReconnectInterval(reconnect).
+		SetBinaryWill(s.base+"/STATE", will, s.qos, true).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Println("mqtt sink connection lost:", err)
+		}).
+		SetOnConnectHandler(func(c paho.Client) {
+			log.Println("mqtt sink connected", u.Host)
+			b, _ := json.Marshal(lifecycle{State: "online", TS: time.Now().UTC().Format(time.RFC3339)})
+			c.Publish(s.base+"/STATE", s.qos, true, b)
+			s.notify()
+		})
+	if u.User != nil {
+		password, _ := u.User.Password()
+		opts.SetUsername(u.User.Username()).SetPassword(password)
+	}
+	s.client = paho.NewClient(opts)
+	// 连接在后台重试，broker 未启动不影响驱动启动
+	s.client.Connect()
+	go s.run()
+	return s, nil
+}
+
+// Topic 变量的主题，主题中不能出现的通配符替换为 _
+func (s *Sink) Topic(driverID, name string) string {
+	r := strings.NewReplacer("+", "_", "#", "_")
+	return s.base + "/" + r.Replace(driverID) + "/" + r.Replace(name)
+}
+
+func (s *Sink) Write(driverID string, p driver.Point, stream bool) error {
+	v := payload{
+		Value:   p.Value,
+		Quality: p.Quality,
+		TS:      p.Timestamp.UTC().Format(time.RFC3339),
+		DT:      fmt.Sprintf("%T", p.Value),
+		Unit:    p.Unit,
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.last[driverID] == nil {
+		s.last[driverID] = make(map[string]payload)
+	}
+	s.last[driverID][p.Name] = v
+	s.mu.Unlock()
+	s.enqueue(s.Topic(driverID, p.Name), b, s.retain)
+	return nil
+}
+
+// WriteStatus 驱动进入 online/degraded 时发布 BIRTH，进入 offline/stopped 时发布 DEATH
+func (s *Sink) WriteStatus(driverID string, st driver.Status) error {
+	connected := st.State == driver.StateOnline || st.State == driver.StateDegraded
+	msg := lifecycle{State: st.State, TS: st.Since.UTC().Format(time.RFC3339)}
+	s.mu.Lock()
+	var topic string
+	switch {
+	case connected && !s.online[driverID]:
+		s.online[driverID] = true
+		topic = s.Topic(driverID, "BIRTH")
+		msg.Metrics = make(map[string]payload, len(s.last[driverID]))
+		for k, v := range s.last[driverID] {
+			msg.Metrics[k] = v
+		}
+	case !connected && s.online[driverID] && (st.State == driver.StateOffline || st.State == driver.StateStopped):
+		s.online[driverID] = false
+		topic = s.Topic(driverID, "DEATH")
+		msg.Error = st.LastError
+	}
+	s.mu.Unlock()
+	if topic == "" {
+		return nil
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.enqueue(topic, b, false)
+	return nil
+}
+
+// WriteEvent 事件不保留，消费方按 event 与 seq 去重
+func (s *Sink) WriteEvent(driverID string, e *driver.Event) error {
+	b, err := json.Marshal(event{
+		Event:    e.Trigger,
+		Tag:      e.Tag,
+		Edge:     e.Edge,
+		Value:    e.Value,
+		Previous: e.Previous,
+		Seq:      e.Seq,
+		TS:       e.Timestamp.UTC().Format(time.RFC3339Nano),
+		Snapshot: e.Snapshot,
+	})
+	if err != nil {
+		return err
+	}
+	s.enqueue(s.Topic(driverID, "EVENT"), b, false)
+	return nil
+}
+
+// enqueue 加入发送队列，不阻塞扫描协程
+func (s *Sink) enqueue(topic string, b []byte, retain bool) {
+	s.mu.Lock()
+	s.seq++
+	s.queue = append(s.queue, message{seq: s.seq, topic: topic, payload: b, retain: retain})
+	if len(s.queue) > s.limit {
+		s.queue = s.queue[len(s.queue)-s.limit:]
+		if s.dropped++; s.dropped == 1 || s.dropped%1000 == 0 {
+			log.Printf("mqtt sink queue full, %d messages dropped", s.dropped)
+		}
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Sink) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending 队列中等待发送的消息数
+func (s *Sink) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// run 发送协程，连接断开时等待重连后的通知
+func (s *Sink) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		}
+		s.flush(s.stop)
+	}
+}
+
+// flush 按顺序发送队列中的消息，收到确认后才移出队列；连接断开或 stop 关闭时返回
+func (s *Sink) flush(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if !s.client.IsConnectionOpen() {
+			return
+		}
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		m := s.queue[0]
+		s.mu.Unlock()
+		t := s.client.Publish(m.topic, s.qos, m.retain, m.payload)
+		if !t.WaitTimeout(publishTimeout) || t.Error() != nil {
+			// 未确认的消息留在队列中，重连后重发
+			return
+		}
+		s.mu.Lock()
+		// 发送期间队列满可能已经丢弃了这条消息
+		if len(s.queue) > 0 && s.queue[0].seq == m.seq {
+			s.queue = s.queue[1:]
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close 在 closeTimeout 内尽量发送积压的消息，发布离线状态后断开
+func (s *Sink) Close() error {
+	close(s.stop)
+	<-s.done
+	deadline := make(chan struct{})
+	timer := time.AfterFunc(closeTimeout, func() { close(deadline) })
+	defer timer.Stop()
+	s.flush(deadline)
+	if s.client.IsConnectionOpen() {
+		b, _ := json.Marshal(lifecycle{State: "offline", TS: time.Now().UTC().Format(time.RFC3339)})
+		s.client.Publish(s.base+"/STATE", s.qos, true, b).WaitTimeout(time.Second)
+	}
+	if n := s.Pending(); n > 0 {
+		log.Printf("mqtt sink closed with %d messages unsent", n)
+	}
+	s.client.Disconnect(250)
+	return nil
+}
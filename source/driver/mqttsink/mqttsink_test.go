@@ -0,0 +1,123 @@
+package mqttsink
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"acetek-mes/driver"
+	"acetek-mes/driver/mqttbroker"
+)
+
+func newTestBroker(t *testing.T, addr string) *mqttbroker.Broker {
+	b := mqttbroker.NewBroker()
+	if err := b.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func newTestSink(t *testing.T, rawURL string) *Sink {
+	s, err := New(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := s.(*Sink)
+	t.Cleanup(func() { sink.Close() })
+	waitFor(t, "connect", sink.client.IsConnectionOpen)
+	return sink
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch <-chan mqttbroker.Message) mqttbroker.Message {
+	t.Helper()
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message")
+	}
+	return mqttbroker.Message{}
+}
+
+func TestPublish(t *testing.T) {
+	broker := newTestBroker(t, "127.0.0.1:0")
+	sink := newTestSink(t, "mqtt://"+broker.Addr()+"?factory=hc&qos=1")
+	state := broker.Watch("dzr/hc/STATE")
+	var online lifecycle
+	if m := receive(t, state); json.Unmarshal(m.Payload, &online) != nil || online.State != "online" || !m.Retain {
+		t.Fatalf("state: %s", m.Payload)
+	}
+
+	births, deaths := broker.Watch("dzr/hc/PLC01/BIRTH"), broker.Watch("dzr/hc/PLC01/DEATH")
+	ts := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	sink.Write("PLC01", driver.Point{Name: "液位", Value: float32(12.5), Quality: "Good", Timestamp: ts, Unit: "m"}, true)
+	sink.WriteStatus("PLC01", driver.Status{State: driver.StateOnline, Since: ts})
+	sink.WriteStatus("PLC01", driver.Status{State: driver.StateDegraded, Since: ts})
+
+	// 变量值为保留消息，订阅者上线即可取得最新值
+	want := `{"value":12.5,"quality":"Good","ts":"2025-03-01T08:00:00Z","dt":"float32","unit":"m"}`
+	waitFor(t, "retained", func() bool {
+		m, ok := broker.Retained("dzr/hc/PLC01/液位")
+		return ok && string(m.Payload) == want && m.QoS == 1
+	})
+	// 只在连接状态变化时发布一次 BIRTH，携带最新值
+	var birth lifecycle
+	if m := receive(t, births); json.Unmarshal(m.Payload, &birth) != nil {
+		t.Fatalf("birth: %s", m.Payload)
+	}
+	if birth.State != driver.StateOnline || birth.Metrics["液位"].Value != 12.5 {
+		t.Fatalf("birth: %+v", birth)
+	}
+	sink.WriteStatus("PLC01", driver.Status{State: driver.StateOffline, Since: ts, LastError: "connection refused"})
+	if m := receive(t, deaths); string(m.Payload) != `{"state":"offline","ts":"2025-03-01T08:00:00Z","error":"connection refused"}` {
+		t.Fatalf("death: %s", m.Payload)
+	}
+	if len(births) != 0 {
+		t.Fatalf("births: %d", len(births)+1)
+	}
+
+	events := broker.Watch("dzr/hc/PLC01/EVENT")
+	sink.WriteEvent("PLC01", &driver.Event{Trigger: "doff", Tag: "落丝信号", Edge: driver.EdgeRising, Value: 1, Seq: 7, Timestamp: ts, Snapshot: map[string]interface{}{"线号": 3}})
+	want = `{"event":"doff","tag":"落丝信号","edge":"rising","value":1,"previous":0,"seq":7,"ts":"2025-03-01T08:00:00Z","snapshot":{"线号":3}}`
+	if m := receive(t, events); string(m.Payload) != want || m.Retain {
+		t.Fatalf("event: %s", m.Payload)
+	}
+}
+
+func TestStoreAndForward(t *testing.T) {
+	broker := newTestBroker(t, "127.0.0.1:0")
+	addr := broker.Addr()
+	sink := newTestSink(t, "mqtt://"+addr+"?qos=1&retain=0&queue=3&reconnect=100")
+	broker.Close()
+	waitFor(t, "disconnect", func() bool { return !sink.client.IsConnectionOpen() })
+
+	// 断线期间积压，队列满时丢弃最旧的
+	for i := range 4 {
+		sink.Write("PLC01", driver.Point{Name: "计数", Value: i, Quality: "Good", Timestamp: time.Now()}, true)
+	}
+	if n := sink.Pending(); n != 3 {
+		t.Fatalf("pending: %d", n)
+	}
+
+	broker = newTestBroker(t, addr)
+	ch := broker.Watch("dzr/PLC01/#")
+	for i := 1; i < 4; i++ {
+		var p payload
+		if m := receive(t, ch); json.Unmarshal(m.Payload, &p) != nil || p.Value != float64(i) || m.Retain {
+			t.Fatalf("message %d: %s retain=%v", i, m.Payload, m.Retain)
+		}
+	}
+	waitFor(t, "queue drained", func() bool { return sink.Pending() == 0 })
+}
@@ -0,0 +1,455 @@
+package opcua
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"acetek-mes/driver"
+	"acetek-mes/driver/opcua/ua"
+)
+
+const (
+	channelLifetime = time.Hour // 请求的安全通道令牌有效期
+	sessionTimeout  = time.Minute
+)
+
+var ErrTimeout = errors.New("opcua: request timeout")
+
+// client OPC UA 二进制协议客户端：UA TCP + 安全通道（None）+ 会话。
+// 读协程按请求 ID 分发应答，因此 Publish 可以与读写请求同时在途
+type client struct {
+	endpoint string
+	timeout  time.Duration
+
+	conn      net.Conn
+	sendLimit int // 对方接收缓冲区，超过时分块发送
+
+	wmu       sync.Mutex // 保护以下发送状态
+	channelID uint32
+	tokenID   uint32
+	seq       uint32
+	requestID uint32
+	handle    uint32
+	authToken ua.NodeID
+	renewAt   time.Time
+
+	pmu     sync.Mutex
+	pending map[uint32]chan []byte
+	done    chan struct{}
+	err     error // 读协程退出的原因，done 关闭后有效
+}
+
+// dial 建立连接、打开安全通道并激活会话
+func dial(endpoint string, timeout time.Duration) (*client, error) {
+	addr, err := hostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		endpoint: endpoint,
+		timeout:  timeout,
+		conn:     conn,
+		pending:  make(map[uint32]chan []byte),
+		done:     make(chan struct{}),
+	}
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.receive()
+	if err := c.createSession(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake HEL/ACK 与第一次 OPN，在读协程启动前同步完成
+func (c *client) handshake() error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	hello := ua.Hello{
+		ReceiveBufferSize: ua.DefaultBufferSize,
+		SendBufferSize:    ua.DefaultBufferSize,
+		MaxMessageSize:    ua.MaxMessageSize,
+		EndpointURL:       c.endpoint,
+	}
+	e := ua.NewEncoder()
+	hello.Encode(e, false)
+	if err := ua.WriteMessage(c.conn, ua.MsgHello, ua.ChunkFinal, e.Bytes()); err != nil {
+		return err
+	}
+	msgType, _, body, err := ua.ReadMessage(c.conn)
+	if err != nil {
+		return err
+	}
+	if msgType == ua.MsgError {
+		return ua.DecodeError(body)
+	}
+	if msgType != ua.MsgAck {
+		return fmt.Errorf("opcua: expect ACK, got %s", msgType)
+	}
+	var ack ua.Hello
+	d := ua.NewDecoder(body)
+	ack.Decode(d, true)
+	if d.Err() != nil {
+		return d.Err()
+	}
+	c.sendLimit = int(min(ack.ReceiveBufferSize, ua.DefaultBufferSize))
+
+	c.requestID++
+	c.seq++
+	req := c.openRequest(0)
+	if err := ua.WriteMessage(c.conn, ua.MsgOpen, ua.ChunkFinal, ua.EncodeOpen(0, ua.SequenceHeader{SequenceNumber: c.seq, RequestID: c.requestID}, req)); err != nil {
+		return err
+	}
+	msgType, _, body, err = ua.ReadMessage(c.conn)
+	if err != nil {
+		return err
+	}
+	if msgType == ua.MsgError {
+		return ua.DecodeError(body)
+	}
+	if msgType != ua.MsgOpen {
+		return fmt.Errorf("opcua: expect OPN, got %s", msgType)
+	}
+	_, _, payload, err := ua.DecodeOpen(body)
+	if err != nil {
+		return err
+	}
+	return c.opened(payload)
+}
+
+func (c *client) openRequest(requestType uint32) *ua.OpenSecureChannelRequest {
+	return &ua.OpenSecureChannelRequest{
+		RequestHeader:     ua.RequestHeader{Timestamp: time.Now(), TimeoutHint: uint32(c.timeout.Milliseconds())},
+		RequestType:       requestType,
+		SecurityMode:      ua.SecurityModeNone,
+		RequestedLifetime: uint32(channelLifetime.Milliseconds()),
+	}
+}
+
+// opened 记录安全通道令牌，在有效期的 75% 时续期
+func (c *client) opened(payload []byte) error {
+	var resp ua.OpenSecureChannelResponse
+	if err := ua.DecodeResponse(payload, &resp); err != nil {
+		return err
+	}
+	c.channelID, c.tokenID = resp.ChannelID, resp.TokenID
+	lifetime := time.Duration(resp.RevisedLifetime) * time.Millisecond
+	if lifetime <= 0 {
+		lifetime = channelLifetime
+	}
+	c.renewAt = time.Now().Add(lifetime * 3 / 4)
+	return nil
+}
+
+// receive 读协程：拼接分块，按请求 ID 交给等待的调用
+func (c *client) receive() {
+	chunks := make(map[uint32][]byte)
+	var err error
+loop:
+	for {
+		var msgType string
+		var chunk byte
+		var body []byte
+		msgType, chunk, body, err = ua.ReadMessage(c.conn)
+		if err != nil {
+			break loop
+		}
+		var seq ua.SequenceHeader
+		var payload []byte
+		switch msgType {
+		case ua.MsgMessage:
+			_, _, seq, payload, err = ua.DecodeSymmetric(body)
+		case ua.MsgOpen:
+			_, seq, payload, err = ua.DecodeOpen(body)
+		case ua.MsgError:
+			err = ua.DecodeError(body)
+		default:
+			err = fmt.Errorf("opcua: unexpected message %s", msgType)
+		}
+		if err != nil {
+			break loop
+		}
+		switch chunk {
+		case ua.ChunkIntermediate:
+			if len(chunks[seq.RequestID])+len(payload) > ua.MaxMessageSize {
+				err = fmt.Errorf("opcua: response exceeds %d bytes", ua.MaxMessageSize)
+				break loop
+			}
+			chunks[seq.RequestID] = append(chunks[seq.RequestID], payload...)
+			continue
+		case ua.ChunkAbort:
+			delete(chunks, seq.RequestID)
+			payload = nil
+		default:
+			if prefix, ok := chunks[seq.RequestID]; ok {
+				payload = append(prefix, payload...)
+				delete(chunks, seq.RequestID)
+			}
+		}
+		if msgType == ua.MsgOpen {
+			// 续期应答在读协程中直接生效
+			c.wmu.Lock()
+			err = c.opened(payload)
+			c.wmu.Unlock()
+			if err != nil {
+				break loop
+			}
+			continue
+		}
+		c.pmu.Lock()
+		ch, ok := c.pending[seq.RequestID]
+		delete(c.pending, seq.RequestID)
+		c.pmu.Unlock()
+		if ok {
+			ch <- payload
+		}
+	}
+	c.pmu.Lock()
+	c.err = err
+	c.pmu.Unlock()
+	close(c.done)
+	c.conn.Close()
+}
+
+// send 按对方缓冲区分块发送一个消息
+func (c *client) send(msgType string, requestID uint32, msg ua.Message) error {
+	e := ua.NewEncoder()
+	ua.EncodeMessage(e, msg)
+	data := e.Bytes()
+	// 消息头 8 字节 + 对称安全头 8 字节 + 序列头 8 字节
+	size := max(c.sendLimit-24, 1024)
+	for {
+		part := data[:min(size, len(data))]
+		data = data[len(part):]
+		chunk := ua.ChunkIntermediate
+		if len(data) == 0 {
+			chunk = ua.ChunkFinal
+		}
+		c.seq++
+		body := ua.NewEncoder()
+		body.UInt32(c.channelID)
+		body.UInt32(c.tokenID)
+		body.UInt32(c.seq)
+		body.UInt32(requestID)
+		body.Raw(part)
+		if err := ua.WriteMessage(c.conn, msgType, chunk, body.Bytes()); err != nil {
+			return err
+		}
+		if chunk == ua.ChunkFinal {
+			return nil
+		}
+	}
+}
+
+// call 发送请求并等待应答，timeout 为 0 时使用连接超时
+func (c *client) call(req ua.Request, resp ua.Response, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = c.timeout
+	}
+	ch := make(chan []byte, 1)
+
+	c.wmu.Lock()
+	if time.Now().After(c.renewAt) {
+		c.renew()
+	}
+	c.requestID++
+	id := c.requestID
+	c.handle++
+	h := req.Header()
+	h.AuthToken = c.authToken
+	h.Timestamp = time.Now()
+	h.Handle = c.handle
+	h.TimeoutHint = uint32(timeout.Milliseconds())
+	c.pmu.Lock()
+	c.pending[id] = ch
+	c.pmu.Unlock()
+	err := c.send(ua.MsgMessage, id, req)
+	c.wmu.Unlock()
+	if err != nil {
+		c.conn.Close()
+		return fmt.Errorf("%w: %v", driver.ErrConnection, err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case payload := <-ch:
+		if payload == nil {
+			return fmt.Errorf("opcua: response aborted")
+		}
+		return ua.DecodeResponse(payload, resp)
+	case <-c.done:
+		return fmt.Errorf("%w: %v", driver.ErrConnection, c.closeErr())
+	case <-timer.C:
+		c.pmu.Lock()
+		delete(c.pending, id)
+		c.pmu.Unlock()
+		return ErrTimeout
+	}
+}
+
+// renew 续期安全通道令牌，应答由读协程处理，调用时持有 wmu
+func (c *client) renew() {
+	c.requestID++
+	c.seq++
+	// 应答到达前继续使用旧令牌，避免重复续期
+	c.renewAt = time.Now().Add(c.timeout)
+	body := ua.EncodeOpen(c.channelID, ua.SequenceHeader{SequenceNumber: c.seq, RequestID: c.requestID}, c.openRequest(1))
+	ua.WriteMessage(c.conn, ua.MsgOpen, ua.ChunkFinal, body)
+}
+
+func (c *client) closeErr() error {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+	if c.err == nil {
+		return errors.New("connection closed")
+	}
+	return c.err
+}
+
+// createSession 创建并激活会话，使用服务端安全策略 None 端点的匿名令牌策略
+func (c *client) createSession() error {
+	var created ua.CreateSessionResponse
+	err := c.call(&ua.CreateSessionRequest{
+		ApplicationURI:          "urn:acetek-mes:dc",
+		ApplicationName:         "acetek-mes dc",
+		EndpointURL:             c.endpoint,
+		SessionName:             "acetek-mes",
+		RequestedSessionTimeout: float64(sessionTimeout.Milliseconds()),
+		MaxResponseMessageSize:  ua.MaxMessageSize,
+	}, &created, 0)
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	c.wmu.Lock()
+	c.authToken = created.AuthToken
+	c.wmu.Unlock()
+
+	identity := ua.Identity{PolicyID: "anonymous"}
+	for _, ep := range created.Endpoints {
+		for _, t := range ep.UserTokens {
+			if ep.SecurityPolicyURI == ua.SecurityPolicyNone && t.TokenType == ua.TokenAnonymous {
+				identity.PolicyID = t.PolicyID
+			}
+		}
+	}
+	var activated ua.ActivateSessionResponse
+	if err := c.call(&ua.ActivateSessionRequest{Identity: identity}, &activated, 0); err != nil {
+		return fmt.Errorf("activate session: %w", err)
+	}
+	return nil
+}
+
+// read 读取节点的值属性
+func (c *client) read(nodes []ua.NodeID) ([]ua.DataValue, error) {
+	req := &ua.ReadRequest{Timestamps: ua.TimestampsBoth, Nodes: make([]ua.ReadValueID, len(nodes))}
+	for i, n := range nodes {
+		req.Nodes[i] = ua.ReadValueID{NodeID: n, AttributeID: ua.AttributeValue}
+	}
+	var resp ua.ReadResponse
+	if err := c.call(req, &resp, 0); err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(nodes) {
+		return nil, fmt.Errorf("opcua: read %d nodes, got %d results", len(nodes), len(resp.Results))
+	}
+	return resp.Results, nil
+}
+
+// write 写入节点的值属性，返回每个节点的结果
+func (c *client) write(nodes []ua.NodeID, values []any) ([]ua.StatusCode, error) {
+	req := &ua.WriteRequest{Nodes: make([]ua.WriteValue, len(nodes))}
+	for i, n := range nodes {
+		req.Nodes[i] = ua.WriteValue{NodeID: n, AttributeID: ua.AttributeValue, Value: ua.DataValue{Value: values[i]}}
+	}
+	var resp ua.WriteResponse
+	if err := c.call(req, &resp, 0); err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(nodes) {
+		return nil, fmt.Errorf("opcua: write %d nodes, got %d results", len(nodes), len(resp.Results))
+	}
+	return resp.Results, nil
+}
+
+func (c *client) createSubscription(interval time.Duration, keepAlive uint32) (*ua.CreateSubscriptionResponse, error) {
+	var resp ua.CreateSubscriptionResponse
+	err := c.call(&ua.CreateSubscriptionRequest{
+		PublishingInterval: float64(interval.Milliseconds()),
+		LifetimeCount:      keepAlive * 3,
+		MaxKeepAliveCount:  keepAlive,
+		PublishingEnabled:  true,
+	}, &resp, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *client) deleteSubscription(id uint32) error {
+	var resp ua.DeleteSubscriptionsResponse
+	return c.call(&ua.DeleteSubscriptionsRequest{SubscriptionIDs: []uint32{id}}, &resp, 0)
+}
+
+func (c *client) createMonitoredItems(subscription uint32, items []ua.MonitoredItemCreateRequest) ([]ua.MonitoredItemCreateResult, error) {
+	var resp ua.CreateMonitoredItemsResponse
+	err := c.call(&ua.CreateMonitoredItemsRequest{
+		SubscriptionID: subscription,
+		Timestamps:     ua.TimestampsBoth,
+		Items:          items,
+	}, &resp, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(items) {
+		return nil, fmt.Errorf("opcua: create %d monitored items, got %d results", len(items), len(resp.Results))
+	}
+	return resp.Results, nil
+}
+
+func (c *client) publish(acks []ua.SubscriptionAcknowledgement, timeout time.Duration) (*ua.PublishResponse, error) {
+	var resp ua.PublishResponse
+	if err := c.call(&ua.PublishRequest{Acks: acks}, &resp, timeout); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// close 关闭会话与安全通道，尽力而为
+func (c *client) close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	var resp ua.CloseSessionResponse
+	c.call(&ua.CloseSessionRequest{DeleteSubscriptions: true}, &resp, c.timeout/2)
+	c.wmu.Lock()
+	c.requestID++
+	c.send(ua.MsgClose, c.requestID, &ua.CloseSecureChannelRequest{})
+	c.wmu.Unlock()
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// closed 连接已断开
+func (c *client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
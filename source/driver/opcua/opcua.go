@@ -0,0 +1,498 @@
+package opcua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"acetek-mes/driver"
+	"acetek-mes/driver/opcua/ua"
+
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+const (
+	defaultPort    = "4840"
+	keepAliveCount = 10 // 订阅无数据变化时的保活周期数
+)
+
+// OpcUaClient OPC UA 驱动。变量地址为 NodeId（如 ns=2;s=Line1.Speed），
+// 默认为每个变量创建监视项，值变化由 Publish 推送；mode=poll 时每个扫描周期 Read
+type OpcUaClient struct {
+	driver.Driver
+	endpoint  string
+	timeout   time.Duration
+	subscribe bool
+
+	client *client
+	sub    *subscription
+	stale  map[*driver.Tag]bool // 需要在下一次扫描时同步读取的变量
+}
+
+// subscription 当前订阅，只在扫描协程中访问
+type subscription struct {
+	id        uint32
+	groups    []*driver.ScanGroup // 创建订阅时的扫描组，热更新后重建订阅
+	handles   map[uint32]*driver.Tag
+	monitored map[*driver.Tag]bool
+	latest    map[*driver.Tag]ua.DataValue
+	updates   chan *ua.PublishResponse
+	errc      chan error
+	stop      chan struct{}
+}
+
+// opc.tcp://host:4840/path?mode=subscribe|poll&timeout=5000&interval=1000
+//
+// 只支持安全策略 None 与匿名访问。用户名登录需要按 Basic256Sha256 等安全策略加密密码，本驱动没有实现，
+// URL 中带用户名时返回错误；服务端只接受用户名登录时需要改用支持加密的 OPC UA 协议栈（如 gopcua）
+func NewOpcUaClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	c := &OpcUaClient{
+		endpoint: (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String(),
+		timeout:  5 * time.Second,
+		stale:    make(map[*driver.Tag]bool),
+	}
+	if u.User != nil || q.Get("user") != "" {
+		return nil, errors.New("opcua: only anonymous access is supported, username login requires an encrypted user token")
+	}
+	switch strings.ToLower(q.Get("mode")) {
+	case "", "subscribe":
+		c.subscribe = true
+	case "poll":
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", q.Get("mode"))
+	}
+	if s := q.Get("timeout"); s != "" {
+		if ms, err := strconv.Atoi(s); err == nil && ms > 0 {
+			c.timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	var interval uint32 = 1000
+	if i := q.Get("interval"); i != "" {
+		if intval, err := strconv.Atoi(i); err == nil {
+			interval = uint32(intval)
+		}
+	}
+	c.Driver = driver.Driver{
+		ID:            id,
+		Name:          name,
+		Tags:          driver.TagMap(parseTags(tags)),
+		Interval:      interval,
+		ChCommand:     make(chan string, 100),
+		ChWrite:       make(chan *driver.WriteRequest, 100),
+		ChWriteResult: make(chan error),
+		ChConfig:      make(chan *driver.Config, 1),
+	}
+	c.InitScanGroups()
+	return c, nil
+}
+
+// hostPort 端点 URL 中的地址，缺省端口 4840
+func hostPort(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// parseTags 解析 NodeId，返回解析成功的变量
+func parseTags(tags []*driver.Tag) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if id, err := ua.ParseNodeID(v.Address); err == nil {
+			v.Parsed = true
+			v.Mate = id
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse address error:", err)
+		}
+	}
+	return result
+}
+
+// 注册 OPC UA 驱动
+func init() {
+	logger.TxtLog("register driver opc.tcp")
+	driver.RegisterDriver("opc.tcp", NewOpcUaClient)
+}
+
+func (c *OpcUaClient) Connect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	return c.connect()
+}
+
+func (c *OpcUaClient) connect() error {
+	if c.Connected {
+		return nil
+	}
+	cl, err := dial(c.endpoint, c.timeout)
+	if err != nil {
+		return err
+	}
+	c.client = cl
+	c.Connected = true
+	c.LastPing = time.Now()
+	// 重新连接后所有变量先同步读取一次
+	for _, v := range c.Tags {
+		c.stale[v] = true
+	}
+	return nil
+}
+
+func (c *OpcUaClient) Disconnect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = false
+	if c.sub != nil {
+		close(c.sub.stop)
+		c.sub = nil
+	}
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.close()
+	c.client = nil
+	return err
+}
+
+func (c *OpcUaClient) IsConnected() bool {
+	return c.Connected
+}
+
+func (c *OpcUaClient) reconnectIfNeeded() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	if c.Connected && c.client.closed() {
+		c.Connected = false
+	}
+	if !c.Connected {
+		if c.client != nil {
+			c.client.close()
+			c.client = nil
+		}
+		if c.sub != nil {
+			close(c.sub.stop)
+			c.sub = nil
+		}
+		if err := c.connect(); err != nil {
+			// 身份验证失败等服务端拒绝不是连接错误，但同样需要退避重连
+			return fmt.Errorf("%w: %v", driver.ErrConnection, err)
+		}
+	}
+	return nil
+}
+
+// ensureSubscription 首次扫描或热更新后创建订阅，为每个变量创建监视项，
+// 采样周期为变量所在扫描组的周期。创建失败的变量改为每个周期同步读取
+func (c *OpcUaClient) ensureSubscription() error {
+	if !c.subscribe || (c.sub != nil && slices.Equal(c.sub.groups, c.Groups)) {
+		return nil
+	}
+	if c.sub != nil {
+		close(c.sub.stop)
+		if err := c.client.deleteSubscription(c.sub.id); err != nil {
+			log.Println("delete subscription:", err)
+		}
+		c.sub = nil
+		// 热更新后旧变量不再需要同步读取
+		c.stale = make(map[*driver.Tag]bool)
+		for _, v := range c.Tags {
+			c.stale[v] = true
+		}
+	}
+	if len(c.Groups) == 0 {
+		return nil
+	}
+	created, err := c.client.createSubscription(c.Groups[0].Interval, keepAliveCount)
+	if err != nil {
+		return err
+	}
+	sub := &subscription{
+		id:        created.SubscriptionID,
+		groups:    c.Groups,
+		handles:   make(map[uint32]*driver.Tag),
+		monitored: make(map[*driver.Tag]bool),
+		latest:    make(map[*driver.Tag]ua.DataValue),
+		updates:   make(chan *ua.PublishResponse, 16),
+		errc:      make(chan error, 1),
+		stop:      make(chan struct{}),
+	}
+	var items []ua.MonitoredItemCreateRequest
+	var tags []*driver.Tag
+	for _, g := range c.Groups {
+		for _, v := range g.Tags {
+			handle := uint32(len(tags) + 1)
+			sub.handles[handle] = v
+			tags = append(tags, v)
+			items = append(items, ua.MonitoredItemCreateRequest{
+				Item:             ua.ReadValueID{NodeID: v.Mate.(ua.NodeID), AttributeID: ua.AttributeValue},
+				MonitoringMode:   ua.MonitoringReporting,
+				ClientHandle:     handle,
+				SamplingInterval: float64(g.Interval.Milliseconds()),
+				QueueSize:        1,
+				DiscardOldest:    true,
+			})
+		}
+	}
+	results, err := c.client.createMonitoredItems(sub.id, items)
+	if err != nil {
+		c.client.deleteSubscription(sub.id)
+		return err
+	}
+	for i, r := range results {
+		if r.Status.IsBad() {
+			log.Printf("opcua %s monitor %s: %v", c.ID, tags[i].Address, r.Status)
+			continue
+		}
+		sub.monitored[tags[i]] = true
+	}
+	log.Printf("opcua %s subscription %d: %d tags, %d monitored, publishing %v",
+		c.ID, sub.id, len(tags), len(sub.monitored), time.Duration(created.RevisedPublishingInterval)*time.Millisecond)
+	interval := time.Duration(created.RevisedPublishingInterval * float64(time.Millisecond))
+	go sub.run(c.client, interval*time.Duration(max(created.RevisedMaxKeepAliveCount, 1))+c.timeout)
+	c.sub = sub
+	return nil
+}
+
+// run 发布协程：始终保持一个 Publish 在途，收到的通知交给扫描协程
+func (s *subscription) run(cl *client, timeout time.Duration) {
+	var acks []ua.SubscriptionAcknowledgement
+	for {
+		resp, err := cl.publish(acks, timeout)
+		acks = nil
+		if err != nil {
+			var status ua.StatusCode
+			// 服务端没有待发送的数据时可能直接返回超时，继续等待
+			if errors.Is(err, ErrTimeout) || (errors.As(err, &status) && status == ua.StatusBadTimeout) {
+				continue
+			}
+			select {
+			case s.errc <- err:
+			default:
+			}
+			return
+		}
+		if len(resp.Notifications) > 0 {
+			acks = append(acks, ua.SubscriptionAcknowledgement{SubscriptionID: resp.SubscriptionID, SequenceNumber: resp.SequenceNumber})
+		}
+		if resp.SubscriptionID != s.id {
+			continue
+		}
+		select {
+		case s.updates <- resp:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// drain 取出发布协程收到的通知，只保留每个变量的最新值
+func (s *subscription) drain() error {
+	for {
+		select {
+		case err := <-s.errc:
+			return err
+		case resp := <-s.updates:
+			for _, n := range resp.Notifications {
+				if v, ok := s.handles[n.ClientHandle]; ok {
+					s.latest[v] = n.Value
+				}
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// apply 用 DataValue 更新变量，状态码映射为质量
+func apply(v *driver.Tag, dv ua.DataValue, now time.Time) {
+	v.Quality = dv.Status.Quality()
+	v.Timestamp = dv.SourceTimestamp
+	if v.Timestamp.IsZero() {
+		v.Timestamp = now
+	}
+	if dv.Status.IsBad() {
+		if dv.Status != ua.StatusBad {
+			log.Printf("opcua tag %s: %v", v.Name, dv.Status)
+		}
+		v.Value = nil
+		return
+	}
+	v.Value = v.Scale(dv.Value)
+}
+
+func (c *OpcUaClient) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadGroup 订阅模式下应用收到的通知，未监视、刚重连或刚写入的变量同步读取；轮询模式全部同步读取
+func (c *OpcUaClient) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if err := c.reconnectIfNeeded(); err != nil {
+		return nil, err
+	}
+	if err := c.ensureSubscription(); err != nil {
+		return nil, c.failed(err)
+	}
+	if c.sub != nil {
+		if err := c.sub.drain(); err != nil {
+			return nil, c.failed(err)
+		}
+	}
+	now := time.Now()
+	var reads []*driver.Tag
+	for _, v := range g.Tags {
+		if c.sub == nil || !c.sub.monitored[v] || c.stale[v] {
+			reads = append(reads, v)
+		} else if dv, ok := c.sub.latest[v]; ok {
+			apply(v, dv, now)
+			delete(c.sub.latest, v)
+		}
+	}
+	var err error
+	if len(reads) > 0 {
+		nodes := make([]ua.NodeID, len(reads))
+		for i, v := range reads {
+			nodes[i] = v.Mate.(ua.NodeID)
+		}
+		var values []ua.DataValue
+		values, err = c.client.read(nodes)
+		for i, v := range reads {
+			if err != nil {
+				v.Quality = "Bad"
+				v.Value = nil
+				v.Timestamp = now
+				continue
+			}
+			apply(v, values[i], now)
+			delete(c.stale, v)
+			if c.sub != nil {
+				// 同步读取之前的通知已过期
+				delete(c.sub.latest, v)
+			}
+		}
+	}
+	for _, v := range g.Tags {
+		result[v.Name] = v.Value
+	}
+	if err != nil {
+		return result, c.failed(err)
+	}
+	return result, nil
+}
+
+// failed 连接已断开时标记为未连接，下一次扫描重新建立会话
+func (c *OpcUaClient) failed(err error) error {
+	if c.client.closed() || driver.IsConnError(err) {
+		c.Connected = false
+		if !errors.Is(err, driver.ErrConnection) {
+			err = fmt.Errorf("%w: %v", driver.ErrConnection, err)
+		}
+	}
+	return err
+}
+
+func (c *OpcUaClient) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
+}
+
+// write 所有变量合并为一个 Write 请求，任一节点失败返回错误
+func (c *OpcUaClient) write(values map[string]interface{}) error {
+	if err := c.reconnectIfNeeded(); err != nil {
+		return err
+	}
+	var names []string
+	var nodes []ua.NodeID
+	var raws []any
+	for k, v := range values {
+		tag, ok := c.Tags[k]
+		if !ok {
+			return fmt.Errorf("tag %s is not define", k)
+		} else if !tag.Writable {
+			return fmt.Errorf("tag %s is readonly", k)
+		}
+		raw, err := variantValue(tag, v)
+		if err != nil {
+			return fmt.Errorf("tag %s: %v", k, err)
+		}
+		names = append(names, k)
+		nodes = append(nodes, tag.Mate.(ua.NodeID))
+		raws = append(raws, raw)
+	}
+	results, err := c.client.write(nodes, raws)
+	if err != nil {
+		return c.failed(err)
+	}
+	var errs []error
+	for i, status := range results {
+		// 写入后下一次读取直接读 PLC，不等待订阅通知
+		c.stale[c.Tags[names[i]]] = true
+		if status.IsBad() {
+			errs = append(errs, fmt.Errorf("write %s: %w", names[i], status))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *OpcUaClient) WriteTag(name string, value interface{}) error {
+	return c.write(map[string]interface{}{name: value})
+}
+
+// variantValue 按变量的数据类型把工程值转换为 Variant 的值，类型与服务端节点不一致时服务端返回 BadTypeMismatch
+func variantValue(tag *driver.Tag, value interface{}) (any, error) {
+	if tag.Datatype == "" {
+		return nil, fmt.Errorf("datatype required for write")
+	}
+	raw, err := tag.Unscale(value)
+	if err != nil {
+		return nil, err
+	}
+	v := tag.ConvertValue(raw)
+	if v == nil {
+		return nil, fmt.Errorf("cannot convert %v to %s", value, tag.Datatype)
+	}
+	if err := ua.CheckVariant(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (c *OpcUaClient) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, func() { c.Disconnect() })
+}
+
+func (c *OpcUaClient) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags)
+	return c.Driver.Reconfig(cfg)
+}
@@ -0,0 +1,195 @@
+package opcua
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	driver "acetek-mes/driver"
+	"acetek-mes/driver/opcua/ua"
+	"acetek-mes/driver/opcua/uaserver"
+)
+
+func newTestServer(t *testing.T) *uaserver.Server {
+	server := uaserver.NewServer()
+	nodes := []struct {
+		id       string
+		value    any
+		writable bool
+	}{
+		{"ns=2;s=Line1.Running", true, true},
+		{"ns=2;s=Line1.Speed", float32(36.5), true},
+		{"ns=2;s=Line1.Count", int32(1200), true},
+		{"ns=2;s=Line1.Batch", "B-001", true},
+		{"ns=2;i=1001", uint16(7), false},
+	}
+	for _, n := range nodes {
+		if err := server.AddVariable(n.id, n.value, n.writable); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newTestClient(t *testing.T, rawURL string, tags []*driver.Tag) *OpcUaClient {
+	c, err := NewOpcUaClient("UA", "", rawURL, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*OpcUaClient)
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func testTags() []*driver.Tag {
+	return []*driver.Tag{
+		{Name: "运行", Address: "ns=2;s=Line1.Running", Datatype: "bool", Writable: true},
+		{Name: "线速", Address: "ns=2;s=Line1.Speed", Datatype: "float32", Writable: true},
+		{Name: "计数", Address: "ns=2;s=Line1.Count", Datatype: "int32", Writable: true},
+		{Name: "批号", Address: "ns=2;s=Line1.Batch", Datatype: "string", Writable: true},
+		{Name: "模式", Address: "ns=2;i=1001", Datatype: "uint16", Writable: true},
+	}
+}
+
+func TestParseNodeID(t *testing.T) {
+	for _, s := range []string{"i=85", "ns=2;s=Line1.Speed", "ns=3;i=1001"} {
+		id, err := ua.ParseNodeID(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if id.String() != s {
+			t.Errorf("%s: %s", s, id)
+		}
+	}
+	for _, s := range []string{"", "ns=x;i=1", "ns=2;g=abc", "i=abc"} {
+		if _, err := ua.ParseNodeID(s); err == nil {
+			t.Errorf("%q: expect error", s)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	server := newTestServer(t)
+	server.SetStatus("ns=2;i=1001", ua.StatusUncertainLastUsableValue)
+	tags := append(testTags(), &driver.Tag{Name: "不存在", Address: "ns=2;s=Missing", Datatype: "int16"})
+	client := newTestClient(t, server.Endpoint()+"?mode=poll", tags)
+
+	values, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"运行": true, "线速": float32(36.5), "计数": int32(1200), "批号": "B-001", "模式": uint16(7)}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s: got %v (%T), want %v", name, values[name], values[name], v)
+		}
+	}
+	// 状态码映射为质量，节点不存在只影响该变量
+	for name, quality := range map[string]string{"运行": "Good", "模式": "Uncertain", "不存在": "Bad"} {
+		if q := client.Tags[name].Quality; q != quality {
+			t.Errorf("%s quality: %s", name, q)
+		}
+	}
+	if values["不存在"] != nil || !client.IsConnected() {
+		t.Fatal("unknown node")
+	}
+	// 轮询模式每个扫描组一次 Read
+	if s := server.Stats(); s.Reads != 1 || s.ReadNodes != 6 {
+		t.Fatalf("stats: %+v", s)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.Endpoint()+"?interval=50", testTags())
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+	reads := server.Stats().Reads
+
+	server.SetValue("ns=2;s=Line1.Count", int32(1300))
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		values, err := client.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values["计数"] == int32(1300) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no data change: %v", values)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// 值变化由 Publish 推送，不再同步读取
+	if s := server.Stats(); s.Reads != reads || s.Publishes == 0 {
+		t.Fatalf("stats: %+v, reads before %d", s, reads)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	server := newTestServer(t)
+
+	// 只支持匿名访问，不发送用户名密码
+	for _, rawURL := range []string{"opc.tcp://operator:secret@" + server.Addr(), server.Endpoint() + "?user=operator&password=secret"} {
+		if _, err := NewOpcUaClient("UA", "", rawURL, testTags()); err == nil {
+			t.Fatalf("%s: username accepted", rawURL)
+		}
+	}
+	client := newTestClient(t, server.Endpoint()+"?interval=50", testTags())
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	// 写入后同步读回校验
+	req := &driver.WriteRequest{Values: map[string]interface{}{"线速": 42.0, "计数": 5.0, "批号": "B-002"}}
+	if err := client.Submit(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ReadBack["线速"] != float32(42) || req.ReadBack["计数"] != int32(5) {
+		t.Fatalf("read back: %v", req.ReadBack)
+	}
+	if v, _ := server.Value("ns=2;s=Line1.Batch"); v != "B-002" {
+		t.Fatalf("batch: %v", v)
+	}
+	// 服务端拒绝写入时返回状态码
+	if err := client.Write("模式", 3.0); err == nil {
+		t.Fatal("not writable node written")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server.Endpoint()+"?interval=50", testTags())
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+	server.Disconnect()
+	// 连接断开后读取失败为连接错误，或在下一次扫描时直接重新建立会话与订阅
+	deadline := time.Now().Add(3 * time.Second)
+	for server.Stats().Sessions < 2 {
+		if _, err := client.Read(); err != nil && !driver.IsConnError(err) {
+			t.Fatalf("expect connection error, got %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no reconnect")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	values, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["批号"] != "B-001" || client.Tags["批号"].Quality != "Good" {
+		t.Fatalf("values: %v", values)
+	}
+	if s := server.Stats(); s.Connections != 2 {
+		t.Fatalf("stats: %+v", s)
+	}
+}
@@ -0,0 +1,295 @@
+// Package ua OPC UA 二进制编码（UA Binary）与 UA TCP 报文，供 opcua 驱动与测试服务端共用。
+// 只实现驱动用到的服务与内置类型，安全策略仅支持 None。
+//
+// 没有使用 gopcua：驱动只需要 Read/Write/订阅几个服务，gopcua 体量大、依赖多，
+// 而且测试需要可控的内存服务端（uaserver）来模拟断线、拒绝写入等情况，两边共用这里的编码。
+// 需要签名或加密（Basic256Sha256 等安全策略）时应改用 gopcua，而不是在这里补实现
+package ua
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// 1601-01-01 到 1970-01-01 的 100ns 间隔数
+const epochOffset = 116444736000000000
+
+var ErrDecode = errors.New("ua: decode error")
+
+// Encoder 按 UA Binary 追加编码，全部为小端
+type Encoder struct {
+	buf []byte
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{buf: make([]byte, 0, 256)}
+}
+
+func (e *Encoder) Bytes() []byte { return e.buf }
+
+func (e *Encoder) Byte(v byte)     { e.buf = append(e.buf, v) }
+func (e *Encoder) Raw(v []byte)    { e.buf = append(e.buf, v...) }
+func (e *Encoder) UInt16(v uint16) { e.buf = binary.LittleEndian.AppendUint16(e.buf, v) }
+func (e *Encoder) UInt32(v uint32) { e.buf = binary.LittleEndian.AppendUint32(e.buf, v) }
+func (e *Encoder) Int32(v int32)   { e.UInt32(uint32(v)) }
+func (e *Encoder) UInt64(v uint64) { e.buf = binary.LittleEndian.AppendUint64(e.buf, v) }
+func (e *Encoder) Int64(v int64)   { e.UInt64(uint64(v)) }
+func (e *Encoder) Float(v float32) { e.UInt32(math.Float32bits(v)) }
+func (e *Encoder) Double(v float64) {
+	e.UInt64(math.Float64bits(v))
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Byte(1)
+	} else {
+		e.Byte(0)
+	}
+}
+
+// Str 空字符串编码为 null（长度 -1）
+func (e *Encoder) Str(s string) {
+	if s == "" {
+		e.Int32(-1)
+		return
+	}
+	e.Int32(int32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *Encoder) ByteString(b []byte) {
+	if b == nil {
+		e.Int32(-1)
+		return
+	}
+	e.Int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *Encoder) DateTime(t time.Time) {
+	if t.IsZero() {
+		e.Int64(0)
+		return
+	}
+	e.Int64(t.UnixNano()/100 + epochOffset)
+}
+
+func (e *Encoder) StringArray(s []string) {
+	if s == nil {
+		e.Int32(-1)
+		return
+	}
+	e.Int32(int32(len(s)))
+	for _, v := range s {
+		e.Str(v)
+	}
+}
+
+// LocalizedText 只编码文本
+func (e *Encoder) LocalizedText(text string) {
+	if text == "" {
+		e.Byte(0)
+		return
+	}
+	e.Byte(0x02)
+	e.Str(text)
+}
+
+func (e *Encoder) QualifiedName(ns uint16, name string) {
+	e.UInt16(ns)
+	e.Str(name)
+}
+
+// ExtensionObject 以二进制编码的结构体，typeID 为编码节点，body 为 nil 时编码为空对象
+func (e *Encoder) ExtensionObject(typeID uint32, body func(*Encoder)) {
+	if body == nil {
+		e.NodeID(NodeID{})
+		e.Byte(0)
+		return
+	}
+	e.NodeID(NewNumericNodeID(0, typeID))
+	e.Byte(0x01)
+	inner := NewEncoder()
+	body(inner)
+	e.ByteString(inner.Bytes())
+}
+
+// Decoder 顺序解码，出错后后续读取均返回零值，调用方最后检查 Err
+type Decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func NewDecoder(b []byte) *Decoder {
+	return &Decoder{buf: b}
+}
+
+func (d *Decoder) Err() error { return d.err }
+
+// Remaining 未解码的字节
+func (d *Decoder) Remaining() []byte {
+	if d.err != nil {
+		return nil
+	}
+	return d.buf[d.pos:]
+}
+
+func (d *Decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: "+format, append([]any{ErrDecode}, args...)...)
+	}
+}
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.pos+n > len(d.buf) {
+		d.fail("need %d bytes at %d, have %d", n, d.pos, len(d.buf))
+		return nil
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *Decoder) Byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *Decoder) Bool() bool { return d.Byte() != 0 }
+
+func (d *Decoder) UInt16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *Decoder) UInt32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *Decoder) Int32() int32 { return int32(d.UInt32()) }
+
+func (d *Decoder) UInt64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *Decoder) Int64() int64           { return int64(d.UInt64()) }
+func (d *Decoder) Float() float32         { return math.Float32frombits(d.UInt32()) }
+func (d *Decoder) Double() float64        { return math.Float64frombits(d.UInt64()) }
+func (d *Decoder) Str() string            { return string(d.ByteString()) }
+func (d *Decoder) StatusCode() StatusCode { return StatusCode(d.UInt32()) }
+
+func (d *Decoder) ByteString() []byte {
+	n := d.Int32()
+	if n < 0 {
+		return nil
+	}
+	b := d.next(int(n))
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *Decoder) DateTime() time.Time {
+	v := d.Int64()
+	if v <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, (v-epochOffset)*100)
+}
+
+// ArrayLength 数组长度，-1（null）返回 0
+func (d *Decoder) ArrayLength() int {
+	n := d.Int32()
+	if n < 0 {
+		return 0
+	}
+	// 每个元素至少 1 字节，防止恶意长度导致大量分配
+	if int(n) > len(d.buf)-d.pos {
+		d.fail("array length %d exceeds message", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *Decoder) StringArray() []string {
+	n := d.ArrayLength()
+	s := make([]string, n)
+	for i := range s {
+		s[i] = d.Str()
+	}
+	return s
+}
+
+func (d *Decoder) LocalizedText() string {
+	mask := d.Byte()
+	if mask&0x01 != 0 {
+		d.Str()
+	}
+	if mask&0x02 != 0 {
+		return d.Str()
+	}
+	return ""
+}
+
+func (d *Decoder) QualifiedName() (uint16, string) {
+	return d.UInt16(), d.Str()
+}
+
+// ExtensionObject 返回编码节点的数字 ID 与二进制内容，空对象返回 0
+func (d *Decoder) ExtensionObject() (uint32, []byte) {
+	id := d.NodeID()
+	switch d.Byte() {
+	case 0x00:
+		return 0, nil
+	case 0x01:
+		n, _ := id.Numeric()
+		return n, d.ByteString()
+	default:
+		// XML 编码不支持，跳过内容
+		d.ByteString()
+		return 0, nil
+	}
+}
+
+// DiagnosticInfo 跳过诊断信息
+func (d *Decoder) DiagnosticInfo() {
+	mask := d.Byte()
+	for _, bit := range []byte{0x01, 0x02, 0x04, 0x08} {
+		if mask&bit != 0 {
+			d.Int32()
+		}
+	}
+	if mask&0x10 != 0 {
+		d.Str()
+	}
+	if mask&0x20 != 0 {
+		d.StatusCode()
+	}
+	if mask&0x40 != 0 {
+		d.DiagnosticInfo()
+	}
+}
+
+func (d *Decoder) DiagnosticInfos() {
+	for range d.ArrayLength() {
+		d.DiagnosticInfo()
+	}
+}
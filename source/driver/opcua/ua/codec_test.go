@@ -0,0 +1,202 @@
+package ua
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScalarRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 8, 30, 0, 123456700, time.UTC)
+	e := NewEncoder()
+	e.Bool(true)
+	e.UInt16(0xBEEF)
+	e.Int32(-5)
+	e.Int64(-1 << 40)
+	e.Float(1.5)
+	e.Double(-2.25)
+	e.Str("产线")
+	e.Str("")
+	e.ByteString([]byte{1, 2})
+	e.DateTime(ts)
+	e.DateTime(time.Time{})
+	e.StringArray([]string{"a", "b"})
+	e.LocalizedText("速度")
+	e.QualifiedName(2, "Speed")
+
+	d := NewDecoder(e.Bytes())
+	if !d.Bool() || d.UInt16() != 0xBEEF || d.Int32() != -5 || d.Int64() != -1<<40 {
+		t.Fatal("integer mismatch")
+	}
+	if d.Float() != 1.5 || d.Double() != -2.25 {
+		t.Fatal("float mismatch")
+	}
+	if s := d.Str(); s != "产线" {
+		t.Fatalf("str %q", s)
+	}
+	if s := d.Str(); s != "" {
+		t.Fatalf("empty str %q", s)
+	}
+	if b := d.ByteString(); !bytes.Equal(b, []byte{1, 2}) {
+		t.Fatalf("bytestring %v", b)
+	}
+	if got := d.DateTime(); !got.Equal(ts) {
+		t.Fatalf("datetime %v, want %v", got, ts)
+	}
+	if got := d.DateTime(); !got.IsZero() {
+		t.Fatalf("zero datetime %v", got)
+	}
+	if s := d.StringArray(); !reflect.DeepEqual(s, []string{"a", "b"}) {
+		t.Fatalf("string array %v", s)
+	}
+	if s := d.LocalizedText(); s != "速度" {
+		t.Fatalf("localized text %q", s)
+	}
+	if ns, name := d.QualifiedName(); ns != 2 || name != "Speed" {
+		t.Fatalf("qualified name %d %q", ns, name)
+	}
+	if d.Err() != nil || len(d.Remaining()) != 0 {
+		t.Fatal(d.Err(), d.Remaining())
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	e := NewEncoder()
+	e.Str("hello")
+	b := e.Bytes()
+
+	d := NewDecoder(b[:len(b)-1])
+	d.Str()
+	if !errors.Is(d.Err(), ErrDecode) {
+		t.Fatalf("err %v", d.Err())
+	}
+	// 出错后不再前进
+	if d.UInt32() != 0 || d.Remaining() != nil {
+		t.Fatal("decoder continued after error")
+	}
+
+	// 数组长度超过剩余字节
+	e = NewEncoder()
+	e.Int32(1000)
+	d = NewDecoder(e.Bytes())
+	d.ArrayLength()
+	if !errors.Is(d.Err(), ErrDecode) {
+		t.Fatalf("array length err %v", d.Err())
+	}
+}
+
+func TestNodeID(t *testing.T) {
+	cases := []struct {
+		text  string
+		first byte
+		size  int
+	}{
+		{"i=85", 0x00, 2},
+		{"ns=2;i=1001", 0x01, 4},
+		{"ns=300;i=7", 0x02, 7},
+		{"ns=1;i=70000", 0x02, 7},
+		{"ns=2;s=Line1.Speed", 0x03, 3 + 4 + len("Line1.Speed")},
+	}
+	for _, c := range cases {
+		n, err := ParseNodeID(c.text)
+		if err != nil {
+			t.Fatal(c.text, err)
+		}
+		if n.String() != c.text {
+			t.Fatalf("%s: string %s", c.text, n)
+		}
+		e := NewEncoder()
+		e.NodeID(n)
+		b := e.Bytes()
+		if b[0] != c.first || len(b) != c.size {
+			t.Fatalf("%s: encoded %x", c.text, b)
+		}
+		d := NewDecoder(b)
+		if got := d.NodeID(); got != n || d.Err() != nil {
+			t.Fatalf("%s: decoded %v %v", c.text, got, d.Err())
+		}
+	}
+	if id, ok := NewNumericNodeID(0, 631).Numeric(); !ok || id != 631 {
+		t.Fatal("numeric", id, ok)
+	}
+	if _, ok := NewStringNodeID(2, "x").Numeric(); ok {
+		t.Fatal("string node id is numeric")
+	}
+	for _, s := range []string{"", "ns=x;i=1", "ns=2;i=abc", "ns=2;g=1"} {
+		if _, err := ParseNodeID(s); err == nil {
+			t.Fatalf("%q: expect error", s)
+		}
+	}
+}
+
+func TestVariant(t *testing.T) {
+	values := []any{
+		nil, true, int8(-1), uint8(2), int16(-3), uint16(4), int32(-5), uint32(6),
+		int64(-7), uint64(8), float32(1.5), 2.5, "批次",
+		time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), []byte{9}, StatusBadNodeIDUnknown,
+	}
+	for _, v := range values {
+		if err := CheckVariant(v); err != nil {
+			t.Fatal(err)
+		}
+		e := NewEncoder()
+		e.Variant(v)
+		d := NewDecoder(e.Bytes())
+		got := d.Variant()
+		if d.Err() != nil {
+			t.Fatalf("%T: %v", v, d.Err())
+		}
+		if tm, ok := v.(time.Time); ok {
+			if !tm.Equal(got.(time.Time)) {
+				t.Fatalf("time %v, want %v", got, tm)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Fatalf("%T: got %#v, want %#v", v, got, v)
+		}
+	}
+	if err := CheckVariant(struct{}{}); err == nil {
+		t.Fatal("expect unsupported type error")
+	}
+}
+
+func TestDataValue(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	values := []DataValue{
+		{Value: float32(36.5)},
+		{Value: int32(1), Status: StatusBadNodeIDUnknown, SourceTimestamp: ts, ServerTimestamp: ts.Add(time.Second)},
+	}
+	for _, v := range values {
+		e := NewEncoder()
+		e.DataValue(v)
+		d := NewDecoder(e.Bytes())
+		got := d.DataValue()
+		if d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+		if got.Value != v.Value || got.Status != v.Status ||
+			!got.SourceTimestamp.Equal(v.SourceTimestamp) || !got.ServerTimestamp.Equal(v.ServerTimestamp) {
+			t.Fatalf("got %+v, want %+v", got, v)
+		}
+	}
+}
+
+func TestExtensionObject(t *testing.T) {
+	e := NewEncoder()
+	e.ExtensionObject(IDAnonymousIdentityToken, func(e *Encoder) { e.Str("anonymous") })
+	e.ExtensionObject(0, nil)
+	d := NewDecoder(e.Bytes())
+	id, body := d.ExtensionObject()
+	if id != IDAnonymousIdentityToken || NewDecoder(body).Str() != "anonymous" {
+		t.Fatalf("got %d %x", id, body)
+	}
+	if id, body := d.ExtensionObject(); id != 0 || len(body) != 0 {
+		t.Fatalf("empty got %d %x", id, body)
+	}
+	if d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+}
@@ -0,0 +1,130 @@
+package ua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NodeID 只支持数字与字符串标识
+type NodeID struct {
+	Namespace uint16
+	id        uint32
+	str       string
+	isString  bool
+}
+
+func NewNumericNodeID(ns uint16, id uint32) NodeID {
+	return NodeID{Namespace: ns, id: id}
+}
+
+func NewStringNodeID(ns uint16, id string) NodeID {
+	return NodeID{Namespace: ns, str: id, isString: true}
+}
+
+// Numeric 数字标识，字符串标识返回 false
+func (n NodeID) Numeric() (uint32, bool) {
+	return n.id, !n.isString
+}
+
+func (n NodeID) IsNull() bool {
+	return n.Namespace == 0 && !n.isString && n.id == 0
+}
+
+// String 标准文本格式，如 ns=2;s=Line1.Speed、i=2258
+func (n NodeID) String() string {
+	var prefix string
+	if n.Namespace != 0 {
+		prefix = fmt.Sprintf("ns=%d;", n.Namespace)
+	}
+	if n.isString {
+		return prefix + "s=" + n.str
+	}
+	return prefix + "i=" + strconv.FormatUint(uint64(n.id), 10)
+}
+
+// ParseNodeID 解析 ns=<ns>;i=<数字> 或 ns=<ns>;s=<字符串>，省略 ns 时为 0
+func ParseNodeID(s string) (NodeID, error) {
+	s = strings.TrimSpace(s)
+	var ns uint64
+	if strings.HasPrefix(s, "ns=") {
+		idx := strings.Index(s, ";")
+		if idx < 0 {
+			return NodeID{}, fmt.Errorf("invalid node id: %s", s)
+		}
+		var err error
+		if ns, err = strconv.ParseUint(s[3:idx], 10, 16); err != nil {
+			return NodeID{}, fmt.Errorf("invalid namespace: %s", s)
+		}
+		s = s[idx+1:]
+	}
+	switch {
+	case strings.HasPrefix(s, "i="):
+		id, err := strconv.ParseUint(s[2:], 10, 32)
+		if err != nil {
+			return NodeID{}, fmt.Errorf("invalid numeric node id: %s", s)
+		}
+		return NewNumericNodeID(uint16(ns), uint32(id)), nil
+	case strings.HasPrefix(s, "s=") && len(s) > 2:
+		return NewStringNodeID(uint16(ns), s[2:]), nil
+	}
+	return NodeID{}, fmt.Errorf("unsupported node id: %s", s)
+}
+
+// NodeID 数字标识按范围选择 2 字节、4 字节或完整编码
+func (e *Encoder) NodeID(n NodeID) {
+	switch {
+	case n.isString:
+		e.Byte(0x03)
+		e.UInt16(n.Namespace)
+		e.Str(n.str)
+	case n.Namespace == 0 && n.id <= 0xFF:
+		e.Byte(0x00)
+		e.Byte(byte(n.id))
+	case n.Namespace <= 0xFF && n.id <= 0xFFFF:
+		e.Byte(0x01)
+		e.Byte(byte(n.Namespace))
+		e.UInt16(uint16(n.id))
+	default:
+		e.Byte(0x02)
+		e.UInt16(n.Namespace)
+		e.UInt32(n.id)
+	}
+}
+
+// NodeID 同时接受 ExpandedNodeId 的标志位，忽略 NamespaceUri 与 ServerIndex
+func (d *Decoder) NodeID() NodeID {
+	encoding := d.Byte()
+	var n NodeID
+	switch encoding & 0x0F {
+	case 0x00:
+		n = NewNumericNodeID(0, uint32(d.Byte()))
+	case 0x01:
+		ns := d.Byte()
+		n = NewNumericNodeID(uint16(ns), uint32(d.UInt16()))
+	case 0x02:
+		ns := d.UInt16()
+		n = NewNumericNodeID(ns, d.UInt32())
+	case 0x03:
+		ns := d.UInt16()
+		n = NewStringNodeID(ns, d.Str())
+	case 0x04:
+		// GUID 不支持，跳过
+		d.UInt16()
+		d.next(16)
+		d.fail("guid node id not supported")
+	case 0x05:
+		d.UInt16()
+		d.ByteString()
+		d.fail("opaque node id not supported")
+	default:
+		d.fail("invalid node id encoding 0x%02X", encoding)
+	}
+	if encoding&0x80 != 0 {
+		d.Str()
+	}
+	if encoding&0x40 != 0 {
+		d.UInt32()
+	}
+	return n
+}
@@ -0,0 +1,983 @@
+package ua
+
+import (
+	"fmt"
+	"time"
+)
+
+// 服务消息的二进制编码节点 ID
+const (
+	IDServiceFault                 uint32 = 397
+	IDOpenSecureChannelRequest     uint32 = 446
+	IDOpenSecureChannelResponse    uint32 = 449
+	IDCloseSecureChannelRequest    uint32 = 452
+	IDCreateSessionRequest         uint32 = 461
+	IDCreateSessionResponse        uint32 = 464
+	IDActivateSessionRequest       uint32 = 467
+	IDActivateSessionResponse      uint32 = 470
+	IDCloseSessionRequest          uint32 = 473
+	IDCloseSessionResponse         uint32 = 476
+	IDReadRequest                  uint32 = 631
+	IDReadResponse                 uint32 = 634
+	IDWriteRequest                 uint32 = 673
+	IDWriteResponse                uint32 = 676
+	IDCreateMonitoredItemsRequest  uint32 = 751
+	IDCreateMonitoredItemsResponse uint32 = 754
+	IDCreateSubscriptionRequest    uint32 = 787
+	IDCreateSubscriptionResponse   uint32 = 790
+	IDDeleteSubscriptionsRequest   uint32 = 847
+	IDDeleteSubscriptionsResponse  uint32 = 850
+	IDPublishRequest               uint32 = 826
+	IDPublishResponse              uint32 = 829
+	IDAnonymousIdentityToken       uint32 = 321
+	IDDataChangeNotification       uint32 = 811
+)
+
+const (
+	AttributeValue uint32 = 13
+
+	SecurityModeNone uint32 = 1
+
+	TokenAnonymous uint32 = 0
+	TokenUserName  uint32 = 1
+
+	MonitoringReporting uint32 = 2
+
+	TimestampsSource uint32 = 0
+	TimestampsBoth   uint32 = 2
+)
+
+// Message 服务消息
+type Message interface {
+	TypeID() uint32
+	Encode(e *Encoder)
+	Decode(d *Decoder)
+}
+
+// Request 带请求头的服务请求
+type Request interface {
+	Message
+	Header() *RequestHeader
+}
+
+// Response 带应答头的服务应答
+type Response interface {
+	Message
+	Response() *ResponseHeader
+}
+
+// EncodeMessage 编码节点 ID 与消息内容
+func EncodeMessage(e *Encoder, m Message) {
+	e.NodeID(NewNumericNodeID(0, m.TypeID()))
+	m.Encode(e)
+}
+
+// DecodeResponse 解码应答，ServiceFault 或服务结果为 Bad 时返回对应的状态码
+func DecodeResponse(body []byte, resp Response) error {
+	d := NewDecoder(body)
+	id, _ := d.NodeID().Numeric()
+	if d.Err() != nil {
+		return d.Err()
+	}
+	if id == IDServiceFault {
+		var fault ServiceFault
+		fault.Decode(d)
+		if d.Err() != nil {
+			return d.Err()
+		}
+		if fault.ServiceResult == StatusGood {
+			return StatusBadUnexpectedError
+		}
+		return fault.ServiceResult
+	}
+	if id != resp.TypeID() {
+		return fmt.Errorf("ua: unexpected response type %d, want %d", id, resp.TypeID())
+	}
+	resp.Decode(d)
+	if d.Err() != nil {
+		return d.Err()
+	}
+	if result := resp.Response().ServiceResult; result.IsBad() {
+		return result
+	}
+	return nil
+}
+
+// RequestHeader 请求头，未使用的字段编码为空
+type RequestHeader struct {
+	AuthToken   NodeID
+	Timestamp   time.Time
+	Handle      uint32
+	TimeoutHint uint32
+}
+
+func (h *RequestHeader) Header() *RequestHeader { return h }
+
+func (h *RequestHeader) encode(e *Encoder) {
+	e.NodeID(h.AuthToken)
+	e.DateTime(h.Timestamp)
+	e.UInt32(h.Handle)
+	e.UInt32(0) // ReturnDiagnostics
+	e.Str("")   // AuditEntryId
+	e.UInt32(h.TimeoutHint)
+	e.ExtensionObject(0, nil)
+}
+
+func (h *RequestHeader) decode(d *Decoder) {
+	h.AuthToken = d.NodeID()
+	h.Timestamp = d.DateTime()
+	h.Handle = d.UInt32()
+	d.UInt32()
+	d.Str()
+	h.TimeoutHint = d.UInt32()
+	d.ExtensionObject()
+}
+
+// ResponseHeader 应答头
+type ResponseHeader struct {
+	Timestamp     time.Time
+	Handle        uint32
+	ServiceResult StatusCode
+}
+
+func (h *ResponseHeader) Response() *ResponseHeader { return h }
+
+func (h *ResponseHeader) encode(e *Encoder) {
+	e.DateTime(h.Timestamp)
+	e.UInt32(h.Handle)
+	e.UInt32(uint32(h.ServiceResult))
+	e.Byte(0)   // ServiceDiagnostics
+	e.Int32(-1) // StringTable
+	e.ExtensionObject(0, nil)
+}
+
+func (h *ResponseHeader) decode(d *Decoder) {
+	h.Timestamp = d.DateTime()
+	h.Handle = d.UInt32()
+	h.ServiceResult = d.StatusCode()
+	d.DiagnosticInfo()
+	d.StringArray()
+	d.ExtensionObject()
+}
+
+type ServiceFault struct {
+	ResponseHeader
+}
+
+func (m *ServiceFault) TypeID() uint32    { return IDServiceFault }
+func (m *ServiceFault) Encode(e *Encoder) { m.ResponseHeader.encode(e) }
+func (m *ServiceFault) Decode(d *Decoder) { m.ResponseHeader.decode(d) }
+
+type OpenSecureChannelRequest struct {
+	RequestHeader
+	ClientProtocolVersion uint32
+	RequestType           uint32 // 0 新建，1 续期
+	SecurityMode          uint32
+	ClientNonce           []byte
+	RequestedLifetime     uint32
+}
+
+func (m *OpenSecureChannelRequest) TypeID() uint32 { return IDOpenSecureChannelRequest }
+
+func (m *OpenSecureChannelRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.UInt32(m.ClientProtocolVersion)
+	e.UInt32(m.RequestType)
+	e.UInt32(m.SecurityMode)
+	e.ByteString(m.ClientNonce)
+	e.UInt32(m.RequestedLifetime)
+}
+
+func (m *OpenSecureChannelRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.ClientProtocolVersion = d.UInt32()
+	m.RequestType = d.UInt32()
+	m.SecurityMode = d.UInt32()
+	m.ClientNonce = d.ByteString()
+	m.RequestedLifetime = d.UInt32()
+}
+
+type OpenSecureChannelResponse struct {
+	ResponseHeader
+	ServerProtocolVersion uint32
+	ChannelID             uint32
+	TokenID               uint32
+	CreatedAt             time.Time
+	RevisedLifetime       uint32
+	ServerNonce           []byte
+}
+
+func (m *OpenSecureChannelResponse) TypeID() uint32 { return IDOpenSecureChannelResponse }
+
+func (m *OpenSecureChannelResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.UInt32(m.ServerProtocolVersion)
+	e.UInt32(m.ChannelID)
+	e.UInt32(m.TokenID)
+	e.DateTime(m.CreatedAt)
+	e.UInt32(m.RevisedLifetime)
+	e.ByteString(m.ServerNonce)
+}
+
+func (m *OpenSecureChannelResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.ServerProtocolVersion = d.UInt32()
+	m.ChannelID = d.UInt32()
+	m.TokenID = d.UInt32()
+	m.CreatedAt = d.DateTime()
+	m.RevisedLifetime = d.UInt32()
+	m.ServerNonce = d.ByteString()
+}
+
+type CloseSecureChannelRequest struct {
+	RequestHeader
+}
+
+func (m *CloseSecureChannelRequest) TypeID() uint32    { return IDCloseSecureChannelRequest }
+func (m *CloseSecureChannelRequest) Encode(e *Encoder) { m.RequestHeader.encode(e) }
+func (m *CloseSecureChannelRequest) Decode(d *Decoder) { m.RequestHeader.decode(d) }
+
+// encodeApplication 编码 ApplicationDescription
+func encodeApplication(e *Encoder, uri, name string, applicationType uint32) {
+	e.Str(uri)
+	e.Str("") // ProductUri
+	e.LocalizedText(name)
+	e.UInt32(applicationType)
+	e.Str("") // GatewayServerUri
+	e.Str("") // DiscoveryProfileUri
+	e.StringArray(nil)
+}
+
+func decodeApplication(d *Decoder) (uri, name string) {
+	uri = d.Str()
+	d.Str()
+	name = d.LocalizedText()
+	d.UInt32()
+	d.Str()
+	d.Str()
+	d.StringArray()
+	return uri, name
+}
+
+type CreateSessionRequest struct {
+	RequestHeader
+	ApplicationURI          string
+	ApplicationName         string
+	EndpointURL             string
+	SessionName             string
+	ClientNonce             []byte
+	RequestedSessionTimeout float64 // 毫秒
+	MaxResponseMessageSize  uint32
+}
+
+func (m *CreateSessionRequest) TypeID() uint32 { return IDCreateSessionRequest }
+
+func (m *CreateSessionRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	encodeApplication(e, m.ApplicationURI, m.ApplicationName, 1)
+	e.Str("") // ServerUri
+	e.Str(m.EndpointURL)
+	e.Str(m.SessionName)
+	e.ByteString(m.ClientNonce)
+	e.ByteString(nil) // ClientCertificate
+	e.Double(m.RequestedSessionTimeout)
+	e.UInt32(m.MaxResponseMessageSize)
+}
+
+func (m *CreateSessionRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.ApplicationURI, m.ApplicationName = decodeApplication(d)
+	d.Str()
+	m.EndpointURL = d.Str()
+	m.SessionName = d.Str()
+	m.ClientNonce = d.ByteString()
+	d.ByteString()
+	m.RequestedSessionTimeout = d.Double()
+	m.MaxResponseMessageSize = d.UInt32()
+}
+
+// UserTokenPolicy 服务端接受的身份令牌
+type UserTokenPolicy struct {
+	PolicyID          string
+	TokenType         uint32
+	SecurityPolicyURI string
+}
+
+// EndpointDescription 只保留驱动需要的字段
+type EndpointDescription struct {
+	EndpointURL       string
+	SecurityMode      uint32
+	SecurityPolicyURI string
+	UserTokens        []UserTokenPolicy
+}
+
+func (m *EndpointDescription) encode(e *Encoder) {
+	e.Str(m.EndpointURL)
+	encodeApplication(e, "", "", 0)
+	e.ByteString(nil)
+	e.UInt32(m.SecurityMode)
+	e.Str(m.SecurityPolicyURI)
+	e.Int32(int32(len(m.UserTokens)))
+	for _, t := range m.UserTokens {
+		e.Str(t.PolicyID)
+		e.UInt32(t.TokenType)
+		e.Str("")
+		e.Str("")
+		e.Str(t.SecurityPolicyURI)
+	}
+	e.Str("http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary")
+	e.Byte(0)
+}
+
+func (m *EndpointDescription) decode(d *Decoder) {
+	m.EndpointURL = d.Str()
+	decodeApplication(d)
+	d.ByteString()
+	m.SecurityMode = d.UInt32()
+	m.SecurityPolicyURI = d.Str()
+	m.UserTokens = make([]UserTokenPolicy, d.ArrayLength())
+	for i := range m.UserTokens {
+		t := &m.UserTokens[i]
+		t.PolicyID = d.Str()
+		t.TokenType = d.UInt32()
+		d.Str()
+		d.Str()
+		t.SecurityPolicyURI = d.Str()
+	}
+	d.Str()
+	d.Byte()
+}
+
+type CreateSessionResponse struct {
+	ResponseHeader
+	SessionID             NodeID
+	AuthToken             NodeID
+	RevisedSessionTimeout float64
+	ServerNonce           []byte
+	Endpoints             []EndpointDescription
+	MaxRequestMessageSize uint32
+}
+
+func (m *CreateSessionResponse) TypeID() uint32 { return IDCreateSessionResponse }
+
+func (m *CreateSessionResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.NodeID(m.SessionID)
+	e.NodeID(m.AuthToken)
+	e.Double(m.RevisedSessionTimeout)
+	e.ByteString(m.ServerNonce)
+	e.ByteString(nil) // ServerCertificate
+	e.Int32(int32(len(m.Endpoints)))
+	for i := range m.Endpoints {
+		m.Endpoints[i].encode(e)
+	}
+	e.Int32(-1) // ServerSoftwareCertificates
+	e.Str("")   // ServerSignature
+	e.ByteString(nil)
+	e.UInt32(m.MaxRequestMessageSize)
+}
+
+func (m *CreateSessionResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.SessionID = d.NodeID()
+	m.AuthToken = d.NodeID()
+	m.RevisedSessionTimeout = d.Double()
+	m.ServerNonce = d.ByteString()
+	d.ByteString()
+	m.Endpoints = make([]EndpointDescription, d.ArrayLength())
+	for i := range m.Endpoints {
+		m.Endpoints[i].decode(d)
+	}
+	for range d.ArrayLength() {
+		d.ByteString()
+		d.ByteString()
+	}
+	d.Str()
+	d.ByteString()
+	m.MaxRequestMessageSize = d.UInt32()
+}
+
+// Identity 身份令牌，只支持匿名令牌（用户名令牌的密码需要按安全策略加密，没有实现）
+type Identity struct {
+	PolicyID string
+	TokenID  uint32 // 解码时为收到的令牌的编码节点，编码时总是匿名令牌
+}
+
+type ActivateSessionRequest struct {
+	RequestHeader
+	Identity Identity
+}
+
+func (m *ActivateSessionRequest) TypeID() uint32 { return IDActivateSessionRequest }
+
+func (m *ActivateSessionRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Str("") // ClientSignature
+	e.ByteString(nil)
+	e.Int32(-1) // ClientSoftwareCertificates
+	e.StringArray(nil)
+	e.ExtensionObject(IDAnonymousIdentityToken, func(e *Encoder) {
+		e.Str(m.Identity.PolicyID)
+	})
+	e.Str("") // UserTokenSignature
+	e.ByteString(nil)
+}
+
+func (m *ActivateSessionRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	d.Str()
+	d.ByteString()
+	for range d.ArrayLength() {
+		d.ByteString()
+		d.ByteString()
+	}
+	d.StringArray()
+	id, body := d.ExtensionObject()
+	m.Identity.TokenID = id
+	m.Identity.PolicyID = NewDecoder(body).Str()
+	d.Str()
+	d.ByteString()
+}
+
+type ActivateSessionResponse struct {
+	ResponseHeader
+	ServerNonce []byte
+}
+
+func (m *ActivateSessionResponse) TypeID() uint32 { return IDActivateSessionResponse }
+
+func (m *ActivateSessionResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.ByteString(m.ServerNonce)
+	e.Int32(-1)
+	e.Int32(-1)
+}
+
+func (m *ActivateSessionResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.ServerNonce = d.ByteString()
+	for range d.ArrayLength() {
+		d.StatusCode()
+	}
+	d.DiagnosticInfos()
+}
+
+type CloseSessionRequest struct {
+	RequestHeader
+	DeleteSubscriptions bool
+}
+
+func (m *CloseSessionRequest) TypeID() uint32 { return IDCloseSessionRequest }
+
+func (m *CloseSessionRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Bool(m.DeleteSubscriptions)
+}
+
+func (m *CloseSessionRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.DeleteSubscriptions = d.Bool()
+}
+
+type CloseSessionResponse struct {
+	ResponseHeader
+}
+
+func (m *CloseSessionResponse) TypeID() uint32    { return IDCloseSessionResponse }
+func (m *CloseSessionResponse) Encode(e *Encoder) { m.ResponseHeader.encode(e) }
+func (m *CloseSessionResponse) Decode(d *Decoder) { m.ResponseHeader.decode(d) }
+
+// ReadValueID 读取或订阅的节点属性
+type ReadValueID struct {
+	NodeID      NodeID
+	AttributeID uint32
+}
+
+func (v *ReadValueID) encode(e *Encoder) {
+	e.NodeID(v.NodeID)
+	e.UInt32(v.AttributeID)
+	e.Str("") // IndexRange
+	e.QualifiedName(0, "")
+}
+
+func (v *ReadValueID) decode(d *Decoder) {
+	v.NodeID = d.NodeID()
+	v.AttributeID = d.UInt32()
+	d.Str()
+	d.QualifiedName()
+}
+
+type ReadRequest struct {
+	RequestHeader
+	MaxAge     float64
+	Timestamps uint32
+	Nodes      []ReadValueID
+}
+
+func (m *ReadRequest) TypeID() uint32 { return IDReadRequest }
+
+func (m *ReadRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Double(m.MaxAge)
+	e.UInt32(m.Timestamps)
+	e.Int32(int32(len(m.Nodes)))
+	for i := range m.Nodes {
+		m.Nodes[i].encode(e)
+	}
+}
+
+func (m *ReadRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.MaxAge = d.Double()
+	m.Timestamps = d.UInt32()
+	m.Nodes = make([]ReadValueID, d.ArrayLength())
+	for i := range m.Nodes {
+		m.Nodes[i].decode(d)
+	}
+}
+
+type ReadResponse struct {
+	ResponseHeader
+	Results []DataValue
+}
+
+func (m *ReadResponse) TypeID() uint32 { return IDReadResponse }
+
+func (m *ReadResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.Int32(int32(len(m.Results)))
+	for _, v := range m.Results {
+		e.DataValue(v)
+	}
+	e.Int32(-1)
+}
+
+func (m *ReadResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.Results = make([]DataValue, d.ArrayLength())
+	for i := range m.Results {
+		m.Results[i] = d.DataValue()
+	}
+	d.DiagnosticInfos()
+}
+
+type WriteValue struct {
+	NodeID      NodeID
+	AttributeID uint32
+	Value       DataValue
+}
+
+type WriteRequest struct {
+	RequestHeader
+	Nodes []WriteValue
+}
+
+func (m *WriteRequest) TypeID() uint32 { return IDWriteRequest }
+
+func (m *WriteRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Int32(int32(len(m.Nodes)))
+	for _, v := range m.Nodes {
+		e.NodeID(v.NodeID)
+		e.UInt32(v.AttributeID)
+		e.Str("")
+		e.DataValue(v.Value)
+	}
+}
+
+func (m *WriteRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.Nodes = make([]WriteValue, d.ArrayLength())
+	for i := range m.Nodes {
+		v := &m.Nodes[i]
+		v.NodeID = d.NodeID()
+		v.AttributeID = d.UInt32()
+		d.Str()
+		v.Value = d.DataValue()
+	}
+}
+
+type WriteResponse struct {
+	ResponseHeader
+	Results []StatusCode
+}
+
+func (m *WriteResponse) TypeID() uint32 { return IDWriteResponse }
+
+func (m *WriteResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.Int32(int32(len(m.Results)))
+	for _, v := range m.Results {
+		e.UInt32(uint32(v))
+	}
+	e.Int32(-1)
+}
+
+func (m *WriteResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.Results = make([]StatusCode, d.ArrayLength())
+	for i := range m.Results {
+		m.Results[i] = d.StatusCode()
+	}
+	d.DiagnosticInfos()
+}
+
+type CreateSubscriptionRequest struct {
+	RequestHeader
+	PublishingInterval float64 // 毫秒
+	LifetimeCount      uint32
+	MaxKeepAliveCount  uint32
+	MaxNotifications   uint32
+	PublishingEnabled  bool
+	Priority           byte
+}
+
+func (m *CreateSubscriptionRequest) TypeID() uint32 { return IDCreateSubscriptionRequest }
+
+func (m *CreateSubscriptionRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Double(m.PublishingInterval)
+	e.UInt32(m.LifetimeCount)
+	e.UInt32(m.MaxKeepAliveCount)
+	e.UInt32(m.MaxNotifications)
+	e.Bool(m.PublishingEnabled)
+	e.Byte(m.Priority)
+}
+
+func (m *CreateSubscriptionRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.PublishingInterval = d.Double()
+	m.LifetimeCount = d.UInt32()
+	m.MaxKeepAliveCount = d.UInt32()
+	m.MaxNotifications = d.UInt32()
+	m.PublishingEnabled = d.Bool()
+	m.Priority = d.Byte()
+}
+
+type CreateSubscriptionResponse struct {
+	ResponseHeader
+	SubscriptionID            uint32
+	RevisedPublishingInterval float64
+	RevisedLifetimeCount      uint32
+	RevisedMaxKeepAliveCount  uint32
+}
+
+func (m *CreateSubscriptionResponse) TypeID() uint32 { return IDCreateSubscriptionResponse }
+
+func (m *CreateSubscriptionResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.UInt32(m.SubscriptionID)
+	e.Double(m.RevisedPublishingInterval)
+	e.UInt32(m.RevisedLifetimeCount)
+	e.UInt32(m.RevisedMaxKeepAliveCount)
+}
+
+func (m *CreateSubscriptionResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.SubscriptionID = d.UInt32()
+	m.RevisedPublishingInterval = d.Double()
+	m.RevisedLifetimeCount = d.UInt32()
+	m.RevisedMaxKeepAliveCount = d.UInt32()
+}
+
+type MonitoredItemCreateRequest struct {
+	Item             ReadValueID
+	MonitoringMode   uint32
+	ClientHandle     uint32
+	SamplingInterval float64
+	QueueSize        uint32
+	DiscardOldest    bool
+}
+
+type CreateMonitoredItemsRequest struct {
+	RequestHeader
+	SubscriptionID uint32
+	Timestamps     uint32
+	Items          []MonitoredItemCreateRequest
+}
+
+func (m *CreateMonitoredItemsRequest) TypeID() uint32 { return IDCreateMonitoredItemsRequest }
+
+func (m *CreateMonitoredItemsRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.UInt32(m.SubscriptionID)
+	e.UInt32(m.Timestamps)
+	e.Int32(int32(len(m.Items)))
+	for i := range m.Items {
+		v := &m.Items[i]
+		v.Item.encode(e)
+		e.UInt32(v.MonitoringMode)
+		e.UInt32(v.ClientHandle)
+		e.Double(v.SamplingInterval)
+		e.ExtensionObject(0, nil) // Filter
+		e.UInt32(v.QueueSize)
+		e.Bool(v.DiscardOldest)
+	}
+}
+
+func (m *CreateMonitoredItemsRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.SubscriptionID = d.UInt32()
+	m.Timestamps = d.UInt32()
+	m.Items = make([]MonitoredItemCreateRequest, d.ArrayLength())
+	for i := range m.Items {
+		v := &m.Items[i]
+		v.Item.decode(d)
+		v.MonitoringMode = d.UInt32()
+		v.ClientHandle = d.UInt32()
+		v.SamplingInterval = d.Double()
+		d.ExtensionObject()
+		v.QueueSize = d.UInt32()
+		v.DiscardOldest = d.Bool()
+	}
+}
+
+type MonitoredItemCreateResult struct {
+	Status                  StatusCode
+	MonitoredItemID         uint32
+	RevisedSamplingInterval float64
+	RevisedQueueSize        uint32
+}
+
+type CreateMonitoredItemsResponse struct {
+	ResponseHeader
+	Results []MonitoredItemCreateResult
+}
+
+func (m *CreateMonitoredItemsResponse) TypeID() uint32 { return IDCreateMonitoredItemsResponse }
+
+func (m *CreateMonitoredItemsResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.Int32(int32(len(m.Results)))
+	for _, v := range m.Results {
+		e.UInt32(uint32(v.Status))
+		e.UInt32(v.MonitoredItemID)
+		e.Double(v.RevisedSamplingInterval)
+		e.UInt32(v.RevisedQueueSize)
+		e.ExtensionObject(0, nil)
+	}
+	e.Int32(-1)
+}
+
+func (m *CreateMonitoredItemsResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.Results = make([]MonitoredItemCreateResult, d.ArrayLength())
+	for i := range m.Results {
+		v := &m.Results[i]
+		v.Status = d.StatusCode()
+		v.MonitoredItemID = d.UInt32()
+		v.RevisedSamplingInterval = d.Double()
+		v.RevisedQueueSize = d.UInt32()
+		d.ExtensionObject()
+	}
+	d.DiagnosticInfos()
+}
+
+type DeleteSubscriptionsRequest struct {
+	RequestHeader
+	SubscriptionIDs []uint32
+}
+
+func (m *DeleteSubscriptionsRequest) TypeID() uint32 { return IDDeleteSubscriptionsRequest }
+
+func (m *DeleteSubscriptionsRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Int32(int32(len(m.SubscriptionIDs)))
+	for _, v := range m.SubscriptionIDs {
+		e.UInt32(v)
+	}
+}
+
+func (m *DeleteSubscriptionsRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.SubscriptionIDs = make([]uint32, d.ArrayLength())
+	for i := range m.SubscriptionIDs {
+		m.SubscriptionIDs[i] = d.UInt32()
+	}
+}
+
+type DeleteSubscriptionsResponse struct {
+	ResponseHeader
+	Results []StatusCode
+}
+
+func (m *DeleteSubscriptionsResponse) TypeID() uint32 { return IDDeleteSubscriptionsResponse }
+
+func (m *DeleteSubscriptionsResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.Int32(int32(len(m.Results)))
+	for _, v := range m.Results {
+		e.UInt32(uint32(v))
+	}
+	e.Int32(-1)
+}
+
+func (m *DeleteSubscriptionsResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.Results = make([]StatusCode, d.ArrayLength())
+	for i := range m.Results {
+		m.Results[i] = d.StatusCode()
+	}
+	d.DiagnosticInfos()
+}
+
+type SubscriptionAcknowledgement struct {
+	SubscriptionID uint32
+	SequenceNumber uint32
+}
+
+type PublishRequest struct {
+	RequestHeader
+	Acks []SubscriptionAcknowledgement
+}
+
+func (m *PublishRequest) TypeID() uint32 { return IDPublishRequest }
+
+func (m *PublishRequest) Encode(e *Encoder) {
+	m.RequestHeader.encode(e)
+	e.Int32(int32(len(m.Acks)))
+	for _, v := range m.Acks {
+		e.UInt32(v.SubscriptionID)
+		e.UInt32(v.SequenceNumber)
+	}
+}
+
+func (m *PublishRequest) Decode(d *Decoder) {
+	m.RequestHeader.decode(d)
+	m.Acks = make([]SubscriptionAcknowledgement, d.ArrayLength())
+	for i := range m.Acks {
+		m.Acks[i] = SubscriptionAcknowledgement{SubscriptionID: d.UInt32(), SequenceNumber: d.UInt32()}
+	}
+}
+
+// MonitoredItemNotification 数据变化通知中的一项
+type MonitoredItemNotification struct {
+	ClientHandle uint32
+	Value        DataValue
+}
+
+// PublishResponse 只解析数据变化通知，事件等其他通知忽略。Notifications 为空时是保活
+type PublishResponse struct {
+	ResponseHeader
+	SubscriptionID    uint32
+	MoreNotifications bool
+	SequenceNumber    uint32
+	PublishTime       time.Time
+	Notifications     []MonitoredItemNotification
+}
+
+func (m *PublishResponse) TypeID() uint32 { return IDPublishResponse }
+
+func (m *PublishResponse) Encode(e *Encoder) {
+	m.ResponseHeader.encode(e)
+	e.UInt32(m.SubscriptionID)
+	e.Int32(-1) // AvailableSequenceNumbers
+	e.Bool(m.MoreNotifications)
+	e.UInt32(m.SequenceNumber)
+	e.DateTime(m.PublishTime)
+	if len(m.Notifications) == 0 {
+		e.Int32(0)
+	} else {
+		e.Int32(1)
+		e.ExtensionObject(IDDataChangeNotification, func(e *Encoder) {
+			e.Int32(int32(len(m.Notifications)))
+			for _, v := range m.Notifications {
+				e.UInt32(v.ClientHandle)
+				e.DataValue(v.Value)
+			}
+			e.Int32(-1)
+		})
+	}
+	e.Int32(-1) // Results
+	e.Int32(-1)
+}
+
+func (m *PublishResponse) Decode(d *Decoder) {
+	m.ResponseHeader.decode(d)
+	m.SubscriptionID = d.UInt32()
+	for range d.ArrayLength() {
+		d.UInt32()
+	}
+	m.MoreNotifications = d.Bool()
+	m.SequenceNumber = d.UInt32()
+	m.PublishTime = d.DateTime()
+	m.Notifications = nil
+	for range d.ArrayLength() {
+		id, body := d.ExtensionObject()
+		if id != IDDataChangeNotification {
+			continue
+		}
+		n := NewDecoder(body)
+		for range n.ArrayLength() {
+			handle := n.UInt32()
+			m.Notifications = append(m.Notifications, MonitoredItemNotification{ClientHandle: handle, Value: n.DataValue()})
+		}
+		if n.Err() != nil {
+			d.fail("data change notification: %v", n.Err())
+		}
+	}
+	for range d.ArrayLength() {
+		d.StatusCode()
+	}
+	d.DiagnosticInfos()
+}
+
+// UnknownRequest 不支持的服务，只有请求头
+type UnknownRequest struct {
+	RequestHeader
+	ID uint32
+}
+
+func (m *UnknownRequest) TypeID() uint32    { return m.ID }
+func (m *UnknownRequest) Encode(e *Encoder) { m.RequestHeader.encode(e) }
+func (m *UnknownRequest) Decode(d *Decoder) { m.RequestHeader.decode(d) }
+
+// NewRequest 按类型 ID 创建请求，供服务端解码
+func NewRequest(id uint32) (Request, bool) {
+	switch id {
+	case IDOpenSecureChannelRequest:
+		return &OpenSecureChannelRequest{}, true
+	case IDCloseSecureChannelRequest:
+		return &CloseSecureChannelRequest{}, true
+	case IDCreateSessionRequest:
+		return &CreateSessionRequest{}, true
+	case IDActivateSessionRequest:
+		return &ActivateSessionRequest{}, true
+	case IDCloseSessionRequest:
+		return &CloseSessionRequest{}, true
+	case IDReadRequest:
+		return &ReadRequest{}, true
+	case IDWriteRequest:
+		return &WriteRequest{}, true
+	case IDCreateSubscriptionRequest:
+		return &CreateSubscriptionRequest{}, true
+	case IDCreateMonitoredItemsRequest:
+		return &CreateMonitoredItemsRequest{}, true
+	case IDDeleteSubscriptionsRequest:
+		return &DeleteSubscriptionsRequest{}, true
+	case IDPublishRequest:
+		return &PublishRequest{}, true
+	}
+	return nil, false
+}
+
+// DecodeRequest 解码请求，未知类型返回类型 ID 与 StatusBadServiceUnsupported
+func DecodeRequest(body []byte) (Request, uint32, error) {
+	d := NewDecoder(body)
+	id, _ := d.NodeID().Numeric()
+	if d.Err() != nil {
+		return nil, 0, d.Err()
+	}
+	req, ok := NewRequest(id)
+	if !ok {
+		// 只解析请求头，用于返回 ServiceFault
+		req := &UnknownRequest{ID: id}
+		req.Decode(d)
+		return req, id, StatusBadServiceUnsupported
+	}
+	req.Decode(d)
+	if d.Err() != nil {
+		return nil, id, d.Err()
+	}
+	return req, id, nil
+}
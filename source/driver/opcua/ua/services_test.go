@@ -0,0 +1,138 @@
+package ua
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	var buf bytes.Buffer
+	hello := Hello{ReceiveBufferSize: DefaultBufferSize, SendBufferSize: DefaultBufferSize, MaxMessageSize: MaxMessageSize, EndpointURL: "opc.tcp://127.0.0.1:4840"}
+	e := NewEncoder()
+	hello.Encode(e, false)
+	if err := WriteMessage(&buf, MsgHello, ChunkFinal, e.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	msgType, chunk, body, err := ReadMessage(&buf)
+	if err != nil || msgType != MsgHello || chunk != ChunkFinal {
+		t.Fatal(msgType, chunk, err)
+	}
+	var got Hello
+	got.Decode(NewDecoder(body), false)
+	if got != hello {
+		t.Fatalf("got %+v, want %+v", got, hello)
+	}
+
+	// 长度小于报文头
+	buf.Write([]byte{'M', 'S', 'G', 'F', 4, 0, 0, 0})
+	if _, _, _, err := ReadMessage(&buf); err == nil {
+		t.Fatal("expect invalid size error")
+	}
+
+	e = NewEncoder()
+	e.UInt32(uint32(StatusBadSecurityPolicyRejected))
+	e.Str("policy")
+	if err := DecodeError(e.Bytes()); err == nil {
+		t.Fatal("expect server error")
+	}
+}
+
+func TestSecureChannel(t *testing.T) {
+	seq := SequenceHeader{SequenceNumber: 1, RequestID: 2}
+	req := &OpenSecureChannelRequest{RequestedLifetime: 600000}
+	channelID, gotSeq, payload, err := DecodeOpen(EncodeOpen(0, seq, req))
+	if err != nil || channelID != 0 || gotSeq != seq {
+		t.Fatal(channelID, gotSeq, err)
+	}
+	decoded, id, err := DecodeRequest(payload)
+	if err != nil || id != IDOpenSecureChannelRequest {
+		t.Fatal(id, err)
+	}
+	if decoded.(*OpenSecureChannelRequest).RequestedLifetime != 600000 {
+		t.Fatalf("got %+v", decoded)
+	}
+
+	// 只接受安全策略 None
+	e := NewEncoder()
+	e.UInt32(0)
+	e.Str("http://opcfoundation.org/UA/SecurityPolicy#Basic256Sha256")
+	e.ByteString(nil)
+	e.ByteString(nil)
+	e.UInt32(1)
+	e.UInt32(1)
+	if _, _, _, err := DecodeOpen(e.Bytes()); !errors.Is(err, StatusBadSecurityPolicyRejected) {
+		t.Fatalf("err %v", err)
+	}
+
+	read := &ReadRequest{Nodes: []ReadValueID{{NodeID: NewStringNodeID(2, "Line1.Speed"), AttributeID: AttributeValue}}}
+	read.Handle = 9
+	channelID, tokenID, gotSeq, payload, err := DecodeSymmetric(EncodeSymmetric(3, 4, seq, read))
+	if err != nil || channelID != 3 || tokenID != 4 || gotSeq != seq {
+		t.Fatal(channelID, tokenID, gotSeq, err)
+	}
+	decoded, _, err = DecodeRequest(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := decoded.(*ReadRequest)
+	if r.Handle != 9 || len(r.Nodes) != 1 || r.Nodes[0] != read.Nodes[0] {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	e := NewEncoder()
+	EncodeMessage(e, &ReadResponse{
+		ResponseHeader: ResponseHeader{Timestamp: ts, Handle: 1},
+		Results:        []DataValue{{Value: int32(1200), SourceTimestamp: ts}},
+	})
+	var resp ReadResponse
+	if err := DecodeResponse(e.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Handle != 1 || len(resp.Results) != 1 || resp.Results[0].Value != int32(1200) {
+		t.Fatalf("got %+v", resp)
+	}
+
+	e = NewEncoder()
+	EncodeMessage(e, &ServiceFault{ResponseHeader: ResponseHeader{ServiceResult: StatusBadSessionIDInvalid}})
+	if err := DecodeResponse(e.Bytes(), &resp); !errors.Is(err, StatusBadSessionIDInvalid) {
+		t.Fatalf("fault err %v", err)
+	}
+
+	// 应答类型不符
+	e = NewEncoder()
+	EncodeMessage(e, &WriteResponse{})
+	if err := DecodeResponse(e.Bytes(), &resp); err == nil {
+		t.Fatal("expect type mismatch error")
+	}
+}
+
+func TestDecodeUnknownRequest(t *testing.T) {
+	e := NewEncoder()
+	e.NodeID(NewNumericNodeID(0, 9999))
+	(&RequestHeader{Handle: 5}).encode(e)
+	req, id, err := DecodeRequest(e.Bytes())
+	if !errors.Is(err, StatusBadServiceUnsupported) || id != 9999 {
+		t.Fatal(id, err)
+	}
+	if req.Header().Handle != 5 {
+		t.Fatalf("handle %d", req.Header().Handle)
+	}
+}
+
+func TestAnonymousIdentity(t *testing.T) {
+	e := NewEncoder()
+	EncodeMessage(e, &ActivateSessionRequest{Identity: Identity{PolicyID: "anonymous"}})
+	req, _, err := DecodeRequest(e.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := req.(*ActivateSessionRequest).Identity
+	if identity.TokenID != IDAnonymousIdentityToken || identity.PolicyID != "anonymous" {
+		t.Fatalf("got %+v", identity)
+	}
+}
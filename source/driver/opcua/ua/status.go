@@ -0,0 +1,102 @@
+package ua
+
+import "fmt"
+
+// StatusCode 高 2 位为严重程度：00 Good，01 Uncertain，10/11 Bad
+type StatusCode uint32
+
+const (
+	StatusGood                              StatusCode = 0x00000000
+	StatusUncertain                         StatusCode = 0x40000000
+	StatusUncertainLastUsableValue          StatusCode = 0x40900000
+	StatusBad                               StatusCode = 0x80000000
+	StatusBadUnexpectedError                StatusCode = 0x80010000
+	StatusBadCommunicationError             StatusCode = 0x80050000
+	StatusBadDecodingError                  StatusCode = 0x80070000
+	StatusBadTimeout                        StatusCode = 0x800A0000
+	StatusBadServiceUnsupported             StatusCode = 0x800B0000
+	StatusBadNothingToDo                    StatusCode = 0x800F0000
+	StatusBadTooManyOperations              StatusCode = 0x80100000
+	StatusBadUserAccessDenied               StatusCode = 0x801F0000
+	StatusBadIdentityTokenInvalid           StatusCode = 0x80200000
+	StatusBadIdentityTokenRejected          StatusCode = 0x80210000
+	StatusBadSecureChannelIDInvalid         StatusCode = 0x80220000
+	StatusBadSessionIDInvalid               StatusCode = 0x80250000
+	StatusBadSessionNotActivated            StatusCode = 0x80270000
+	StatusBadSubscriptionIDInvalid          StatusCode = 0x80280000
+	StatusBadNodeIDUnknown                  StatusCode = 0x80340000
+	StatusBadAttributeIDInvalid             StatusCode = 0x80350000
+	StatusBadNotWritable                    StatusCode = 0x803B0000
+	StatusBadOutOfService                   StatusCode = 0x808D0000
+	StatusBadTypeMismatch                   StatusCode = 0x80740000
+	StatusBadNoSubscription                 StatusCode = 0x80790000
+	StatusBadSecurityPolicyRejected         StatusCode = 0x80550000
+	StatusBadTCPEndpointURLInvalid          StatusCode = 0x80830000
+	StatusBadMonitoredItemIDInvalid         StatusCode = 0x80420000
+	StatusBadWaitingForInitialData          StatusCode = 0x80320000
+	StatusBadConnectionClosed               StatusCode = 0x80AE0000
+	StatusBadDeviceFailure                  StatusCode = 0x808B0000
+	StatusBadSensorFailure                  StatusCode = 0x808C0000
+	StatusUncertainSensorNotAccurate        StatusCode = 0x40930000
+	StatusUncertainEngineeringUnitsExceeded StatusCode = 0x40940000
+)
+
+var statusNames = map[StatusCode]string{
+	StatusGood:                              "Good",
+	StatusUncertain:                         "Uncertain",
+	StatusUncertainLastUsableValue:          "UncertainLastUsableValue",
+	StatusBad:                               "Bad",
+	StatusBadUnexpectedError:                "BadUnexpectedError",
+	StatusBadCommunicationError:             "BadCommunicationError",
+	StatusBadDecodingError:                  "BadDecodingError",
+	StatusBadTimeout:                        "BadTimeout",
+	StatusBadServiceUnsupported:             "BadServiceUnsupported",
+	StatusBadNothingToDo:                    "BadNothingToDo",
+	StatusBadTooManyOperations:              "BadTooManyOperations",
+	StatusBadUserAccessDenied:               "BadUserAccessDenied",
+	StatusBadIdentityTokenInvalid:           "BadIdentityTokenInvalid",
+	StatusBadIdentityTokenRejected:          "BadIdentityTokenRejected",
+	StatusBadSecureChannelIDInvalid:         "BadSecureChannelIdInvalid",
+	StatusBadSessionIDInvalid:               "BadSessionIdInvalid",
+	StatusBadSessionNotActivated:            "BadSessionNotActivated",
+	StatusBadSubscriptionIDInvalid:          "BadSubscriptionIdInvalid",
+	StatusBadNodeIDUnknown:                  "BadNodeIdUnknown",
+	StatusBadAttributeIDInvalid:             "BadAttributeIdInvalid",
+	StatusBadNotWritable:                    "BadNotWritable",
+	StatusBadOutOfService:                   "BadOutOfService",
+	StatusBadTypeMismatch:                   "BadTypeMismatch",
+	StatusBadNoSubscription:                 "BadNoSubscription",
+	StatusBadSecurityPolicyRejected:         "BadSecurityPolicyRejected",
+	StatusBadTCPEndpointURLInvalid:          "BadTcpEndpointUrlInvalid",
+	StatusBadMonitoredItemIDInvalid:         "BadMonitoredItemIdInvalid",
+	StatusBadWaitingForInitialData:          "BadWaitingForInitialData",
+	StatusBadConnectionClosed:               "BadConnectionClosed",
+	StatusBadDeviceFailure:                  "BadDeviceFailure",
+	StatusBadSensorFailure:                  "BadSensorFailure",
+	StatusUncertainSensorNotAccurate:        "UncertainSensorNotAccurate",
+	StatusUncertainEngineeringUnitsExceeded: "UncertainEngineeringUnitsExceeded",
+}
+
+func (s StatusCode) IsGood() bool      { return s&0xC0000000 == 0 }
+func (s StatusCode) IsUncertain() bool { return s&0xC0000000 == 0x40000000 }
+func (s StatusCode) IsBad() bool       { return s&0x80000000 != 0 }
+
+// Quality 转换为变量质量 Good/Uncertain/Bad
+func (s StatusCode) Quality() string {
+	switch {
+	case s.IsGood():
+		return "Good"
+	case s.IsUncertain():
+		return "Uncertain"
+	default:
+		return "Bad"
+	}
+}
+
+// Error 状态码可直接作为错误返回，忽略低 16 位的附加信息
+func (s StatusCode) Error() string {
+	if name, ok := statusNames[s&0xFFFF0000]; ok {
+		return fmt.Sprintf("%s (0x%08X)", name, uint32(s))
+	}
+	return fmt.Sprintf("status 0x%08X", uint32(s))
+}
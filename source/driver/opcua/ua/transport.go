@@ -0,0 +1,157 @@
+package ua
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// UA TCP 报文类型
+const (
+	MsgHello   = "HEL"
+	MsgAck     = "ACK"
+	MsgError   = "ERR"
+	MsgOpen    = "OPN"
+	MsgClose   = "CLO"
+	MsgMessage = "MSG"
+
+	ChunkFinal        byte = 'F'
+	ChunkIntermediate byte = 'C'
+	ChunkAbort        byte = 'A'
+
+	SecurityPolicyNone = "http://opcfoundation.org/UA/SecurityPolicy#None"
+
+	// DefaultBufferSize 收发缓冲区与单个报文的大小
+	DefaultBufferSize = 65536
+	// MaxMessageSize 多个分块拼接后的最大消息
+	MaxMessageSize = 16 << 20
+)
+
+// WriteMessage 写一个报文块：类型(3) 分块标志(1) 长度(4) 内容
+func WriteMessage(w io.Writer, msgType string, chunk byte, body []byte) error {
+	frame := make([]byte, 8, 8+len(body))
+	copy(frame, msgType)
+	frame[3] = chunk
+	binary.LittleEndian.PutUint32(frame[4:], uint32(8+len(body)))
+	_, err := w.Write(append(frame, body...))
+	return err
+}
+
+// ReadMessage 读一个报文块，返回类型、分块标志与头部之后的内容
+func ReadMessage(r io.Reader) (string, byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size < 8 || size > DefaultBufferSize {
+		return "", 0, nil, fmt.Errorf("ua: invalid message size %d", size)
+	}
+	body := make([]byte, size-8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", 0, nil, err
+	}
+	return string(header[:3]), header[3], body, nil
+}
+
+// Hello 客户端连接后发送的第一个报文，Acknowledge 为服务端的应答（不含 EndpointURL）
+type Hello struct {
+	Version           uint32
+	ReceiveBufferSize uint32
+	SendBufferSize    uint32
+	MaxMessageSize    uint32
+	MaxChunkCount     uint32
+	EndpointURL       string
+}
+
+func (h *Hello) Encode(e *Encoder, ack bool) {
+	e.UInt32(h.Version)
+	e.UInt32(h.ReceiveBufferSize)
+	e.UInt32(h.SendBufferSize)
+	e.UInt32(h.MaxMessageSize)
+	e.UInt32(h.MaxChunkCount)
+	if !ack {
+		e.Str(h.EndpointURL)
+	}
+}
+
+func (h *Hello) Decode(d *Decoder, ack bool) {
+	h.Version = d.UInt32()
+	h.ReceiveBufferSize = d.UInt32()
+	h.SendBufferSize = d.UInt32()
+	h.MaxMessageSize = d.UInt32()
+	h.MaxChunkCount = d.UInt32()
+	if !ack {
+		h.EndpointURL = d.Str()
+	}
+}
+
+// DecodeError 解析 ERR 报文
+func DecodeError(body []byte) error {
+	d := NewDecoder(body)
+	code := d.StatusCode()
+	reason := d.Str()
+	if d.Err() != nil {
+		return d.Err()
+	}
+	return fmt.Errorf("ua: server error %v: %s", code, reason)
+}
+
+// SequenceHeader 安全头之后的序列号与请求 ID
+type SequenceHeader struct {
+	SequenceNumber uint32
+	RequestID      uint32
+}
+
+// EncodeOpen OPN 报文内容：通道 ID、非对称安全头（None）、序列头与消息
+func EncodeOpen(channelID uint32, seq SequenceHeader, msg Message) []byte {
+	e := NewEncoder()
+	e.UInt32(channelID)
+	e.Str(SecurityPolicyNone)
+	e.ByteString(nil)
+	e.ByteString(nil)
+	e.UInt32(seq.SequenceNumber)
+	e.UInt32(seq.RequestID)
+	EncodeMessage(e, msg)
+	return e.Bytes()
+}
+
+// DecodeOpen 解析 OPN 报文，返回通道 ID、序列头与消息内容
+func DecodeOpen(body []byte) (uint32, SequenceHeader, []byte, error) {
+	d := NewDecoder(body)
+	channelID := d.UInt32()
+	policy := d.Str()
+	d.ByteString()
+	d.ByteString()
+	seq := SequenceHeader{SequenceNumber: d.UInt32(), RequestID: d.UInt32()}
+	if d.Err() != nil {
+		return 0, seq, nil, d.Err()
+	}
+	if policy != SecurityPolicyNone {
+		return 0, seq, nil, StatusBadSecurityPolicyRejected
+	}
+	return channelID, seq, d.Remaining(), nil
+}
+
+// EncodeSymmetric MSG/CLO 报文内容：通道 ID、令牌 ID、序列头与消息
+func EncodeSymmetric(channelID, tokenID uint32, seq SequenceHeader, msg Message) []byte {
+	e := NewEncoder()
+	e.UInt32(channelID)
+	e.UInt32(tokenID)
+	e.UInt32(seq.SequenceNumber)
+	e.UInt32(seq.RequestID)
+	EncodeMessage(e, msg)
+	return e.Bytes()
+}
+
+// DecodeSymmetric 解析 MSG/CLO 报文，返回通道 ID、令牌 ID、序列头与消息内容
+func DecodeSymmetric(body []byte) (uint32, uint32, SequenceHeader, []byte, error) {
+	d := NewDecoder(body)
+	channelID := d.UInt32()
+	tokenID := d.UInt32()
+	seq := SequenceHeader{SequenceNumber: d.UInt32(), RequestID: d.UInt32()}
+	if d.Err() != nil {
+		return 0, 0, seq, nil, d.Err()
+	}
+	return channelID, tokenID, seq, d.Remaining(), nil
+}
@@ -0,0 +1,246 @@
+package ua
+
+import (
+	"fmt"
+	"time"
+)
+
+// 内置类型 ID
+const (
+	TypeNull       byte = 0
+	TypeBoolean    byte = 1
+	TypeSByte      byte = 2
+	TypeByte       byte = 3
+	TypeInt16      byte = 4
+	TypeUInt16     byte = 5
+	TypeInt32      byte = 6
+	TypeUInt32     byte = 7
+	TypeInt64      byte = 8
+	TypeUInt64     byte = 9
+	TypeFloat      byte = 10
+	TypeDouble     byte = 11
+	TypeString     byte = 12
+	TypeDateTime   byte = 13
+	TypeByteString byte = 15
+	TypeStatusCode byte = 19
+)
+
+// typeOf Go 类型对应的内置类型，不支持的类型返回 false
+func typeOf(v any) (byte, bool) {
+	switch v.(type) {
+	case nil:
+		return TypeNull, true
+	case bool:
+		return TypeBoolean, true
+	case int8:
+		return TypeSByte, true
+	case uint8:
+		return TypeByte, true
+	case int16:
+		return TypeInt16, true
+	case uint16:
+		return TypeUInt16, true
+	case int32:
+		return TypeInt32, true
+	case uint32:
+		return TypeUInt32, true
+	case int64:
+		return TypeInt64, true
+	case uint64:
+		return TypeUInt64, true
+	case float32:
+		return TypeFloat, true
+	case float64:
+		return TypeDouble, true
+	case string:
+		return TypeString, true
+	case time.Time:
+		return TypeDateTime, true
+	case []byte:
+		return TypeByteString, true
+	case StatusCode:
+		return TypeStatusCode, true
+	}
+	return 0, false
+}
+
+// CheckVariant 检查值能否编码为 Variant
+func CheckVariant(v any) error {
+	if _, ok := typeOf(v); !ok {
+		return fmt.Errorf("unsupported variant type %T", v)
+	}
+	return nil
+}
+
+func (e *Encoder) scalar(v any) {
+	switch x := v.(type) {
+	case bool:
+		e.Bool(x)
+	case int8:
+		e.Byte(byte(x))
+	case uint8:
+		e.Byte(x)
+	case int16:
+		e.UInt16(uint16(x))
+	case uint16:
+		e.UInt16(x)
+	case int32:
+		e.Int32(x)
+	case uint32:
+		e.UInt32(x)
+	case int64:
+		e.Int64(x)
+	case uint64:
+		e.UInt64(x)
+	case float32:
+		e.Float(x)
+	case float64:
+		e.Double(x)
+	case string:
+		e.Str(x)
+	case time.Time:
+		e.DateTime(x)
+	case []byte:
+		e.ByteString(x)
+	case StatusCode:
+		e.UInt32(uint32(x))
+	}
+}
+
+// Variant 编码标量值，不支持的类型编码为 null，调用前应先 CheckVariant
+func (e *Encoder) Variant(v any) {
+	t, ok := typeOf(v)
+	if !ok || t == TypeNull {
+		e.Byte(0)
+		return
+	}
+	e.Byte(t)
+	e.scalar(v)
+}
+
+func (d *Decoder) scalar(t byte) any {
+	switch t {
+	case TypeBoolean:
+		return d.Bool()
+	case TypeSByte:
+		return int8(d.Byte())
+	case TypeByte:
+		return d.Byte()
+	case TypeInt16:
+		return int16(d.UInt16())
+	case TypeUInt16:
+		return d.UInt16()
+	case TypeInt32:
+		return d.Int32()
+	case TypeUInt32:
+		return d.UInt32()
+	case TypeInt64:
+		return d.Int64()
+	case TypeUInt64:
+		return d.UInt64()
+	case TypeFloat:
+		return d.Float()
+	case TypeDouble:
+		return d.Double()
+	case TypeString:
+		return d.Str()
+	case TypeDateTime:
+		return d.DateTime()
+	case TypeByteString:
+		return d.ByteString()
+	case TypeStatusCode:
+		return d.StatusCode()
+	case 14: // Guid
+		return d.next(16)
+	case 17: // NodeId
+		return d.NodeID().String()
+	case 20: // QualifiedName
+		_, name := d.QualifiedName()
+		return name
+	case 21: // LocalizedText
+		return d.LocalizedText()
+	}
+	d.fail("unsupported variant type %d", t)
+	return nil
+}
+
+// Variant 解码值，数组解码为 []any，多维数组忽略维度
+func (d *Decoder) Variant() any {
+	mask := d.Byte()
+	t := mask & 0x3F
+	if t == TypeNull {
+		return nil
+	}
+	var v any
+	if mask&0x80 != 0 {
+		items := make([]any, d.ArrayLength())
+		for i := range items {
+			items[i] = d.scalar(t)
+		}
+		v = items
+	} else {
+		v = d.scalar(t)
+	}
+	if mask&0x40 != 0 {
+		for range d.ArrayLength() {
+			d.Int32()
+		}
+	}
+	return v
+}
+
+// DataValue 节点的值、状态与时间戳
+type DataValue struct {
+	Value           any
+	Status          StatusCode
+	SourceTimestamp time.Time
+	ServerTimestamp time.Time
+}
+
+func (e *Encoder) DataValue(v DataValue) {
+	var mask byte = 0x01
+	if v.Status != StatusGood {
+		mask |= 0x02
+	}
+	if !v.SourceTimestamp.IsZero() {
+		mask |= 0x04
+	}
+	if !v.ServerTimestamp.IsZero() {
+		mask |= 0x08
+	}
+	e.Byte(mask)
+	e.Variant(v.Value)
+	if mask&0x02 != 0 {
+		e.UInt32(uint32(v.Status))
+	}
+	if mask&0x04 != 0 {
+		e.DateTime(v.SourceTimestamp)
+	}
+	if mask&0x08 != 0 {
+		e.DateTime(v.ServerTimestamp)
+	}
+}
+
+func (d *Decoder) DataValue() DataValue {
+	var v DataValue
+	mask := d.Byte()
+	if mask&0x01 != 0 {
+		v.Value = d.Variant()
+	}
+	if mask&0x02 != 0 {
+		v.Status = d.StatusCode()
+	}
+	if mask&0x04 != 0 {
+		v.SourceTimestamp = d.DateTime()
+	}
+	if mask&0x10 != 0 {
+		d.UInt16()
+	}
+	if mask&0x08 != 0 {
+		v.ServerTimestamp = d.DateTime()
+	}
+	if mask&0x20 != 0 {
+		d.UInt16()
+	}
+	return v
+}
@@ -0,0 +1,640 @@
+// Package uaserver 内存中的 OPC UA 服务端，只支持安全策略 None 与 opcua 驱动用到的服务，
+// 用于驱动测试与现场调试
+package uaserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"acetek-mes/driver/opcua/ua"
+)
+
+const minPublishingInterval = 20 * time.Millisecond
+
+// Stats 服务端计数，用于验证驱动的请求方式
+type Stats struct {
+	Connections int
+	Sessions    int
+	Reads       int // Read 请求数
+	ReadNodes   int // Read 请求中的节点数
+	Writes      int
+	Publishes   int // 已应答的 Publish（含保活）
+}
+
+type variable struct {
+	value    any
+	status   ua.StatusCode
+	source   time.Time
+	writable bool
+	version  uint64
+}
+
+// Server OPC UA 服务端
+type Server struct {
+	mu       sync.Mutex
+	nodes    map[string]*variable
+	listener net.Listener
+	conns    map[*conn]bool
+	stats    Stats
+	nextID   uint32
+	wg       sync.WaitGroup
+}
+
+func NewServer() *Server {
+	return &Server{
+		nodes: make(map[string]*variable),
+		conns: make(map[*conn]bool),
+	}
+}
+
+func nodeKey(id string) (string, error) {
+	n, err := ua.ParseNodeID(id)
+	if err != nil {
+		return "", err
+	}
+	return n.String(), nil
+}
+
+// AddVariable 添加变量节点，id 为 NodeId 文本，如 ns=2;s=Line1.Speed
+func (s *Server) AddVariable(id string, value any, writable bool) error {
+	key, err := nodeKey(id)
+	if err != nil {
+		return err
+	}
+	if err := ua.CheckVariant(value); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[key] = &variable{value: value, source: time.Now(), writable: writable, version: 1}
+	return nil
+}
+
+// SetValue 修改变量的值，状态恢复为 Good
+func (s *Server) SetValue(id string, value any) error {
+	return s.update(id, func(v *variable) {
+		v.value = value
+		v.status = ua.StatusGood
+	})
+}
+
+// SetStatus 修改变量的状态码，模拟设备或传感器故障
+func (s *Server) SetStatus(id string, status ua.StatusCode) error {
+	return s.update(id, func(v *variable) { v.status = status })
+}
+
+func (s *Server) update(id string, f func(*variable)) error {
+	key, err := nodeKey(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.nodes[key]
+	if !ok {
+		return fmt.Errorf("node %s not found", id)
+	}
+	f(v)
+	v.source = time.Now()
+	v.version++
+	return nil
+}
+
+// Value 变量当前的值与状态
+func (s *Server) Value(id string) (any, ua.StatusCode) {
+	key, err := nodeKey(id)
+	if err != nil {
+		return nil, ua.StatusBadNodeIDUnknown
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.nodes[key]
+	if !ok {
+		return nil, ua.StatusBadNodeIDUnknown
+	}
+	return v.value, v.status
+}
+
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *Server) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c := &conn{server: s, conn: nc, publishes: make(chan uint32, 16), subs: make(map[uint32]*subscription)}
+			s.mu.Lock()
+			s.conns[c] = true
+			s.stats.Connections++
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				c.serve()
+			}()
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Endpoint 客户端连接的端点 URL
+func (s *Server) Endpoint() string {
+	return "opc.tcp://" + s.Addr()
+}
+
+// Disconnect 断开所有客户端连接，模拟网络中断
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.conn.Close()
+	}
+}
+
+func (s *Server) Close() error {
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.Disconnect()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) id() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return s.nextID
+}
+
+// conn 一个客户端连接，同一时间只有一个会话
+type conn struct {
+	server    *Server
+	conn      net.Conn
+	wmu       sync.Mutex
+	channelID uint32
+	tokenID   uint32
+	seq       uint32
+
+	token     ua.NodeID
+	activated bool
+	subs      map[uint32]*subscription // 只在 serve 协程中修改，subMu 保护
+	subMu     sync.Mutex
+	publishes chan uint32 // 等待应答的 Publish 请求 ID
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.conn.Close()
+		c.subMu.Lock()
+		for id, sub := range c.subs {
+			close(sub.stop)
+			delete(c.subs, id)
+		}
+		c.subMu.Unlock()
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		c.server.mu.Unlock()
+	}()
+	if err := c.hello(); err != nil {
+		log.Println("uaserver hello:", err)
+		return
+	}
+	chunks := make(map[uint32][]byte)
+	for {
+		msgType, chunk, body, err := ua.ReadMessage(c.conn)
+		if err != nil {
+			return
+		}
+		switch msgType {
+		case ua.MsgOpen:
+			_, seq, payload, err := ua.DecodeOpen(body)
+			if err != nil {
+				return
+			}
+			if err := c.open(seq.RequestID, payload); err != nil {
+				return
+			}
+		case ua.MsgClose:
+			return
+		case ua.MsgMessage:
+			_, _, seq, payload, err := ua.DecodeSymmetric(body)
+			if err != nil {
+				return
+			}
+			if chunk != ua.ChunkFinal {
+				chunks[seq.RequestID] = append(chunks[seq.RequestID], payload...)
+				continue
+			}
+			if prefix, ok := chunks[seq.RequestID]; ok {
+				payload = append(prefix, payload...)
+				delete(chunks, seq.RequestID)
+			}
+			c.handle(seq.RequestID, payload)
+		default:
+			return
+		}
+	}
+}
+
+// hello HEL/ACK
+func (c *conn) hello() error {
+	msgType, _, body, err := ua.ReadMessage(c.conn)
+	if err != nil {
+		return err
+	}
+	if msgType != ua.MsgHello {
+		return fmt.Errorf("expect HEL, got %s", msgType)
+	}
+	var hello ua.Hello
+	d := ua.NewDecoder(body)
+	hello.Decode(d, false)
+	if d.Err() != nil {
+		return d.Err()
+	}
+	ack := ua.Hello{
+		ReceiveBufferSize: ua.DefaultBufferSize,
+		SendBufferSize:    ua.DefaultBufferSize,
+		MaxMessageSize:    ua.MaxMessageSize,
+	}
+	e := ua.NewEncoder()
+	ack.Encode(e, true)
+	return ua.WriteMessage(c.conn, ua.MsgAck, ua.ChunkFinal, e.Bytes())
+}
+
+// open 新建或续期安全通道
+func (c *conn) open(requestID uint32, payload []byte) error {
+	req, _, err := ua.DecodeRequest(payload)
+	if err != nil {
+		return err
+	}
+	open, ok := req.(*ua.OpenSecureChannelRequest)
+	if !ok {
+		return errors.New("expect OpenSecureChannelRequest")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.channelID == 0 {
+		c.channelID = c.server.id()
+	}
+	c.tokenID++
+	c.seq++
+	resp := &ua.OpenSecureChannelResponse{
+		ResponseHeader:  ua.ResponseHeader{Timestamp: time.Now(), Handle: open.Handle},
+		ChannelID:       c.channelID,
+		TokenID:         c.tokenID,
+		CreatedAt:       time.Now(),
+		RevisedLifetime: open.RequestedLifetime,
+	}
+	return ua.WriteMessage(c.conn, ua.MsgOpen, ua.ChunkFinal, ua.EncodeOpen(c.channelID, ua.SequenceHeader{SequenceNumber: c.seq, RequestID: requestID}, resp))
+}
+
+// send 发送应答，单个分块
+func (c *conn) send(requestID uint32, msg ua.Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.seq++
+	body := ua.EncodeSymmetric(c.channelID, c.tokenID, ua.SequenceHeader{SequenceNumber: c.seq, RequestID: requestID}, msg)
+	return ua.WriteMessage(c.conn, ua.MsgMessage, ua.ChunkFinal, body)
+}
+
+func (c *conn) fault(requestID uint32, handle uint32, status ua.StatusCode) {
+	c.send(requestID, &ua.ServiceFault{ResponseHeader: ua.ResponseHeader{Timestamp: time.Now(), Handle: handle, ServiceResult: status}})
+}
+
+func (c *conn) handle(requestID uint32, payload []byte) {
+	req, _, err := ua.DecodeRequest(payload)
+	if err != nil {
+		status := ua.StatusBadDecodingError
+		if errors.As(err, &status) && req != nil {
+			c.fault(requestID, req.Header().Handle, status)
+		} else {
+			c.fault(requestID, 0, ua.StatusBadDecodingError)
+		}
+		return
+	}
+	h := req.Header()
+	header := ua.ResponseHeader{Timestamp: time.Now(), Handle: h.Handle}
+
+	switch r := req.(type) {
+	case *ua.CreateSessionRequest:
+		c.token = ua.NewNumericNodeID(1, c.server.id())
+		c.activated = false
+		tokens := []ua.UserTokenPolicy{{PolicyID: "anonymous", TokenType: ua.TokenAnonymous}}
+		c.send(requestID, &ua.CreateSessionResponse{
+			ResponseHeader:        header,
+			SessionID:             ua.NewNumericNodeID(1, c.server.id()),
+			AuthToken:             c.token,
+			RevisedSessionTimeout: r.RequestedSessionTimeout,
+			Endpoints: []ua.EndpointDescription{{
+				EndpointURL:       r.EndpointURL,
+				SecurityMode:      ua.SecurityModeNone,
+				SecurityPolicyURI: ua.SecurityPolicyNone,
+				UserTokens:        tokens,
+			}},
+		})
+		return
+	case *ua.ActivateSessionRequest:
+		if h.AuthToken != c.token || c.token.IsNull() {
+			c.fault(requestID, h.Handle, ua.StatusBadSessionIDInvalid)
+			return
+		}
+		if status := c.server.authenticate(r.Identity); status != ua.StatusGood {
+			c.fault(requestID, h.Handle, status)
+			return
+		}
+		c.activated = true
+		c.server.mu.Lock()
+		c.server.stats.Sessions++
+		c.server.mu.Unlock()
+		c.send(requestID, &ua.ActivateSessionResponse{ResponseHeader: header})
+		return
+	}
+
+	if h.AuthToken != c.token || c.token.IsNull() {
+		c.fault(requestID, h.Handle, ua.StatusBadSessionIDInvalid)
+		return
+	}
+	if !c.activated {
+		c.fault(requestID, h.Handle, ua.StatusBadSessionNotActivated)
+		return
+	}
+
+	switch r := req.(type) {
+	case *ua.CloseSessionRequest:
+		c.activated = false
+		c.token = ua.NodeID{}
+		if r.DeleteSubscriptions {
+			c.deleteSubscriptions(nil)
+		}
+		c.send(requestID, &ua.CloseSessionResponse{ResponseHeader: header})
+	case *ua.ReadRequest:
+		c.send(requestID, &ua.ReadResponse{ResponseHeader: header, Results: c.server.read(r.Nodes)})
+	case *ua.WriteRequest:
+		c.send(requestID, &ua.WriteResponse{ResponseHeader: header, Results: c.server.write(r.Nodes)})
+	case *ua.CreateSubscriptionRequest:
+		sub := c.createSubscription(r)
+		c.send(requestID, &ua.CreateSubscriptionResponse{
+			ResponseHeader:            header,
+			SubscriptionID:            sub.id,
+			RevisedPublishingInterval: float64(sub.interval.Milliseconds()),
+			RevisedLifetimeCount:      sub.keepAlive * 3,
+			RevisedMaxKeepAliveCount:  sub.keepAlive,
+		})
+	case *ua.CreateMonitoredItemsRequest:
+		results, status := c.createMonitoredItems(r)
+		if status != ua.StatusGood {
+			c.fault(requestID, h.Handle, status)
+			return
+		}
+		c.send(requestID, &ua.CreateMonitoredItemsResponse{ResponseHeader: header, Results: results})
+	case *ua.DeleteSubscriptionsRequest:
+		c.send(requestID, &ua.DeleteSubscriptionsResponse{ResponseHeader: header, Results: c.deleteSubscriptions(r.SubscriptionIDs)})
+	case *ua.PublishRequest:
+		c.subMu.Lock()
+		n := len(c.subs)
+		c.subMu.Unlock()
+		if n == 0 {
+			c.fault(requestID, h.Handle, ua.StatusBadNoSubscription)
+			return
+		}
+		select {
+		case c.publishes <- requestID:
+		default:
+			c.fault(requestID, h.Handle, ua.StatusBadTooManyOperations)
+		}
+	default:
+		c.fault(requestID, h.Handle, ua.StatusBadServiceUnsupported)
+	}
+}
+
+// authenticate 只接受匿名令牌
+func (s *Server) authenticate(identity ua.Identity) ua.StatusCode {
+	if identity.TokenID != ua.IDAnonymousIdentityToken {
+		return ua.StatusBadIdentityTokenInvalid
+	}
+	return ua.StatusGood
+}
+
+func (s *Server) read(nodes []ua.ReadValueID) []ua.DataValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Reads++
+	s.stats.ReadNodes += len(nodes)
+	now := time.Now()
+	results := make([]ua.DataValue, len(nodes))
+	for i, n := range nodes {
+		v, ok := s.nodes[n.NodeID.String()]
+		switch {
+		case !ok:
+			results[i] = ua.DataValue{Status: ua.StatusBadNodeIDUnknown, ServerTimestamp: now}
+		case n.AttributeID != ua.AttributeValue:
+			results[i] = ua.DataValue{Status: ua.StatusBadAttributeIDInvalid, ServerTimestamp: now}
+		default:
+			results[i] = ua.DataValue{Value: v.value, Status: v.status, SourceTimestamp: v.source, ServerTimestamp: now}
+		}
+	}
+	return results
+}
+
+func (s *Server) write(nodes []ua.WriteValue) []ua.StatusCode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Writes++
+	results := make([]ua.StatusCode, len(nodes))
+	for i, n := range nodes {
+		v, ok := s.nodes[n.NodeID.String()]
+		switch {
+		case !ok:
+			results[i] = ua.StatusBadNodeIDUnknown
+		case n.AttributeID != ua.AttributeValue:
+			results[i] = ua.StatusBadAttributeIDInvalid
+		case !v.writable:
+			results[i] = ua.StatusBadNotWritable
+		case reflect.TypeOf(n.Value.Value) != reflect.TypeOf(v.value):
+			results[i] = ua.StatusBadTypeMismatch
+		default:
+			v.value = n.Value.Value
+			v.status = ua.StatusGood
+			v.source = time.Now()
+			v.version++
+		}
+	}
+	return results
+}
+
+// snapshot 监视项对应变量的当前值与版本
+func (s *Server) snapshot(key string) (ua.DataValue, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.nodes[key]
+	if !ok {
+		return ua.DataValue{}, 0, false
+	}
+	return ua.DataValue{Value: v.value, Status: v.status, SourceTimestamp: v.source, ServerTimestamp: time.Now()}, v.version, true
+}
+
+type monitoredItem struct {
+	handle  uint32
+	key     string
+	version uint64 // 已发送的版本，0 表示尚未发送初始值
+}
+
+type subscription struct {
+	id        uint32
+	interval  time.Duration
+	keepAlive uint32
+	mu        sync.Mutex
+	items     []*monitoredItem
+	stop      chan struct{}
+}
+
+func (c *conn) createSubscription(r *ua.CreateSubscriptionRequest) *subscription {
+	interval := max(time.Duration(r.PublishingInterval*float64(time.Millisecond)), minPublishingInterval)
+	sub := &subscription{id: c.server.id(), interval: interval, keepAlive: max(r.MaxKeepAliveCount, 1), stop: make(chan struct{})}
+	c.subMu.Lock()
+	c.subs[sub.id] = sub
+	c.subMu.Unlock()
+	go c.publish(sub)
+	return sub
+}
+
+func (c *conn) createMonitoredItems(r *ua.CreateMonitoredItemsRequest) ([]ua.MonitoredItemCreateResult, ua.StatusCode) {
+	c.subMu.Lock()
+	sub, ok := c.subs[r.SubscriptionID]
+	c.subMu.Unlock()
+	if !ok {
+		return nil, ua.StatusBadSubscriptionIDInvalid
+	}
+	results := make([]ua.MonitoredItemCreateResult, len(r.Items))
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	for i, item := range r.Items {
+		key := item.Item.NodeID.String()
+		if _, _, ok := c.server.snapshot(key); !ok {
+			results[i].Status = ua.StatusBadNodeIDUnknown
+			continue
+		}
+		sub.items = append(sub.items, &monitoredItem{handle: item.ClientHandle, key: key})
+		results[i] = ua.MonitoredItemCreateResult{
+			MonitoredItemID:         c.server.id(),
+			RevisedSamplingInterval: float64(sub.interval.Milliseconds()),
+			RevisedQueueSize:        1,
+		}
+	}
+	return results, ua.StatusGood
+}
+
+// deleteSubscriptions ids 为 nil 时删除全部
+func (c *conn) deleteSubscriptions(ids []uint32) []ua.StatusCode {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if ids == nil {
+		for id := range c.subs {
+			ids = append(ids, id)
+		}
+	}
+	results := make([]ua.StatusCode, len(ids))
+	for i, id := range ids {
+		sub, ok := c.subs[id]
+		if !ok {
+			results[i] = ua.StatusBadSubscriptionIDInvalid
+			continue
+		}
+		close(sub.stop)
+		delete(c.subs, id)
+	}
+	return results
+}
+
+// publish 每个发布周期检查监视项的变化，有变化或保活到期时用一个排队的 Publish 应答
+func (c *conn) publish(sub *subscription) {
+	ticker := time.NewTicker(sub.interval)
+	defer ticker.Stop()
+	var idle, seq uint32
+	for {
+		select {
+		case <-sub.stop:
+			return
+		case <-ticker.C:
+		}
+		idle++
+		var notifications []ua.MonitoredItemNotification
+		var versions []uint64
+		sub.mu.Lock()
+		for _, item := range sub.items {
+			value, version, ok := c.server.snapshot(item.key)
+			if !ok {
+				value, version = ua.DataValue{Status: ua.StatusBadNodeIDUnknown}, item.version+1
+			}
+			if version != item.version {
+				notifications = append(notifications, ua.MonitoredItemNotification{ClientHandle: item.handle, Value: value})
+				versions = append(versions, version)
+			}
+		}
+		sub.mu.Unlock()
+		if len(notifications) == 0 && idle < sub.keepAlive {
+			continue
+		}
+		var requestID uint32
+		select {
+		case requestID = <-c.publishes:
+		default:
+			// 没有排队的 Publish，变化留到下一个周期
+			continue
+		}
+		resp := &ua.PublishResponse{
+			ResponseHeader: ua.ResponseHeader{Timestamp: time.Now()},
+			SubscriptionID: sub.id,
+			SequenceNumber: seq + 1,
+			PublishTime:    time.Now(),
+			Notifications:  notifications,
+		}
+		if err := c.send(requestID, resp); err != nil {
+			return
+		}
+		if len(notifications) > 0 {
+			seq++
+			sub.mu.Lock()
+			i := 0
+			for _, item := range sub.items {
+				if i < len(notifications) && notifications[i].ClientHandle == item.handle {
+					item.version = versions[i]
+					i++
+				}
+			}
+			sub.mu.Unlock()
+		}
+		idle = 0
+		c.server.mu.Lock()
+		c.server.stats.Publishes++
+		c.server.mu.Unlock()
+	}
+}
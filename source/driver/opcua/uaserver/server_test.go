@@ -0,0 +1,175 @@
+package uaserver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"acetek-mes/driver/opcua/ua"
+)
+
+func TestVariables(t *testing.T) {
+	s := NewServer()
+	if err := s.AddVariable("ns=2;s=Line1.Speed", float32(36.5), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddVariable("Line1.Speed", 1, true); err == nil {
+		t.Fatal("expect invalid node id error")
+	}
+	if err := s.AddVariable("ns=2;s=Line1.Bad", struct{}{}, true); err == nil {
+		t.Fatal("expect unsupported type error")
+	}
+	if v, status := s.Value("ns=2;s=Line1.Speed"); v != float32(36.5) || status != ua.StatusGood {
+		t.Fatal(v, status)
+	}
+	if err := s.SetStatus("ns=2;s=Line1.Speed", ua.StatusBadNodeIDUnknown); err != nil {
+		t.Fatal(err)
+	}
+	if _, status := s.Value("ns=2;s=Line1.Speed"); status != ua.StatusBadNodeIDUnknown {
+		t.Fatal(status)
+	}
+	if err := s.SetValue("ns=2;s=Line1.Speed", float32(40)); err != nil {
+		t.Fatal(err)
+	}
+	if v, status := s.Value("ns=2;s=Line1.Speed"); v != float32(40) || status != ua.StatusGood {
+		t.Fatal(v, status)
+	}
+	if err := s.SetValue("ns=2;s=Missing", 1); err == nil {
+		t.Fatal("expect not found error")
+	}
+	if _, status := s.Value("ns=2;s=Missing"); status != ua.StatusBadNodeIDUnknown {
+		t.Fatal(status)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := NewServer()
+	if status := s.authenticate(ua.Identity{PolicyID: "anonymous", TokenID: ua.IDAnonymousIdentityToken}); status != ua.StatusGood {
+		t.Fatal(status)
+	}
+	// 用户名等其它令牌一律拒绝
+	if status := s.authenticate(ua.Identity{PolicyID: "username", TokenID: 324}); status != ua.StatusBadIdentityTokenInvalid {
+		t.Fatal(status)
+	}
+}
+
+// testConn 直接按 UA TCP 协议收发报文
+type testConn struct {
+	t       *testing.T
+	conn    net.Conn
+	channel uint32
+	token   uint32
+	seq     uint32
+}
+
+func dial(t *testing.T, s *Server) *testConn {
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &testConn{t: t, conn: conn}
+
+	hello := ua.Hello{ReceiveBufferSize: ua.DefaultBufferSize, SendBufferSize: ua.DefaultBufferSize, EndpointURL: s.Endpoint()}
+	e := ua.NewEncoder()
+	hello.Encode(e, false)
+	if err := ua.WriteMessage(conn, ua.MsgHello, ua.ChunkFinal, e.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if msgType, _, _, err := ua.ReadMessage(conn); err != nil || msgType != ua.MsgAck {
+		t.Fatal(msgType, err)
+	}
+
+	c.seq++
+	if err := ua.WriteMessage(conn, ua.MsgOpen, ua.ChunkFinal, ua.EncodeOpen(0, ua.SequenceHeader{SequenceNumber: c.seq, RequestID: c.seq}, &ua.OpenSecureChannelRequest{RequestedLifetime: 60000})); err != nil {
+		t.Fatal(err)
+	}
+	_, _, body, err := ua.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, payload, err := ua.DecodeOpen(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var open ua.OpenSecureChannelResponse
+	if err := ua.DecodeResponse(payload, &open); err != nil {
+		t.Fatal(err)
+	}
+	c.channel, c.token = open.ChannelID, open.TokenID
+	return c
+}
+
+func (c *testConn) call(req ua.Request, resp ua.Response) error {
+	c.seq++
+	body := ua.EncodeSymmetric(c.channel, c.token, ua.SequenceHeader{SequenceNumber: c.seq, RequestID: c.seq}, req)
+	if err := ua.WriteMessage(c.conn, ua.MsgMessage, ua.ChunkFinal, body); err != nil {
+		c.t.Fatal(err)
+	}
+	_, _, body, err := ua.ReadMessage(c.conn)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_, _, _, payload, err := ua.DecodeSymmetric(body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return ua.DecodeResponse(payload, resp)
+}
+
+func TestSession(t *testing.T) {
+	s := NewServer()
+	if err := s.AddVariable("ns=2;i=1001", uint16(7), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := dial(t, s)
+
+	var session ua.CreateSessionResponse
+	if err := c.call(&ua.CreateSessionRequest{EndpointURL: s.Endpoint(), RequestedSessionTimeout: 60000}, &session); err != nil {
+		t.Fatal(err)
+	}
+	// 只公布匿名令牌
+	if len(session.Endpoints) != 1 || len(session.Endpoints[0].UserTokens) != 1 ||
+		session.Endpoints[0].UserTokens[0].TokenType != ua.TokenAnonymous {
+		t.Fatalf("endpoints %+v", session.Endpoints)
+	}
+
+	node := []ua.ReadValueID{{NodeID: ua.NewNumericNodeID(2, 1001), AttributeID: ua.AttributeValue}}
+	read := &ua.ReadRequest{Nodes: node}
+	read.AuthToken = session.AuthToken
+	var resp ua.ReadResponse
+	if err := c.call(read, &resp); !errors.Is(err, ua.StatusBadSessionNotActivated) {
+		t.Fatalf("read before activate: %v", err)
+	}
+
+	activate := &ua.ActivateSessionRequest{Identity: ua.Identity{PolicyID: "anonymous"}}
+	activate.AuthToken = session.AuthToken
+	if err := c.call(activate, &ua.ActivateSessionResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.call(read, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Value != uint16(7) {
+		t.Fatalf("results %+v", resp.Results)
+	}
+
+	write := &ua.WriteRequest{Nodes: []ua.WriteValue{{NodeID: node[0].NodeID, AttributeID: ua.AttributeValue, Value: ua.DataValue{Value: uint16(8)}}}}
+	write.AuthToken = session.AuthToken
+	var written ua.WriteResponse
+	if err := c.call(write, &written); err != nil {
+		t.Fatal(err)
+	}
+	if len(written.Results) != 1 || written.Results[0] != ua.StatusBadNotWritable {
+		t.Fatalf("write results %v", written.Results)
+	}
+	if stats := s.Stats(); stats.Sessions != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
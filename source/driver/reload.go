@@ -0,0 +1,85 @@
+package driver
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// Config 运行中的驱动可以热更新的配置
+type Config struct {
+	Tags     []*Tag
+	Triggers []*Trigger
+}
+
+// Reconfig 把新的变量与触发器配置交给扫描协程，在两次扫描之间生效，不重连 PLC。
+// 驱动需要先解析新变量的地址（设置 Parsed/Mate），解析失败的变量不应放入 cfg
+func (d *Driver) Reconfig(cfg *Config) error {
+	if d.ChConfig == nil {
+		return errors.New("driver does not support reconfig")
+	}
+	done := d.running()
+	if done == nil {
+		return ErrStopped
+	}
+	select {
+	case d.ChConfig <- cfg:
+		return nil
+	case <-done:
+		return ErrStopped
+	}
+}
+
+// config 变量的配置部分，不含运行时的值与质量
+func (t *Tag) config() Tag {
+	c := *t
+	c.Value, c.Timestamp, c.Quality = nil, time.Time{}, ""
+	c.Parsed, c.Mate, c.report = false, nil, reportState{}
+	return c
+}
+
+// sameConfig 配置未变化的变量沿用原对象，保留当前值与上报状态
+func (t *Tag) sameConfig(o *Tag) bool {
+	return t.config() == o.config()
+}
+
+// applyConfig 在扫描协程中替换变量与触发器，重建扫描组
+func (d *Driver) applyConfig(cfg *Config) {
+	tags := make(map[string]*Tag, len(cfg.Tags))
+	var added, changed int
+	for _, t := range cfg.Tags {
+		old, ok := d.Tags[t.Name]
+		switch {
+		case !ok:
+			added++
+		case old.sameConfig(t):
+			t = old
+		default:
+			changed++
+		}
+		tags[t.Name] = t
+	}
+	var removed []*Tag
+	for name, t := range d.Tags {
+		if _, ok := tags[name]; !ok {
+			t.Quality = "Bad"
+			t.Timestamp = time.Now()
+			removed = append(removed, t)
+		}
+	}
+	// 已删除的变量最后上报一次 Bad，避免 Redis 中一直保留旧的 Good 值
+	d.UpdateTags(removed)
+	d.tagsMu.Lock()
+	d.Tags = tags
+	d.tagsMu.Unlock()
+	d.InitScanGroups()
+	now := time.Now()
+	for _, g := range d.Groups {
+		g.next = now
+	}
+
+	d.flushEvents()
+	d.SetTriggers(cfg.Triggers)
+	log.Printf("driver %s reconfigured: %d tags, %d added, %d changed, %d removed, %d triggers",
+		d.ID, len(tags), added, changed, len(removed), len(d.Triggers))
+}
@@ -0,0 +1,41 @@
+package driver
+
+import "testing"
+
+func TestApplyConfig(t *testing.T) {
+	d := &Driver{ID: "plc1", Interval: 1000, Tags: map[string]*Tag{
+		"丝包高度": {Name: "丝包高度", Address: "DB1.DBW0", Datatype: TypeInt16, Value: int16(100), Quality: "Good"},
+		"开摆时间": {Name: "开摆时间", Address: "DB1.DBD2", Datatype: TypeUInt32, Value: uint32(5), Quality: "Good"},
+		"旧变量":  {Name: "旧变量", Address: "DB1.DBW6", Datatype: TypeInt16, Value: int16(1), Quality: "Good"},
+	}}
+	d.InitScanGroups()
+	height, removed := d.Tags["丝包高度"], d.Tags["旧变量"]
+
+	tags := []*Tag{
+		{Name: "丝包高度", Address: "DB1.DBW0", Datatype: TypeInt16, Value: int16(0)},
+		{Name: "开摆时间", Address: "DB1.DBD2", Datatype: TypeUInt32, ScanClass: ScanSlow},
+		{Name: "落丝信号", Address: "DB1.DBX8.0", Datatype: TypeBool, ScanClass: ScanFast},
+	}
+	before := fingerprint(tags, nil)
+	d.applyConfig(&Config{Tags: tags})
+
+	if d.Tags["丝包高度"] != height || height.Value != int16(100) {
+		t.Fatal("unchanged tag should keep its value")
+	}
+	if d.Tags["开摆时间"].ScanClass != ScanSlow || d.Tags["落丝信号"] == nil || d.Tags["旧变量"] != nil {
+		t.Fatalf("tags: %v", d.Tags)
+	}
+	if removed.Quality != "Bad" || len(d.Groups) != 3 {
+		t.Fatalf("removed %s, groups %d", removed.Quality, len(d.Groups))
+	}
+
+	// 变量的值变化不影响配置摘要
+	tags[0].Value, tags[0].Quality = int16(7), "Good"
+	if fingerprint(tags, nil) != before {
+		t.Fatal("fingerprint should ignore values")
+	}
+	tags[0].Deadband = 0.5
+	if fingerprint(tags, nil) == before {
+		t.Fatal("fingerprint should include config")
+	}
+}
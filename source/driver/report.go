@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultHeartbeat 值未变化时最长多久向 stream 补发一次
+const DefaultHeartbeat = 60 * time.Second
+
+// reportState 最近一次上报到 Redis 的值，用于变化检测
+type reportState struct {
+	streamValue   interface{}
+	streamQuality string
+	streamTime    time.Time
+	hashValue     string
+	hashQuality   string
+	reported      bool
+}
+
+// heartbeat 变量的最长静默时间
+func (t *Tag) heartbeat() time.Duration {
+	if t.Heartbeat > 0 {
+		return time.Duration(t.Heartbeat) * time.Second
+	}
+	return DefaultHeartbeat
+}
+
+// exceedsDeadband 判断数值变化是否超过死区，非数值类型只要不同即视为变化
+func (t *Tag) exceedsDeadband(last, current interface{}) bool {
+	lv, lok := ToFloat64(last)
+	cv, cok := ToFloat64(current)
+	if !lok || !cok {
+		return fmt.Sprintf("%v", last) != fmt.Sprintf("%v", current)
+	}
+	diff := math.Abs(cv - lv)
+	if diff == 0 {
+		return false
+	}
+	if t.Deadband > 0 && diff < t.Deadband {
+		return false
+	}
+	if t.DeadbandPercent > 0 {
+		// 配置了量程时按工程量程的百分比，否则按上次上报值的百分比
+		span := math.Abs(lv)
+		if t.hasRange() {
+			span = math.Abs(t.EngMax - t.EngMin)
+		}
+		if diff < span*t.DeadbandPercent/100 {
+			return false
+		}
+	}
+	return true
+}
+
+// reportDecision 返回是否需要写入 stream（变化超过死区或心跳到期）以及是否需要刷新 real: 哈希
+func (t *Tag) reportDecision(now time.Time) (stream bool, hash bool) {
+	r := &t.report
+	if !r.reported || r.streamQuality != t.Quality || now.Sub(r.streamTime) >= t.heartbeat() {
+		return true, true
+	}
+	if t.exceedsDeadband(r.streamValue, t.Value) {
+		return true, true
+	}
+	return false, r.hashQuality != t.Quality || r.hashValue != fmt.Sprintf("%v", t.Value)
+}
+
+func (t *Tag) reportedStream(now time.Time) {
+	t.report.streamValue = t.Value
+	t.report.streamQuality = t.Quality
+	t.report.streamTime = now
+	t.report.reported = true
+	t.reportedHash()
+}
+
+func (t *Tag) reportedHash() {
+	t.report.hashValue = fmt.Sprintf("%v", t.Value)
+	t.report.hashQuality = t.Quality
+}
@@ -0,0 +1,45 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportDecision(t *testing.T) {
+	now := time.Now()
+	tag := &Tag{Name: "丝包高度", Quality: "Good", Value: float32(100), Deadband: 0.5, Heartbeat: 10}
+
+	check := func(step string, value interface{}, quality string, at time.Time, wantStream, wantHash bool) {
+		t.Helper()
+		tag.Value, tag.Quality = value, quality
+		stream, hash := tag.reportDecision(at)
+		if stream != wantStream || hash != wantHash {
+			t.Fatalf("%s: stream=%v hash=%v", step, stream, hash)
+		}
+		if stream {
+			tag.reportedStream(at)
+		} else if hash {
+			tag.reportedHash()
+		}
+	}
+
+	check("first", float32(100), "Good", now, true, true)
+	check("unchanged", float32(100), "Good", now.Add(time.Second), false, false)
+	check("inside deadband", float32(100.3), "Good", now.Add(2*time.Second), false, true)
+	check("same as hash", float32(100.3), "Good", now.Add(3*time.Second), false, false)
+	check("exceeds deadband", float32(100.6), "Good", now.Add(4*time.Second), true, true)
+	check("quality", nil, "Bad", now.Add(5*time.Second), true, true)
+	check("heartbeat", nil, "Bad", now.Add(15*time.Second), true, true)
+
+	pct := &Tag{Value: int16(200), Quality: "Good", DeadbandPercent: 5}
+	pct.reportedStream(now)
+	if pct.Value = int16(209); pct.exceedsDeadband(int16(200), pct.Value) {
+		t.Fatal("4.5% change should be inside 5% deadband")
+	}
+	if pct.Value = int16(211); !pct.exceedsDeadband(int16(200), pct.Value) {
+		t.Fatal("5.5% change should exceed 5% deadband")
+	}
+	if !pct.exceedsDeadband("a", "b") || pct.exceedsDeadband(true, true) {
+		t.Fatal("non numeric compare")
+	}
+}
@@ -0,0 +1,184 @@
+package s7
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	driver "acetek-mes/driver"
+)
+
+// StructField 结构体字段，Offset 为相对结构体起始的字节偏移
+type StructField struct {
+	Name     string
+	DataType string
+	Offset   int
+	Bit      int
+	Length   int
+}
+
+var (
+	structuredRe = regexp.MustCompile(`^(.*?)(?:\{([^{}]*)\})?(?:\[(\d+)\])?$`)
+	fieldRe      = regexp.MustCompile(`^\s*([^:\s]+)\s*:\s*([A-Za-z_0-9]+)(?:\((\d+)\))?\s*@\s*(\d+)(?:\.([0-7]))?\s*$`)
+)
+
+// parseStructured 解析数组与结构体后缀：
+//
+//	DB10.DBW0[48]                              48 个字
+//	DB10.DBX0.0[256]                           256 个位
+//	DB10.DBB0{速度:float32@0,运行:bool@4.0}      结构体
+//	DB10.DBB0{速度:float32@0,运行:bool@4.0}[8]   结构体数组，元素按结构体大小（偶数字节对齐）排列
+//
+// 没有后缀时返回 nil
+func parseStructured(address string, vdb int) (*S7Tag, error) {
+	match := structuredRe.FindStringSubmatch(address)
+	if match == nil || (match[2] == "" && match[3] == "") {
+		return nil, nil
+	}
+	tag, err := parseAddress(match[1], vdb)
+	if err != nil {
+		return nil, err
+	}
+	tag.Raw = address
+	if match[2] != "" {
+		if tag.Fields, err = parseFields(match[2]); err != nil {
+			return nil, err
+		}
+		tag.DataType = driver.TypeStruct
+		tag.WordLen = Byte
+		tag.Length = structSize(tag.Fields)
+	}
+	if match[3] != "" {
+		tag.Count, _ = strconv.Atoi(match[3])
+		if tag.Count <= 0 {
+			return nil, fmt.Errorf("invalid array length: %s", address)
+		}
+	}
+	return tag, nil
+}
+
+func parseFields(layout string) ([]StructField, error) {
+	var fields []StructField
+	names := make(map[string]bool)
+	for _, def := range strings.Split(layout, ",") {
+		if strings.TrimSpace(def) == "" {
+			continue
+		}
+		match := fieldRe.FindStringSubmatch(def)
+		if match == nil {
+			return nil, fmt.Errorf("invalid struct field: %s", def)
+		}
+		f := StructField{Name: match[1], DataType: strings.ToLower(match[2]), Length: 1}
+		if names[f.Name] {
+			return nil, fmt.Errorf("duplicate struct field: %s", f.Name)
+		}
+		names[f.Name] = true
+		f.Offset, _ = strconv.Atoi(match[4])
+		if match[5] != "" {
+			f.Bit, _ = strconv.Atoi(match[5])
+		}
+		if n, err := strconv.Atoi(match[3]); err == nil && n > 0 {
+			switch f.DataType {
+			case driver.TypeString:
+				f.Length = n + 2
+			case driver.TypeWString:
+				f.Length = 4 + 2*n
+			default:
+				f.Length = n
+			}
+		}
+		switch f.DataType {
+		case driver.TypeBool, driver.TypeByte, driver.TypeBytes, driver.TypeInt16, driver.TypeUInt16,
+			driver.TypeInt32, driver.TypeUInt32, driver.TypeFloat32, driver.TypeInt64, driver.TypeFloat64,
+			driver.TypeDTL, driver.TypeDateTime, driver.TypeTime, driver.TypeString, driver.TypeWString, driver.TypeChars:
+		default:
+			return nil, fmt.Errorf("unsupported struct field type: %s", f.DataType)
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("empty struct")
+	}
+	return fields, nil
+}
+
+func (f StructField) tag() S7Tag {
+	return S7Tag{DataType: f.DataType, Bit: f.Bit, Length: f.Length, WordLen: Byte, Valid: true}
+}
+
+// structSize 结构体占用的字节数，按 S7 规则补齐到偶数
+func structSize(fields []StructField) int {
+	size := 0
+	for _, f := range fields {
+		size = max(size, f.Offset+valueSize(f.tag()))
+	}
+	return size + size%2
+}
+
+// elementSize 数组单个元素的字节数
+func elementSize(t S7Tag) int {
+	t.Count = 0
+	return valueSize(t)
+}
+
+// structuredSize 数组或结构体变量占用的总字节数，位数组按位紧密排列
+func structuredSize(t S7Tag) int {
+	if t.Count == 0 {
+		return elementSize(t)
+	}
+	if t.DataType == driver.TypeBool && len(t.Fields) == 0 {
+		return (t.Bit + t.Count + 7) / 8
+	}
+	return elementSize(t) * t.Count
+}
+
+// parseStructuredValue 数组解析为 []interface{}，结构体解析为 map[string]interface{}
+func parseStructuredValue(t S7Tag, buffer []byte) (any, error) {
+	if t.Count == 0 {
+		return parseStruct(t, buffer)
+	}
+	if len(buffer) < structuredSize(t) {
+		return nil, errors.New("buffer too short for array")
+	}
+	values := make([]interface{}, t.Count)
+	element := t
+	element.Count = 0
+	size := elementSize(t)
+	for i := range values {
+		var value any
+		var err error
+		switch {
+		case len(t.Fields) > 0:
+			value, err = parseStruct(element, buffer[i*size:])
+		case t.DataType == driver.TypeBool:
+			bit := t.Bit + i
+			value = buffer[bit/8]&(1<<(bit%8)) != 0
+		default:
+			value, err = ParseValueFromBuffer(element, buffer[i*size:(i+1)*size])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %v", i, err)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func parseStruct(t S7Tag, buffer []byte) (any, error) {
+	values := make(map[string]interface{}, len(t.Fields))
+	for _, f := range t.Fields {
+		ft := f.tag()
+		end := f.Offset + valueSize(ft)
+		if end > len(buffer) {
+			return nil, fmt.Errorf("buffer too short for field %s", f.Name)
+		}
+		value, err := ParseValueFromBuffer(ft, buffer[f.Offset:end])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		values[f.Name] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,206 @@
+package s7
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"acetek-mes/driver"
+
+	"github.com/robinson/gos7"
+)
+
+const (
+	maxMultiReadItems  = 20 // S7 单次多变量读取的最大变量数
+	readRequestHeader  = 19 // 多变量读取请求头
+	readRequestItem    = 12 // 每个变量的请求参数
+	readResponseHeader = 21 // 多变量读取应答头
+	readResponseItem   = 4  // 每个变量的应答数据头
+	defaultBlockGap    = 32 // 合并相邻地址时允许跨越的空闲字节数
+	minPDULength       = 240
+)
+
+// readBlock 同一区域内地址连续（或相近）的一段字节，一次读取后按偏移切出各变量
+type readBlock struct {
+	Area     int
+	DBNumber int
+	Start    int
+	Length   int
+	Data     []byte
+	Tags     []*driver.Tag
+}
+
+func (b *readBlock) end() int {
+	return b.Start + b.Length
+}
+
+// readPlan 按 PDU 大小分组后的读取计划，singles 为 TM/CT 等不能合并的变量
+type readPlan struct {
+	pduLength int
+	requests  [][]*readBlock
+	singles   []*driver.Tag
+}
+
+// valueSize 变量在 PLC 中实际占用的字节数，数据类型比地址宽时以数据类型为准
+func valueSize(t S7Tag) int {
+	if t.Count > 0 {
+		return structuredSize(t)
+	}
+	size := t.Length
+	switch t.DataType {
+	case driver.TypeInt16, driver.TypeUInt16:
+		size = max(size, 2)
+	case driver.TypeInt32, driver.TypeUInt32, driver.TypeFloat32, driver.TypeTime:
+		size = max(size, 4)
+	case driver.TypeInt64, driver.TypeFloat64, driver.TypeDateTime:
+		size = max(size, 8)
+	case driver.TypeDTL:
+		size = max(size, 12)
+	case driver.TypeWString:
+		// 地址未给出长度时按 WSTRING[254] 读取
+		if size < 5 {
+			size = 4 + 2*254
+		}
+	case driver.TypeString:
+		// 地址未给出长度时按 S7 默认 STRING[254] 读取
+		if size < 3 {
+			size = 256
+		}
+	}
+	return size
+}
+
+func itemSize(length int) int {
+	return readResponseItem + length + length%2
+}
+
+// buildReadPlan 将变量按区域/DB 分组，合并相邻地址为块，再按 PDU 大小打包成多变量读取请求
+func buildReadPlan(tags []*driver.Tag, pduLength int, gap int) *readPlan {
+	plan := &readPlan{pduLength: pduLength}
+	maxBlock := pduLength - readResponseHeader - readResponseItem
+	maxBlock -= maxBlock % 2
+
+	type areaKey struct{ area, db int }
+	groups := make(map[areaKey][]*driver.Tag)
+	for _, v := range tags {
+		if !v.Parsed {
+			continue
+		}
+		t, ok := v.Mate.(S7Tag)
+		if !ok {
+			continue
+		}
+		if t.Area == AreaTM || t.Area == AreaCT || valueSize(t) > maxBlock {
+			plan.singles = append(plan.singles, v)
+			continue
+		}
+		key := areaKey{t.Area, t.DBNumber}
+		groups[key] = append(groups[key], v)
+	}
+
+	var blocks []*readBlock
+	for key, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].Mate.(S7Tag).Start < group[j].Mate.(S7Tag).Start
+		})
+		var current *readBlock
+		for _, v := range group {
+			t := v.Mate.(S7Tag)
+			end := t.Start + valueSize(t)
+			if current != nil && t.Start <= current.end()+gap && max(end, current.end())-current.Start <= maxBlock {
+				current.Length = max(end, current.end()) - current.Start
+				current.Tags = append(current.Tags, v)
+				continue
+			}
+			current = &readBlock{Area: key.area, DBNumber: key.db, Start: t.Start, Length: end - t.Start, Tags: []*driver.Tag{v}}
+			blocks = append(blocks, current)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		if blocks[i].Area != blocks[j].Area {
+			return blocks[i].Area < blocks[j].Area
+		}
+		if blocks[i].DBNumber != blocks[j].DBNumber {
+			return blocks[i].DBNumber < blocks[j].DBNumber
+		}
+		return blocks[i].Start < blocks[j].Start
+	})
+
+	var request []*readBlock
+	requestSize, responseSize := readRequestHeader, readResponseHeader
+	for _, b := range blocks {
+		b.Data = make([]byte, b.Length)
+		if len(request) > 0 && (len(request) >= maxMultiReadItems ||
+			requestSize+readRequestItem > pduLength || responseSize+itemSize(b.Length) > pduLength) {
+			plan.requests = append(plan.requests, request)
+			request = nil
+			requestSize, responseSize = readRequestHeader, readResponseHeader
+		}
+		request = append(request, b)
+		requestSize += readRequestItem
+		responseSize += itemSize(b.Length)
+	}
+	if len(request) > 0 {
+		plan.requests = append(plan.requests, request)
+	}
+	return plan
+}
+
+// readBlocks 执行一次读取计划，返回值为连接级错误，单个变量的错误体现在 Quality 上
+func (c *S7Client) readBlocks(plan *readPlan, result map[string]interface{}) error {
+	for _, request := range plan.requests {
+		items := make([]gos7.S7DataItem, len(request))
+		for i, b := range request {
+			items[i] = gos7.S7DataItem{
+				Area:     b.Area,
+				WordLen:  int(Byte),
+				DBNumber: b.DBNumber,
+				Start:    b.Start,
+				Amount:   b.Length,
+				Data:     b.Data,
+			}
+		}
+		if err := c.client.AGReadMulti(items, len(items)); err != nil {
+			ts := time.Now()
+			for _, b := range request {
+				for _, v := range b.Tags {
+					setTagValue(v, nil, ts, err)
+					result[v.Name] = v.Value
+				}
+			}
+			return err
+		}
+		ts := time.Now()
+		for i, b := range request {
+			var itemError error
+			if items[i].Error != "" {
+				itemError = fmt.Errorf("read %s: %s", b.Tags[0].Address, items[i].Error)
+				log.Println("read block error:", itemError)
+			}
+			for _, v := range b.Tags {
+				if itemError != nil {
+					setTagValue(v, nil, ts, itemError)
+				} else {
+					t := v.Mate.(S7Tag)
+					offset := t.Start - b.Start
+					value, err := ParseValueFromBuffer(t, b.Data[offset:offset+valueSize(t)])
+					setTagValue(v, value, ts, err)
+				}
+				result[v.Name] = v.Value
+			}
+		}
+	}
+	return nil
+}
+
+func setTagValue(v *driver.Tag, value any, ts time.Time, err error) {
+	v.Timestamp = ts
+	if err != nil {
+		v.Quality = "Bad"
+		v.Value = nil
+		return
+	}
+	v.Quality = "Good"
+	v.Value = v.Scale(value)
+}
@@ -0,0 +1,77 @@
+package s7
+
+import (
+	"fmt"
+	"testing"
+
+	driver "acetek-mes/driver"
+)
+
+func parsedTags(t *testing.T, defs map[string]string) []*driver.Tag {
+	var tags []*driver.Tag
+	for address, datatype := range defs {
+		s7t, err := ParseAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s7t.DataType = datatype
+		tags = append(tags, &driver.Tag{Name: address, Address: address, Datatype: datatype, Parsed: true, Mate: *s7t})
+	}
+	return tags
+}
+
+func TestBuildReadPlan(t *testing.T) {
+	tags := parsedTags(t, map[string]string{
+		"DB1.DBX0.1":  driver.TypeBool,
+		"DB1.DBW2":    driver.TypeInt16,
+		"DB1.DBD4":    driver.TypeFloat32,
+		"DB1.DBW40":   driver.TypeUInt16,
+		"DB1.DBW300":  driver.TypeUInt16,
+		"DB2.DBW2":    driver.TypeInt16,
+		"MW10":        driver.TypeInt16,
+		"T5":          driver.TypeUInt16,
+		"DB1.DBD1000": driver.TypeUInt32,
+	})
+	plan := buildReadPlan(tags, 240, defaultBlockGap)
+	if len(plan.singles) != 1 || plan.singles[0].Name != "T5" {
+		t.Fatalf("singles: %+v", plan.singles)
+	}
+	if len(plan.requests) != 1 {
+		t.Fatalf("requests: %d", len(plan.requests))
+	}
+	var got []string
+	for _, b := range plan.requests[0] {
+		got = append(got, fmt.Sprintf("%x/%d/%d+%d:%d", b.Area, b.DBNumber, b.Start, b.Length, len(b.Tags)))
+	}
+	want := []string{"83/0/10+2:1", "84/1/0+42:4", "84/1/300+2:1", "84/1/1000+4:1", "84/2/2+2:1"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("blocks: got %v, want %v", got, want)
+	}
+}
+
+func TestBuildReadPlanRespectsPDU(t *testing.T) {
+	defs := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		defs[fmt.Sprintf("DB%d.DBD0", i+1)] = driver.TypeFloat32
+	}
+	for i := 0; i < 100; i++ {
+		defs[fmt.Sprintf("DB100.DBD%d", i*4)] = driver.TypeFloat32
+	}
+	plan := buildReadPlan(parsedTags(t, defs), 240, defaultBlockGap)
+	blocks := 0
+	for _, request := range plan.requests {
+		requestSize, responseSize := readRequestHeader, readResponseHeader
+		for _, b := range request {
+			requestSize += readRequestItem
+			responseSize += itemSize(b.Length)
+			blocks++
+		}
+		if len(request) > maxMultiReadItems || requestSize > 240 || responseSize > 240 {
+			t.Fatalf("request exceeds pdu: %d items, %d/%d bytes", len(request), requestSize, responseSize)
+		}
+	}
+	// DB100 的 400 字节需要拆成两个块
+	if blocks != 52 {
+		t.Fatalf("blocks: %d", blocks)
+	}
+}
@@ -0,0 +1,161 @@
+package s7
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/robinson/gos7"
+)
+
+// 连接类型，决定远端 TSAP 的高字节
+const (
+	ConnectionPG    = 1
+	ConnectionOP    = 2
+	ConnectionBasic = 3
+)
+
+// defaultLocalTSAP gos7 使用的本地 TSAP
+const defaultLocalTSAP = 0x0100
+
+// Family PLC 系列的默认连接参数，URL 中的 rack/slot/conn/localtsap/remotetsap/vdb 可以覆盖
+type Family struct {
+	Rack           int
+	Slot           int
+	ConnectionType int
+	LocalTSAP      uint16 // 0 为 defaultLocalTSAP
+	RemoteTSAP     uint16 // 0 时由连接类型、机架号、槽号计算
+	VAreaDB        int    // V 区映射的 DB 号
+}
+
+var families = map[string]Family{
+	"s7-300":      {Rack: 0, Slot: 2, ConnectionType: ConnectionPG, VAreaDB: 1},
+	"s7-1200":     {Rack: 0, Slot: 1, ConnectionType: ConnectionPG, VAreaDB: 1},
+	"s7-1500":     {Rack: 0, Slot: 1, ConnectionType: ConnectionPG, VAreaDB: 1},
+	"s7-200smart": {Rack: 0, Slot: 1, ConnectionType: ConnectionPG, VAreaDB: 1},
+	"logo":        {ConnectionType: ConnectionOP, LocalTSAP: 0x0100, RemoteTSAP: 0x0200, VAreaDB: 1},
+}
+
+// 未指定 family 时与之前一致：机架 0、槽 1、PG 连接，V 区为 DB1
+var defaultFamily = Family{Rack: 0, Slot: 1, ConnectionType: ConnectionPG, VAreaDB: 1}
+
+// parseFamily 按 family 参数取得默认值，再用 URL 中的其它参数覆盖
+func parseFamily(query url.Values) (Family, error) {
+	f := defaultFamily
+	if name := strings.ToLower(query.Get("family")); name != "" {
+		var ok bool
+		if f, ok = families[name]; !ok {
+			return f, fmt.Errorf("unknown s7 family: %s", name)
+		}
+	}
+	if r := query.Get("rack"); r != "" {
+		rack, err := strconv.Atoi(r)
+		if err != nil || rack < 0 || rack > 7 {
+			return f, fmt.Errorf("invalid rack: %s", r)
+		}
+		f.Rack = rack
+		f.RemoteTSAP = 0
+	}
+	if s := query.Get("slot"); s != "" {
+		slot, err := strconv.Atoi(s)
+		if err != nil || slot < 0 || slot > 31 {
+			return f, fmt.Errorf("invalid slot: %s", s)
+		}
+		f.Slot = slot
+		f.RemoteTSAP = 0
+	}
+	if c := query.Get("conn"); c != "" {
+		switch strings.ToLower(c) {
+		case "pg", "1":
+			f.ConnectionType = ConnectionPG
+		case "op", "2":
+			f.ConnectionType = ConnectionOP
+		case "basic", "3":
+			f.ConnectionType = ConnectionBasic
+		default:
+			return f, fmt.Errorf("invalid connection type: %s", c)
+		}
+		f.RemoteTSAP = 0
+	}
+	var err error
+	if t := query.Get("localtsap"); t != "" {
+		if f.LocalTSAP, err = parseTSAP(t); err != nil {
+			return f, err
+		}
+	}
+	if t := query.Get("remotetsap"); t != "" {
+		if f.RemoteTSAP, err = parseTSAP(t); err != nil {
+			return f, err
+		}
+	}
+	if v := query.Get("vdb"); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil || db <= 0 {
+			return f, fmt.Errorf("invalid vdb: %s", v)
+		}
+		f.VAreaDB = db
+	}
+	return f, nil
+}
+
+// parseTSAP 支持 "03.01"（两个十六进制字节，与 STEP 7 中的写法一致）、"0x0301" 与十进制
+func parseTSAP(s string) (uint16, error) {
+	if hi, lo, ok := strings.Cut(s, "."); ok {
+		h, err1 := strconv.ParseUint(hi, 16, 8)
+		l, err2 := strconv.ParseUint(lo, 16, 8)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("invalid tsap: %s", s)
+		}
+		return uint16(h<<8 | l), nil
+	}
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tsap: %s", s)
+	}
+	return uint16(v), nil
+}
+
+func (f Family) remoteTSAP() uint16 {
+	if f.RemoteTSAP != 0 {
+		return f.RemoteTSAP
+	}
+	return uint16(f.ConnectionType)<<8 | uint16(f.Rack*0x20+f.Slot)
+}
+
+// newHandler 按连接参数创建 gos7 连接。gos7 的远端 TSAP 由连接类型、机架号、槽号计算，
+// 直接配置的远端 TSAP 反算为这三个值传入
+func (f Family) newHandler(address string) (*gos7.TCPClientHandler, error) {
+	remote := f.remoteTSAP()
+	handler := gos7.NewTCPClientHandlerWithConnectType(address, int(remote&0xFF)>>5, int(remote&0x1F), int(remote>>8))
+	if f.LocalTSAP != 0 && f.LocalTSAP != defaultLocalTSAP {
+		if err := setLocalTSAP(handler, f.LocalTSAP); err != nil {
+			return nil, err
+		}
+	}
+	return handler, nil
+}
+
+// setLocalTSAP gos7 没有导出本地 TSAP（固定为 0x0100），部分协议转换器要求其它值。
+// 通过反射修改未导出字段，gos7 升级后字段不存在时返回错误
+func setLocalTSAP(h *gos7.TCPClientHandler, tsap uint16) error {
+	transporter := reflect.ValueOf(h).Elem().FieldByName("tcpTransporter")
+	if !transporter.IsValid() || transporter.Kind() != reflect.Struct {
+		return fmt.Errorf("local tsap %04X not supported by this gos7 version", tsap)
+	}
+	high := transporter.FieldByName("localTSAPHigh")
+	low := transporter.FieldByName("localTSAPLow")
+	for _, f := range []reflect.Value{high, low} {
+		if !f.IsValid() || f.Kind() != reflect.Uint8 {
+			return fmt.Errorf("local tsap %04X not supported by this gos7 version", tsap)
+		}
+	}
+	set := func(f reflect.Value, b byte) {
+		reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().SetUint(uint64(b))
+	}
+	set(high, byte(tsap>>8))
+	set(low, byte(tsap))
+	return nil
+}
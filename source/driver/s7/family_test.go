@@ -0,0 +1,74 @@
+package s7
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestParseFamily(t *testing.T) {
+	cases := map[string]struct {
+		remote uint16
+		local  uint16
+		vdb    int
+	}{
+		"":                             {0x0101, 0, 1},
+		"family=s7-300":                {0x0102, 0, 1},
+		"family=s7-1200&rack=0&slot=0": {0x0100, 0, 1},
+		"family=s7-200smart&vdb=2":     {0x0101, 0, 2},
+		"family=logo":                  {0x0200, 0x0100, 1},
+		"family=s7-1500&conn=basic":    {0x0301, 0, 1},
+		"family=s7-200smart&localtsap=10.00&remotetsap=0x1001": {0x1001, 0x1000, 1},
+	}
+	for query, want := range cases {
+		values, _ := url.ParseQuery(query)
+		f, err := parseFamily(values)
+		if err != nil || f.remoteTSAP() != want.remote || f.LocalTSAP != want.local || f.VAreaDB != want.vdb {
+			t.Errorf("%q: %+v remote %#04x %v", query, f, f.remoteTSAP(), err)
+		}
+	}
+	for _, query := range []string{"family=s5", "conn=xx", "slot=40", "remotetsap=1.2.3"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseFamily(values); err == nil {
+			t.Errorf("%q: expect error", query)
+		}
+	}
+
+	tag, err := parseAddress("VW100", 3)
+	if err != nil || tag.Area != AreaDB || tag.DBNumber != 3 || tag.Start != 100 {
+		t.Fatalf("v area: %+v %v", tag, err)
+	}
+}
+
+func TestConnectionTSAP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 22)
+		if _, err := io.ReadFull(conn, buf); err == nil {
+			received <- buf
+		}
+	}()
+
+	values, _ := url.ParseQuery("family=logo&localtsap=02.00&remotetsap=03.00")
+	f, _ := parseFamily(values)
+	handler, err := f.newHandler(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Connect()
+	cr := <-received
+	if cr[16] != 0x02 || cr[17] != 0x00 || cr[20] != 0x03 || cr[21] != 0x00 {
+		t.Fatalf("connection request tsap: % x", cr)
+	}
+}
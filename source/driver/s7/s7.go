@@ -1,7 +1,8 @@
 package s7
 
 import (
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -19,8 +20,9 @@ type S7Client struct {
 	handler *gos7.TCPClientHandler
 	client  gos7.Client
 	addr    string
-	rack    int
-	slot    int
+	family  Family
+	gap     int
+	plans   driver.PlanCache[*readPlan]
 }
 
 func NewS7Client(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
@@ -33,55 +35,71 @@ func NewS7Client(id string, name string, rawURL string, tags []*driver.Tag) (dri
 	if port == "" {
 		port = "102"
 	}
-	rack := 0
-	slot := 1
-	var interval uint32 = 1000
-	if r := u.Query().Get("rack"); r != "" {
-		rack, _ = strconv.Atoi(r)
-	}
-	if s := u.Query().Get("slot"); s != "" {
-		slot, _ = strconv.Atoi(s)
+	family, err := parseFamily(u.Query())
+	if err != nil {
+		return nil, err
 	}
+	var interval uint32 = 1000
 	if i := u.Query().Get("interval"); i != "" {
 		if intval, err := strconv.Atoi(i); err == nil {
 			interval = uint32(intval)
 		}
 	}
-	handler := gos7.NewTCPClientHandler(fmt.Sprintf("%s:%s", host, port), rack, slot)
-	handler.Timeout = 2 * time.Second
-
-	client := gos7.NewClient(handler)
-	mtags := make(map[string]*driver.Tag)
-	for _, v := range tags {
-		if s7t, err := ParseAddress(v.Address); err == nil {
-			v.Parsed = true
-			s7t.DataType = v.Datatype
-			v.Mate = *s7t
-			mtags[v.Name] = v
-		} else {
-			v.Parsed = false
-			v.Mate = nil
-			log.Println("parse address error:", err)
+	gap := defaultBlockGap
+	if g := u.Query().Get("gap"); g != "" {
+		if intval, err := strconv.Atoi(g); err == nil && intval >= 0 {
+			gap = intval
 		}
 	}
+	handler, err := family.newHandler(fmt.Sprintf("%s:%s", host, port))
+	if err != nil {
+		return nil, err
+	}
+	handler.Timeout = 2 * time.Second
 
-	return &S7Client{
+	client := gos7.NewClient(handler)
+	c := &S7Client{
 
 		handler: handler,
 		client:  client,
 		addr:    rawURL,
-		rack:    rack,
-		slot:    slot,
+		family:  family,
+		gap:     gap,
 		Driver: driver.Driver{
 			ID:            id,
 			Name:          name,
-			Tags:          mtags,
+			Tags:          driver.TagMap(parseTags(tags, family.VAreaDB)),
 			Interval:      interval,
 			ChCommand:     make(chan string, 100),
-			ChWrite:       make(chan map[string]interface{}, 100),
+			ChWrite:       make(chan *driver.WriteRequest, 100),
 			ChWriteResult: make(chan error),
+			ChConfig:      make(chan *driver.Config, 1),
 		},
-	}, nil
+	}
+	c.InitScanGroups()
+	return c, nil
+}
+
+// parseTags 解析变量地址，返回解析成功的变量
+func parseTags(tags []*driver.Tag, vdb int) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if s7t, err := parseAddress(v.Address, vdb); err == nil {
+			v.Parsed = true
+			// 未配置数据类型时使用地址推断的类型，例如 DB10.DTL0
+			if v.Datatype == "" || len(s7t.Fields) > 0 {
+				v.Datatype = s7t.DataType
+			}
+			s7t.DataType = v.Datatype
+			v.Mate = *s7t
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse address error:", err)
+		}
+	}
+	return result
 }
 
 // 注册 S7 驱动
@@ -114,15 +132,15 @@ func (c *S7Client) IsConnected() bool {
 	return c.Connected
 }
 
+// reconnectIfNeeded 只在断开后重连，重连的时机与退避由扫描循环控制
 func (c *S7Client) reconnectIfNeeded() error {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
-	if !c.Connected || time.Since(c.LastPing) > 5*time.Second {
+	if !c.Connected {
 		c.handler.Close()
 		err := c.handler.Connect()
 		if err != nil {
-			c.Connected = false
-			return err
+			return fmt.Errorf("%w: %v", driver.ErrConnection, err)
 		}
 		c.Connected = true
 		c.LastPing = time.Now()
@@ -131,64 +149,90 @@ func (c *S7Client) reconnectIfNeeded() error {
 }
 
 func (c *S7Client) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ReadGroup 读取一个扫描组，每个扫描组有独立的读取计划
+func (c *S7Client) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 	if err := c.reconnectIfNeeded(); err != nil {
 		return nil, err
 	}
-	for _, v := range c.Tags {
-		if !v.Parsed {
-			continue
+	pduLength := c.handler.PDULength
+	if pduLength <= 0 {
+		pduLength = minPDULength
+	}
+	// 重连后 PDU 大小变化时重新生成读取计划
+	plan, built := c.plans.Get(c.Groups, g,
+		func(p *readPlan) bool { return p.pduLength == pduLength },
+		func() *readPlan { return buildReadPlan(g.Tags, pduLength, c.gap) })
+	if built {
+		log.Printf("s7 %s group %s read plan: %d tags, %d requests, %d singles", c.ID, g.Name(), len(g.Tags), len(plan.requests), len(plan.singles))
+	}
+	if err := c.readBlocks(plan, result); err != nil {
+		return result, err
+	}
+	for _, v := range plan.singles {
+		s7tag := v.Mate.(S7Tag)
+		byts := make([]byte, max(valueSize(s7tag), 2))
+		resultError := c.readArea(s7tag, byts)
+		var value any
+		var err error
+		if resultError == nil {
+			value, err = ParseValueFromBuffer(s7tag, byts)
 		}
-		var resultError error
-		byts := make([]byte, 256)
-		if s7tag, ok := v.Mate.(S7Tag); ok {
-			switch s7tag.Area {
-			case AreaDB:
-				 resultError= c.client.AGReadDB(s7tag.DBNumber, s7tag.Start, s7tag.Length, byts)
-			case AreaTM:
-				resultError= c.client.AGReadTM(s7tag.Start, s7tag.Length, byts)
-			case AreaCT:
-				resultError= c.client.AGReadCT(s7tag.Start, s7tag.Length, byts)
-			case AreaMK:
-				resultError= c.client.AGReadMB(s7tag.Start, s7tag.Length, byts)
-			case AreaPA:
-				resultError= c.client.AGReadEB(s7tag.Start, s7tag.Length, byts)
-			case AreaPE:
-				resultError= c.client.AGReadAB(s7tag.Start, s7tag.Length, byts)
-			default:
-				log.Println("tag ")
-			}
-			log.Printf("resultError:%v\n %+v %s", resultError, s7tag, hex.EncodeToString(byts[0:s7tag.Length]))
-			value, err := ParseValueFromBuffer(s7tag, byts)
-			if resultError == nil && err == nil {
-				result[v.Name] = value
-				v.Quality = "Good"
-				v.Value = value
-				v.Timestamp = time.Now()
-			} else {
-				result[v.Name] = nil
-				v.Quality = "Bad"
-				v.Value = nil
-				v.Timestamp = time.Now()
-				log.Println("resultError:", resultError, "parse error:", err)
-			}
-
-		} else {
-			log.Println("tag is not s7 tag")
+		if resultError != nil || err != nil {
+			log.Println("resultError:", resultError, "parse error:", err)
 		}
+		setTagValue(v, value, time.Now(), errors.Join(resultError, err))
 		result[v.Name] = v.Value
+		if driver.IsConnError(resultError) {
+			return result, resultError
+		}
 	}
 	return result, nil
 }
 
-func (c *S7Client) Write(name string, value interface{}) error {
-	c.ChWrite <- map[string]interface{}{
-		name: value,
+func (c *S7Client) readArea(t S7Tag, buffer []byte) error {
+	switch t.Area {
+	case AreaDB:
+		return c.client.AGReadDB(t.DBNumber, t.Start, len(buffer), buffer)
+	case AreaTM:
+		return c.client.AGReadTM(t.Start, len(buffer)/2, buffer)
+	case AreaCT:
+		return c.client.AGReadCT(t.Start, len(buffer)/2, buffer)
+	case AreaMK:
+		return c.client.AGReadMB(t.Start, len(buffer), buffer)
+	case AreaPE:
+		return c.client.AGReadEB(t.Start, len(buffer), buffer)
+	case AreaPA:
+		return c.client.AGReadAB(t.Start, len(buffer), buffer)
+	default:
+		return fmt.Errorf("未知区域: %d", t.Area)
 	}
-	return <-c.ChWriteResult
+}
+
+func (c *S7Client) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
 }
 
 func (c *S7Client) write(values map[string]interface{}) error {
+	// 写入可能先于第一次读取，未连接时 PDU 长度为 0
+	if err := c.reconnectIfNeeded(); err != nil {
+		return err
+	}
 	for k, v := range values {
 		if t, ok := c.Tags[k]; !ok {
 			return fmt.Errorf("tag %s is not define", k)
@@ -215,6 +259,10 @@ func (c *S7Client) WriteTag(name string, value interface{}) error {
 		return fmt.Errorf("tag %s Mate not S7Tag", name)
 	}
 
+	if t.Count > 0 || len(t.Fields) > 0 {
+		return fmt.Errorf("tag %s: 数组/结构体变量不支持写入", name)
+	}
+
 	// bool 位操作写入
 	if tag.Datatype == "bool" && t.WordLen == Bit {
 		buffer := make([]byte, 1)
@@ -260,69 +308,39 @@ func (c *S7Client) WriteTag(name string, value interface{}) error {
 		}
 	}
 
-	// 普通类型写入（非位）
-	data, err := tag.ConvertToBytes(value)
+	// 普通类型写入（非位），工程值先反算为原始值
+	raw, err := tag.Unscale(value)
+	if err != nil {
+		return err
+	}
+	data, err := tag.ConvertToBytes(raw)
 	if err != nil {
 		return err
 	}
-	length := len(data)
+	data, offset, err := fitLength(t, data)
+	if err != nil {
+		return err
+	}
+	start, length := t.Start+offset, len(data)
 
 	switch t.Area {
 	case AreaDB:
-		return c.client.AGWriteDB(t.DBNumber, t.Start, length, data)
+		return c.client.AGWriteDB(t.DBNumber, start, length, data)
 	case AreaMK:
-		return c.client.AGWriteMB(t.Start, length, data)
+		return c.client.AGWriteMB(start, length, data)
 	case AreaPE:
-		return c.client.AGWriteEB(t.Start, length, data)
+		return c.client.AGWriteEB(start, length, data)
 	case AreaPA:
-		return c.client.AGWriteAB(t.Start, length, data)
+		return c.client.AGWriteAB(start, length, data)
 	default:
 		return fmt.Errorf("未知区域: %d", t.Area)
 	}
 }
-func (c *S7Client) Start() error {
-	go func() {
-		ticker := time.NewTicker(time.Duration(c.Interval) * time.Millisecond)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				ts := time.Now()
-				if values, err := c.Read(); err != nil {
-					c.FailCount++
-					if c.FailCount > 5 {
-						for _, v := range c.Tags {
-							v.Quality = "Bad"
-							v.Timestamp = ts
-						}
-					}
-					log.Println("read error:", err)
-				} else {
-					log.Printf("%+v", values)
-					c.FailCount = 0
-				}
-				c.Update()
-			case command := <-c.ChCommand:
-				switch command {
-				case "stop":
-					return
-				default:
-					break
-				}
-			case writeValues := <-c.ChWrite:
-				log.Println("write value ", writeValues)
-				c.ChWriteResult <- c.write(writeValues)
-			}
-		}
-	}()
-	return nil
-}
-
-func (c *S7Client) Stop() error {
-	c.ChCommand <- "stop"
-	return nil
+func (c *S7Client) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, c.Close)
 }
 
-func (c *S7Client) Reconfig() error {
-	return nil
+func (c *S7Client) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags, c.family.VAreaDB)
+	return c.Driver.Reconfig(cfg)
 }
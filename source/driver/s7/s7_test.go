@@ -1,51 +1,181 @@
 package s7
 
 import (
-	"log"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
 	"testing"
 	"time"
 
 	driver "acetek-mes/driver"
+	"acetek-mes/driver/s7/s7server"
 )
-const
-	connect_url = "s7://172.20.10.6:102?rack=2&slot=0"
-var(
-	tags = []*driver.Tag{
-		&driver.Tag{
-			Name: "DB1.DBX0.1",
-			Address: "DB1.DBX0.2",
-			Datatype: "bool",
-			Writable: true,
-		},
-		&driver.Tag{
-			Name: "DB1.DBW16",
-			Address: "DB1.DBW16",
-			Datatype: "uint16",
-			Writable: true,
-		},
-		&driver.Tag{
-			Name: "DB1.DBD32",
-			Address: "DB1.DBD32",
-			Datatype: "float32",
-			Writable: true,
-		},
+
+// newTestServer 启动内存中的 S7 服务端，DB1 为 64 字节
+func newTestServer(t *testing.T) *s7server.Server {
+	server := s7server.NewServer()
+	server.SetDB(1, make([]byte, 64))
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
 	}
-)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newTestClient(t *testing.T, server *s7server.Server, tags []*driver.Tag) *S7Client {
+	c, err := NewS7Client("S7", "", "s7://"+server.Addr()+"?rack=0&slot=1", tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*S7Client)
+	t.Cleanup(client.Close)
+	return client
+}
+
+func testTags() []*driver.Tag {
+	return []*driver.Tag{
+		{Name: "运行", Address: "DB1.DBX0.2", Datatype: "bool", Writable: true},
+		{Name: "故障", Address: "DB1.DBX0.3", Datatype: "bool"},
+		{Name: "线速", Address: "DB1.DBW16", Datatype: "uint16", Writable: true},
+		{Name: "温度", Address: "DB1.DBD32", Datatype: "float32", Writable: true},
+		{Name: "批号", Address: "DB1.STRING40(10)", Writable: true},
+		{Name: "计数", Address: "MW10", Datatype: "int16", Writable: true},
+	}
+}
+
 func TestRead(t *testing.T) {
-	log.Println("test read")
-	if c, err := NewS7Client("OOD","", connect_url, tags);err!= nil{
-		t.Log(err)
-	}else{
-		c.Start()
-		tck := time.NewTicker(time.Second)
-	for{
-		select{
-		case <-tck.C:
-			log.Println("write ", c.Write("DB1.DBX0.1", time.Now().Second()%2 == 0));
+	server := newTestServer(t)
+	db := make([]byte, 64)
+	db[0] = 0x0C
+	binary.BigEndian.PutUint16(db[16:], 1200)
+	binary.BigEndian.PutUint32(db[32:], math.Float32bits(36.5))
+	copy(db[40:], []byte{10, 3, 'D', 'Z', 'R'})
+	server.SetDB(1, db)
+	if err := server.Write(s7server.AreaMK, 0, 10, []byte{0xFF, 0xFE}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestClient(t, server, testTags())
+	values, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"运行": true, "故障": true, "线速": uint16(1200), "温度": float32(36.5), "批号": "DZR", "计数": int16(-2)}
+	for name, v := range want {
+		if values[name] != v {
+			t.Errorf("%s: got %v (%T), want %v", name, values[name], values[name], v)
+		}
+	}
+	// 同一扫描组的 DB 与 M 区变量合并为一次读取
+	if s := server.Stats(); s.Reads != 1 || s.ReadItems != 2 {
+		t.Fatalf("batching: %+v", s)
+	}
+	if tsap := server.RemoteTSAP(); tsap != 0x0101 {
+		t.Fatalf("remote tsap: %04X", tsap)
+	}
+}
+
+func TestWriteTag(t *testing.T) {
+	server := newTestServer(t)
+	server.Write(s7server.AreaDB, 1, 0, []byte{0x09})
+	// 字符串最大长度由 PLC 定义，写入时保留
+	server.Write(s7server.AreaDB, 1, 40, []byte{12})
+	client := newTestClient(t, server, testTags())
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 位写入为读-改-写，不影响同一字节的其他位
+	if err := client.WriteTag("运行", true); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := server.Read(s7server.AreaDB, 1, 0, 1); b[0] != 0x0D {
+		t.Fatalf("set bit: %08b", b[0])
+	}
+	if err := client.WriteTag("运行", false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := server.Read(s7server.AreaDB, 1, 0, 1); b[0] != 0x09 {
+		t.Fatalf("reset bit: %08b", b[0])
+	}
+
+	for name, value := range map[string]interface{}{"线速": 1500.0, "温度": float32(-12.25), "批号": "A-01", "计数": -300.0} {
+		if err := client.WriteTag(name, value); err != nil {
+			t.Fatalf("write %s: %v", name, err)
 		}
 	}
+	if b, _ := server.Read(s7server.AreaDB, 1, 16, 2); binary.BigEndian.Uint16(b) != 1500 {
+		t.Fatalf("uint16: %v", b)
+	}
+	if b, _ := server.Read(s7server.AreaDB, 1, 32, 4); math.Float32frombits(binary.BigEndian.Uint32(b)) != -12.25 {
+		t.Fatalf("float32: %v", b)
+	}
+	if b, _ := server.Read(s7server.AreaDB, 1, 40, 6); string(b) != "\x0c\x04A-01" {
+		t.Fatalf("string: %q", b)
 	}
+	if b, _ := server.Read(s7server.AreaMK, 0, 10, 2); int16(binary.BigEndian.Uint16(b)) != -300 {
+		t.Fatalf("int16: %v", b)
+	}
+}
 
+func TestSubmitReadBack(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server, testTags())
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
 
-	log.Println("read complete")
-}
\ No newline at end of file
+	// 写入成功即表示读回校验通过
+	req := &driver.WriteRequest{Values: map[string]interface{}{"线速": 800.0}}
+	if err := client.Submit(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ReadBack["线速"] != uint16(800) {
+		t.Fatalf("read back: %v", req.ReadBack)
+	}
+	if err := client.Write("故障", true); err == nil {
+		t.Fatal("readonly tag written")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server, testTags())
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+	server.Disconnect()
+	// 连接被服务端断开后读取失败，关闭后下一次读取重新连接
+	if _, err := client.Read(); err == nil || !driver.IsConnError(err) {
+		t.Fatalf("expect connection error, got %v", err)
+	}
+	client.Close()
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if s := server.Stats(); s.Connections != 2 {
+		t.Fatalf("connections: %d", s.Connections)
+	}
+}
+
+// 不完整的 COTP 连接请求关闭连接，不影响服务端
+func TestShortFrame(t *testing.T) {
+	server := newTestServer(t)
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{3, 0, 0, 7, 2, 0xE0, 0})
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 22)); err != io.EOF {
+		t.Fatalf("short frame: %v", err)
+	}
+	client := newTestClient(t, server, testTags())
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,532 @@
+// Package s7server 最小化的 S7 通讯服务端（ISO-on-TCP + S7comm），数据保存在内存中。
+// 支持 COTP 连接、Setup Communication、DB/M/I/Q 区的 Read Var 与 Write Var，
+// 用于在没有 PLC 的环境下测试 S7 驱动，也可以通过 cmd/s7server 单独运行。
+package s7server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// 存储区，与 S7 协议中的区域代码一致
+const (
+	AreaPE = 0x81 // I 输入
+	AreaPA = 0x82 // Q 输出
+	AreaMK = 0x83 // M 位存储区
+	AreaDB = 0x84 // 数据块
+)
+
+// 读写项返回码
+const (
+	retSuccess       = 0xFF
+	retAccessError   = 0x03 // 传输类型不支持
+	retAddressError  = 0x05 // 地址超出范围
+	retDataTypeError = 0x06
+	retNotExist      = 0x0A // 数据块不存在
+)
+
+// 传输大小
+const (
+	wlBit  = 0x01
+	tsBit  = 0x03
+	tsByte = 0x04
+)
+
+const (
+	DefaultPDULength = 480
+	maxItems         = 20
+)
+
+// Stats 服务端收到的请求计数，可用于验证驱动的批量读取
+type Stats struct {
+	Connections int
+	Reads       int // Read Var 请求数
+	ReadItems   int // Read Var 请求中的变量项数
+	Writes      int
+	WriteItems  int
+}
+
+type Server struct {
+	// PDULength 协商的最大 PDU 长度，客户端请求更小时取较小值
+	PDULength int
+
+	mu       sync.Mutex
+	db       map[int][]byte
+	areas    map[int][]byte
+	stats    Stats
+	tsap     uint16
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer 创建服务端，M/I/Q 区各 256 字节，数据块通过 SetDB 添加
+func NewServer() *Server {
+	return &Server{
+		PDULength: DefaultPDULength,
+		db:        make(map[int][]byte),
+		areas: map[int][]byte{
+			AreaPE: make([]byte, 256),
+			AreaPA: make([]byte, 256),
+			AreaMK: make([]byte, 256),
+		},
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// SetDB 添加或替换数据块 DB<number>
+func (s *Server) SetDB(number int, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db[number] = data
+}
+
+// SetArea 替换 M/I/Q 区
+func (s *Server) SetArea(area int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.areas[area]; !ok {
+		return fmt.Errorf("unknown area: 0x%02X", area)
+	}
+	s.areas[area] = data
+	return nil
+}
+
+// memory 返回区域对应的内存，调用方需持有锁
+func (s *Server) memory(area int, db int) ([]byte, byte) {
+	if area == AreaDB {
+		if data, ok := s.db[db]; ok {
+			return data, retSuccess
+		}
+		return nil, retNotExist
+	}
+	if data, ok := s.areas[area]; ok {
+		return data, retSuccess
+	}
+	return nil, retAccessError
+}
+
+// Read 读取内存中的数据，area 为 AreaDB 时 db 为数据块号
+func (s *Server) Read(area, db, start, size int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem, ret := s.memory(area, db)
+	if ret != retSuccess {
+		return nil, fmt.Errorf("area 0x%02X DB%d not exist", area, db)
+	}
+	if start < 0 || start+size > len(mem) {
+		return nil, fmt.Errorf("area 0x%02X DB%d: %d+%d out of range", area, db, start, size)
+	}
+	return append([]byte(nil), mem[start:start+size]...), nil
+}
+
+// Write 直接修改内存中的数据，模拟 PLC 程序改变变量
+func (s *Server) Write(area, db, start int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem, ret := s.memory(area, db)
+	if ret != retSuccess {
+		return fmt.Errorf("area 0x%02X DB%d not exist", area, db)
+	}
+	if start < 0 || start+len(data) > len(mem) {
+		return fmt.Errorf("area 0x%02X DB%d: %d+%d out of range", area, db, start, len(data))
+	}
+	copy(mem[start:], data)
+	return nil
+}
+
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// RemoteTSAP 最近一次连接请求中客户端指定的目标 TSAP
+func (s *Server) RemoteTSAP() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tsap
+}
+
+// Listen 监听 address 并在后台处理连接，端口为 0 时由系统分配，通过 Addr 获取
+func (s *Server) Listen(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.serve(l)
+	return nil
+}
+
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	l := s.listener
+	s.listener = nil
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	var err error
+	if l != nil {
+		err = l.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// Disconnect 断开当前所有客户端连接，用于测试驱动的重连
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *Server) serve(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("s7server accept:", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.stats.Connections++
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.handle(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Println("s7server", conn.RemoteAddr(), err)
+			}
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// handle 处理一个连接上的 TPKT 报文
+func (s *Server) handle(conn net.Conn) error {
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return err
+		}
+		if header[0] != 3 {
+			return fmt.Errorf("invalid TPKT version %d", header[0])
+		}
+		length := int(binary.BigEndian.Uint16(header[2:]))
+		if length < 7 {
+			return fmt.Errorf("invalid TPKT length %d", length)
+		}
+		frame := make([]byte, length)
+		copy(frame, header)
+		if _, err := io.ReadFull(conn, frame[4:]); err != nil {
+			return err
+		}
+		var response []byte
+		switch frame[5] {
+		case 0xE0: // COTP CR
+			var err error
+			if response, err = s.connectConfirm(frame); err != nil {
+				return err
+			}
+		case 0xF0: // COTP DT
+			var err error
+			if response, err = s.job(frame[7:]); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported COTP PDU 0x%02X", frame[5])
+		}
+		if _, err := conn.Write(response); err != nil {
+			return err
+		}
+	}
+}
+
+// connectConfirm 应答 COTP 连接请求，交换源与目标引用，原样返回 TSAP 参数
+func (s *Server) connectConfirm(frame []byte) ([]byte, error) {
+	// TPKT 4 字节 + COTP CR 固定部分 7 字节
+	if len(frame) < 11 {
+		return nil, fmt.Errorf("COTP CR too short: %d", len(frame))
+	}
+	response := []byte{
+		3, 0, 0, 22,
+		17, 0xD0,
+		frame[8], frame[9], // 目标引用 = 请求的源引用
+		0, 1, // 源引用
+		0,
+		0xC0, 1, 0x0A,
+		0xC1, 2, 1, 0,
+		0xC2, 2, 1, 2,
+	}
+	// 参数从 COTP 头之后开始，依次为 代码、长度、值
+	for i := 11; i+1 < len(frame); {
+		code, size := frame[i], int(frame[i+1])
+		if i+2+size > len(frame) {
+			break
+		}
+		value := frame[i+2 : i+2+size]
+		switch {
+		case code == 0xC1 && size == 2:
+			copy(response[16:18], value)
+		case code == 0xC2 && size == 2:
+			copy(response[20:22], value)
+			s.mu.Lock()
+			s.tsap = binary.BigEndian.Uint16(value)
+			s.mu.Unlock()
+		}
+		i += 2 + size
+	}
+	return response, nil
+}
+
+// job 处理 S7 作业请求，payload 从 S7 协议头开始
+func (s *Server) job(payload []byte) ([]byte, error) {
+	if len(payload) < 12 || payload[0] != 0x32 {
+		return nil, errors.New("invalid S7 header")
+	}
+	if payload[1] != 1 {
+		return nil, fmt.Errorf("unsupported ROSCTR %d", payload[1])
+	}
+	paramLen := int(binary.BigEndian.Uint16(payload[6:]))
+	dataLen := int(binary.BigEndian.Uint16(payload[8:]))
+	if len(payload) < 10+paramLen+dataLen || paramLen < 1 {
+		return nil, errors.New("truncated S7 job")
+	}
+	ref := binary.BigEndian.Uint16(payload[4:])
+	params := payload[10 : 10+paramLen]
+	data := payload[10+paramLen : 10+paramLen+dataLen]
+
+	switch params[0] {
+	case 0xF0: // Setup Communication
+		if len(params) < 8 {
+			return nil, errors.New("invalid setup communication")
+		}
+		pdu := int(binary.BigEndian.Uint16(params[6:]))
+		if s.PDULength > 0 && (pdu == 0 || pdu > s.PDULength) {
+			pdu = s.PDULength
+		}
+		out := []byte{0xF0, 0, 0, 1, 0, 1, 0, 0}
+		binary.BigEndian.PutUint16(out[6:], uint16(pdu))
+		return ackData(ref, out, nil), nil
+	case 0x04:
+		out, values, err := s.readVar(params)
+		if err != nil {
+			return nil, err
+		}
+		return ackData(ref, out, values), nil
+	case 0x05:
+		out, err := s.writeVar(params, data)
+		if err != nil {
+			return nil, err
+		}
+		return ackData(ref, out, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported S7 function 0x%02X", params[0])
+}
+
+// ackData 组装 Ack-Data 应答报文
+func ackData(ref uint16, params []byte, data []byte) []byte {
+	frame := make([]byte, 19, 19+len(params)+len(data))
+	frame[0], frame[3] = 3, 0
+	frame[4], frame[5], frame[6] = 2, 0xF0, 0x80
+	frame[7], frame[8] = 0x32, 3
+	binary.BigEndian.PutUint16(frame[11:], ref)
+	binary.BigEndian.PutUint16(frame[13:], uint16(len(params)))
+	binary.BigEndian.PutUint16(frame[15:], uint16(len(data)))
+	frame = append(frame, params...)
+	frame = append(frame, data...)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(frame)))
+	return frame
+}
+
+// item 一个变量项的地址
+type item struct {
+	wordLen int
+	amount  int
+	db      int
+	area    int
+	address int // 位地址
+}
+
+// size 请求的字节数，位访问只传送一个字节
+func (it item) size() int {
+	switch it.wordLen {
+	case wlBit:
+		return 1
+	case 0x02, 0x03: // byte / char
+		return it.amount
+	case 0x04, 0x05: // word / int
+		return it.amount * 2
+	case 0x06, 0x07, 0x08: // dword / dint / real
+		return it.amount * 4
+	}
+	return -1
+}
+
+// parseItems 解析 Read/Write Var 参数中的变量项
+func parseItems(params []byte) ([]item, error) {
+	if len(params) < 2 {
+		return nil, errors.New("invalid var parameters")
+	}
+	count := int(params[1])
+	if count == 0 || count > maxItems || len(params) < 2+count*12 {
+		return nil, fmt.Errorf("invalid item count %d", count)
+	}
+	items := make([]item, count)
+	for i := range items {
+		p := params[2+i*12:]
+		if p[0] != 0x12 || p[1] != 0x0A || p[2] != 0x10 {
+			return nil, errors.New("unsupported var specification")
+		}
+		items[i] = item{
+			wordLen: int(p[3]),
+			amount:  int(binary.BigEndian.Uint16(p[4:])),
+			db:      int(binary.BigEndian.Uint16(p[6:])),
+			area:    int(p[8]),
+			address: int(p[9])<<16 | int(p[10])<<8 | int(p[11]),
+		}
+	}
+	return items, nil
+}
+
+func (s *Server) readVar(params []byte) ([]byte, []byte, error) {
+	items, err := parseItems(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Reads++
+	s.stats.ReadItems += len(items)
+
+	var data []byte
+	for i, it := range items {
+		if i > 0 && len(data)%2 != 0 {
+			data = append(data, 0) // 奇数长度的项后补齐
+		}
+		value, ret := s.readItem(it)
+		if ret != retSuccess {
+			data = append(data, ret, 0, 0, 0)
+			continue
+		}
+		ts, length := byte(tsByte), len(value)*8
+		if it.wordLen == wlBit {
+			ts, length = tsBit, 1
+		}
+		data = append(data, retSuccess, ts, byte(length>>8), byte(length))
+		data = append(data, value...)
+	}
+	return []byte{0x04, byte(len(items))}, data, nil
+}
+
+// readItem 调用方需持有锁
+func (s *Server) readItem(it item) ([]byte, byte) {
+	mem, ret := s.memory(it.area, it.db)
+	if ret != retSuccess {
+		return nil, ret
+	}
+	size := it.size()
+	if size < 0 {
+		return nil, retDataTypeError
+	}
+	start := it.address >> 3
+	if start+size > len(mem) {
+		return nil, retAddressError
+	}
+	if it.wordLen == wlBit {
+		if mem[start]&(1<<(it.address&7)) != 0 {
+			return []byte{1}, retSuccess
+		}
+		return []byte{0}, retSuccess
+	}
+	return append([]byte(nil), mem[start:start+size]...), retSuccess
+}
+
+func (s *Server) writeVar(params []byte, data []byte) ([]byte, error) {
+	items, err := parseItems(params)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Writes++
+	s.stats.WriteItems += len(items)
+
+	out := []byte{0x05, byte(len(items))}
+	offset := 0
+	for i, it := range items {
+		if i > 0 && offset%2 != 0 {
+			offset++
+		}
+		if offset+4 > len(data) {
+			return nil, errors.New("truncated write data")
+		}
+		ts := data[offset+1]
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if ts != tsBit && ts != 0x09 {
+			length >>= 3 // 以位为单位
+		}
+		offset += 4
+		if offset+length > len(data) {
+			return nil, errors.New("truncated write data")
+		}
+		out = append(out, s.writeItem(it, data[offset:offset+length]))
+		offset += length
+	}
+	return out, nil
+}
+
+// writeItem 调用方需持有锁
+func (s *Server) writeItem(it item, value []byte) byte {
+	mem, ret := s.memory(it.area, it.db)
+	if ret != retSuccess {
+		return ret
+	}
+	size := it.size()
+	if size < 0 {
+		return retDataTypeError
+	}
+	start := it.address >> 3
+	if len(value) != size || start+size > len(mem) {
+		return retAddressError
+	}
+	if it.wordLen == wlBit {
+		bit := byte(1 << (it.address & 7))
+		if value[0] != 0 {
+			mem[start] |= bit
+		} else {
+			mem[start] &^= bit
+		}
+		return retSuccess
+	}
+	copy(mem[start:], value)
+	return retSuccess
+}
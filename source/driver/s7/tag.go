@@ -8,6 +8,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf16"
 
 	driver "acetek-mes/driver"
 )
@@ -42,30 +44,71 @@ type S7Tag struct {
 	Raw       string
 	ErrorInfo string
 	DataType  string
+	Count     int           // 数组元素个数，0 为单个值
+	Fields    []StructField // 结构体字段
 }
 
+// ParseAddress 解析地址，V 区按 DB1 处理
 func ParseAddress(address string) (*S7Tag, error) {
+	return parseAddress(address, defaultFamily.VAreaDB)
+}
+
+// parseAddress 解析地址，V 区映射到 vdb 指定的 DB
+func parseAddress(address string, vdb int) (*S7Tag, error) {
+	if tag, err := parseStructured(address, vdb); tag != nil || err != nil {
+		return tag, err
+	}
 	tag := &S7Tag{Raw: address, Length: 1}
 
 	dbRe := regexp.MustCompile(`(?i)^DB(\d+)\.DB([BXWD])(\d+)(?:\.(\d))?$`)
-	stringRe := regexp.MustCompile(`(?i)^DB(\d+)\.STRING(\d+)(?:\((\d+)\))?$`)
+	typedRe := regexp.MustCompile(`(?i)^DB(\d+)\.(STRING|WSTRING|CHAR|DTL|DATE_AND_TIME|DT|TIME|LREAL|LINT)(\d+)(?:\((\d+)\))?$`)
 	simpleRe := regexp.MustCompile(`(?i)^([PIQMVTCS])(\d+)(?:\.(\d))?$`)
 	memRe := regexp.MustCompile(`(?i)^([VMIQ])([BWD])(\d+)$`) // 新增支持 VD100 等格式
 
-	// 处理 STRING 类型
-	if match := stringRe.FindStringSubmatch(address); match != nil {
+	// 处理 STRING/WSTRING/CHAR 及 DTL 等按类型寻址的格式，例如 DB10.STRING20(30)、DB10.DTL0
+	if match := typedRe.FindStringSubmatch(address); match != nil {
 		db, _ := strconv.Atoi(match[1])
+		offset, _ := strconv.Atoi(match[3])
 		tag.DBNumber = db
 		tag.Area = AreaDB
 		tag.WordLen = Byte
-		offset, _ := strconv.Atoi(match[2])
-		tag.Length = 32
-		l, err := strconv.Atoi(match[3])
-		if err == nil && l > 0 && l <= 254 {
-			tag.Length = l + 2
-		}
-		tag.DataType = "STRING"
 		tag.Start = offset
+		l, err := strconv.Atoi(match[4])
+		if err != nil || l <= 0 {
+			l = 0
+		}
+		switch strings.ToUpper(match[2]) {
+		case "STRING":
+			tag.DataType = driver.TypeString
+			tag.Length = 256 // 未声明长度时按 STRING[254]
+			if l > 0 && l <= 254 {
+				tag.Length = l + 2
+			}
+		case "WSTRING":
+			tag.DataType = driver.TypeWString
+			tag.Length = 4 + 2*254
+			if l > 0 && l <= 16382 {
+				tag.Length = 4 + 2*l
+			}
+		case "CHAR":
+			tag.DataType = driver.TypeChars
+			tag.Length = max(l, 1)
+		case "DTL":
+			tag.DataType = driver.TypeDTL
+			tag.Length = 12
+		case "DT", "DATE_AND_TIME":
+			tag.DataType = driver.TypeDateTime
+			tag.Length = 8
+		case "TIME":
+			tag.DataType = driver.TypeTime
+			tag.Length = 4
+		case "LREAL":
+			tag.DataType = driver.TypeFloat64
+			tag.Length = 8
+		case "LINT":
+			tag.DataType = driver.TypeInt64
+			tag.Length = 8
+		}
 		tag.Valid = true
 		return tag, nil
 	}
@@ -82,19 +125,19 @@ func ParseAddress(address string) (*S7Tag, error) {
 		case "B":
 			tag.WordLen = Byte
 			tag.Length = 1
-			tag.DataType = "BYTE"
+			tag.DataType = driver.TypeByte
 		case "W":
 			tag.WordLen = Word
 			tag.Length = 2
-			tag.DataType = "WORD"
+			tag.DataType = driver.TypeUInt16
 		case "D":
 			tag.WordLen = DWord
 			tag.Length = 4
-			tag.DataType = "DWORD"
+			tag.DataType = driver.TypeUInt32
 		case "X":
 			tag.WordLen = Bit
 			tag.Length = 1
-			tag.DataType = "BOOL"
+			tag.DataType = driver.TypeBool
 			if match[4] != "" {
 				tag.Bit, _ = strconv.Atoi(match[4])
 			}
@@ -120,7 +163,7 @@ func ParseAddress(address string) (*S7Tag, error) {
 			tag.Area = AreaMK
 		case "V":
 			tag.Area = AreaDB
-			tag.DBNumber = 1 // 假定 V 区在 DB1，可调整
+			tag.DBNumber = vdb
 		default:
 			return nil, fmt.Errorf("unsupported area: %s", area)
 		}
@@ -129,15 +172,15 @@ func ParseAddress(address string) (*S7Tag, error) {
 		case "B":
 			tag.WordLen = Byte
 			tag.Length = 1
-			tag.DataType = "BYTE"
+			tag.DataType = driver.TypeByte
 		case "W":
 			tag.WordLen = Word
 			tag.Length = 2
-			tag.DataType = "WORD"
+			tag.DataType = driver.TypeUInt16
 		case "D":
 			tag.WordLen = DWord
 			tag.Length = 4
-			tag.DataType = "DWORD"
+			tag.DataType = driver.TypeUInt32
 		default:
 			return nil, fmt.Errorf("unsupported datatype: %s", dt)
 		}
@@ -155,11 +198,11 @@ func ParseAddress(address string) (*S7Tag, error) {
 			tag.Bit, _ = strconv.Atoi(match[3])
 			tag.WordLen = Bit
 			tag.Length = 1
-			tag.DataType = "BOOL"
+			tag.DataType = driver.TypeBool
 		} else {
 			tag.WordLen = Byte
 			tag.Length = 1
-			tag.DataType = "BYTE"
+			tag.DataType = driver.TypeByte
 		}
 
 		switch area {
@@ -171,7 +214,7 @@ func ParseAddress(address string) (*S7Tag, error) {
 			tag.Area = AreaMK
 		case "V":
 			tag.Area = AreaDB
-			tag.DBNumber = 1 // 同样假定为 DB1
+			tag.DBNumber = vdb
 		case "T":
 			tag.Area = AreaTM
 		case "C":
@@ -188,6 +231,9 @@ func ParseAddress(address string) (*S7Tag, error) {
 }
 
 func ParseValueFromBuffer(tag S7Tag, buffer []byte) (any, error) {
+	if tag.Count > 0 || len(tag.Fields) > 0 {
+		return parseStructuredValue(tag, buffer)
+	}
 	offset := 0
 	if offset < 0 || offset >= len(buffer) {
 		return nil, errors.New("byte offset out of bounds")
@@ -213,7 +259,7 @@ func ParseValueFromBuffer(tag S7Tag, buffer []byte) (any, error) {
 		if end > len(buffer) {
 			return nil, errors.New("length out of range for bytes")
 		}
-		return buffer[offset:end], nil
+		return append([]byte(nil), buffer[offset:end]...), nil
 
 	case driver.TypeInt16:
 		if offset+1 >= len(buffer) {
@@ -255,7 +301,92 @@ func ParseValueFromBuffer(tag S7Tag, buffer []byte) (any, error) {
 		}
 		return string(buffer[2 : 2+actualLen]), nil
 
+	case driver.TypeWString:
+		if len(buffer) < 4 {
+			return nil, errors.New("buffer too short for S7 wstring header")
+		}
+		actualLen := int(binary.BigEndian.Uint16(buffer[2:]))
+		if len(buffer) < 4+2*actualLen {
+			return nil, errors.New("buffer too short for wstring data")
+		}
+		chars := make([]uint16, actualLen)
+		for i := range chars {
+			chars[i] = binary.BigEndian.Uint16(buffer[4+2*i:])
+		}
+		return string(utf16.Decode(chars)), nil
+
+	case driver.TypeChars:
+		end := min(offset+tag.Length, len(buffer))
+		return strings.TrimRight(string(buffer[offset:end]), "\x00"), nil
+
+	case driver.TypeInt64:
+		if offset+7 >= len(buffer) {
+			return nil, errors.New("offset out of range for int64")
+		}
+		return int64(binary.BigEndian.Uint64(buffer[offset:])), nil
+
+	case driver.TypeFloat64:
+		if offset+7 >= len(buffer) {
+			return nil, errors.New("offset out of range for float64")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(buffer[offset:])), nil
+
+	case driver.TypeTime:
+		if offset+3 >= len(buffer) {
+			return nil, errors.New("offset out of range for time")
+		}
+		return time.Duration(int32(binary.BigEndian.Uint32(buffer[offset:]))) * time.Millisecond, nil
+
+	case driver.TypeDTL:
+		if offset+11 >= len(buffer) {
+			return nil, errors.New("offset out of range for dtl")
+		}
+		b := buffer[offset:]
+		return time.Date(int(binary.BigEndian.Uint16(b)), time.Month(b[2]), int(b[3]),
+			int(b[5]), int(b[6]), int(b[7]), int(binary.BigEndian.Uint32(b[8:])), time.Local), nil
+
+	case driver.TypeDateTime:
+		if offset+7 >= len(buffer) {
+			return nil, errors.New("offset out of range for date_and_time")
+		}
+		b := buffer[offset:]
+		year := fromBCD(b[0]) + 2000
+		if year >= 2090 {
+			year -= 100 // 90-99 表示 1990-1999
+		}
+		ms := fromBCD(b[6])*10 + int(b[7]>>4)
+		return time.Date(year, time.Month(fromBCD(b[1])), fromBCD(b[2]),
+			fromBCD(b[3]), fromBCD(b[4]), fromBCD(b[5]), ms*int(time.Millisecond), time.Local), nil
+
 	default:
 		return nil, errors.New("unsupported data type: " + string(tag.DataType))
 	}
 }
+
+func fromBCD(b byte) int {
+	return int(b>>4)*10 + int(b&0x0F)
+}
+
+// fitLength 按地址声明的长度检查字符串类型的编码，返回要写入的数据及其相对变量起始地址的偏移：
+// STRING/WSTRING 的最大长度由 PLC 定义，从实际长度开始写入，不覆盖最大长度；CHAR 数组补齐到定长
+func fitLength(tag S7Tag, data []byte) ([]byte, int, error) {
+	switch tag.DataType {
+	case driver.TypeString:
+		if int(data[1]) > tag.Length-2 {
+			return nil, 0, fmt.Errorf("string length %d exceeds STRING[%d]", data[1], tag.Length-2)
+		}
+		return data[1:], 1, nil
+	case driver.TypeWString:
+		maxLen := (tag.Length - 4) / 2
+		if n := int(binary.BigEndian.Uint16(data[2:])); n > maxLen {
+			return nil, 0, fmt.Errorf("wstring length %d exceeds WSTRING[%d]", n, maxLen)
+		}
+		return data[2:], 2, nil
+	case driver.TypeChars:
+		if len(data) > tag.Length {
+			return nil, 0, fmt.Errorf("chars length %d exceeds CHAR[%d]", len(data), tag.Length)
+		}
+		data = append(data, make([]byte, tag.Length-len(data))...)
+	}
+	return data, 0, nil
+}
@@ -0,0 +1,120 @@
+package s7
+
+import (
+	"testing"
+	"time"
+
+	driver "acetek-mes/driver"
+)
+
+func TestParseTypedAddress(t *testing.T) {
+	cases := map[string]struct {
+		datatype string
+		length   int
+	}{
+		"DB10.STRING20(30)": {driver.TypeString, 32},
+		"DB10.STRING20":     {driver.TypeString, 256},
+		"DB10.WSTRING0(10)": {driver.TypeWString, 24},
+		"DB10.CHAR4(16)":    {driver.TypeChars, 16},
+		"DB10.DTL40":        {driver.TypeDTL, 12},
+		"DB10.DT52":         {driver.TypeDateTime, 8},
+		"DB10.TIME60":       {driver.TypeTime, 4},
+		"DB10.LREAL64":      {driver.TypeFloat64, 8},
+		"DB10.LINT72":       {driver.TypeInt64, 8},
+		"DB10.DBW0":         {driver.TypeUInt16, 2},
+	}
+	for address, want := range cases {
+		tag, err := ParseAddress(address)
+		if err != nil || tag.DataType != want.datatype || tag.Length != want.length {
+			t.Errorf("%s: %+v %v", address, tag, err)
+		}
+	}
+}
+
+func TestDataTypeRoundTrip(t *testing.T) {
+	opened := time.Date(2025, 3, 18, 7, 45, 30, 123000000, time.Local)
+	cases := []struct {
+		address string
+		value   interface{}
+	}{
+		{"DB10.LINT0", int64(-9000000000)},
+		{"DB10.LREAL0", 3.141592653589793},
+		{"DB10.DTL0", opened},
+		{"DB10.DT0", opened},
+		{"DB10.TIME0", 90 * time.Minute},
+		{"DB10.STRING0(30)", "5AB-12"},
+		{"DB10.WSTRING0(20)", "开摆时间"},
+		{"DB10.CHAR0(8)", "LOT42"},
+	}
+	for _, c := range cases {
+		s7t, err := ParseAddress(c.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag := &driver.Tag{Name: c.address, Datatype: s7t.DataType}
+		data, err := tag.ConvertToBytes(c.value)
+		offset := 0
+		if err == nil {
+			data, offset, err = fitLength(*s7t, data)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		buf := make([]byte, valueSize(*s7t))
+		copy(buf[offset:], data)
+		got, err := ParseValueFromBuffer(*s7t, buf)
+		if err != nil {
+			t.Fatalf("%s: %v", c.address, err)
+		}
+		if tm, ok := got.(time.Time); ok {
+			if !tm.Equal(c.value.(time.Time)) {
+				t.Errorf("%s: got %v", c.address, got)
+			}
+		} else if got != c.value {
+			t.Errorf("%s: got %#v, want %#v", c.address, got, c.value)
+		}
+	}
+
+	// 超出声明长度
+	s7t, _ := ParseAddress("DB10.STRING0(4)")
+	data, _ := (&driver.Tag{Datatype: driver.TypeString}).ConvertToBytes("too long")
+	if _, _, err := fitLength(*s7t, data); err == nil {
+		t.Fatal("expect length error")
+	}
+}
+
+func TestStructuredTags(t *testing.T) {
+	words, err := ParseAddress("DB10.DBW0[48]")
+	if err != nil || words.Count != 48 || valueSize(*words) != 96 {
+		t.Fatalf("word array: %+v %v", words, err)
+	}
+	bits, err := ParseAddress("DB10.DBX2.3[10]")
+	if err != nil || bits.Count != 10 || valueSize(*bits) != 2 {
+		t.Fatalf("bit array: %+v %v", bits, err)
+	}
+	buf := []byte{0x08, 0x10}
+	got, err := ParseValueFromBuffer(*bits, buf)
+	values, _ := got.([]interface{})
+	if err != nil || len(values) != 10 || values[0] != true || values[1] != false || values[9] != true {
+		t.Fatalf("bits: %v %v", got, err)
+	}
+
+	pos, err := ParseAddress("DB10.DBB0{速度:float32@0,锭号:int16@4,运行:bool@6.0,断头:bool@6.1}[2]")
+	if err != nil || pos.DataType != driver.TypeStruct || pos.Length != 8 || valueSize(*pos) != 16 {
+		t.Fatalf("struct array: %+v %v", pos, err)
+	}
+	buf = []byte{0x42, 0xc8, 0, 0, 0, 1, 0x01, 0, 0x43, 0x48, 0, 0, 0, 2, 0x02, 0}
+	got, err = ParseValueFromBuffer(*pos, buf)
+	values, _ = got.([]interface{})
+	if err != nil || len(values) != 2 {
+		t.Fatalf("struct values: %v %v", got, err)
+	}
+	second := values[1].(map[string]interface{})
+	if second["速度"] != float32(200) || second["锭号"] != int16(2) || second["运行"] != false || second["断头"] != true {
+		t.Fatalf("struct element: %v", second)
+	}
+
+	if _, err := ParseAddress("DB10.DBB0{速度:decimal@0}"); err == nil {
+		t.Fatal("expect field type error")
+	}
+}
@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"fmt"
+	"math"
+)
+
+// scaling 返回线性换算系数：工程值 = 原始值 * gain + offset。
+// 同时配置量程与 Gain/Offset 时以量程为准。
+func (t *Tag) scaling() (gain float64, offset float64, ok bool) {
+	if t.hasRange() {
+		gain = (t.EngMax - t.EngMin) / (t.RawMax - t.RawMin)
+		return gain, t.EngMin - t.RawMin*gain, true
+	}
+	if t.Gain != 0 || t.Offset != 0 {
+		gain = t.Gain
+		if gain == 0 {
+			gain = 1
+		}
+		return gain, t.Offset, true
+	}
+	return 1, 0, false
+}
+
+func (t *Tag) hasRange() bool {
+	return t.RawMax != t.RawMin && t.EngMax != t.EngMin
+}
+
+func clamp(v, a, b float64) float64 {
+	return math.Max(math.Min(a, b), math.Min(math.Max(a, b), v))
+}
+
+// Scale 将驱动读到的原始值换算为工程值，未配置换算或非数值类型时原样返回
+func (t *Tag) Scale(raw interface{}) interface{} {
+	gain, offset, ok := t.scaling()
+	if !ok || t.Datatype == TypeBool {
+		return raw
+	}
+	v, ok := ToFloat64(raw)
+	if !ok {
+		return raw
+	}
+	eng := v*gain + offset
+	if t.Clamp && t.hasRange() {
+		eng = clamp(eng, t.EngMin, t.EngMax)
+	}
+	return eng
+}
+
+// Unscale 将写入的工程值反算为原始值，整数类型四舍五入，超出原始类型范围时报错
+func (t *Tag) Unscale(eng interface{}) (interface{}, error) {
+	gain, offset, ok := t.scaling()
+	if !ok || t.Datatype == TypeBool {
+		return eng, nil
+	}
+	v, ok := ToFloat64(eng)
+	if !ok {
+		s, isString := eng.(string)
+		if !isString {
+			return nil, fmt.Errorf("tag %s: scaled value must be numeric, got %T", t.Name, eng)
+		}
+		if _, err := fmt.Sscanf(s, "%g", &v); err != nil {
+			return nil, fmt.Errorf("tag %s: scaled value must be numeric: %s", t.Name, s)
+		}
+	}
+	if t.Clamp && t.hasRange() {
+		v = clamp(v, t.EngMin, t.EngMax)
+	}
+	raw := (v - offset) / gain
+	if t.Clamp && t.hasRange() {
+		raw = clamp(raw, t.RawMin, t.RawMax)
+	}
+
+	min, max, ok := IntRange(t.Datatype)
+	if !ok {
+		return raw, nil
+	}
+	raw = math.Round(raw)
+	if raw < min || raw > max {
+		return nil, fmt.Errorf("tag %s: raw value %v out of %s range", t.Name, raw, t.Datatype)
+	}
+	return raw, nil
+}
+
+// IntRange 整数类型的取值范围，非整数类型返回 false
+func IntRange(datatype string) (min float64, max float64, ok bool) {
+	switch datatype {
+	case TypeInt16:
+		return math.MinInt16, math.MaxInt16, true
+	case TypeUInt16:
+		return 0, math.MaxUint16, true
+	case TypeInt32:
+		return math.MinInt32, math.MaxInt32, true
+	case TypeUInt32:
+		return 0, math.MaxUint32, true
+	case TypeByte:
+		return 0, math.MaxUint8, true
+	case TypeInt64:
+		return math.MinInt64, math.MaxInt64, true
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,33 @@
+package driver
+
+import "testing"
+
+func TestScale(t *testing.T) {
+	// 4-20mA 模拟量 0-27648 对应 0-1.6MPa
+	tag := &Tag{Name: "压力", Datatype: TypeInt16, RawMin: 0, RawMax: 27648, EngMin: 0, EngMax: 1.6, Clamp: true, Unit: "MPa"}
+	if v := tag.Scale(int16(13824)); v != 0.8 {
+		t.Fatalf("scale: %v", v)
+	}
+	if v := tag.Scale(int16(32000)); v != 1.6 {
+		t.Fatalf("clamp: %v", v)
+	}
+	if raw, err := tag.Unscale(0.8); err != nil || raw != float64(13824) {
+		t.Fatalf("unscale: %v %v", raw, err)
+	}
+	if raw, err := tag.Unscale("2.0"); err != nil || raw != float64(27648) {
+		t.Fatalf("unscale clamp: %v %v", raw, err)
+	}
+
+	height := &Tag{Name: "丝包高度", Datatype: TypeUInt16, Gain: 0.1, Offset: -5}
+	if v := height.Scale(uint16(1050)); v != 100.0 {
+		t.Fatalf("gain/offset: %v", v)
+	}
+	if _, err := height.Unscale(10000); err == nil {
+		t.Fatal("expect out of range error")
+	}
+
+	plain := &Tag{Datatype: TypeFloat32}
+	if v := plain.Scale(float32(1.5)); v != float32(1.5) {
+		t.Fatalf("no scaling: %v", v)
+	}
+}
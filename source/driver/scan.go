@@ -0,0 +1,214 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScanFast   string = "fast"
+	ScanNormal string = "normal"
+	ScanSlow   string = "slow"
+
+	ScanFastInterval uint32 = 100
+	ScanSlowInterval uint32 = 5000
+)
+
+// ScanInterval 将扫描类别转换为毫秒，空或 normal 使用驱动的 interval
+func ScanInterval(class string, interval uint32) (uint32, error) {
+	switch strings.ToLower(strings.TrimSpace(class)) {
+	case "", ScanNormal:
+		return interval, nil
+	case ScanFast:
+		return ScanFastInterval, nil
+	case ScanSlow:
+		return ScanSlowInterval, nil
+	}
+	ms, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(class), "ms"))
+	if err != nil || ms <= 0 {
+		return interval, fmt.Errorf("invalid scan class: %s", class)
+	}
+	return uint32(ms), nil
+}
+
+// ScanGroup 相同扫描周期的一组变量，由驱动的扫描循环独立调度
+type ScanGroup struct {
+	Interval time.Duration
+	Tags     []*Tag
+	next     time.Time
+
+	Cycles       uint64        // 已执行的扫描次数
+	Overruns     uint64        // 读取耗时或排队导致错过下一周期的次数
+	Skipped      uint64        // 因超时被跳过的周期数
+	LastDuration time.Duration // 最近一次读取耗时
+	MaxDuration  time.Duration // 最大读取耗时
+	LastOverrun  time.Time
+}
+
+func (g *ScanGroup) Name() string {
+	return fmt.Sprintf("%dms", g.Interval.Milliseconds())
+}
+
+// record 记录一次扫描，计算下一次执行时间
+func (g *ScanGroup) record(start time.Time, duration time.Duration) bool {
+	g.Cycles++
+	g.LastDuration = duration
+	if duration > g.MaxDuration {
+		g.MaxDuration = duration
+	}
+	g.next = g.next.Add(g.Interval)
+	now := start.Add(duration)
+	if !g.next.Before(now) {
+		return false
+	}
+	missed := uint64(now.Sub(g.next)/g.Interval) + 1
+	g.Overruns++
+	g.Skipped += missed
+	g.next = g.next.Add(time.Duration(missed) * g.Interval)
+	g.LastOverrun = now
+	return true
+}
+
+// InitScanGroups 按变量的扫描类别分组，需在 Tags 确定后调用
+func (d *Driver) InitScanGroups() {
+	groups := make(map[uint32]*ScanGroup)
+	names := make([]string, 0, len(d.Tags))
+	for name := range d.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tag := d.Tags[name]
+		interval, err := ScanInterval(tag.ScanClass, d.Interval)
+		if err != nil {
+			log.Printf("driver %s tag %s: %v, use %dms", d.ID, name, err, interval)
+		}
+		if interval == 0 {
+			interval = 1000
+		}
+		g, ok := groups[interval]
+		if !ok {
+			g = &ScanGroup{Interval: time.Duration(interval) * time.Millisecond}
+			groups[interval] = g
+		}
+		g.Tags = append(g.Tags, tag)
+	}
+	d.Groups = make([]*ScanGroup, 0, len(groups))
+	for _, g := range groups {
+		d.Groups = append(d.Groups, g)
+	}
+	sort.Slice(d.Groups, func(i, j int) bool {
+		return d.Groups[i].Interval < d.Groups[j].Interval
+	})
+}
+
+// Run 扫描循环：各扫描组按各自周期读取，写入请求与配置更新在同一协程中串行处理。
+// ctx 取消或收到 stop 命令后返回。
+func (d *Driver) Run(ctx context.Context, readGroup func(*ScanGroup) (map[string]interface{}, error), write func(map[string]interface{}) error) {
+	now := time.Now()
+	for _, g := range d.Groups {
+		g.next = now
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			d.scan(ctx, readGroup)
+			timer.Reset(time.Until(d.nextWake()))
+		case command := <-d.ChCommand:
+			switch command {
+			case "stop":
+				return
+			default:
+				break
+			}
+		case cfg := <-d.ChConfig:
+			d.applyConfig(cfg)
+			timer.Reset(time.Until(d.nextScan()))
+		case req := <-d.ChWrite:
+			d.ChWriteResult <- d.handleWrite(req, readGroup, write)
+		}
+	}
+}
+
+// scan 读取到期的扫描组。连接错误或连续读取失败时进入 offline，退避结束前不再读取。
+// 本轮读取的变量在最后一起上报，输出每个周期只写一批
+func (d *Driver) scan(ctx context.Context, readGroup func(*ScanGroup) (map[string]interface{}, error)) {
+	cycleStart := time.Now()
+	if d.waiting(cycleStart) {
+		return
+	}
+	d.reconnecting(cycleStart)
+	ran, failed := false, false
+	var due []*Tag
+	flush := func() {
+		if len(due) > 0 {
+			d.UpdateTags(due)
+			d.FireTriggers(due)
+			due = nil
+		}
+	}
+	for _, g := range d.Groups {
+		if ctx.Err() != nil {
+			flush()
+			return
+		}
+		start := time.Now()
+		if start.Before(g.next) {
+			continue
+		}
+		ran = true
+		if _, err := readGroup(g); err != nil {
+			log.Println("read error:", err)
+			d.FailCount++
+			if IsConnError(err) || d.FailCount > maxReadFailures {
+				flush()
+				d.offline(err, start)
+				return
+			}
+			failed = true
+			d.recordError(err, start)
+		} else {
+			d.FailCount = 0
+			d.LastPing = time.Now()
+		}
+		due = append(due, g.Tags...)
+		if g.record(start, time.Since(start)) && g.Overruns%100 == 1 {
+			log.Printf("driver %s scan group %s overrun: %d overruns, %d skipped, last %v, max %v",
+				d.ID, g.Name(), g.Overruns, g.Skipped, g.LastDuration, g.MaxDuration)
+		}
+	}
+	flush()
+	if ran {
+		d.cycleDone(time.Since(cycleStart), failed, time.Now())
+	}
+}
+
+// nextWake offline 时等到退避结束，否则等到最近一个扫描组到期
+func (d *Driver) nextWake() time.Time {
+	if s := d.Status(); s.State == StateOffline {
+		return s.RetryAt
+	}
+	return d.nextScan()
+}
+
+func (d *Driver) nextScan() time.Time {
+	var next time.Time
+	for _, g := range d.Groups {
+		if next.IsZero() || g.next.Before(next) {
+			next = g.next
+		}
+	}
+	if next.IsZero() {
+		next = time.Now().Add(time.Second)
+	}
+	return next
+}
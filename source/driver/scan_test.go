@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanInterval(t *testing.T) {
+	cases := map[string]uint32{"": 1000, "normal": 1000, "FAST": ScanFastInterval, "slow": ScanSlowInterval, "250": 250, "3000ms": 3000}
+	for class, want := range cases {
+		if got, err := ScanInterval(class, 1000); err != nil || got != want {
+			t.Errorf("%q: got %d, %v", class, got, err)
+		}
+	}
+	if _, err := ScanInterval("-5", 1000); err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestScanGroups(t *testing.T) {
+	d := &Driver{
+		Interval: 1000,
+		Tags: map[string]*Tag{
+			"落丝信号": {Name: "落丝信号", ScanClass: ScanFast},
+			"断带标识": {Name: "断带标识", ScanClass: "100"},
+			"开摆时间": {Name: "开摆时间", ScanClass: ScanSlow},
+			"丝包高度": {Name: "丝包高度"},
+		},
+	}
+	d.InitScanGroups()
+	if len(d.Groups) != 3 || len(d.Groups[0].Tags) != 2 || d.Groups[2].Interval != 5*time.Second {
+		t.Fatalf("groups: %+v", d.Groups)
+	}
+
+	g := d.Groups[0]
+	start := time.Now()
+	g.next = start
+	if g.record(start, 50*time.Millisecond) || g.next != start.Add(100*time.Millisecond) {
+		t.Fatalf("unexpected overrun: %+v", g)
+	}
+	if !g.record(g.next, 250*time.Millisecond) || g.Overruns != 1 || g.Skipped != 2 || g.next != start.Add(400*time.Millisecond) {
+		t.Fatalf("overrun not recorded: %+v", g)
+	}
+}
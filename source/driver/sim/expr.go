@@ -0,0 +1,244 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Generator 按驱动启动后经过的时间生成变量的原始值
+type Generator interface {
+	Value(elapsed time.Duration) interface{}
+}
+
+var exprRe = regexp.MustCompile(`^\s*(\w+)\s*\((.*)\)\s*$`)
+
+// ParseExpr 解析仿真变量的地址表达式：
+//
+//	sine(幅值, 周期[, 偏移])       正弦波
+//	ramp(起点, 终点, 周期)         锯齿波，每个周期从起点线性变化到终点
+//	random(最小, 最大)             均匀分布随机数
+//	toggle(周期)                   每个周期翻转一次的 bool
+//	const(值)                      常量，可为数值或字符串
+//	csv(文件, 列[, 步长])          按步长（默认 1s）循环回放 CSV 的一列，列为列名（首行为表头）或从 0 开始的序号
+//
+// 周期与步长可写为 5s、500ms，纯数字按秒
+func ParseExpr(expr string) (Generator, error) {
+	m := exprRe.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid sim expression: %s", expr)
+	}
+	name := strings.ToLower(m[1])
+	if name == "const" {
+		return constant{value: strings.TrimSpace(m[2])}, nil
+	}
+	var args []string
+	if strings.TrimSpace(m[2]) != "" {
+		args = strings.Split(m[2], ",")
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+	}
+	switch name {
+	case "sine":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("sine(幅值, 周期[, 偏移]): %s", expr)
+		}
+		g := sine{}
+		var err error
+		if g.amplitude, err = parseFloat(args[0]); err != nil {
+			return nil, err
+		}
+		if g.period, err = parsePeriod(args[1]); err != nil {
+			return nil, err
+		}
+		if len(args) == 3 {
+			if g.offset, err = parseFloat(args[2]); err != nil {
+				return nil, err
+			}
+		}
+		return g, nil
+	case "ramp":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("ramp(起点, 终点, 周期): %s", expr)
+		}
+		g := ramp{}
+		var err error
+		if g.from, err = parseFloat(args[0]); err != nil {
+			return nil, err
+		}
+		if g.to, err = parseFloat(args[1]); err != nil {
+			return nil, err
+		}
+		if g.period, err = parsePeriod(args[2]); err != nil {
+			return nil, err
+		}
+		return g, nil
+	case "random":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("random(最小, 最大): %s", expr)
+		}
+		g := random{}
+		var err error
+		if g.min, err = parseFloat(args[0]); err != nil {
+			return nil, err
+		}
+		if g.max, err = parseFloat(args[1]); err != nil {
+			return nil, err
+		}
+		return g, nil
+	case "toggle":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("toggle(周期): %s", expr)
+		}
+		period, err := parsePeriod(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return toggle{period: period}, nil
+	case "csv":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("csv(文件, 列[, 步长]): %s", expr)
+		}
+		step := time.Second
+		if len(args) == 3 {
+			var err error
+			if step, err = parsePeriod(args[2]); err != nil {
+				return nil, err
+			}
+		}
+		return loadReplay(args[0], args[1], step)
+	}
+	return nil, fmt.Errorf("unknown sim function: %s", m[1])
+}
+
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %s", s)
+	}
+	return f, nil
+}
+
+func parsePeriod(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("invalid period: %s", s)
+		}
+		d = time.Duration(f * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("period must be positive: %s", s)
+	}
+	return d, nil
+}
+
+// phase 当前周期内已经过的比例 [0, 1)
+func phase(elapsed, period time.Duration) float64 {
+	return float64(elapsed%period) / float64(period)
+}
+
+type sine struct {
+	amplitude float64
+	period    time.Duration
+	offset    float64
+}
+
+func (g sine) Value(elapsed time.Duration) interface{} {
+	return g.offset + g.amplitude*math.Sin(2*math.Pi*phase(elapsed, g.period))
+}
+
+type ramp struct {
+	from, to float64
+	period   time.Duration
+}
+
+func (g ramp) Value(elapsed time.Duration) interface{} {
+	return g.from + (g.to-g.from)*phase(elapsed, g.period)
+}
+
+type random struct {
+	min, max float64
+}
+
+func (g random) Value(time.Duration) interface{} {
+	return g.min + (g.max-g.min)*rand.Float64()
+}
+
+type toggle struct {
+	period time.Duration
+}
+
+func (g toggle) Value(elapsed time.Duration) interface{} {
+	return (elapsed/g.period)%2 == 1
+}
+
+type constant struct {
+	value string
+}
+
+func (g constant) Value(time.Duration) interface{} {
+	return g.value
+}
+
+// replay 循环回放 CSV 中的一列
+type replay struct {
+	values []string
+	step   time.Duration
+}
+
+func (g replay) Value(elapsed time.Duration) interface{} {
+	return g.values[int(elapsed/g.step)%len(g.values)]
+}
+
+func loadReplay(path string, column string, step time.Duration) (Generator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", path, err)
+	}
+	index, err := strconv.Atoi(column)
+	if err == nil && index < 0 {
+		return nil, fmt.Errorf("invalid column: %s", column)
+	}
+	if err != nil {
+		// 按列名查找，首行为表头
+		if len(records) == 0 {
+			return nil, fmt.Errorf("%s is empty", path)
+		}
+		index = -1
+		for i, h := range records[0] {
+			if strings.TrimSpace(h) == column {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("%s: column %s not found", path, column)
+		}
+		records = records[1:]
+	}
+	g := replay{step: step}
+	for _, row := range records {
+		if index < len(row) {
+			g.values = append(g.values, strings.TrimSpace(row[index]))
+		}
+	}
+	if len(g.values) == 0 {
+		return nil, fmt.Errorf("%s: column %s has no values", path, column)
+	}
+	return g, nil
+}
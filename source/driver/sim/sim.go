@@ -0,0 +1,281 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"acetek-mes/driver"
+
+	"github.com/yxcloud1/go-comm/logger"
+)
+
+// SimClient 仿真驱动，变量值由地址表达式生成，用于在没有 PLC 的环境下调试整条数据链路
+type SimClient struct {
+	driver.Driver
+	start  time.Time
+	held   map[string]interface{} // 写入后保持的原始值，覆盖表达式
+	faults faults
+	now    func() time.Time
+}
+
+// faults 故障注入，概率取值 0~1
+type faults struct {
+	timeout    float64       // 整组读取超时的概率
+	bad        float64       // 单个变量质量为 Bad 的概率
+	disconnect float64       // 连接断开的概率
+	latency    time.Duration // 每次读取的延迟
+}
+
+// sim://local?interval=1000&timeout=0.05&bad=0.1&disconnect=0.01&latency=20ms
+func NewSimClient(id string, name string, rawURL string, tags []*driver.Tag) (driver.IDriver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	var interval uint32 = 1000
+	if i := q.Get("interval"); i != "" {
+		if intval, err := strconv.Atoi(i); err == nil {
+			interval = uint32(intval)
+		}
+	}
+	var f faults
+	for key, p := range map[string]*float64{"timeout": &f.timeout, "bad": &f.bad, "disconnect": &f.disconnect} {
+		if s := q.Get(key); s != "" {
+			if *p, err = strconv.ParseFloat(s, 64); err != nil || *p < 0 || *p > 1 {
+				return nil, fmt.Errorf("invalid %s probability: %s", key, s)
+			}
+		}
+	}
+	if s := q.Get("latency"); s != "" {
+		if f.latency, err = parsePeriod(s); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &SimClient{
+		held:   make(map[string]interface{}),
+		faults: f,
+		now:    time.Now,
+		Driver: driver.Driver{
+			ID:            id,
+			Name:          name,
+			Tags:          driver.TagMap(parseTags(tags)),
+			Interval:      interval,
+			ChCommand:     make(chan string, 100),
+			ChWrite:       make(chan *driver.WriteRequest, 100),
+			ChWriteResult: make(chan error),
+			ChConfig:      make(chan *driver.Config, 1),
+		},
+	}
+	c.start = c.now()
+	c.InitScanGroups()
+	return c, nil
+}
+
+// parseTags 解析变量的表达式，返回解析成功的变量
+func parseTags(tags []*driver.Tag) []*driver.Tag {
+	var result []*driver.Tag
+	for _, v := range tags {
+		if g, err := ParseExpr(v.Address); err == nil {
+			v.Parsed = true
+			v.Mate = g
+			result = append(result, v)
+		} else {
+			v.Parsed = false
+			v.Mate = nil
+			log.Println("parse sim expression error:", err)
+		}
+	}
+	return result
+}
+
+// 注册仿真驱动
+func init() {
+	logger.TxtLog("register driver sim")
+	driver.RegisterDriver("sim", NewSimClient)
+}
+
+func (c *SimClient) Connect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = true
+	return nil
+}
+
+func (c *SimClient) Disconnect() error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	c.Connected = false
+	return nil
+}
+
+func (c *SimClient) IsConnected() bool {
+	return c.Connected
+}
+
+func (c *SimClient) Read() (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, g := range c.Groups {
+		values, err := c.ReadGroup(g)
+		for k, v := range values {
+			result[k] = v
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (c *SimClient) ReadGroup(g *driver.ScanGroup) (map[string]interface{}, error) {
+	if c.faults.latency > 0 {
+		time.Sleep(c.faults.latency)
+	}
+	if err := c.Connect(); err != nil {
+		return nil, err
+	}
+	if hit(c.faults.disconnect) {
+		c.Disconnect()
+		return nil, fmt.Errorf("%w: sim disconnect", driver.ErrConnection)
+	}
+	if hit(c.faults.timeout) {
+		return nil, fmt.Errorf("sim read %s: %w", g.Name(), os.ErrDeadlineExceeded)
+	}
+
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	now := c.now()
+	elapsed := now.Sub(c.start)
+	result := make(map[string]interface{})
+	for _, v := range g.Tags {
+		gen, ok := v.Mate.(Generator)
+		if !ok {
+			continue
+		}
+		raw, held := c.held[v.Name]
+		if !held {
+			raw = gen.Value(elapsed)
+		}
+		value, err := cast(v, raw)
+		v.Timestamp = now
+		if err != nil || hit(c.faults.bad) {
+			if err != nil {
+				log.Println("sim tag", v.Name, err)
+			}
+			v.Quality = "Bad"
+			v.Value = nil
+		} else {
+			v.Quality = "Good"
+			v.Value = v.Scale(value)
+		}
+		result[v.Name] = v.Value
+	}
+	return result, nil
+}
+
+func hit(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// cast 将表达式生成的值转换为变量的数据类型
+func cast(t *driver.Tag, raw interface{}) (interface{}, error) {
+	switch t.Datatype {
+	case driver.TypeBool:
+		switch v := raw.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		if f, ok := toFloat(raw); ok {
+			return f != 0, nil
+		}
+	case driver.TypeString, driver.TypeChars, driver.TypeWString:
+		return fmt.Sprintf("%v", raw), nil
+	case driver.TypeByte, driver.TypeInt16, driver.TypeUInt16, driver.TypeInt32, driver.TypeUInt32, driver.TypeInt64:
+		if f, ok := toFloat(raw); ok {
+			f = math.Round(f)
+			if min, max, _ := driver.IntRange(t.Datatype); f < min || f > max {
+				return nil, fmt.Errorf("%v out of %s range", raw, t.Datatype)
+			}
+			return t.ConvertValue(f), nil
+		}
+	case driver.TypeFloat32, driver.TypeFloat64:
+		if f, ok := toFloat(raw); ok {
+			return t.ConvertValue(f), nil
+		}
+	default:
+		if v := t.ConvertValue(raw); v != nil {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to %s", raw, t.Datatype)
+}
+
+func toFloat(raw interface{}) (float64, bool) {
+	if s, ok := raw.(string); ok {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+	return driver.ToFloat64(raw)
+}
+
+func (c *SimClient) Write(name string, value interface{}) error {
+	return c.Submit(&driver.WriteRequest{
+		Values: map[string]interface{}{name: value},
+	})
+}
+
+func (c *SimClient) write(values map[string]interface{}) error {
+	for k, v := range values {
+		if err := c.WriteTag(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTag 写入的值保持不变，直到再次写入或驱动重启
+func (c *SimClient) WriteTag(name string, value interface{}) error {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+
+	tag, ok := c.Tags[name]
+	if !ok {
+		return fmt.Errorf("tag %s is not defined", name)
+	}
+	if !tag.Writable {
+		return fmt.Errorf("tag %s is readonly", name)
+	}
+	if !c.Connected {
+		return fmt.Errorf("%w: sim disconnected", driver.ErrConnection)
+	}
+	raw, err := tag.Unscale(value)
+	if err != nil {
+		return err
+	}
+	if _, err := cast(tag, raw); err != nil {
+		return err
+	}
+	c.held[name] = raw
+	return nil
+}
+
+func (c *SimClient) Start(ctx context.Context) error {
+	return c.Go(ctx, c.ReadGroup, c.write, func() { c.Disconnect() })
+}
+
+func (c *SimClient) Reconfig(cfg *driver.Config) error {
+	cfg.Tags = parseTags(cfg.Tags)
+	return c.Driver.Reconfig(cfg)
+}
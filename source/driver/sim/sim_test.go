@@ -0,0 +1,133 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"acetek-mes/driver"
+)
+
+func TestParseExpr(t *testing.T) {
+	csvFile := filepath.Join(t.TempDir(), "weight.csv")
+	if err := os.WriteFile(csvFile, []byte("ts,weight\n1,10.5\n2,11\n3,12.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		expr    string
+		elapsed time.Duration
+		want    interface{}
+	}{
+		{"sine(10,60s)", 15 * time.Second, 10.0},
+		{"sine(10, 60, 50)", 45 * time.Second, 40.0},
+		{"ramp(0,100,5s)", 2500 * time.Millisecond, 50.0},
+		{"ramp(0,100,5s)", 5 * time.Second, 0.0},
+		{"toggle(3s)", time.Second, false},
+		{"toggle(3s)", 4 * time.Second, true},
+		{"const(DZR-01, A)", time.Hour, "DZR-01, A"},
+		{"csv(" + csvFile + ", weight)", 1500 * time.Millisecond, "11"},
+		{"csv(" + csvFile + ", 1, 500ms)", 1500 * time.Millisecond, "12.5"},
+	}
+	for _, c := range cases {
+		g, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		got := g.Value(c.elapsed)
+		if f, ok := got.(float64); ok {
+			got = math.Round(f*1e6) / 1e6
+		}
+		if got != c.want {
+			t.Errorf("%s at %v: got %v, want %v", c.expr, c.elapsed, got, c.want)
+		}
+	}
+	g, _ := ParseExpr("random(5, 6)")
+	if v := g.Value(0).(float64); v < 5 || v >= 6 {
+		t.Errorf("random: %v", v)
+	}
+	for _, expr := range []string{"DB1.DBW0", "sine(10)", "ramp(0,1,-1s)", "noise(1)", "csv(missing.csv, 0)"} {
+		if _, err := ParseExpr(expr); err == nil {
+			t.Errorf("%s: expect error", expr)
+		}
+	}
+}
+
+func TestSimClient(t *testing.T) {
+	tags := []*driver.Tag{
+		{Name: "速度", Address: "ramp(0,1000,10s)", Datatype: driver.TypeInt16, Gain: 0.1},
+		{Name: "运行", Address: "toggle(1s)", Datatype: driver.TypeBool, Writable: true},
+		{Name: "设定", Address: "const(25)", Datatype: driver.TypeFloat32, Writable: true},
+		{Name: "错误", Address: "DB1.DBW0", Datatype: driver.TypeInt16},
+		{Name: "溢出", Address: "const(40000)", Datatype: driver.TypeInt16},
+	}
+	c, err := NewSimClient("sim1", "仿真", "sim://local?interval=50", tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*SimClient)
+	if _, ok := client.GetTag("错误"); ok {
+		t.Fatal("invalid expression should be skipped")
+	}
+	start := client.start
+	client.now = func() time.Time { return start.Add(2500 * time.Millisecond) }
+
+	values, err := client.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["速度"] != 25.0 || values["运行"] != false || values["设定"] != float32(25) {
+		t.Fatalf("unexpected values: %+v", values)
+	}
+	// 超出数据类型范围的值不回绕，质量为 Bad
+	if tag, _ := client.GetTag("溢出"); values["溢出"] != nil || tag.Quality != "Bad" {
+		t.Fatalf("out of range: %v %s", values["溢出"], tag.Quality)
+	}
+
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+	if err := client.Write("设定", 30.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Write("运行", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Write("速度", 10); err == nil {
+		t.Fatal("readonly tag written")
+	}
+	if tag, _ := client.GetTag("设定"); tag.Value != float32(30.5) {
+		t.Fatalf("read back: %v", tag.Value)
+	}
+}
+
+func TestSimFaults(t *testing.T) {
+	tags := []*driver.Tag{{Name: "温度", Address: "sine(5,60s,20)", Datatype: driver.TypeFloat32}}
+	c, err := NewSimClient("sim2", "", "sim://local?bad=1", tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := c.(*SimClient)
+	if _, err := client.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if tag, _ := client.GetTag("温度"); tag.Quality != "Bad" {
+		t.Fatalf("quality: %s", tag.Quality)
+	}
+
+	client.faults = faults{timeout: 1}
+	if _, err := client.Read(); !errors.Is(err, os.ErrDeadlineExceeded) || driver.IsConnError(err) {
+		t.Fatalf("timeout: %v", err)
+	}
+	client.faults = faults{disconnect: 1}
+	if _, err := client.Read(); !driver.IsConnError(err) || client.IsConnected() {
+		t.Fatalf("disconnect: %v", err)
+	}
+	if _, err := NewSimClient("sim3", "", "sim://local?bad=2", tags); err == nil {
+		t.Fatal("expect invalid probability")
+	}
+}
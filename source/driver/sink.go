@@ -0,0 +1,275 @@
+package driver
+
+import (
+	"acetek-mes/redishelper"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+)
+
+// Point 上报给输出的一个变量值
+type Point = redishelper.Point
+
+// Sink 变量值的输出目标，由扫描协程调用
+type Sink interface {
+	// Write stream 为 false 表示死区内的小幅变化，只需刷新最新值，不必写历史
+	Write(driverID string, p Point, stream bool) error
+	Close() error
+}
+
+// BatchSink 支持批量写入的输出另外实现，扫描协程每个周期调用一次，p.Stream 为 false 时只刷新最新值。
+// 部分变量失败时返回 *redishelper.BatchError
+type BatchSink interface {
+	WriteBatch(driverID string, points []Point) error
+}
+
+// StatusSink 需要驱动连接状态的输出另外实现，状态变化时立即调用，未变化时定期刷新，
+// 例如 Redis 的状态哈希、MQTT 的 birth/death 消息
+type StatusSink interface {
+	WriteStatus(driverID string, s Status) error
+}
+
+// EventSink 需要触发器事件的输出另外实现。事件按顺序写入，失败时该输出下次从失败的事件开始重发
+type EventSink interface {
+	WriteEvent(driverID string, e *Event) error
+}
+
+// StateSink 能保存触发器状态的输出另外实现（Redis），进程重启后据此继续判断边沿、延续事件序号。
+// 驱动的输出都没有实现时，触发器状态只在内存中，重启后以第一个值为基准，序号从 1 开始
+type StateSink interface {
+	SaveTriggerState(driverID, trigger, state string) error
+	LoadTriggerState(driverID string) (map[string]string, error)
+}
+
+type SinkConstructor func(rawURL string) (Sink, error)
+
+var sinkRegistry = map[string]SinkConstructor{
+	"redis":  func(string) (Sink, error) { return RedisSink{}, nil },
+	"memory": func(string) (Sink, error) { return NewMemorySink(), nil },
+}
+
+// RegisterSink 按 URL scheme 注册输出，与驱动注册方式相同
+func RegisterSink(scheme string, constructor SinkConstructor) {
+	sinkRegistry[scheme] = constructor
+}
+
+func NewSink(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if constructor, ok := sinkRegistry[u.Scheme]; ok {
+		return constructor(rawURL)
+	}
+	return nil, fmt.Errorf("unknown sink: %s", u.Scheme)
+}
+
+// defaultSinks 未配置输出的驱动写 Redis
+var defaultSinks = []Sink{RedisSink{}}
+
+// MaxSinkRetry 每个输出最多缓存的待重发变量值，超过时丢弃最早的
+const MaxSinkRetry = 1000
+
+// SetSinks 设置驱动的输出，需在 Start 之前调用
+func (d *Driver) SetSinks(sinks []Sink) {
+	d.sinks = sinks
+	d.retry = nil
+}
+
+// sinkList 驱动的输出，未设置时为 defaultSinks
+func (d *Driver) sinkList() []Sink {
+	if d.sinks == nil {
+		return defaultSinks
+	}
+	return d.sinks
+}
+
+// emit 写入所有输出，规则与 emitBatch 相同
+func (d *Driver) emit(p Point, stream bool) error {
+	p.Stream = stream
+	return d.emitBatch([]Point{p})[0]
+}
+
+// emitBatch 批量写入所有输出，返回每个变量的结果。只要有一个输出成功就算已上报，变量不再重复上报，
+// 失败的输出把该值放入自己的重发队列，下次写入时先补发；全部失败时返回错误，下一个周期重新上报
+func (d *Driver) emitBatch(points []Point) []error {
+	sinks := d.sinkList()
+	if len(d.retry) != len(sinks) {
+		d.retry = make([][]Point, len(sinks))
+	}
+	errs := make([][]error, len(points))
+	failed := make([][]int, len(sinks))
+	for si, s := range sinks {
+		retry := d.retry[si]
+		batch := points
+		if len(retry) > 0 {
+			batch = append(append([]Point(nil), retry...), points...)
+		}
+		var requeue []Point
+		for i, err := range writeSink(s, d.ID, batch) {
+			switch {
+			case err == nil:
+			case i < len(retry):
+				requeue = append(requeue, retry[i])
+			default:
+				errs[i-len(retry)] = append(errs[i-len(retry)], err)
+				failed[si] = append(failed[si], i-len(retry))
+			}
+		}
+		d.retry[si] = requeue
+	}
+	result := make([]error, len(points))
+	for i := range points {
+		if len(sinks) > 0 && len(errs[i]) == len(sinks) {
+			result[i] = errors.Join(errs[i]...)
+		}
+	}
+	for si := range sinks {
+		for _, i := range failed[si] {
+			if result[i] == nil {
+				d.retry[si] = append(d.retry[si], points[i])
+			}
+		}
+		if n := len(d.retry[si]); n > MaxSinkRetry {
+			log.Printf("driver %s sink %d: drop %d points", d.ID, si, n-MaxSinkRetry)
+			d.retry[si] = d.retry[si][n-MaxSinkRetry:]
+		}
+	}
+	return result
+}
+
+// writeSink 写入一个输出，返回每个变量的错误
+func writeSink(s Sink, driverID string, points []Point) []error {
+	errs := make([]error, len(points))
+	if bs, ok := s.(BatchSink); ok {
+		err := bs.WriteBatch(driverID, points)
+		var be *redishelper.BatchError
+		if errors.As(err, &be) {
+			copy(errs, be.Errors)
+		} else if err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+	for i, p := range points {
+		errs[i] = s.Write(driverID, p, p.Stream)
+	}
+	return errs
+}
+
+// emitStatus 把状态交给实现了 StatusSink 的输出，返回各输出的错误
+func (d *Driver) emitStatus(st Status) error {
+	var errs []error
+	for _, v := range d.sinkList() {
+		if ss, ok := v.(StatusSink); ok {
+			if err := ss.WriteStatus(d.ID, st); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stateSink 保存触发器状态的输出，没有时返回 nil
+func (d *Driver) stateSink() StateSink {
+	for _, v := range d.sinkList() {
+		if ss, ok := v.(StateSink); ok {
+			return ss
+		}
+	}
+	return nil
+}
+
+// RedisSink 写 real: 哈希与 stream:，即原来的行为
+type RedisSink struct{}
+
+func (RedisSink) Write(driverID string, p Point, stream bool) error {
+	return redishelper.Instance().SetRealtimePoint(driverID, p, stream)
+}
+
+// WriteBatch 一个扫描周期的变量在一个 pipeline 中写入
+func (RedisSink) WriteBatch(driverID string, points []Point) error {
+	return redishelper.Instance().SetRealtimeBatch(driverID, points)
+}
+
+// WriteStatus 写 driver:<id>:status 哈希
+func (RedisSink) WriteStatus(driverID string, st Status) error {
+	return redishelper.Instance().SetDriverStatus(driverID, st.fields())
+}
+
+// WriteEvent 写 event:<id> stream，同时在一个事务中保存触发器状态
+func (RedisSink) WriteEvent(driverID string, e *Event) error {
+	return redishelper.Instance().PublishEvent(driverID, e.fields(), e.Trigger, e.state)
+}
+
+func (RedisSink) SaveTriggerState(driverID, trigger, state string) error {
+	return redishelper.Instance().SetEventState(driverID, trigger, state)
+}
+
+func (RedisSink) LoadTriggerState(driverID string) (map[string]string, error) {
+	return redishelper.Instance().GetEventState(driverID)
+}
+
+func (RedisSink) Close() error {
+	return nil
+}
+
+// MemorySink 在内存中保存每个变量的最新值与历史以及事件，用于测试
+type MemorySink struct {
+	mu      sync.Mutex
+	latest  map[string]Point
+	history map[string][]Point
+	events  map[string][]*Event
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{latest: make(map[string]Point), history: make(map[string][]Point), events: make(map[string][]*Event)}
+}
+
+func (s *MemorySink) Write(driverID string, p Point, stream bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := driverID + ":" + p.Name
+	s.latest[key] = p
+	if stream {
+		s.history[key] = append(s.history[key], p)
+	}
+	return nil
+}
+
+func (s *MemorySink) WriteEvent(driverID string, e *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[driverID] = append(s.events[driverID], e)
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Latest 变量的最新值
+func (s *MemorySink) Latest(driverID, name string) (Point, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.latest[driverID+":"+name]
+	return p, ok
+}
+
+// History 写入历史的值
+func (s *MemorySink) History(driverID, name string) []Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Point(nil), s.history[driverID+":"+name]...)
+}
+
+// Events 驱动发布的事件
+func (s *MemorySink) Events(driverID string) []*Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Event(nil), s.events[driverID]...)
+}
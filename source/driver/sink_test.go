@@ -0,0 +1,215 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"acetek-mes/redishelper"
+)
+
+type failSink struct{ writes int }
+
+func (s *failSink) Write(string, Point, bool) error {
+	s.writes++
+	return errors.New("offline")
+}
+
+func (s *failSink) Close() error { return nil }
+
+// downSink down 为 true 时写入失败，恢复后写入内存
+type downSink struct {
+	*MemorySink
+	down bool
+}
+
+func (s *downSink) Write(driverID string, p Point, stream bool) error {
+	if s.down {
+		return errors.New("offline")
+	}
+	return s.MemorySink.Write(driverID, p, stream)
+}
+
+func TestSinks(t *testing.T) {
+	memory := NewMemorySink()
+	d := &Driver{ID: "PLC01", Tags: TagMap([]*Tag{{Name: "液位", Value: float32(10), Quality: "Good", Deadband: 1}})}
+	d.SetSinks([]Sink{memory})
+	tag := d.Tags["液位"]
+
+	d.Update()
+	tag.Value = float32(10.5)
+	d.Update()
+	// 死区内的变化只刷新最新值，不写历史
+	if p, _ := memory.Latest("PLC01", "液位"); p.Value != float32(10.5) {
+		t.Fatalf("latest: %+v", p)
+	}
+	if h := memory.History("PLC01", "液位"); len(h) != 1 || h[0].Value != float32(10) {
+		t.Fatalf("history: %+v", h)
+	}
+
+	// 一个输出故障不影响其他输出，也不会重复写历史
+	fail := &failSink{}
+	d.SetSinks([]Sink{fail, memory})
+	tag.Value = float32(20)
+	d.Update()
+	d.Update()
+	if h := memory.History("PLC01", "液位"); len(h) != 2 || fail.writes != 1 {
+		t.Fatalf("history: %d, failed writes: %d", len(h), fail.writes)
+	}
+
+	// 全部输出故障时下一个周期重新上报
+	d.SetSinks([]Sink{fail})
+	tag.Value = float32(30)
+	d.Update()
+	d.Update()
+	if fail.writes != 3 {
+		t.Fatalf("failed writes: %d", fail.writes)
+	}
+}
+
+// batchSink 记录每次批量写入，reject 中的变量写入失败
+type batchSink struct {
+	batches [][]Point
+	reject  string
+}
+
+func (s *batchSink) Write(string, Point, bool) error { return errors.New("not batched") }
+
+func (s *batchSink) WriteBatch(_ string, points []Point) error {
+	s.batches = append(s.batches, points)
+	be := &redishelper.BatchError{Errors: make([]error, len(points))}
+	failed := false
+	for i, p := range points {
+		if p.Name == s.reject {
+			be.Errors[i], failed = errors.New("WRONGTYPE"), true
+		}
+	}
+	if failed {
+		return be
+	}
+	return nil
+}
+
+func (s *batchSink) Close() error { return nil }
+
+func TestBatchSink(t *testing.T) {
+	d := &Driver{
+		ID:       "PLC01",
+		Interval: 1000,
+		Tags: TagMap([]*Tag{
+			{Name: "落丝信号", ScanClass: ScanFast},
+			{Name: "线号"},
+			{Name: "开摆时间", ScanClass: ScanSlow},
+		}),
+	}
+	d.InitScanGroups()
+	sink := &batchSink{reject: "线号"}
+	d.SetSinks([]Sink{sink})
+	readGroup := func(g *ScanGroup) (map[string]interface{}, error) {
+		for _, v := range g.Tags {
+			v.Value, v.Quality = 1, "Good"
+		}
+		return nil, nil
+	}
+
+	// 三个扫描组同时到期，只写一批
+	d.scan(context.Background(), readGroup)
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 3 || !sink.batches[0][0].Stream {
+		t.Fatalf("batches: %+v", sink.batches)
+	}
+	// 值没有变化时不再上报，失败的变量下一个周期重新上报
+	for _, g := range d.Groups {
+		g.next = time.Time{}
+	}
+	d.scan(context.Background(), readGroup)
+	if len(sink.batches) != 2 || len(sink.batches[1]) != 1 || sink.batches[1][0].Name != "线号" {
+		t.Fatalf("batches: %+v", sink.batches)
+	}
+}
+
+func TestSinkDown(t *testing.T) {
+	memory, influx := NewMemorySink(), &downSink{MemorySink: NewMemorySink(), down: true}
+	d := &Driver{ID: "PLC01", Tags: TagMap([]*Tag{{Name: "液位", Value: float32(10), Quality: "Good"}})}
+	d.SetSinks([]Sink{memory, influx})
+	tag := d.Tags["液位"]
+
+	// 一个输出故障时另一个输出正常上报，故障输出恢复后补发漏掉的值
+	d.Update()
+	influx.down = false
+	tag.Value = float32(20)
+	d.Update()
+	h := influx.History("PLC01", "液位")
+	if len(h) != 2 || h[0].Value != float32(10) || h[1].Value != float32(20) {
+		t.Fatalf("history: %+v", h)
+	}
+	if h := memory.History("PLC01", "液位"); len(h) != 2 {
+		t.Fatalf("history: %+v", h)
+	}
+
+	// 重发队列有上限
+	influx.down = true
+	for i := 0; i < MaxSinkRetry+10; i++ {
+		tag.Value = float32(i)
+		d.Update()
+	}
+	if len(d.retry[1]) != MaxSinkRetry || d.retry[1][0].Value != float32(10) {
+		t.Fatalf("retry: %d", len(d.retry[1]))
+	}
+}
+
+func TestSinksFor(t *testing.T) {
+	mgr := &DriverMgr{sinks: make(map[string]Sink)}
+	if mgr.sinksFor("PLC01") != nil {
+		t.Fatal("default sinks")
+	}
+	mgr.SetSinks(map[string][]string{
+		"*":     {"memory://", "redis://"},
+		"PLC02": {"memory://"},
+		"PLC03": {},
+		"PLC04": {"unknown://"},
+	})
+	a, b := mgr.sinksFor("PLC01"), mgr.sinksFor("PLC02")
+	if len(a) != 2 || len(b) != 1 || a[0] != b[0] {
+		t.Fatalf("sinks: %v %v", a, b)
+	}
+	if s := mgr.sinksFor("PLC03"); s == nil || len(s) != 0 {
+		t.Fatalf("disabled: %v", s)
+	}
+	if s := mgr.sinksFor("PLC04"); len(s) != 0 {
+		t.Fatalf("unknown: %v", s)
+	}
+}
+
+// 创建输出时（例如连接 MQTT 服务器）不持有管理器的锁
+func TestOpenSinksUnlocked(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	RegisterSink("slow", func(string) (Sink, error) {
+		close(dialing)
+		<-release
+		return NewMemorySink(), nil
+	})
+	defer delete(sinkRegistry, "slow")
+	mgr := &DriverMgr{clients: make(map[string]IDriver), sinks: make(map[string]Sink)}
+	done := make(chan struct{})
+	go func() {
+		mgr.SetSinks(map[string][]string{"*": {"slow://broker"}})
+		close(done)
+	}()
+	<-dialing
+	locked := make(chan struct{})
+	go func() {
+		mgr.client("PLC01")
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("manager locked while opening sink")
+	}
+	close(release)
+	<-done
+	if s := mgr.sinksFor("PLC01"); len(s) != 1 {
+		t.Fatalf("sinks: %v", s)
+	}
+}
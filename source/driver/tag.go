@@ -1,11 +1,15 @@
 package driver
 
 import (
+	"acetek-mes/redishelper"
+	"acetek-mes/valconv"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"strconv"
 	"time"
+	"unicode/utf16"
 )
 
 type Tag struct {
@@ -19,7 +23,27 @@ type Tag struct {
 	Value     interface{}
 	Timestamp time.Time
 	Quality   string
+	ScanClass string // 扫描类别 fast/normal/slow 或毫秒数，空为驱动 interval
 	Mate      any
+
+	Deadband        float64 // 绝对死区
+	DeadbandPercent float64 // 百分比死区，配置量程时相对工程量程，否则相对上次上报值
+	Heartbeat       uint32  // 最长静默秒数，0 为 DefaultHeartbeat
+	report          reportState
+
+	RawMin float64 // 原始量程下限
+	RawMax float64 // 原始量程上限
+	EngMin float64 // 工程量程下限
+	EngMax float64 // 工程量程上限
+	Gain   float64 // 未配置量程时：工程值 = 原始值 * Gain + Offset
+	Offset float64
+	Clamp  bool   // 工程值限制在量程内
+	Unit   string // 工程单位
+
+	WriteMin  float64 // 允许写入的工程值范围，WriteMax > WriteMin 时生效
+	WriteMax  float64
+	Interlock string // 写入联锁条件，例如 "运行状态==0 && 门锁!=1"
+	Confirm   bool   // 写入需要第二人确认
 }
 
 const (
@@ -32,8 +56,21 @@ const (
 	TypeByte    string = "byte"
 	TypeBytes   string = "bytes"
 	TypeString string = "string"
+
+	TypeInt64    string = "int64"         // S7 LINT
+	TypeFloat64  string = "float64"       // S7 LREAL
+	TypeDTL      string = "dtl"           // S7 DTL，12 字节，值为 time.Time
+	TypeDateTime string = "date_and_time" // S7 DATE_AND_TIME，8 字节 BCD，值为 time.Time
+	TypeTime     string = "time"          // S7 TIME，有符号毫秒数，值为 time.Duration
+	TypeChars    string = "chars"         // S7 Array of CHAR，定长，无长度头
+	TypeWString  string = "wstring"       // S7 WSTRING，UTF-16
+	TypeStruct   string = "struct"        // 结构体，值为 map[string]interface{}
 )
 
+func (t *Tag) Point() redishelper.Point {
+	return redishelper.Point{Name: t.Name, Value: t.Value, Quality: t.Quality, Timestamp: t.Timestamp, Unit: t.Unit}
+}
+
 func (t* Tag) ConvertValue(value interface{}) interface{}{
 	return convert(value, t.Datatype)
 }
@@ -101,6 +138,8 @@ func convert(value interface{}, t string) interface{} {
 			return float32(f)
 		case float64:
 			return float32(v)
+		case float32:
+			return v
 		case int:
 			return float32(v)
 		}
@@ -110,6 +149,8 @@ func convert(value interface{}, t string) interface{} {
 			return v
 		case int:
 			return byte(v)
+		case float64:
+			return byte(v)
 		case string:
 			bs := []byte(v)
 			if len(bs) > 0 {
@@ -123,8 +164,56 @@ func convert(value interface{}, t string) interface{} {
 		case string:
 			return []byte(v)
 		}
-	case TypeString:
+	case TypeString, TypeChars, TypeWString:
 		return fmt.Sprintf("%v", value)
+	case TypeInt64:
+		switch v := value.(type) {
+		case string:
+			i, _ := strconv.ParseInt(v, 10, 64)
+			return i
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		case float64:
+			return int64(v)
+		}
+	case TypeFloat64:
+		switch v := value.(type) {
+		case string:
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		case float64:
+			return v
+		case float32:
+			return float64(v)
+		case int:
+			return float64(v)
+		}
+	case TypeDTL, TypeDateTime:
+		switch v := value.(type) {
+		case time.Time:
+			return v
+		case string:
+			if t, err := valconv.ParseTime(v); err == nil {
+				return t
+			}
+		}
+	case TypeTime:
+		switch v := value.(type) {
+		case time.Duration:
+			return v
+		case string:
+			if d, err := valconv.ParseDuration(v); err == nil {
+				return d
+			}
+		case int:
+			return time.Duration(v) * time.Millisecond
+		case int32:
+			return time.Duration(v) * time.Millisecond
+		case float64:
+			return time.Duration(v * float64(time.Millisecond))
+		}
 	}
 	return nil
 }
@@ -133,6 +222,9 @@ func convert(value interface{}, t string) interface{} {
 func toBytes(value interface{}, t string) ([]byte, error) {
 
 	value = convert(value, t)
+	if value == nil {
+		return nil, fmt.Errorf("cannot convert value to %s", t)
+	}
 
 	buf := new(bytes.Buffer)
 
@@ -159,12 +251,54 @@ func toBytes(value interface{}, t string) ([]byte, error) {
 	case TypeBytes:
 		return value.([]byte), nil
 	case TypeString:
+		// S7 STRING：最大长度、实际长度、字符，只写入实际长度部分
 		s := value.(string)
-		b := make([]byte, 256)
-		copy(b, []byte(s))
-		return b, nil
+		if len(s) > 254 {
+			return nil, fmt.Errorf("string too long: %d", len(s))
+		}
+		return append([]byte{254, byte(len(s))}, s...), nil
+	case TypeChars:
+		return []byte(value.(string)), nil
+	case TypeWString:
+		// S7 WSTRING：最大长度、实际长度各 2 字节，UTF-16 大端字符
+		chars := utf16.Encode([]rune(value.(string)))
+		if len(chars) > 16382 {
+			return nil, fmt.Errorf("wstring too long: %d", len(chars))
+		}
+		binary.Write(buf, binary.BigEndian, [2]uint16{16382, uint16(len(chars))})
+		binary.Write(buf, binary.BigEndian, chars)
+	case TypeInt64:
+		binary.Write(buf, binary.BigEndian, value.(int64))
+	case TypeFloat64:
+		binary.Write(buf, binary.BigEndian, value.(float64))
+	case TypeDTL:
+		t := value.(time.Time).In(time.Local)
+		binary.Write(buf, binary.BigEndian, uint16(t.Year()))
+		buf.Write([]byte{byte(t.Month()), byte(t.Day()), byte(t.Weekday()) + 1, byte(t.Hour()), byte(t.Minute()), byte(t.Second())})
+		binary.Write(buf, binary.BigEndian, uint32(t.Nanosecond()))
+	case TypeDateTime:
+		t := value.(time.Time).In(time.Local)
+		if t.Year() < 1990 || t.Year() > 2089 {
+			return nil, fmt.Errorf("date_and_time out of range: %v", t)
+		}
+		ms := t.Nanosecond() / int(time.Millisecond)
+		return []byte{
+			toBCD(t.Year() % 100), toBCD(int(t.Month())), toBCD(t.Day()),
+			toBCD(t.Hour()), toBCD(t.Minute()), toBCD(t.Second()),
+			toBCD(ms / 10), byte(ms%10)<<4 | byte(t.Weekday()+1),
+		}, nil
+	case TypeTime:
+		ms := value.(time.Duration).Milliseconds()
+		if ms < math.MinInt32 || ms > math.MaxInt32 {
+			return nil, fmt.Errorf("time out of range: %v", value)
+		}
+		binary.Write(buf, binary.BigEndian, int32(ms))
 	default:
 		return nil, fmt.Errorf("unsupported type: %s", t)
 	}
 	return buf.Bytes(), nil
+}
+
+func toBCD(v int) byte {
+	return byte(v/10)<<4 | byte(v%10)
 }
\ No newline at end of file
@@ -0,0 +1,334 @@
+package driver
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EdgeRising  = "rising"  // 值增大，bool 为 false -> true
+	EdgeFalling = "falling" // 值减小，bool 为 true -> false
+	EdgeChange  = "change"  // 任意变化
+)
+
+// maxPendingEvents 输出不可用时最多缓存的待发布事件数，超出后丢弃最早的事件；
+// 触发器状态恢复前每个触发器最多缓存同样多的值变化
+const maxPendingEvents = 1000
+
+// Trigger 边沿触发的事件定义。
+// 只在触发变量质量为 Good 时判断边沿，断线期间保留最后一个好值，重连后与之比较，
+// 因此同一个边沿不会因为重连而重复发布。最后的值与序号保存在实现了 StateSink 的输出（Redis）中，
+// 进程重启后继续沿用；状态恢复前（例如启动时 Redis 不可用）观察到的值变化先缓存，恢复后按顺序补判边沿。
+type Trigger struct {
+	Name     string   // 事件名称，例如 "doff"
+	Tag      string   // 触发变量，bool 或整数类型
+	Edge     string   // EdgeRising / EdgeFalling / EdgeChange
+	Snapshot []string // 事件发生时一并记录的变量，取各变量最近一次读到的值
+
+	last     float64
+	armed    bool
+	seq      uint64
+	dirty    bool
+	observed []observation
+}
+
+// observation 触发器状态恢复前观察到的一个值
+type observation struct {
+	value     float64
+	timestamp time.Time
+	snapshot  map[string]interface{}
+}
+
+type triggerState struct {
+	Last float64 `json:"last"`
+	Seq  uint64  `json:"seq"`
+}
+
+// Event 一次边沿事件，Seq 在同一触发器内单调递增，消费方可据此去重
+type Event struct {
+	Trigger   string
+	Tag       string
+	Edge      string
+	Value     float64
+	Previous  float64
+	Seq       uint64
+	Timestamp time.Time
+	Snapshot  map[string]interface{}
+
+	state string
+	sent  []bool // 各输出是否已写入，与驱动的输出对应
+}
+
+func (e *Event) fields() map[string]interface{} {
+	snapshot, _ := json.Marshal(e.Snapshot)
+	return map[string]interface{}{
+		"event":    e.Trigger,
+		"tag":      e.Tag,
+		"edge":     e.Edge,
+		"value":    strconv.FormatFloat(e.Value, 'f', -1, 64),
+		"previous": strconv.FormatFloat(e.Previous, 'f', -1, 64),
+		"seq":      e.Seq,
+		"ts":       e.Timestamp.UTC().Format(time.RFC3339Nano),
+		"snapshot": string(snapshot),
+	}
+}
+
+// ParseSnapshot 解析逗号分隔的伴随变量列表
+func ParseSnapshot(s string) []string {
+	var result []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (t *Trigger) state() string {
+	b, _ := json.Marshal(triggerState{Last: t.last, Seq: t.seq})
+	return string(b)
+}
+
+// detect 用新值更新触发器，返回上一个值以及是否形成事件。第一个值只作为基准。
+func (t *Trigger) detect(v float64) (prev float64, fired bool) {
+	prev = t.last
+	if !t.armed {
+		t.last, t.armed, t.dirty = v, true, true
+		return prev, false
+	}
+	if v == prev {
+		return prev, false
+	}
+	t.last, t.dirty = v, true
+	switch t.Edge {
+	case EdgeRising:
+		fired = v > prev
+	case EdgeFalling:
+		fired = v < prev
+	default:
+		fired = true
+	}
+	if fired {
+		t.seq++
+	}
+	return prev, fired
+}
+
+// SetTriggers 校验并设置事件触发器，触发变量不存在或类型不支持的触发器被忽略
+func (d *Driver) SetTriggers(triggers []*Trigger) {
+	d.Triggers = d.Triggers[:0]
+	for _, t := range triggers {
+		tag, ok := d.Tags[t.Tag]
+		if !ok {
+			log.Printf("driver %s trigger %s: tag %s not found", d.ID, t.Name, t.Tag)
+			continue
+		}
+		switch tag.Datatype {
+		case TypeBool, TypeByte, TypeInt16, TypeUInt16, TypeInt32, TypeUInt32:
+		default:
+			log.Printf("driver %s trigger %s: tag %s type %s not supported", d.ID, t.Name, t.Tag, tag.Datatype)
+			continue
+		}
+		t.Edge = strings.ToLower(strings.TrimSpace(t.Edge))
+		switch t.Edge {
+		case EdgeRising, EdgeFalling, EdgeChange:
+		case "":
+			t.Edge = EdgeRising
+		default:
+			log.Printf("driver %s trigger %s: unknown edge %s", d.ID, t.Name, t.Edge)
+			continue
+		}
+		d.Triggers = append(d.Triggers, t)
+	}
+	d.triggerLoaded = false
+}
+
+// loadTriggers 从 StateSink 恢复触发器的最后值与序号，没有 StateSink 时只在内存中判断。
+// 恢复前缓存的值变化按顺序补判边沿
+func (d *Driver) loadTriggers() error {
+	if ss := d.stateSink(); ss != nil {
+		states, err := ss.LoadTriggerState(d.ID)
+		if err != nil {
+			return err
+		}
+		for _, t := range d.Triggers {
+			var s triggerState
+			if v, ok := states[t.Name]; ok && json.Unmarshal([]byte(v), &s) == nil {
+				t.last, t.seq, t.armed = s.Last, s.Seq, true
+			}
+		}
+	}
+	d.triggerLoaded = true
+	var events []*Event
+	for _, t := range d.Triggers {
+		for _, o := range t.observed {
+			if e := d.fire(t, o); e != nil {
+				events = append(events, e)
+			}
+		}
+		t.observed = nil
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	for _, e := range events {
+		d.queueEvent(e)
+	}
+	return nil
+}
+
+// FireTriggers 判断 tags 中触发变量的边沿，生成事件并发布到各 EventSink，发布失败的事件下次按顺序重发
+func (d *Driver) FireTriggers(tags []*Tag) {
+	if len(d.Triggers) == 0 {
+		return
+	}
+	if !d.triggerLoaded {
+		if err := d.loadTriggers(); err != nil {
+			log.Printf("driver %s load trigger state: %v", d.ID, err)
+		}
+	}
+	for _, t := range d.Triggers {
+		tag, ok := d.Tags[t.Tag]
+		if !ok || !containsTag(tags, tag) || tag.Quality != "Good" {
+			continue
+		}
+		v, ok := ToFloat64(tag.Value)
+		if !ok {
+			continue
+		}
+		o := observation{value: v, timestamp: tag.Timestamp}
+		if d.triggerLoaded {
+			if e := d.fire(t, o); e != nil {
+				d.queueEvent(e)
+			}
+			continue
+		}
+		// 状态未恢复，只缓存值变化，伴随变量取当前值
+		if n := len(t.observed); n > 0 && t.observed[n-1].value == v {
+			continue
+		}
+		if len(t.observed) >= maxPendingEvents {
+			log.Printf("driver %s trigger %s: drop value observed before state loaded", d.ID, t.Name)
+			t.observed = t.observed[1:]
+		}
+		o.snapshot = d.snapshot(t)
+		t.observed = append(t.observed, o)
+	}
+	d.flushEvents()
+}
+
+// fire 用一个值更新触发器，形成事件时返回事件；o.snapshot 为空时取伴随变量的当前值
+func (d *Driver) fire(t *Trigger, o observation) *Event {
+	prev, fired := t.detect(o.value)
+	if !fired {
+		return nil
+	}
+	snapshot := o.snapshot
+	if snapshot == nil {
+		snapshot = d.snapshot(t)
+	}
+	t.dirty = false
+	return &Event{
+		Trigger:   t.Name,
+		Tag:       t.Tag,
+		Edge:      t.Edge,
+		Value:     o.value,
+		Previous:  prev,
+		Seq:       t.seq,
+		Timestamp: o.timestamp,
+		Snapshot:  snapshot,
+		state:     t.state(),
+	}
+}
+
+// snapshot 伴随变量的当前值，质量不为 Good 的为 nil
+func (d *Driver) snapshot(t *Trigger) map[string]interface{} {
+	snapshot := make(map[string]interface{}, len(t.Snapshot))
+	for _, name := range t.Snapshot {
+		if s, ok := d.Tags[name]; ok && s.Quality == "Good" {
+			snapshot[name] = s.Value
+		} else {
+			snapshot[name] = nil
+		}
+	}
+	return snapshot
+}
+
+func (d *Driver) queueEvent(e *Event) {
+	if len(d.events) >= maxPendingEvents {
+		log.Printf("driver %s drop event %s seq %d", d.ID, d.events[0].Trigger, d.events[0].Seq)
+		d.events = d.events[1:]
+	}
+	d.events = append(d.events, e)
+}
+
+// flushEvents 按顺序把待发布事件写入各 EventSink。每个输出从自己第一个失败的事件开始重发，
+// 不影响其他输出；所有输出都写入后事件出队
+func (d *Driver) flushEvents() {
+	sinks := d.sinkList()
+	failed := make([]bool, len(sinks))
+	for _, e := range d.events {
+		if len(e.sent) != len(sinks) {
+			e.sent = make([]bool, len(sinks))
+		}
+		for i, s := range sinks {
+			es, ok := s.(EventSink)
+			if !ok {
+				e.sent[i] = true
+				continue
+			}
+			if e.sent[i] || failed[i] {
+				continue
+			}
+			if err := es.WriteEvent(d.ID, e); err != nil {
+				log.Printf("driver %s publish event %s seq %d: %v", d.ID, e.Trigger, e.Seq, err)
+				failed[i] = true
+				continue
+			}
+			e.sent[i] = true
+		}
+	}
+	for len(d.events) > 0 && !slices.Contains(d.events[0].sent, false) {
+		d.events = d.events[1:]
+	}
+
+	state := slices.IndexFunc(sinks, func(s Sink) bool {
+		_, ok := s.(StateSink)
+		return ok
+	})
+	if state < 0 {
+		for _, t := range d.Triggers {
+			t.dirty = false
+		}
+		return
+	}
+	// 保存状态的输出写完待发布事件后再保存未形成事件的值变化，避免旧事件的状态覆盖新状态
+	for _, e := range d.events {
+		if !e.sent[state] {
+			return
+		}
+	}
+	ss := sinks[state].(StateSink)
+	for _, t := range d.Triggers {
+		if !t.dirty {
+			continue
+		}
+		if err := ss.SaveTriggerState(d.ID, t.Name, t.state()); err != nil {
+			log.Printf("driver %s save trigger %s: %v", d.ID, t.Name, err)
+			return
+		}
+		t.dirty = false
+	}
+}
+
+func containsTag(tags []*Tag, tag *Tag) bool {
+	for _, v := range tags {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
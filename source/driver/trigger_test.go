@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// stateSink 模拟 Redis：事件与触发器状态，down 时写入与读取都失败
+type stateSink struct {
+	*MemorySink
+	stored    map[string]string
+	published []*Event
+	down      bool
+}
+
+func (s *stateSink) WriteEvent(driverID string, e *Event) error {
+	if s.down {
+		return errors.New("redis down")
+	}
+	s.published = append(s.published, e)
+	s.stored[e.Trigger] = e.state
+	return nil
+}
+
+func (s *stateSink) SaveTriggerState(driverID, trigger, state string) error {
+	if s.down {
+		return errors.New("redis down")
+	}
+	s.stored[trigger] = state
+	return nil
+}
+
+func (s *stateSink) LoadTriggerState(driverID string) (map[string]string, error) {
+	if s.down {
+		return nil, errors.New("redis down")
+	}
+	return s.stored, nil
+}
+
+func TestTriggers(t *testing.T) {
+	redis := &stateSink{MemorySink: NewMemorySink(), stored: map[string]string{}}
+	memory := NewMemorySink()
+	sinks := []Sink{redis, memory}
+
+	newDriver := func() *Driver {
+		d := &Driver{ID: "plc1", Tags: map[string]*Tag{
+			"落丝信号": {Name: "落丝信号", Datatype: TypeBool},
+			"线号":   {Name: "线号", Datatype: TypeInt16, Value: int16(3), Quality: "Good"},
+			"已摆时间": {Name: "已摆时间", Datatype: TypeInt32, Value: int32(1200), Quality: "Good"},
+		}}
+		d.SetTriggers([]*Trigger{{Name: "doff", Tag: "落丝信号", Edge: "Rising", Snapshot: ParseSnapshot("线号, 已摆时间")}})
+		d.SetSinks(sinks)
+		return d
+	}
+	d := newDriver()
+	signal := d.Tags["落丝信号"]
+	scan := func(value interface{}, quality string) {
+		signal.Value, signal.Quality, signal.Timestamp = value, quality, time.Now()
+		d.FireTriggers([]*Tag{signal})
+	}
+
+	scan(false, "Good")
+	scan(true, "Good")
+	scan(true, "Good")
+	if len(redis.published) != 1 || redis.published[0].Seq != 1 || redis.published[0].Snapshot["已摆时间"] != int32(1200) {
+		t.Fatalf("rising edge: %v", redis.published)
+	}
+
+	// 断线期间不判断边沿，重连后值未变不重复发布
+	scan(nil, "Bad")
+	scan(true, "Good")
+	scan(false, "Good")
+	if len(redis.published) != 1 {
+		t.Fatalf("duplicate after reconnect: %d", len(redis.published))
+	}
+
+	// Redis 不可用时事件缓存，恢复后按顺序补发；其他输出不受影响
+	redis.down = true
+	scan(true, "Good")
+	scan(false, "Good")
+	scan(true, "Good")
+	if events := memory.Events("plc1"); len(events) != 3 {
+		t.Fatalf("memory during redis down: %d", len(events))
+	}
+	redis.down = false
+	scan(true, "Good")
+	if len(redis.published) != 3 || redis.published[1].Seq != 2 || redis.published[2].Seq != 3 {
+		t.Fatalf("replay: %v", redis.published)
+	}
+
+	// 重启后从 Redis 恢复状态，当前仍为 true 不产生事件
+	d = newDriver()
+	signal = d.Tags["落丝信号"]
+	scan(true, "Good")
+	scan(false, "Good")
+	scan(true, "Good")
+	if len(redis.published) != 4 || redis.published[3].Seq != 4 {
+		t.Fatalf("restart: %v", redis.published)
+	}
+
+	// 启动时 Redis 不可用，恢复状态后按顺序补判期间的边沿，序号延续
+	redis.down = true
+	d = newDriver()
+	signal = d.Tags["落丝信号"]
+	scan(false, "Good")
+	scan(true, "Good")
+	redis.down = false
+	scan(true, "Good")
+	if len(redis.published) != 5 || redis.published[4].Seq != 5 {
+		t.Fatalf("redis down at start: %v", redis.published)
+	}
+	events := memory.Events("plc1")
+	if len(events) != 5 || events[4].Seq != 5 {
+		t.Fatalf("memory: %v", events)
+	}
+
+	// 没有保存状态的输出时只在内存中判断，第一个值为基准
+	sinks = []Sink{memory}
+	d = newDriver()
+	signal = d.Tags["落丝信号"]
+	scan(true, "Good")
+	scan(false, "Good")
+	scan(true, "Good")
+	if events := memory.Events("plc1"); len(events) != 6 || events[5].Seq != 1 {
+		t.Fatalf("no state sink: %v", events)
+	}
+}
@@ -23,8 +23,39 @@ type DCItem struct {
 	Value       string         `gorm:"size:500"` // 数据值
 	Quality     string         `gorm:"size:50"`  // 数据质量，例如 "good", "bad", "unknown"
 	DataType    string         `gorm:"size:50"`  // 数据类型，例如 "string", "int", "float", "bool" 等
+	ScanClass   string         `gorm:"size:20"`  // 扫描类别，例如 "fast", "normal", "slow" 或毫秒数 "250"
+	Deadband    float64        `gorm:"default:0"` // 绝对死区，变化小于该值不写入 stream
+	DeadbandPct float64        `gorm:"default:0"` // 百分比死区，配置量程时相对工程量程，否则相对上次上报值
+	Heartbeat   int            `gorm:"default:0"` // 最长静默秒数，0 为默认 60 秒
+	RawMin      float64        `gorm:"default:0"` // 原始量程，与工程量程一起配置线性换算
+	RawMax      float64        `gorm:"default:0"`
+	EngMin      float64        `gorm:"default:0"` // 工程量程
+	EngMax      float64        `gorm:"default:0"`
+	Gain        float64        `gorm:"default:0"` // 未配置量程时：工程值 = 原始值 * Gain + Offset
+	Offset      float64        `gorm:"default:0"`
+	Clamp       bool           `gorm:"default:0"`  // 工程值限制在量程内
+	Unit        string         `gorm:"size:20"`    // 工程单位，例如 "MPa", "mm", "%"
+	WriteMin    float64        `gorm:"default:0"`  // 允许写入的工程值范围，WriteMax > WriteMin 时生效
+	WriteMax    float64        `gorm:"default:0"`
+	Interlock   string         `gorm:"size:255"`   // 写入联锁条件，例如 "运行状态==0 && 门锁!=1"
+	Confirm     bool           `gorm:"default:0"`  // 写入需要第二人确认
 	Timestamp   time.Time      `gorm:"type:DateTime"`
 	CreatedAt   time.Time      `gorm:"type:DateTime"` // 自动维护
 	UpdatedAt   time.Time      `gorm:"type:DateTime"` // 自动维护
 	DeletedAt   gorm.DeletedAt // 软删除支持（可选）
 }
+
+// DCTrigger 边沿触发事件定义：触发变量出现指定边沿时，向 event:<driver> stream 发布一条事件
+type DCTrigger struct {
+	IntID       int            `gorm:"column:int_id;autoIncrement;not null;<-:create"`
+	Name        string         `gorm:"size:100;primaryKey"` // 事件名称，例如 "doff"
+	DriverID    string         `gorm:"size:36;primaryKey"`  // 关联的 Driver ID
+	Tag         string         `gorm:"size:100"`            // 触发变量（DCItem.ID），bool 或整数类型
+	Edge        string         `gorm:"size:20"`             // rising / falling / change
+	Snapshot    string         `gorm:"size:500"`            // 事件发生时一并记录的变量，逗号分隔
+	Description string         `gorm:"size:255"`
+	Enabled     bool           `gorm:"default:1"`
+	CreatedAt   time.Time      `gorm:"type:DateTime"`
+	UpdatedAt   time.Time      `gorm:"type:DateTime"`
+	DeletedAt   gorm.DeletedAt
+}
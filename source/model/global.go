@@ -27,6 +27,21 @@ type SysLog struct {
 	Message    string `gorm:"size:2000;not null"` // 日志消息内容
 }
 
+// WriteAudit PLC 写入审计记录，每个被写入（或被拒绝）的变量一条，Name 为变量名称
+type WriteAudit struct {
+	Entity
+	DriverID  string    `gorm:"size:36;index"`
+	User      string    `gorm:"size:100"`  // 操作人，空为系统写入
+	Confirmer string    `gorm:"size:100"`  // 第二确认人
+	Source    string    `gorm:"size:20"`   // 来源，例如 "http", "redis"
+	Previous  string    `gorm:"size:500"`  // 写入前的值
+	Value     string    `gorm:"size:500"`  // 请求写入的值
+	ReadBack  string    `gorm:"size:500"`  // 写入后读回的值
+	Result    string    `gorm:"size:20"`   // ok / rejected / failed
+	Message   string    `gorm:"size:2000"` // 拒绝或失败原因
+	Timestamp time.Time `gorm:"type:DateTime"`
+}
+
 var (
 	entitys []interface{}
 )
@@ -34,6 +49,7 @@ var (
 func init() {
 	entitys = []interface{}{
 		&SysLog{},
+		&WriteAudit{},
 
 		&YarnBreakLog{},
 		&YarnPosition{},
@@ -56,6 +72,7 @@ func init() {
 
 		&DCDriver{},
 		&DCItem{},
+		&DCTrigger{},
 
 		&LIMSCustomSample{},
 		&LimsDcRequestLog{},
@@ -2,6 +2,7 @@ package redishelper
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -35,6 +36,10 @@ type RedisHelper struct {
 	ready      bool
 	subscribed map[string]func(dev, pt string, data map[string]string) // deviceID -> callback
 	subMu      sync.Mutex
+	spool      *Spool                  // 不为空时 Redis 不可用期间的实时值写入磁盘，恢复后重放
+	members    map[string]*memberCache // deviceID -> 已 SADD 的变量
+	groups     map[string]bool         // stream + 消费组 -> 已创建
+	memberMu   sync.Mutex
 }
 
 type RedisConfig struct {
@@ -96,13 +101,7 @@ func (h *RedisHelper) InitFromURL(rawURL string) error {
 		PoolSize: 1200,
 	}
 
-	client := redis.NewClient(opt)
-	if err := client.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("redis ping error: %w", err)
-	}
-
-	h.client = client
-	h.config = &RedisConfig{
+	cfg := &RedisConfig{
 		Name:         host,
 		Host:         host,
 		Port:         port,
@@ -111,6 +110,16 @@ func (h *RedisHelper) InitFromURL(rawURL string) error {
 		StreamMaxLen: streamLen,
 		URL:          rawURL,
 	}
+	client := redis.NewClient(opt)
+	if err := client.Ping(ctx).Err(); err != nil {
+		// 启动时 Redis 不可用，由 keepAlive 继续重连，期间启用了缓存的实时值先写入缓存
+		h.config = cfg
+		go h.keepAlive()
+		return fmt.Errorf("redis ping error: %w", err)
+	}
+
+	h.client = client
+	h.config = cfg
 	h.ready = true
 
 	go h.keepAlive()
@@ -119,6 +128,13 @@ func (h *RedisHelper) InitFromURL(rawURL string) error {
 	return nil
 }
 
+// Configured 已调用 InitFromURL，无论当前是否连接
+func (h *RedisHelper) Configured() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config != nil
+}
+
 func (h *RedisHelper) keepAlive() {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -132,11 +148,13 @@ func (h *RedisHelper) keepAlive() {
 			cfg := h.config
 			h.mu.RUnlock()
 
-			if client == nil || cfg == nil {
+			if cfg == nil {
 				continue
 			}
 
-			if err := client.Ping(ctx).Err(); err != nil {
+			if client == nil {
+				h.reconnect()
+			} else if err := client.Ping(ctx).Err(); err != nil {
 				failCount++
 				loggerFunc("[RedisHelper] Ping failed (%d): %v", failCount, err)
 				time.Sleep(time.Second * time.Duration(failCount))
@@ -144,6 +162,7 @@ func (h *RedisHelper) keepAlive() {
 			} else {
 				failCount = 0
 			}
+			h.replaySpool()
 		case <-h.stopCh:
 			return
 		}
@@ -178,6 +197,7 @@ func (h *RedisHelper) reconnect() {
 
 	h.client = client
 	h.ready = true
+	h.resetMembers()
 	loggerFunc("[RedisHelper] Reconnected successfully.")
 
 	h.subMu.Lock()
@@ -193,36 +213,256 @@ func (h *RedisHelper) reconnect() {
 	h.subMu.Unlock()
 }
 
+// Point 一个实时点位的值，Unit 为空时哈希中不写 unit 字段
+type Point struct {
+	Name      string
+	Value     any
+	Quality   string
+	Timestamp time.Time
+	Unit      string
+	Stream    bool // SetRealtimeBatch 是否写 stream，为 false 时只刷新 real: 哈希
+}
+
+func (p Point) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"value":   formatValue(p.Value),
+		"ts":      p.Timestamp.UTC().Format(time.RFC3339),
+		"quality": p.Quality,
+		"dt":      fmt.Sprintf("%T", p.Value),
+	}
+	if p.Unit != "" {
+		fields["unit"] = p.Unit
+	}
+	return fields
+}
+
+// formatValue 数组与结构体值以 JSON 保存
+func formatValue(v any) string {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 func (h *RedisHelper) SetRealtime(deviceID, point string, value any, quality string, timestamp time.Time) error {
+	return h.SetRealtimePoint(deviceID, Point{Name: point, Value: value, Quality: quality, Timestamp: timestamp}, true)
+}
+
+// SetRealtimePoint 刷新 real: 哈希，stream 为 false 时不写 stream（死区内的小幅变化）
+func (h *RedisHelper) SetRealtimePoint(deviceID string, p Point, stream bool) error {
+	p.Stream = stream
+	err := h.SetRealtimeBatch(deviceID, []Point{p})
+	var be *BatchError
+	if errors.As(err, &be) {
+		return be.Errors[0]
+	}
+	return err
+}
+
+// BatchError 批量写入中部分变量失败，Errors 与写入的变量一一对应，成功的为 nil
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	var first error
+	failed := 0
+	for _, err := range e.Errors {
+		if err != nil {
+			if failed++; first == nil {
+				first = err
+			}
+		}
+	}
+	return fmt.Sprintf("%d of %d points failed: %v", failed, len(e.Errors), first)
+}
+
+// SetRealtimeBatch 在一个 pipeline 中写入一个设备的多个变量：HSET real:，Stream 为 true 时 XADD stream:，
+// 新出现的变量才 SADD point:<device>:points。不使用 MULTI，变量之间不需要原子性；
+// 个别命令失败时返回 *BatchError，连接错误时整批写入缓存（已启用时）
+func (h *RedisHelper) SetRealtimeBatch(deviceID string, points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
 	h.mu.RLock()
 	client := h.client
 	cfg := h.config
+	spool := h.spool
 	h.mu.RUnlock()
 
+	// 缓存中还有未重放的记录时继续写缓存，保证同一变量按顺序写入
+	if spool != nil && (client == nil || spool.Len() > 0) {
+		return spoolPoints(spool, deviceID, points)
+	}
 	if client == nil {
 		return errors.New("Redis not initialized")
 	}
-	ts := timestamp.UTC().Format(time.RFC3339)
-	key := fmt.Sprintf("real:%s:%s", deviceID, point)
-	streamKey := fmt.Sprintf("stream:%s:%s", deviceID, point)
 
-	fields := map[string]interface{}{
-		"value":   fmt.Sprintf("%v", value),
-		"ts":      ts,
-		"quality": quality,
-		"dt":      fmt.Sprintf("%T", value),
+	added := h.newMembers(deviceID, points)
+	owners := make([]int, 0, len(points)*2+1) // 每条命令对应的变量下标，SADD 为 -1
+	cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, p := range points {
+			queueRealtime(pipe, cfg, deviceID, p.Name, p.fields(), p.Stream, "")
+			owners = append(owners, i)
+			if p.Stream {
+				owners = append(owners, i)
+			}
+		}
+		if len(added) > 0 {
+			pipe.SAdd(ctx, fmt.Sprintf("point:%s:points", deviceID), added...)
+			owners = append(owners, -1)
+		}
+		return nil
+	})
+	if err == nil {
+		h.cacheMembers(deviceID, added)
+		return nil
 	}
-
-	if err := client.HSet(ctx, key, fields).Err(); err != nil {
+	if isConnError(err) {
+		// Redis 可能已重启丢失数据，重新 SADD 所有变量
+		h.resetMembers()
+		if spool != nil {
+			return spoolPoints(spool, deviceID, points)
+		}
 		return err
 	}
-	_ = client.SAdd(ctx, fmt.Sprintf("point:%s:points", deviceID), point).Err()
+	be := &BatchError{Errors: make([]error, len(points))}
+	for i, cmd := range cmds {
+		if i < len(owners) && owners[i] >= 0 && cmd.Err() != nil && be.Errors[owners[i]] == nil {
+			be.Errors[owners[i]] = cmd.Err()
+		}
+	}
+	return be
+}
+
+// queueRealtime 把一个变量的 HSET 与 XADD 加入 pipeline，顺序与 SetRealtimeBatch 中的 owners 一致。
+// id 为 stream 条目的 ID，为空时由 Redis 按当前时间生成
+func queueRealtime(pipe redis.Pipeliner, cfg *RedisConfig, deviceID, point string, fields map[string]interface{}, stream bool, id string) {
+	pipe.HSet(ctx, fmt.Sprintf("real:%s:%s", deviceID, point), fields)
+	if stream {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamKey(deviceID, point),
+			ID:     id,
+			MaxLen: cfg.StreamMaxLen,
+			Values: fields,
+		})
+	}
+}
+
+func streamKey(deviceID, point string) string {
+	return fmt.Sprintf("stream:%s:%s", deviceID, point)
+}
+
+func spoolPoints(spool *Spool, deviceID string, points []Point) error {
+	for _, p := range points {
+		if err := spool.Append(spoolRecord{Device: deviceID, Point: p.Name, Fields: p.fields(), Stream: p.Stream}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memberTTL 变量集合缓存的有效期，过期后重新 SADD，Redis 被清空或重启后能恢复集合
+const memberTTL = 10 * time.Minute
+
+type memberCache struct {
+	at    time.Time
+	names map[string]bool
+}
+
+// newMembers 返回还没有确认加入 point:<device>:points 的变量
+func (h *RedisHelper) newMembers(deviceID string, points []Point) []interface{} {
+	h.memberMu.Lock()
+	defer h.memberMu.Unlock()
+	m := h.members[deviceID]
+	if m != nil && time.Since(m.at) > memberTTL {
+		delete(h.members, deviceID)
+		m = nil
+	}
+	var added []interface{}
+	for _, p := range points {
+		if m == nil || !m.names[p.Name] {
+			added = append(added, p.Name)
+		}
+	}
+	return added
+}
+
+func (h *RedisHelper) cacheMembers(deviceID string, names []interface{}) {
+	if len(names) == 0 {
+		return
+	}
+	h.memberMu.Lock()
+	defer h.memberMu.Unlock()
+	if h.members == nil {
+		h.members = make(map[string]*memberCache)
+	}
+	m := h.members[deviceID]
+	if m == nil {
+		m = &memberCache{at: time.Now(), names: make(map[string]bool)}
+		h.members[deviceID] = m
+	}
+	for _, v := range names {
+		m.names[v.(string)] = true
+	}
+}
 
-	return client.XAdd(ctx, &redis.XAddArgs{
-		Stream: streamKey,
-		MaxLen: cfg.StreamMaxLen,
-		Values: fields,
-	}).Err()
+// resetMembers Redis 重连或连接出错时清除已 SADD 的变量与已创建的消费组，下次重新写入
+func (h *RedisHelper) resetMembers() {
+	h.memberMu.Lock()
+	h.members = nil
+	h.groups = nil
+	h.memberMu.Unlock()
+}
+
+// PublishEvent 在同一事务中写入事件 stream event:<device> 并保存触发器状态 event:<device>:state，
+// 两者要么都成功要么都失败，保证每个边沿只发布一次
+func (h *RedisHelper) PublishEvent(deviceID string, values map[string]interface{}, trigger string, state string) error {
+	h.mu.RLock()
+	client := h.client
+	cfg := h.config
+	h.mu.RUnlock()
+
+	if client == nil {
+		return errors.New("Redis not initialized")
+	}
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: fmt.Sprintf("event:%s", deviceID),
+			MaxLen: cfg.StreamMaxLen,
+			Values: values,
+		})
+		pipe.HSet(ctx, fmt.Sprintf("event:%s:state", deviceID), trigger, state)
+		return nil
+	})
+	return err
+}
+
+// SetEventState 只保存触发器状态（值变化但未形成事件时）
+func (h *RedisHelper) SetEventState(deviceID string, trigger string, state string) error {
+	h.mu.RLock()
+	client := h.client
+	h.mu.RUnlock()
+
+	if client == nil {
+		return errors.New("Redis not initialized")
+	}
+	return client.HSet(ctx, fmt.Sprintf("event:%s:state", deviceID), trigger, state).Err()
+}
+
+// GetEventState 读取各触发器最近一次发布事件时的状态
+func (h *RedisHelper) GetEventState(deviceID string) (map[string]string, error) {
+	h.mu.RLock()
+	client := h.client
+	h.mu.RUnlock()
+
+	if client == nil {
+		return nil, errors.New("Redis not initialized")
+	}
+	return client.HGetAll(ctx, fmt.Sprintf("event:%s:state", deviceID)).Result()
 }
 
 func (h *RedisHelper) GetRealtime(deviceID, point string) (map[string]string, error) {
@@ -315,3 +555,15 @@ func (h *RedisHelper) Client() *redis.Client {
 	defer h.mu.RUnlock()
 	return h.client
 }
+
+// SetDriverStatus 刷新驱动状态哈希 driver:<id>:status
+func (h *RedisHelper) SetDriverStatus(driverID string, fields map[string]interface{}) error {
+	h.mu.RLock()
+	client := h.client
+	h.mu.RUnlock()
+
+	if client == nil {
+		return errors.New("Redis not initialized")
+	}
+	return client.HSet(ctx, fmt.Sprintf("driver:%s:status", driverID), fields).Err()
+}
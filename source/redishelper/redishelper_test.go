@@ -0,0 +1,29 @@
+package redishelper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMembers(t *testing.T) {
+	h := &RedisHelper{}
+	points := []Point{{Name: "液位"}, {Name: "温度"}}
+	added := h.newMembers("PLC01", points)
+	if len(added) != 2 {
+		t.Fatalf("added: %v", added)
+	}
+	h.cacheMembers("PLC01", added)
+	// 已加入集合的变量不再 SADD
+	if added := h.newMembers("PLC01", append(points, Point{Name: "压力"})); len(added) != 1 || added[0] != "压力" {
+		t.Fatalf("added: %v", added)
+	}
+	h.members["PLC01"].at = time.Now().Add(-memberTTL - time.Second)
+	if added := h.newMembers("PLC01", points); len(added) != 2 {
+		t.Fatalf("expired: %v", added)
+	}
+	h.cacheMembers("PLC01", h.newMembers("PLC01", points))
+	h.resetMembers()
+	if added := h.newMembers("PLC01", points); len(added) != 2 {
+		t.Fatalf("reset: %v", added)
+	}
+}
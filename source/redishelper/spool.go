@@ -0,0 +1,405 @@
+package redishelper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const segmentExt = ".seg"
+
+// spoolRecord 一条缓存的实时值，fields 与写入 real: 哈希的字段相同，重放时保持原时间戳
+type spoolRecord struct {
+	Device string                 `json:"d"`
+	Point  string                 `json:"p"`
+	Fields map[string]interface{} `json:"f"`
+	Stream bool                   `json:"s,omitempty"`
+}
+
+type segment struct {
+	id    uint64
+	size  int64
+	count int
+}
+
+// Spool Redis 不可用时缓存实时值的磁盘队列。数据按顺序追加到分段文件（每行一条 JSON），
+// 总大小超过上限时删除最旧的分段；重放从最旧的分段开始，一个分段重放完后删除。
+// 进程在重放中途退出时，该分段下次会从头重放，stream 中可能出现少量重复
+type Spool struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []segment
+	w        *os.File // 最后一个分段，nil 时下次追加新建分段
+	readOff  int64    // 第一个分段已重放的字节数
+	busy     uint64   // 正在重放的分段，不能删除
+	count    int
+	size     int64
+	dropped  int
+}
+
+// OpenSpool 打开缓存目录，上次未重放的分段保留，等 Redis 可用后重放
+func OpenSpool(dir string, maxBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid spool size: %d", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes, segmentSize: maxBytes / 8}
+	if s.segmentSize < 1 {
+		s.segmentSize = 1
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		id, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) || err != nil {
+			continue
+		}
+		seg := segment{id: id}
+		if seg.size, seg.count, err = countLines(filepath.Join(dir, e.Name())); err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+		s.count += seg.count
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+	if s.count > 0 {
+		log.Printf("[RedisHelper] spool %s: %d records to replay", dir, s.count)
+	}
+	return s, nil
+}
+
+func countLines(path string) (int64, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var size int64
+	count := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		size += int64(len(line))
+		if err == io.EOF {
+			return size, count, nil
+		} else if err != nil {
+			return 0, 0, err
+		}
+		count++
+	}
+}
+
+func (s *Spool) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%012d%s", id, segmentExt))
+}
+
+// Append 追加一条记录，当前分段写满时新建分段
+func (s *Spool) Append(r spoolRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil || s.segments[len(s.segments)-1].size >= s.segmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	last := &s.segments[len(s.segments)-1]
+	last.size += int64(len(b))
+	last.count++
+	s.size += int64(len(b))
+	s.count++
+	s.trim()
+	return nil
+}
+
+// roll 关闭当前分段，新建下一个分段，调用方持有 s.mu
+func (s *Spool) roll() error {
+	if s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+	var id uint64 = 1
+	if n := len(s.segments); n > 0 {
+		id = s.segments[n-1].id + 1
+	}
+	f, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.w = f
+	s.segments = append(s.segments, segment{id: id})
+	return nil
+}
+
+// trim 超过上限时删除最旧的分段，正在写入与正在重放的分段除外，调用方持有 s.mu
+func (s *Spool) trim() {
+	for i := 0; s.size > s.maxBytes && i < len(s.segments)-1; {
+		seg := s.segments[i]
+		if seg.id == s.busy {
+			i++
+			continue
+		}
+		if err := os.Remove(s.path(seg.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println("[RedisHelper] remove spool segment:", err)
+		}
+		s.segments = append(s.segments[:i], s.segments[i+1:]...)
+		s.size -= seg.size
+		s.count -= seg.count
+		if i == 0 {
+			s.readOff = 0
+		}
+		s.dropped += seg.count
+		log.Printf("[RedisHelper] spool full, %d records dropped", s.dropped)
+	}
+}
+
+// Len 等待重放的记录数
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Replay 从最旧的记录开始按顺序交给 fn，每次最多 batch 条；fn 返回错误时停止，未成功的记录下次重放
+func (s *Spool) Replay(batch int, fn func([]spoolRecord) error) error {
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		head := s.segments[0]
+		if len(s.segments) == 1 && s.w != nil {
+			// 正在写入的分段先关闭，之后的记录写入新的分段
+			s.w.Close()
+			s.w = nil
+		}
+		off := s.readOff
+		s.busy = head.id
+		s.mu.Unlock()
+
+		err := s.replaySegment(head.id, off, batch, fn)
+		s.mu.Lock()
+		s.busy = 0
+		if err == nil {
+			if err = os.Remove(s.path(head.id)); errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+			s.size -= s.segments[0].size
+			s.count -= s.segments[0].count
+			s.segments = s.segments[1:]
+			s.readOff = 0
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replaySegment 从 off 开始分批重放一个分段，每批成功后记录重放位置；末尾不完整的行（写入时进程退出）忽略
+func (s *Spool) replaySegment(id uint64, off int64, batch int, fn func([]spoolRecord) error) error {
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for eof := false; !eof; {
+		var records []spoolRecord
+		var consumed int64
+		lines := 0
+		for len(records) < batch {
+			line, err := r.ReadBytes('\n')
+			if err == io.EOF {
+				eof = true
+				break
+			} else if err != nil {
+				return err
+			}
+			consumed += int64(len(line))
+			lines++
+			var rec spoolRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				log.Println("[RedisHelper] skip spool record:", err)
+				continue
+			}
+			records = append(records, rec)
+		}
+		if len(records) > 0 {
+			if err := fn(records); err != nil {
+				return err
+			}
+		}
+		s.mu.Lock()
+		s.readOff += consumed
+		s.segments[0].count -= lines
+		s.count -= lines
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// EnableSpool 在 InitFromURL 之前调用，Redis 不可用期间的实时值写入 dir，最多 maxBytes 字节
+func (h *RedisHelper) EnableSpool(dir string, maxBytes int64) error {
+	spool, err := OpenSpool(dir, maxBytes)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.spool = spool
+	h.mu.Unlock()
+	return nil
+}
+
+// SpoolLen 缓存中等待重放的记录数
+func (h *RedisHelper) SpoolLen() int {
+	h.mu.RLock()
+	spool := h.spool
+	h.mu.RUnlock()
+	if spool == nil {
+		return 0
+	}
+	return spool.Len()
+}
+
+// replaySpool 由 keepAlive 在连接正常时调用，按原顺序与原时间戳补写缓存的实时值
+func (h *RedisHelper) replaySpool() {
+	h.mu.RLock()
+	client := h.client
+	cfg := h.config
+	spool := h.spool
+	h.mu.RUnlock()
+	if client == nil || spool == nil || spool.Len() == 0 {
+		return
+	}
+	replayed := 0
+	tops := make(streamTops)
+	err := spool.Replay(500, func(records []spoolRecord) error {
+		if err := loadTops(client, tops, records); err != nil {
+			return err
+		}
+		_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, r := range records {
+				id := ""
+				if r.Stream {
+					id = tops.next(streamKey(r.Device, r.Point), r.Fields)
+				}
+				queueRealtime(pipe, cfg, r.Device, r.Point, r.Fields, r.Stream, id)
+				pipe.SAdd(ctx, fmt.Sprintf("point:%s:points", r.Device), r.Point)
+			}
+			return nil
+		})
+		if err != nil && !isConnError(err) {
+			// 个别命令失败（例如键类型不对）不影响其他记录，不再重试
+			loggerFunc("[RedisHelper] Replay spool: %v", err)
+			err = nil
+		}
+		if err == nil {
+			replayed += len(records)
+		}
+		return err
+	})
+	if err != nil {
+		loggerFunc("[RedisHelper] Replay spool failed, %d records left: %v", spool.Len(), err)
+		return
+	}
+	loggerFunc("[RedisHelper] Replayed %d spooled records", replayed)
+}
+
+// streamTop stream 中最后一条的 ID
+type streamTop struct {
+	ms  int64
+	seq int64
+}
+
+type streamTops map[string]streamTop
+
+// loadTops 查询本批记录涉及的 stream 的最后一条 ID，已查询过的不再查询
+func loadTops(client *redis.Client, tops streamTops, records []spoolRecord) error {
+	cmds := make(map[string]*redis.XMessageSliceCmd)
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, r := range records {
+			key := streamKey(r.Device, r.Point)
+			if _, ok := tops[key]; !ok && r.Stream && cmds[key] == nil {
+				cmds[key] = pipe.XRevRangeN(ctx, key, "+", "-", 1)
+			}
+		}
+		return nil
+	})
+	if err != nil && isConnError(err) {
+		return err
+	}
+	for key, cmd := range cmds {
+		var top streamTop
+		if msgs, err := cmd.Result(); err == nil && len(msgs) > 0 {
+			ms, seq, _ := strings.Cut(msgs[0].ID, "-")
+			top.ms, _ = strconv.ParseInt(ms, 10, 64)
+			top.seq, _ = strconv.ParseInt(seq, 10, 64)
+		}
+		tops[key] = top
+	}
+	return nil
+}
+
+// next 重放记录的 stream ID：按原时间戳（秒）生成 <ms>-<seq>，按 ID 查询时间范围时与原时间一致。
+// 时间戳早于 stream 中最后一条时（例如断线前同一秒已写入的值）无法插入，返回空由 Redis 按当前时间生成，
+// 该 stream 之后重放的记录也都由 Redis 生成
+func (tops streamTops) next(key string, fields map[string]interface{}) string {
+	top := tops[key]
+	ts, err := time.Parse(time.RFC3339, fmt.Sprint(fields["ts"]))
+	ms := ts.UnixMilli()
+	switch {
+	case err != nil || ms < top.ms || ms <= 0:
+		tops[key] = streamTop{ms: math.MaxInt64}
+		return ""
+	case ms > top.ms:
+		top = streamTop{ms: ms}
+	default:
+		top.seq++
+	}
+	tops[key] = top
+	return fmt.Sprintf("%d-%d", top.ms, top.seq)
+}
+
+// isConnError 连接类错误，写入缓存等待重放；其他错误（命令错误）直接返回
+func isConnError(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, redis.ErrClosed) || errors.Is(err, redis.ErrPoolTimeout) ||
+		strings.HasPrefix(err.Error(), "LOADING")
+}
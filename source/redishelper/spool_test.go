@@ -0,0 +1,156 @@
+package redishelper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func record(i int) spoolRecord {
+	return spoolRecord{Device: "PLC01", Point: "计数", Fields: map[string]interface{}{"value": fmt.Sprint(i)}, Stream: true}
+}
+
+// drain 重放所有记录，返回值序列
+func drain(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var values []string
+	err := s.Replay(3, func(records []spoolRecord) error {
+		for _, r := range records {
+			values = append(values, r.Fields["value"].(string))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.segmentSize = 200
+	for i := range 10 {
+		if err := s.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(files) < 2 {
+		t.Fatalf("segments: %v", files)
+	}
+
+	// 第二批失败时停止，已成功的不再重放
+	batches := 0
+	fail := errors.New("connection refused")
+	err = s.Replay(3, func(records []spoolRecord) error {
+		if batches++; batches == 2 {
+			return fail
+		}
+		return nil
+	})
+	if err != fail || s.Len() != 7 {
+		t.Fatalf("replay: %v, len %d", err, s.Len())
+	}
+
+	// 重放期间追加的记录排在后面
+	s.Append(record(10))
+	want := "[3 4 5 6 7 8 9 10]"
+	if values := drain(t, s); fmt.Sprint(values) != want || s.Len() != 0 {
+		t.Fatalf("values: %v, len %d", values, s.Len())
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(files) != 0 {
+		t.Fatalf("segments left: %v", files)
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenSpool(dir, 1<<20)
+	for i := range 3 {
+		s.Append(record(i))
+	}
+	// 模拟写入中途退出留下的不完整行
+	f, _ := os.OpenFile(s.path(s.segments[0].id), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"d":"PLC01","p":`)
+	f.Close()
+
+	s, err := OpenSpool(dir, 1<<20)
+	if err != nil || s.Len() != 3 {
+		t.Fatalf("reopen: %v, len %d", err, s.Len())
+	}
+	s.Append(record(3))
+	if values := drain(t, s); fmt.Sprint(values) != "[0 1 2 3]" {
+		t.Fatalf("values: %v", values)
+	}
+}
+
+func TestSpoolLimit(t *testing.T) {
+	s, _ := OpenSpool(t.TempDir(), 800)
+	for i := range 100 {
+		s.Append(record(i))
+	}
+	// 超过上限时丢弃最旧的分段，保留最新的记录
+	if s.size > 800+s.segmentSize {
+		t.Fatalf("size: %d", s.size)
+	}
+	values := drain(t, s)
+	if len(values) == 0 || len(values) >= 100 || values[len(values)-1] != "99" {
+		t.Fatalf("values: %v", values)
+	}
+}
+
+func TestSetRealtimeSpool(t *testing.T) {
+	h := &RedisHelper{}
+	if err := h.SetRealtime("PLC01", "液位", 1.5, "Good", time.Now()); err == nil {
+		t.Fatal("expect error without redis")
+	}
+	if err := h.EnableSpool(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	if err := h.SetRealtimePoint("PLC01", Point{Name: "液位", Value: float32(1.5), Quality: "Good", Timestamp: ts, Unit: "m"}, false); err != nil {
+		t.Fatal(err)
+	}
+	batch := []Point{{Name: "温度", Value: 20, Stream: true}, {Name: "压力", Value: 0.5}}
+	if err := h.SetRealtimeBatch("PLC01", batch); err != nil || h.SpoolLen() != 3 {
+		t.Fatalf("batch: %v, len %d", err, h.SpoolLen())
+	}
+	var got spoolRecord
+	h.spool.Replay(10, func(records []spoolRecord) error {
+		got = records[0]
+		return nil
+	})
+	// 保留原时间戳与数据类型
+	if got.Stream || got.Fields["ts"] != "2025-03-01T08:00:00Z" || got.Fields["dt"] != "float32" || got.Fields["unit"] != "m" {
+		t.Fatalf("record: %+v", got)
+	}
+}
+
+func TestStreamTops(t *testing.T) {
+	at := func(ts string) map[string]interface{} { return map[string]interface{}{"ts": ts} }
+	// stream 中最后一条为 2024-05-01T08:00:00Z 的第 0 条
+	tops := streamTops{"stream:PLC01:计数": {ms: 1714550400000}}
+	var ids []string
+	for _, ts := range []string{"2024-05-01T08:00:00Z", "2024-05-01T08:00:01Z", "2024-05-01T08:00:01Z"} {
+		ids = append(ids, tops.next("stream:PLC01:计数", at(ts)))
+	}
+	// 同一秒的记录递增序号；空 stream 从第 0 条开始
+	ids = append(ids, tops.next("stream:PLC01:液位", at("2024-05-01T08:00:00Z")))
+	want := "[1714550400000-1 1714550401000-0 1714550401000-1 1714550400000-0]"
+	if fmt.Sprint(ids) != want {
+		t.Fatalf("ids: %v", ids)
+	}
+	// 早于最后一条时由 Redis 生成，之后的记录也由 Redis 生成
+	if id := tops.next("stream:PLC01:计数", at("2024-05-01T07:59:59Z")); id != "" {
+		t.Fatalf("older: %s", id)
+	}
+	if id := tops.next("stream:PLC01:计数", at("2024-05-01T08:00:02Z")); id != "" {
+		t.Fatalf("after fallback: %s", id)
+	}
+}
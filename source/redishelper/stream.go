@@ -0,0 +1,99 @@
+package redishelper
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReadGroup 以消费组方式读取 stream 中的新消息，消费组不存在时创建（只消费创建之后的消息）。
+// block 时间内没有消息时返回空
+func (h *RedisHelper) ReadGroup(stream, group, consumer string, count int64, block time.Duration) ([]redis.XMessage, error) {
+	h.mu.RLock()
+	client := h.client
+	h.mu.RUnlock()
+
+	if client == nil {
+		return nil, errors.New("Redis not initialized")
+	}
+	if err := h.createGroup(client, stream, group); err != nil {
+		return nil, err
+	}
+	result, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			// stream 被删除，下次重新创建消费组
+			h.memberMu.Lock()
+			delete(h.groups, stream+"\x00"+group)
+			h.memberMu.Unlock()
+		}
+		return nil, err
+	}
+	var messages []redis.XMessage
+	for _, s := range result {
+		messages = append(messages, s.Messages...)
+	}
+	return messages, nil
+}
+
+// createGroup 每个 stream 的消费组只创建一次，重连后重新创建
+func (h *RedisHelper) createGroup(client *redis.Client, stream, group string) error {
+	key := stream + "\x00" + group
+	h.memberMu.Lock()
+	created := h.groups[key]
+	h.memberMu.Unlock()
+	if created {
+		return nil
+	}
+	err := client.XGroupCreateMkStream(ctx, stream, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	h.memberMu.Lock()
+	if h.groups == nil {
+		h.groups = make(map[string]bool)
+	}
+	h.groups[key] = true
+	h.memberMu.Unlock()
+	return nil
+}
+
+// Ack 确认消费组中的消息已处理
+func (h *RedisHelper) Ack(stream, group string, ids ...string) error {
+	h.mu.RLock()
+	client := h.client
+	h.mu.RUnlock()
+
+	if client == nil {
+		return errors.New("Redis not initialized")
+	}
+	return client.XAck(ctx, stream, group, ids...).Err()
+}
+
+// AddStream 向 stream 追加一条记录，长度按 streamMaxLen 截断
+func (h *RedisHelper) AddStream(stream string, values map[string]interface{}) error {
+	h.mu.RLock()
+	client := h.client
+	cfg := h.config
+	h.mu.RUnlock()
+
+	if client == nil {
+		return errors.New("Redis not initialized")
+	}
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: cfg.StreamMaxLen,
+		Values: values,
+	}).Err()
+}
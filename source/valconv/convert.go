@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func StringToTargetType(value string, typ string) (interface{}, error) {
@@ -42,9 +43,40 @@ func StringToTargetType(value string, typ string) (interface{}, error) {
 		return strconv.ParseFloat(value, 64)
 	case "bool":
 		return strconv.ParseBool(value)
-	case "string":
+	case "string", "chars", "wstring":
 		return value, nil
+	case "dtl", "date_and_time":
+		return ParseTime(value)
+	case "time":
+		return ParseDuration(value)
 	default:
 		return nil, fmt.Errorf("unsupported type: %s", typ)
 	}
-}
\ No newline at end of file
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseTime 解析日期时间，未带时区的按本地时间
+func ParseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", value)
+}
+
+// ParseDuration 解析时长，纯数字按毫秒，否则按 Go 时长格式，例如 "1h30m"、"500ms"
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(value)
+}